@@ -6,7 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/kushalsdesk/redis_with_go/commands"
 	"github.com/kushalsdesk/redis_with_go/rdb"
 	"github.com/kushalsdesk/redis_with_go/server"
 	"github.com/kushalsdesk/redis_with_go/store"
@@ -17,10 +19,43 @@ func main() {
 	replicaof := flag.String("replicaof", "", "Master host and port")
 	dir := flag.String("dir", ".", "Directory for RDB file")
 	dbfilename := flag.String("dbfilename", "dump.rdb", "RDB filename")
+	rdbchecksum := flag.String("rdbchecksum", "yes", "Verify the checksum of the RDB file on load: yes or no")
+	listMaxListpackSize := flag.Int("list-max-listpack-size", -2, "Max list node size: -1..-5 for 4-64 KB, or an element count")
+	listCompressDepth := flag.Int("list-compress-depth", 0, "List nodes kept uncompressed at each end, 0 disables compression")
+	busyReplyThreshold := flag.Int("busy-reply-threshold", 5000, "Milliseconds a script runs before other clients get BUSY, 0 to never reply BUSY")
+	requirepass := flag.String("requirepass", "", "Password clients must AUTH with")
+	protectedMode := flag.String("protected-mode", "yes", "Refuse clients from other hosts while there is no password: yes or no")
+	masteruser := flag.String("masteruser", "", "User a replica AUTHs with to its master")
+	masterauth := flag.String("masterauth", "", "Password a replica AUTHs with to its master")
+	aclfile := flag.String("aclfile", "", "File the ACL users are loaded from and saved to with ACL SAVE")
 	flag.Parse()
 
+	if *rdbchecksum != "yes" && *rdbchecksum != "no" {
+		fmt.Println("ERR: --rdbchecksum must be 'yes' or 'no'")
+		os.Exit(1)
+	}
+	if *protectedMode != "yes" && *protectedMode != "no" {
+		fmt.Println("ERR: --protected-mode must be 'yes' or 'no'")
+		os.Exit(1)
+	}
+	if *busyReplyThreshold < 0 {
+		fmt.Println("ERR: --busy-reply-threshold must not be negative")
+		os.Exit(1)
+	}
+
 	// Set configuration first
 	store.SetConfig(*dir, *dbfilename)
+	store.SetRDBChecksum(*rdbchecksum == "yes")
+	store.SetListConfig(*listMaxListpackSize, *listCompressDepth)
+	store.SetBusyReplyThreshold(time.Duration(*busyReplyThreshold) * time.Millisecond)
+	store.SetAuthConfig(*requirepass, *protectedMode == "yes")
+	store.SetMasterAuth(*masteruser, *masterauth)
+	store.SetACLFile(*aclfile)
+
+	if err := commands.InitACL(*requirepass, *aclfile); err != nil {
+		fmt.Printf("ERR: %v\n", err)
+		os.Exit(1)
+	}
 
 	// global port for replication handshake
 	serverPort := *port
@@ -44,7 +79,7 @@ func main() {
 	rdbPath := filepath.Join(*dir, *dbfilename)
 	if _, err := os.Stat(rdbPath); err == nil {
 		fmt.Printf("📦 RDB file found at %s\n", rdbPath)
-		if err := rdb.LoadRDB(rdbPath); err != nil {
+		if err := rdb.LoadRDB(rdbPath, commands.LoadFunctionLibrary); err != nil {
 			fmt.Printf("❌ Failed to load RDB file: %v\n", err)
 			fmt.Printf("⚠️  Starting with empty dataset\n")
 		}
@@ -52,6 +87,8 @@ func main() {
 		fmt.Printf("ℹ️  No RDB file found at %s, starting with empty dataset\n", rdbPath)
 	}
 
+	store.StartActiveExpireCycle()
+
 	addr := fmt.Sprintf("0.0.0.0:%s", *port)
 	if *replicaof != "" {
 		fmt.Printf("Starting Redis server as replica of %s on %s\n", *replicaof, addr)
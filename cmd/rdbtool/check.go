@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/kushalsdesk/redis_with_go/rdb"
+)
+
+// runCheck reads a whole file the way the server loads it and reports the
+// first problem with its offset, like redis-check-rdb
+func runCheck(args []string) error {
+	path, opts := parseFlags(flag.NewFlagSet("check", flag.ExitOnError), args)
+
+	fmt.Printf("[offset 0] Checking RDB file %s\n", path)
+
+	keys := make(map[string]int)
+	expires := 0
+	info, err := rdb.Parse(path, opts, func(kv *rdb.KeyValue) error {
+		keys[kv.TypeName()]++
+		if kv.Expiry != nil {
+			expires++
+		}
+		return nil
+	})
+
+	if info != nil && info.Version > 0 {
+		fmt.Printf("[info] RDB version %d\n", info.Version)
+		for _, field := range info.Aux {
+			fmt.Printf("[info] AUX FIELD %s = '%s'\n", field.Key, field.Value)
+		}
+		if len(info.Functions) > 0 {
+			fmt.Printf("[info] %d function libraries\n", len(info.Functions))
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("--- RDB ERROR DETECTED ---\n")
+		var parseErr *rdb.ParseError
+		if errors.As(err, &parseErr) {
+			fmt.Printf("[offset %d] %v\n", parseErr.Offset, parseErr.Err)
+		} else {
+			fmt.Printf("%v\n", err)
+		}
+		if info != nil {
+			fmt.Printf("[info] %d keys read before the error\n", info.Keys)
+		}
+		return fmt.Errorf("%s is not a valid RDB file", path)
+	}
+
+	switch {
+	case info.ChecksumVerified:
+		fmt.Printf("[info] Checksum OK\n")
+	case info.Version < 5:
+		fmt.Printf("[info] No checksum before version 5\n")
+	case info.Checksum == 0:
+		fmt.Printf("[info] Checksum not verified, the file was saved without one\n")
+	default:
+		fmt.Printf("[info] Checksum not verified\n")
+	}
+
+	fmt.Printf("[info] %d keys read, %d with an expire\n", info.Keys, expires)
+	for _, name := range typeNames {
+		if keys[name] > 0 {
+			fmt.Printf("[info] %d %s keys\n", keys[name], name)
+		}
+	}
+	fmt.Printf("\\o/ RDB looks OK! \\o/\n")
+	return nil
+}
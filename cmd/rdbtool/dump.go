@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kushalsdesk/redis_with_go/rdb"
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// dumpedKey is how a key is printed. JSON strings cannot hold bytes that are
+// not valid UTF-8, so when the name or any string of the value has some,
+// every string of the key is printed in base64 and Encoding says so.
+type dumpedKey struct {
+	DB        int         `json:"db"`
+	Key       string      `json:"key"`
+	Encoding  string      `json:"encoding,omitempty"`
+	Type      string      `json:"type"`
+	ExpiresAt *int64      `json:"expires_at_ms,omitempty"`
+	TTL       *int64      `json:"ttl_ms,omitempty"`
+	Value     interface{} `json:"value"`
+}
+
+type dumpedMember struct {
+	Member string `json:"member"`
+	Score  string `json:"score"`
+}
+
+type dumpedStream struct {
+	Length       int           `json:"length"`
+	LastID       string        `json:"last_id"`
+	EntriesAdded int64         `json:"entries_added"`
+	MaxDeletedID string        `json:"max_deleted_id"`
+	Entries      []dumpedEntry `json:"entries"`
+	Groups       []dumpedGroup `json:"groups"`
+}
+
+type dumpedEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+type dumpedGroup struct {
+	Name        string           `json:"name"`
+	LastID      string           `json:"last_id"`
+	EntriesRead int64            `json:"entries_read"`
+	Pending     []dumpedPending  `json:"pending"`
+	Consumers   []dumpedConsumer `json:"consumers"`
+}
+
+type dumpedPending struct {
+	ID            string `json:"id"`
+	Consumer      string `json:"consumer"`
+	DeliveryTime  int64  `json:"delivery_time_ms"`
+	DeliveryCount int64  `json:"delivery_count"`
+}
+
+type dumpedConsumer struct {
+	Name       string `json:"name"`
+	SeenTime   int64  `json:"seen_time_ms"`
+	ActiveTime int64  `json:"active_time_ms"`
+}
+
+// runDump prints every key of a file, as one JSON array or as one JSON
+// object per line
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	format := fs.String("format", "json", "Output format: json or ndjson")
+	path, opts := parseFlags(fs, args)
+	if *format != "json" && *format != "ndjson" {
+		return fmt.Errorf("unknown format '%s', expected json or ndjson", *format)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	now := time.Now()
+	first := true
+	if *format == "json" {
+		out.WriteString("[")
+	}
+
+	_, err := rdb.Parse(path, opts, func(kv *rdb.KeyValue) error {
+		line, err := json.Marshal(newDumpedKey(kv, now))
+		if err != nil {
+			return fmt.Errorf("failed to encode key '%s': %w", kv.Key, err)
+		}
+
+		if *format == "json" {
+			if !first {
+				out.WriteString(",")
+			}
+			out.WriteString("\n  ")
+		}
+		out.Write(line)
+		if *format == "ndjson" {
+			out.WriteString("\n")
+		}
+		first = false
+		return nil
+	})
+
+	if *format == "json" {
+		if !first {
+			out.WriteString("\n")
+		}
+		out.WriteString("]\n")
+	}
+	return err
+}
+
+// keyStrings calls fn with the name of a key and every string of its value
+func keyStrings(kv *rdb.KeyValue, fn func(s string)) {
+	fn(kv.Key)
+	switch value := kv.Value.(type) {
+	case string:
+		fn(value)
+	case []string:
+		for _, s := range value {
+			fn(s)
+		}
+	case []store.ZSetEntry:
+		for _, entry := range value {
+			fn(entry.Member)
+		}
+	case *store.Stream:
+		for _, entry := range value.Entries() {
+			for _, s := range entry.Fields {
+				fn(s)
+			}
+		}
+		for _, group := range value.Groups {
+			fn(group.Name)
+			for name := range group.Consumers {
+				fn(name)
+			}
+		}
+	}
+}
+
+// textEncoder returns how the strings of a key are printed: as they are, or
+// all in base64 when one of them is not valid UTF-8
+func textEncoder(kv *rdb.KeyValue) (func(string) string, string) {
+	binary := false
+	keyStrings(kv, func(s string) {
+		binary = binary || !utf8.ValidString(s)
+	})
+	if !binary {
+		return func(s string) string { return s }, ""
+	}
+	return func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }, "base64"
+}
+
+func encodeAll(strs []string, text func(string) string) []string {
+	encoded := make([]string, len(strs))
+	for i, s := range strs {
+		encoded[i] = text(s)
+	}
+	return encoded
+}
+
+func newDumpedKey(kv *rdb.KeyValue, now time.Time) dumpedKey {
+	text, encoding := textEncoder(kv)
+	dumped := dumpedKey{
+		DB:       kv.DB,
+		Key:      text(kv.Key),
+		Encoding: encoding,
+		Type:     kv.TypeName(),
+	}
+
+	if kv.Expiry != nil {
+		expiresAt := kv.Expiry.UnixMilli()
+		ttl := max(kv.Expiry.Sub(now).Milliseconds(), 0)
+		dumped.ExpiresAt, dumped.TTL = &expiresAt, &ttl
+	}
+
+	switch value := kv.Value.(type) {
+	case string:
+		dumped.Value = text(value)
+
+	case []string:
+		if dumped.Type != "hash" {
+			dumped.Value = encodeAll(value, text)
+			break
+		}
+		fields := make(map[string]string, len(value)/2)
+		for i := 0; i < len(value); i += 2 {
+			fields[text(value[i])] = text(value[i+1])
+		}
+		dumped.Value = fields
+
+	case []store.ZSetEntry:
+		members := make([]dumpedMember, len(value))
+		for i, entry := range value {
+			members[i] = dumpedMember{Member: text(entry.Member), Score: formatScore(entry.Score)}
+		}
+		dumped.Value = members
+
+	case *store.Stream:
+		dumped.Value = newDumpedStream(value, text)
+
+	default:
+		dumped.Value = value
+	}
+	return dumped
+}
+
+func newDumpedStream(stream *store.Stream, text func(string) string) dumpedStream {
+	dumped := dumpedStream{
+		Length:       stream.Len(),
+		LastID:       stream.LastID.String(),
+		EntriesAdded: stream.EntriesAdded,
+		MaxDeletedID: stream.MaxDeletedID.String(),
+		Entries:      make([]dumpedEntry, 0, stream.Len()),
+		Groups:       make([]dumpedGroup, 0, len(stream.Groups)),
+	}
+
+	for _, entry := range stream.Entries() {
+		dumped.Entries = append(dumped.Entries, dumpedEntry{ID: entry.ID.String(), Fields: encodeAll(entry.Fields, text)})
+	}
+
+	for _, group := range sortedGroups(stream) {
+		dumpedGroup := dumpedGroup{
+			Name:        text(group.Name),
+			LastID:      group.LastID.String(),
+			EntriesRead: group.EntriesRead,
+			Pending:     make([]dumpedPending, 0, len(group.Pending)),
+			Consumers:   make([]dumpedConsumer, 0, len(group.Consumers)),
+		}
+		for _, pending := range sortedPending(group.Pending) {
+			dumpedGroup.Pending = append(dumpedGroup.Pending, dumpedPending{
+				ID:            pending.ID.String(),
+				Consumer:      text(pending.Consumer),
+				DeliveryTime:  formatTime(pending.DeliveryTime),
+				DeliveryCount: pending.DeliveryCount,
+			})
+		}
+		for _, consumer := range sortedConsumers(group) {
+			dumpedGroup.Consumers = append(dumpedGroup.Consumers, dumpedConsumer{
+				Name:       text(consumer.Name),
+				SeenTime:   formatTime(consumer.SeenTime),
+				ActiveTime: formatTime(consumer.ActiveTime),
+			})
+		}
+		dumped.Groups = append(dumped.Groups, dumpedGroup)
+	}
+	return dumped
+}
+
+// sortedGroups returns the groups of a stream by name
+func sortedGroups(stream *store.Stream) []*store.ConsumerGroup {
+	groups := make([]*store.ConsumerGroup, 0, len(stream.Groups))
+	for _, group := range stream.Groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// sortedConsumers returns the consumers of a group by name
+func sortedConsumers(group *store.ConsumerGroup) []*store.StreamConsumer {
+	consumers := make([]*store.StreamConsumer, 0, len(group.Consumers))
+	for _, consumer := range group.Consumers {
+		consumers = append(consumers, consumer)
+	}
+	sort.Slice(consumers, func(i, j int) bool { return consumers[i].Name < consumers[j].Name })
+	return consumers
+}
+
+// sortedPending returns the entries of a PEL by ID
+func sortedPending(pel map[store.StreamID]*store.PendingEntry) []*store.PendingEntry {
+	entries := make([]*store.PendingEntry, 0, len(pel))
+	for _, pending := range pel {
+		entries = append(entries, pending)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID.Compare(entries[j].ID) < 0 })
+	return entries
+}
@@ -0,0 +1,146 @@
+// Command rdbtool inspects and converts RDB files without a server:
+//
+//	rdbtool check <file>             validate the structure and checksum
+//	rdbtool dump [flags] <file>      print keys as JSON or NDJSON
+//	rdbtool stats [flags] <file>     count keys, find the biggest, bucket TTLs
+//	rdbtool to-resp [flags] <file>   print the commands that rebuild the data
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/rdb"
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+type subcommand struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var subcommands = []subcommand{
+	{"check", "validate the structure and checksum of a file", runCheck},
+	{"dump", "print keys, types, TTLs and values as JSON or NDJSON", runDump},
+	{"stats", "count keys per type, list the biggest keys, bucket TTLs", runStats},
+	{"to-resp", "print a RESP command stream that rebuilds the data", runToRESP},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: rdbtool <command> [flags] <file>\n\nCommands:\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %-8s  %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'rdbtool <command> -h' for the flags of a command.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range subcommands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+		if err := cmd.run(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "rdbtool %s: %v\n", cmd.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if os.Args[1] != "-h" && os.Args[1] != "--help" && os.Args[1] != "help" {
+		fmt.Fprintf(os.Stderr, "rdbtool: unknown command '%s'\n\n", os.Args[1])
+	}
+	usage()
+	os.Exit(2)
+}
+
+// parseFlags parses the flags of a command, which takes a single file.
+// Every command can skip checksum verification like the server.
+func parseFlags(fs *flag.FlagSet, args []string) (string, rdb.ParseOptions) {
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: rdbtool %s [flags] <file>\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	checksum := fs.String("rdbchecksum", "yes", "Verify the checksum of the file: yes or no")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || (*checksum != "yes" && *checksum != "no") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Arg(0), rdb.ParseOptions{VerifyChecksum: *checksum == "yes"}
+}
+
+// formatScore formats a sorted set score the way Redis replies with it:
+// the shortest digits that round-trip, in plain notation unless the
+// exponent is below -4 or at least 17
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+
+	scientific := strconv.FormatFloat(score, 'e', -1, 64)
+	exp, _ := strconv.Atoi(scientific[strings.IndexByte(scientific, 'e')+1:])
+	if exp < -4 || exp >= 17 {
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// formatTime formats a time as unix milliseconds, -1 for the zero time
+func formatTime(t time.Time) int64 {
+	if t.IsZero() {
+		return -1
+	}
+	return t.UnixMilli()
+}
+
+// valueSize returns how many elements a value holds and roughly how many
+// bytes its strings take
+func valueSize(kv *rdb.KeyValue) (int, int) {
+	switch value := kv.Value.(type) {
+	case string:
+		return 1, len(value)
+
+	case []string:
+		bytes := 0
+		for _, s := range value {
+			bytes += len(s)
+		}
+		if kv.TypeName() == "hash" {
+			return len(value) / 2, bytes
+		}
+		return len(value), bytes
+
+	case []store.ZSetEntry:
+		bytes := 0
+		for _, entry := range value {
+			bytes += len(entry.Member) + 8
+		}
+		return len(value), bytes
+
+	case *store.Stream:
+		bytes := 0
+		for _, entry := range value.Entries() {
+			bytes += 16
+			for _, s := range entry.Fields {
+				bytes += len(s)
+			}
+		}
+		return value.Len(), bytes
+	}
+	return 0, 0
+}
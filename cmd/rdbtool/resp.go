@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/kushalsdesk/redis_with_go/rdb"
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// Collections are rebuilt by commands of at most this many elements
+const respBatchSize = 128
+
+// runToRESP prints the commands that rebuild the keys of a file, ready to
+// be piped into a server such as with redis-cli --pipe. Collections are
+// deleted first so the commands can be replayed, and SELECT only comes
+// up when a file has keys outside database 0. Function libraries follow as
+// FUNCTION LOAD REPLACE.
+func runToRESP(args []string) error {
+	fs := flag.NewFlagSet("to-resp", flag.ExitOnError)
+	path, opts := parseFlags(fs, args)
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	currentDB := 0
+	info, err := rdb.Parse(path, opts, func(kv *rdb.KeyValue) error {
+		if kv.DB != currentDB {
+			writeCommand(out, "SELECT", strconv.Itoa(kv.DB))
+			currentDB = kv.DB
+		}
+
+		switch value := kv.Value.(type) {
+		case string:
+			writeCommand(out, "SET", kv.Key, value)
+
+		case []string:
+			writeCommand(out, "DEL", kv.Key)
+			switch kv.TypeName() {
+			case "list":
+				writeBatches(out, []string{"RPUSH", kv.Key}, value, 1)
+			case "set":
+				writeBatches(out, []string{"SADD", kv.Key}, value, 1)
+			case "hash":
+				writeBatches(out, []string{"HSET", kv.Key}, value, 2)
+			}
+
+		case []store.ZSetEntry:
+			writeCommand(out, "DEL", kv.Key)
+			args := make([]string, 0, 2*len(value))
+			for _, entry := range value {
+				args = append(args, formatScore(entry.Score), entry.Member)
+			}
+			writeBatches(out, []string{"ZADD", kv.Key}, args, 2)
+
+		case *store.Stream:
+			writeCommand(out, "DEL", kv.Key)
+			writeStream(out, kv.Key, value)
+
+		default:
+			return fmt.Errorf("cannot convert key '%s' of type %s", kv.Key, kv.TypeName())
+		}
+
+		if kv.Expiry != nil {
+			writeCommand(out, "PEXPIREAT", kv.Key, strconv.FormatInt(kv.Expiry.UnixMilli(), 10))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, code := range info.Functions {
+		writeCommand(out, "FUNCTION", "LOAD", "REPLACE", code)
+	}
+	return nil
+}
+
+// writeStream adds the entries of a stream, restores its IDs and counters,
+// then recreates its groups with their consumers and pending entries.
+// Pending entries that were deleted from the stream cannot be recreated,
+// and consumers get fresh seen and active times.
+func writeStream(out *bufio.Writer, key string, stream *store.Stream) {
+	entries := stream.Entries()
+	for _, entry := range entries {
+		args := append([]string{"XADD", key, entry.ID.String()}, entry.Fields...)
+		writeCommand(out, args...)
+	}
+	if len(entries) == 0 {
+		// An empty stream still needs a key for XSETID
+		writeCommand(out, "XADD", key, "MAXLEN", "0", "0-1", "_", "_")
+	}
+
+	writeCommand(out, "XSETID", key, stream.LastID.String(),
+		"ENTRIESADDED", strconv.FormatInt(stream.EntriesAdded, 10),
+		"MAXDELETEDID", stream.MaxDeletedID.String())
+
+	for _, group := range sortedGroups(stream) {
+		args := []string{"XGROUP", "CREATE", key, group.Name, group.LastID.String()}
+		if group.EntriesRead >= 0 {
+			args = append(args, "ENTRIESREAD", strconv.FormatInt(group.EntriesRead, 10))
+		}
+		writeCommand(out, args...)
+
+		for _, consumer := range sortedConsumers(group) {
+			writeCommand(out, "XGROUP", "CREATECONSUMER", key, group.Name, consumer.Name)
+		}
+		for _, pending := range sortedPending(group.Pending) {
+			writeCommand(out, "XCLAIM", key, group.Name, pending.Consumer, "0", pending.ID.String(),
+				"TIME", strconv.FormatInt(pending.DeliveryTime.UnixMilli(), 10),
+				"RETRYCOUNT", strconv.FormatInt(pending.DeliveryCount, 10),
+				"FORCE", "JUSTID")
+		}
+	}
+}
+
+// writeBatches writes prefix followed by args, split in commands of at
+// most respBatchSize groups of size arguments
+func writeBatches(out *bufio.Writer, prefix, args []string, size int) {
+	step := respBatchSize * size
+	for start := 0; start < len(args); start += step {
+		end := min(start+step, len(args))
+		writeCommand(out, append(prefix[:len(prefix):len(prefix)], args[start:end]...)...)
+	}
+}
+
+// writeCommand writes a command as a RESP array of bulk strings
+func writeCommand(out *bufio.Writer, args ...string) {
+	fmt.Fprintf(out, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(out, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
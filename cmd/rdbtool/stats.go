@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/rdb"
+)
+
+var typeNames = []string{"string", "list", "set", "zset", "hash", "stream"}
+
+// ttlBuckets are the upper bounds of the expiry histogram
+var ttlBuckets = []struct {
+	label string
+	limit time.Duration
+}{
+	{"< 1m", time.Minute},
+	{"< 1h", time.Hour},
+	{"< 1d", 24 * time.Hour},
+	{"< 7d", 7 * 24 * time.Hour},
+	{"< 30d", 30 * 24 * time.Hour},
+}
+
+type typeStats struct {
+	keys     int
+	elements int
+	bytes    int
+}
+
+type bigKey struct {
+	db       int
+	key      string
+	typeName string
+	elements int
+	bytes    int
+}
+
+// runStats reads a whole file and prints key counts per type, the biggest
+// keys by the bytes their strings take and how far off their expires are
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	top := fs.Int("top", 10, "How many of the biggest keys to list")
+	path, opts := parseFlags(fs, args)
+
+	now := time.Now()
+	types := make(map[string]*typeStats)
+	var biggest []bigKey
+	dbs := make(map[int]int)
+	noExpiry, expired := 0, 0
+	ttls := make([]int, len(ttlBuckets)+1)
+
+	info, err := rdb.Parse(path, opts, func(kv *rdb.KeyValue) error {
+		name := kv.TypeName()
+		elements, bytes := valueSize(kv)
+
+		stats := types[name]
+		if stats == nil {
+			stats = &typeStats{}
+			types[name] = stats
+		}
+		stats.keys++
+		stats.elements += elements
+		stats.bytes += bytes
+		dbs[kv.DB]++
+
+		// Keep the top biggest, sorted biggest first
+		if *top > 0 {
+			i := sort.Search(len(biggest), func(i int) bool { return biggest[i].bytes < bytes })
+			if i < *top {
+				biggest = append(biggest, bigKey{})
+				copy(biggest[i+1:], biggest[i:])
+				biggest[i] = bigKey{db: kv.DB, key: kv.Key, typeName: name, elements: elements, bytes: bytes}
+				if len(biggest) > *top {
+					biggest = biggest[:*top]
+				}
+			}
+		}
+
+		switch {
+		case kv.Expiry == nil:
+			noExpiry++
+		case !kv.Expiry.After(now):
+			expired++
+		default:
+			ttl := kv.Expiry.Sub(now)
+			bucket := len(ttlBuckets)
+			for i, b := range ttlBuckets {
+				if ttl < b.limit {
+					bucket = i
+					break
+				}
+			}
+			ttls[bucket]++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("RDB version %d, %d keys\n", info.Version, info.Keys)
+	dbNumbers := make([]int, 0, len(dbs))
+	for db := range dbs {
+		dbNumbers = append(dbNumbers, db)
+	}
+	sort.Ints(dbNumbers)
+	for _, db := range dbNumbers {
+		fmt.Printf("  db%d: %d keys\n", db, dbs[db])
+	}
+
+	fmt.Printf("\nKeys by type:\n")
+	fmt.Printf("  %-8s %10s %12s %14s\n", "type", "keys", "elements", "bytes")
+	for _, name := range typeNames {
+		if stats := types[name]; stats != nil {
+			fmt.Printf("  %-8s %10d %12d %14d\n", name, stats.keys, stats.elements, stats.bytes)
+		}
+	}
+
+	if len(biggest) > 0 {
+		fmt.Printf("\nBiggest keys:\n")
+		fmt.Printf("  %-8s %12s %14s  %s\n", "type", "elements", "bytes", "key")
+		for _, big := range biggest {
+			fmt.Printf("  %-8s %12d %14d  db%d:%q\n", big.typeName, big.elements, big.bytes, big.db, big.key)
+		}
+	}
+
+	fmt.Printf("\nExpires:\n")
+	fmt.Printf("  %-10s %10d\n", "none", noExpiry)
+	fmt.Printf("  %-10s %10d\n", "expired", expired)
+	for i, b := range ttlBuckets {
+		fmt.Printf("  %-10s %10d\n", b.label, ttls[i])
+	}
+	fmt.Printf("  %-10s %10d\n", ">= 30d", ttls[len(ttlBuckets)])
+	return nil
+}
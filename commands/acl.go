@@ -0,0 +1,507 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Clients authenticate as ACL users. A user has passwords and the
+// permissions of its root selector and of any further selectors, and runs
+// a command when one selector allows both the command and all its keys.
+// The default user starts as "on nopass ~* &* +@all" and takes the
+// password of requirepass.
+
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords []string // SHA-256 digests in hex
+	root      *aclSelector
+	selectors []*aclSelector
+}
+
+// aclSelector is a set of permissions. Command rules apply in order, the
+// last one that matches a command decides.
+type aclSelector struct {
+	commands []commandRule
+	keys     []keyPattern
+	channels []string
+}
+
+type commandRule struct {
+	allow bool
+	name  string // "command", "command|subcommand" or "@category", lowercase
+}
+
+type keyPattern struct {
+	access  keyAccess
+	pattern string
+}
+
+// aclDenial is why a command is refused. When no selector allows a
+// command the highest one is reported, like in Redis.
+type aclDenial int
+
+const (
+	aclAllowed aclDenial = iota
+	aclDeniedCommand
+	aclDeniedKey
+	aclDeniedAuth
+)
+
+var (
+	aclMutex sync.RWMutex
+	aclUsers = map[string]*aclUser{"default": newDefaultUser()}
+)
+
+func newDefaultUser() *aclUser {
+	u := newUser("default")
+	u.enabled, u.nopass = true, true
+	u.root.keys = []keyPattern{{keyReadWrite, "*"}}
+	u.root.channels = []string{"*"}
+	u.root.commands = []commandRule{{true, "@all"}}
+	return u
+}
+
+// newUser returns a user that is off, has no password and can do nothing
+func newUser(name string) *aclUser {
+	return &aclUser{name: name, root: &aclSelector{}}
+}
+
+// clone returns a copy of u that rules can change without touching u
+func (u *aclUser) clone() *aclUser {
+	c := *u
+	c.passwords = append([]string(nil), u.passwords...)
+	c.root = u.root.clone()
+	c.selectors = make([]*aclSelector, len(u.selectors))
+	for i, sel := range u.selectors {
+		c.selectors[i] = sel.clone()
+	}
+	return &c
+}
+
+func (sel *aclSelector) clone() *aclSelector {
+	return &aclSelector{
+		commands: append([]commandRule(nil), sel.commands...),
+		keys:     append([]keyPattern(nil), sel.keys...),
+		channels: append([]string(nil), sel.channels...),
+	}
+}
+
+// mergeSelectorArgs joins the rules of a selector that clients sent as
+// several arguments, like "(~foo" "+get)", back into one
+func mergeSelectorArgs(rules []string) ([]string, error) {
+	var merged []string
+	for i := 0; i < len(rules); i++ {
+		rule := rules[i]
+		if !strings.HasPrefix(rule, "(") || strings.HasSuffix(rule, ")") {
+			merged = append(merged, rule)
+			continue
+		}
+		start := i
+		for !strings.HasSuffix(rule, ")") {
+			i++
+			if i == len(rules) {
+				return nil, fmt.Errorf("Unmatched parenthesis in acl selector starting at '%s'.", rules[start])
+			}
+			rule += " " + rules[i]
+		}
+		merged = append(merged, rule)
+	}
+	return merged, nil
+}
+
+// setRule applies one ACL SETUSER rule to u
+func (u *aclUser) setRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass, u.passwords = true, nil
+		return nil
+	case "resetpass":
+		u.nopass, u.passwords = false, nil
+		return nil
+	case "clearselectors":
+		u.selectors = nil
+		return nil
+	case "reset":
+		u.enabled, u.nopass, u.passwords = false, false, nil
+		u.root, u.selectors = &aclSelector{}, nil
+		return nil
+	}
+
+	switch {
+	case rule == "":
+		return errors.New("Syntax error")
+	case rule[0] == '>' || rule[0] == '#':
+		digest, err := passwordDigest(rule)
+		if err != nil {
+			return err
+		}
+		u.nopass = false
+		for _, p := range u.passwords {
+			if p == digest {
+				return nil
+			}
+		}
+		u.passwords = append(u.passwords, digest)
+		return nil
+	case rule[0] == '<' || rule[0] == '!':
+		digest, err := passwordDigest(rule)
+		if err != nil {
+			return err
+		}
+		for i, p := range u.passwords {
+			if p == digest {
+				u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+				return nil
+			}
+		}
+		return errors.New("The password you are trying to remove from the user does not exist")
+	case rule[0] == '(':
+		if !strings.HasSuffix(rule, ")") {
+			return fmt.Errorf("Unmatched parenthesis in acl selector starting at '%s'.", rule)
+		}
+		sel := &aclSelector{}
+		for _, r := range strings.Fields(rule[1 : len(rule)-1]) {
+			if err := sel.setRule(r); err != nil {
+				return err
+			}
+		}
+		u.selectors = append(u.selectors, sel)
+		return nil
+	}
+	return u.root.setRule(rule)
+}
+
+// passwordDigest returns the digest of a >password or <password rule, or
+// checks the one of a #hash or !hash rule
+func passwordDigest(rule string) (string, error) {
+	if rule[0] == '>' || rule[0] == '<' {
+		return hashPassword(rule[1:]), nil
+	}
+	digest := rule[1:]
+	if len(digest) != sha256.Size*2 || strings.Trim(digest, "0123456789abcdef") != "" {
+		return "", errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	}
+	return digest, nil
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// setRule applies a rule about commands, keys or channels to sel
+func (sel *aclSelector) setRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "allkeys":
+		sel.keys = []keyPattern{{keyReadWrite, "*"}}
+		return nil
+	case "resetkeys":
+		sel.keys = nil
+		return nil
+	case "allchannels":
+		sel.channels = []string{"*"}
+		return nil
+	case "resetchannels":
+		sel.channels = nil
+		return nil
+	case "allcommands":
+		return sel.addCommandRule(true, "@all")
+	case "nocommands":
+		return sel.addCommandRule(false, "@all")
+	}
+
+	switch rule[0] {
+	case '~', '%':
+		access, pattern := keyReadWrite, rule[1:]
+		if rule[0] == '%' {
+			flags, rest, found := strings.Cut(rule[1:], "~")
+			if !found || flags == "" {
+				return errors.New("Syntax error")
+			}
+			access = 0
+			for _, flag := range strings.ToUpper(flags) {
+				switch flag {
+				case 'R':
+					access |= keyRead
+				case 'W':
+					access |= keyWrite
+				default:
+					return errors.New("Syntax error")
+				}
+			}
+			pattern = rest
+		}
+		if len(sel.keys) > 0 && sel.keys[0].pattern == "*" && sel.keys[0].access == keyReadWrite {
+			return errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns")
+		}
+		if pattern == "*" && access == keyReadWrite {
+			sel.keys = nil
+		}
+		sel.keys = append(sel.keys, keyPattern{access, pattern})
+		return nil
+	case '&':
+		if len(sel.channels) > 0 && sel.channels[0] == "*" {
+			return errors.New("Adding a pattern after the * pattern (or the 'allchannels' flag) is not valid and does not have any effect. Try 'resetchannels' to start with an empty list of channels")
+		}
+		if rule[1:] == "*" {
+			sel.channels = nil
+		}
+		sel.channels = append(sel.channels, rule[1:])
+		return nil
+	case '+', '-':
+		return sel.addCommandRule(rule[0] == '+', strings.ToLower(rule[1:]))
+	}
+	return errors.New("Syntax error")
+}
+
+// addCommandRule adds +name or -name, dropping the rules it overrides
+func (sel *aclSelector) addCommandRule(allow bool, name string) error {
+	if !validCommandRule(name) {
+		return errors.New("Unknown command or category name in ACL")
+	}
+	if name == "@all" {
+		sel.commands = nil
+	}
+	rules := sel.commands[:0]
+	for _, r := range sel.commands {
+		if r.name != name {
+			rules = append(rules, r)
+		}
+	}
+	sel.commands = append(rules, commandRule{allow, name})
+	return nil
+}
+
+func validCommandRule(name string) bool {
+	if category, isCategory := strings.CutPrefix(name, "@"); isCategory {
+		return category == "all" || categoryByName(category) != 0
+	}
+	command, subcommand, hasSub := strings.Cut(name, "|")
+	info, exists := commandTable[strings.ToUpper(command)]
+	if !exists {
+		return false
+	}
+	return !hasSub || (subcommand != "" && info.flags&flagContainer != 0)
+}
+
+func categoryByName(name string) aclCategories {
+	for i, n := range aclCategoryNames {
+		if n == name {
+			return 1 << i
+		}
+	}
+	return 0
+}
+
+// describe returns the rules that recreate u, as ACL LIST shows them
+func (u *aclUser) describe() string {
+	parts := []string{"user", u.name}
+	if u.enabled {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if u.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, p := range u.passwords {
+		parts = append(parts, "#"+p)
+	}
+	parts = append(parts, u.root.describe())
+	for _, sel := range u.selectors {
+		parts = append(parts, "("+sel.describe()+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+func (sel *aclSelector) describe() string {
+	var parts []string
+	if keys := sel.describeKeys(); keys != "" {
+		parts = append(parts, keys)
+	}
+	parts = append(parts, sel.describeChannels(), sel.describeCommands())
+	return strings.Join(parts, " ")
+}
+
+func (sel *aclSelector) describeKeys() string {
+	patterns := make([]string, len(sel.keys))
+	for i, k := range sel.keys {
+		switch k.access {
+		case keyRead:
+			patterns[i] = "%R~" + k.pattern
+		case keyWrite:
+			patterns[i] = "%W~" + k.pattern
+		default:
+			patterns[i] = "~" + k.pattern
+		}
+	}
+	return strings.Join(patterns, " ")
+}
+
+func (sel *aclSelector) describeChannels() string {
+	if len(sel.channels) == 0 {
+		return "resetchannels"
+	}
+	patterns := make([]string, len(sel.channels))
+	for i, c := range sel.channels {
+		patterns[i] = "&" + c
+	}
+	return strings.Join(patterns, " ")
+}
+
+func (sel *aclSelector) describeCommands() string {
+	rules := make([]string, 0, len(sel.commands)+1)
+	if len(sel.commands) == 0 || sel.commands[0].name != "@all" {
+		rules = append(rules, "-@all")
+	}
+	for _, r := range sel.commands {
+		if r.allow {
+			rules = append(rules, "+"+r.name)
+		} else {
+			rules = append(rules, "-"+r.name)
+		}
+	}
+	return strings.Join(rules, " ")
+}
+
+// check tells whether u may run args, and what was refused when not: the
+// command's name or a key
+func (u *aclUser) check(info commandInfo, args []string) (aclDenial, string) {
+	denial, object := u.root.check(info, args)
+	for _, sel := range u.selectors {
+		if denial == aclAllowed {
+			break
+		}
+		if d, o := sel.check(info, args); d == aclAllowed || d > denial {
+			denial, object = d, o
+		}
+	}
+	return denial, object
+}
+
+func (sel *aclSelector) check(info commandInfo, args []string) (aclDenial, string) {
+	if name := fullCommandName(info, args); !sel.allowsCommand(name, commandCategories(info, name)) {
+		return aclDeniedCommand, name
+	}
+	for _, key := range info.commandKeys(args) {
+		if !sel.allowsKey(args[key.pos], key.access) {
+			return aclDeniedKey, args[key.pos]
+		}
+	}
+	return aclAllowed, ""
+}
+
+func (sel *aclSelector) allowsCommand(name string, categories aclCategories) bool {
+	command, _, _ := strings.Cut(name, "|")
+	allowed := false
+	for _, r := range sel.commands {
+		var matches bool
+		if category, isCategory := strings.CutPrefix(r.name, "@"); isCategory {
+			matches = category == "all" || categories&categoryByName(category) != 0
+		} else {
+			matches = r.name == command || r.name == name
+		}
+		if matches {
+			allowed = r.allow
+		}
+	}
+	return allowed
+}
+
+// allowsKey tells whether one pattern grants all the access to key
+func (sel *aclSelector) allowsKey(key string, access keyAccess) bool {
+	for _, k := range sel.keys {
+		if k.access&access == access && stringMatch(k.pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// fullCommandName is the lowercase name ACL rules use for a command, with
+// the subcommand of a container command
+func fullCommandName(info commandInfo, args []string) string {
+	name := strings.ToLower(args[0])
+	if info.flags&flagContainer != 0 && len(args) > 1 {
+		name += "|" + strings.ToLower(args[1])
+	}
+	return name
+}
+
+func commandCategories(info commandInfo, name string) aclCategories {
+	if categories, exists := subcommandCategories[strings.ToUpper(name)]; exists {
+		return categories
+	}
+	return info.aclCategories()
+}
+
+// authenticate returns the user that username and password log in as, nil
+// when the user does not exist, is off or has another password
+func authenticate(username, password string) *aclUser {
+	aclMutex.RLock()
+	defer aclMutex.RUnlock()
+
+	u := aclUsers[username]
+	if u == nil || !u.enabled {
+		return nil
+	}
+	if u.nopass {
+		return u
+	}
+	digest := hashPassword(password)
+	for _, p := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(p), []byte(digest)) == 1 {
+			return u
+		}
+	}
+	return nil
+}
+
+// defaultUserOpen returns the default user when new clients are logged in
+// as it without AUTH, nil when they have to AUTH
+func defaultUserOpen() *aclUser {
+	aclMutex.RLock()
+	defer aclMutex.RUnlock()
+
+	if u := aclUsers["default"]; u.enabled && u.nopass {
+		return u
+	}
+	return nil
+}
+
+// DefaultUserHasNoPassword tells whether the default user takes any
+// password, which is when protected mode refuses clients from other hosts
+func DefaultUserHasNoPassword() bool {
+	aclMutex.RLock()
+	defer aclMutex.RUnlock()
+	return aclUsers["default"].nopass
+}
+
+// InitACL gives the default user the password of requirepass and then
+// loads the users of the ACL file, if there is one
+func InitACL(requirePass, aclFile string) error {
+	if requirePass != "" {
+		aclMutex.Lock()
+		u := aclUsers["default"]
+		u.setRule("resetpass")
+		u.setRule(">" + requirePass)
+		aclMutex.Unlock()
+	}
+	if aclFile == "" {
+		return nil
+	}
+	return loadACLFile(aclFile)
+}
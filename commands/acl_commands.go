@@ -0,0 +1,512 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// noACLFileReply answers ACL SAVE and ACL LOAD without --aclfile
+const noACLFileReply = "-ERR This Redis instance is not configured to use an ACL file. " +
+	"You may want to specify users via the ACL SETUSER command and then restart the server with '--aclfile <path>' to keep them in a file.\r\n"
+
+// aclLogMaxLen is how many entries ACL LOG keeps, like acllog-max-len
+const aclLogMaxLen = 128
+
+// aclLogGrouping is how long a denial counts as a repeat of an entry with
+// the same reason, context, object and user
+const aclLogGrouping = 60 * time.Second
+
+// aclLogEntry records commands and logins that were refused
+type aclLogEntry struct {
+	count      int
+	reason     string
+	context    string // toplevel, multi or lua
+	object     string // the command, key or AUTH
+	username   string
+	clientInfo string
+	entryID    int64
+	created    time.Time
+	updated    time.Time
+}
+
+var (
+	aclLog         []*aclLogEntry // newest first
+	aclLogMutex    sync.Mutex
+	nextACLEntryID int64
+)
+
+// aclCheck returns why the user of conn may not run args, "" when it may.
+// Connections that are not clients, like the replication stream, run
+// anything, and commands that do not exist or have the wrong number of
+// arguments are left to fail on their own. Denials are logged for ACL LOG.
+func aclCheck(conn net.Conn, args []string, context string) string {
+	info := lookupClient(conn)
+	if info == nil {
+		return ""
+	}
+	u := info.user.Load()
+	command, exists := commandTable[strings.ToUpper(args[0])]
+	if u == nil || !exists || !command.acceptsArgs(len(args)) || strings.EqualFold(args[0], "AUTH") {
+		return ""
+	}
+
+	aclMutex.RLock()
+	denial, object := u.check(command, args)
+	username := u.name
+	aclMutex.RUnlock()
+
+	switch denial {
+	case aclDeniedCommand:
+		addACLLogEntry(conn, denial, context, object, username)
+		return fmt.Sprintf("User %s has no permissions to run the '%s' command", username, object)
+	case aclDeniedKey:
+		addACLLogEntry(conn, denial, context, object, username)
+		return "No permissions to access a key"
+	}
+	return ""
+}
+
+func addACLLogEntry(conn net.Conn, denial aclDenial, context, object, username string) {
+	reason := map[aclDenial]string{
+		aclDeniedCommand: "command",
+		aclDeniedKey:     "key",
+		aclDeniedAuth:    "auth",
+	}[denial]
+	clientInfo := describeClient(callerOf(conn))
+	now := time.Now()
+
+	aclLogMutex.Lock()
+	defer aclLogMutex.Unlock()
+
+	for i, entry := range aclLog {
+		if entry.reason == reason && entry.context == context && entry.object == object &&
+			entry.username == username && now.Sub(entry.updated) < aclLogGrouping {
+			entry.count++
+			entry.updated = now
+			entry.clientInfo = clientInfo
+			copy(aclLog[1:i+1], aclLog[:i])
+			aclLog[0] = entry
+			return
+		}
+	}
+
+	entry := &aclLogEntry{
+		count:      1,
+		reason:     reason,
+		context:    context,
+		object:     object,
+		username:   username,
+		clientInfo: clientInfo,
+		entryID:    nextACLEntryID,
+		created:    now,
+		updated:    now,
+	}
+	nextACLEntryID++
+	aclLog = append([]*aclLogEntry{entry}, aclLog...)
+	if len(aclLog) > aclLogMaxLen {
+		aclLog = aclLog[:aclLogMaxLen]
+	}
+}
+
+// describeClient is the client-info of ACL LOG entries
+func describeClient(conn net.Conn) string {
+	info := lookupClient(conn)
+	if info == nil {
+		return ""
+	}
+	username := ""
+	if u := info.user.Load(); u != nil {
+		username = u.name
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s user=%s", info.id, conn.RemoteAddr(), conn.LocalAddr(), username)
+}
+
+// aclSubcommandArgs are the least and most arguments of the ACL
+// subcommands, 0 for no most
+var aclSubcommandArgs = map[string][2]int{
+	"SETUSER": {3, 0},
+	"GETUSER": {3, 3},
+	"DELUSER": {3, 0},
+	"LIST":    {2, 2},
+	"USERS":   {2, 2},
+	"WHOAMI":  {2, 2},
+	"CAT":     {2, 3},
+	"LOG":     {2, 3},
+	"SAVE":    {2, 2},
+	"LOAD":    {2, 2},
+}
+
+func handleACL(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'acl' command\r\n"))
+		return
+	}
+
+	subcommand := strings.ToUpper(args[1])
+	if limits, known := aclSubcommandArgs[subcommand]; known && (len(args) < limits[0] || limits[1] > 0 && len(args) > limits[1]) {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for 'acl|%s' command\r\n", strings.ToLower(args[1]))))
+		return
+	}
+
+	switch subcommand {
+	case "SETUSER":
+		handleACLSetUser(args, conn)
+	case "GETUSER":
+		handleACLGetUser(args, conn)
+	case "DELUSER":
+		handleACLDelUser(args, conn)
+	case "LIST", "USERS":
+		aclMutex.RLock()
+		users := sortedUsers()
+		resp := fmt.Sprintf("*%d\r\n", len(users))
+		for _, u := range users {
+			if subcommand == "LIST" {
+				resp += respBulk(u.describe())
+			} else {
+				resp += respBulk(u.name)
+			}
+		}
+		aclMutex.RUnlock()
+		conn.Write([]byte(resp))
+	case "WHOAMI":
+		username := "default"
+		if info := lookupClient(callerOf(conn)); info != nil {
+			username = info.user.Load().name
+		}
+		writeBulkString(conn, username)
+	case "CAT":
+		handleACLCat(args, conn)
+	case "LOG":
+		handleACLLog(args, conn)
+	case "SAVE":
+		path := store.GetConfig().ACLFile
+		if path == "" {
+			conn.Write([]byte(noACLFileReply))
+			return
+		}
+		if err := saveACLFile(path); err != nil {
+			fmt.Printf("❌ Failed to save ACL file %s: %v\n", path, err)
+			conn.Write([]byte("-ERR There was an error trying to save the ACLs. Please check the server logs for more information\r\n"))
+			return
+		}
+		conn.Write([]byte("+OK\r\n"))
+	case "LOAD":
+		path := store.GetConfig().ACLFile
+		if path == "" {
+			conn.Write([]byte(noACLFileReply))
+			return
+		}
+		if err := loadACLFile(path); err != nil {
+			conn.Write([]byte("-ERR " + err.Error() + "\r\n"))
+			return
+		}
+		conn.Write([]byte("+OK\r\n"))
+	default:
+		conn.Write([]byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try ACL HELP.\r\n", args[1])))
+	}
+}
+
+// handleACLSetUser is ACL SETUSER username [rule ...]. The rules apply to
+// a copy of the user, which replaces it only when they are all valid.
+func handleACLSetUser(args []string, conn net.Conn) {
+	name := args[2]
+	if strings.ContainsAny(name, " \x00") {
+		conn.Write([]byte("-ERR Usernames can't contain spaces or null characters\r\n"))
+		return
+	}
+	rules, err := mergeSelectorArgs(args[3:])
+	if err != nil {
+		conn.Write([]byte("-ERR " + err.Error() + "\r\n"))
+		return
+	}
+
+	aclMutex.Lock()
+	defer aclMutex.Unlock()
+
+	existing := aclUsers[name]
+	u := newUser(name)
+	if existing != nil {
+		u = existing.clone()
+	}
+	for _, rule := range rules {
+		if err := u.setRule(rule); err != nil {
+			conn.Write([]byte(fmt.Sprintf("-ERR Error in ACL SETUSER modifier '%s': %s\r\n", rule, err)))
+			return
+		}
+	}
+
+	// Clients logged in as the user keep pointing at it
+	if existing != nil {
+		*existing = *u
+	} else {
+		aclUsers[name] = u
+	}
+	conn.Write([]byte("+OK\r\n"))
+}
+
+func handleACLGetUser(args []string, conn net.Conn) {
+	aclMutex.RLock()
+	defer aclMutex.RUnlock()
+
+	u := aclUsers[args[2]]
+	if u == nil {
+		conn.Write([]byte("*-1\r\n"))
+		return
+	}
+
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+
+	resp := "*12\r\n" + respBulk("flags") + respBulks(flags) +
+		respBulk("passwords") + respBulks(u.passwords) +
+		describeSelectorFields(u.root) +
+		respBulk("selectors") + fmt.Sprintf("*%d\r\n", len(u.selectors))
+	for _, sel := range u.selectors {
+		resp += "*6\r\n" + describeSelectorFields(sel)
+	}
+	conn.Write([]byte(resp))
+}
+
+// describeSelectorFields returns the commands, keys and channels fields of
+// ACL GETUSER for a selector
+func describeSelectorFields(sel *aclSelector) string {
+	channels := ""
+	if len(sel.channels) > 0 {
+		channels = sel.describeChannels()
+	}
+	return respBulk("commands") + respBulk(sel.describeCommands()) +
+		respBulk("keys") + respBulk(sel.describeKeys()) +
+		respBulk("channels") + respBulk(channels)
+}
+
+func respBulks(values []string) string {
+	resp := fmt.Sprintf("*%d\r\n", len(values))
+	for _, v := range values {
+		resp += respBulk(v)
+	}
+	return resp
+}
+
+// handleACLDelUser is ACL DELUSER username [username ...]. The clients
+// logged in as the removed users are disconnected.
+func handleACLDelUser(args []string, conn net.Conn) {
+	for _, name := range args[2:] {
+		if name == "default" {
+			conn.Write([]byte("-ERR The 'default' user cannot be removed\r\n"))
+			return
+		}
+	}
+
+	removed := make(map[*aclUser]bool)
+	aclMutex.Lock()
+	for _, name := range args[2:] {
+		if u, exists := aclUsers[name]; exists {
+			removed[u] = true
+			delete(aclUsers, name)
+		}
+	}
+	aclMutex.Unlock()
+
+	writeInteger(conn, int64(len(removed)))
+	disconnectUsers(removed)
+}
+
+// disconnectUsers closes the connections of the clients logged in as one
+// of users
+func disconnectUsers(users map[*aclUser]bool) {
+	if len(users) == 0 {
+		return
+	}
+	clientsMutex.RLock()
+	var conns []net.Conn
+	for conn, info := range clients {
+		if users[info.user.Load()] {
+			conns = append(conns, conn)
+		}
+	}
+	clientsMutex.RUnlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// sortedUsers returns the users by name, the caller holds aclMutex
+func sortedUsers() []*aclUser {
+	users := make([]*aclUser, 0, len(aclUsers))
+	for _, u := range aclUsers {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].name < users[j].name })
+	return users
+}
+
+// handleACLCat is ACL CAT [category], the categories or the commands in one
+func handleACLCat(args []string, conn net.Conn) {
+	if len(args) == 2 {
+		conn.Write([]byte(respBulks(aclCategoryNames)))
+		return
+	}
+
+	category := categoryByName(strings.ToLower(args[2]))
+	if category == 0 {
+		conn.Write([]byte(fmt.Sprintf("-ERR Unknown category '%s'\r\n", args[2])))
+		return
+	}
+	var names []string
+	for name, info := range commandTable {
+		if info.aclCategories()&category != 0 {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	for name, categories := range subcommandCategories {
+		if categories&category != 0 {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	sort.Strings(names)
+	conn.Write([]byte(respBulks(names)))
+}
+
+// handleACLLog is ACL LOG [count | RESET]
+func handleACLLog(args []string, conn net.Conn) {
+	count := 10
+	if len(args) == 3 {
+		if strings.EqualFold(args[2], "RESET") {
+			aclLogMutex.Lock()
+			aclLog = nil
+			aclLogMutex.Unlock()
+			conn.Write([]byte("+OK\r\n"))
+			return
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+			return
+		}
+		count = n
+	}
+
+	aclLogMutex.Lock()
+	defer aclLogMutex.Unlock()
+
+	entries := aclLog[:min(count, len(aclLog))]
+	now := time.Now()
+	resp := fmt.Sprintf("*%d\r\n", len(entries))
+	for _, entry := range entries {
+		age := float64(now.Sub(entry.created).Milliseconds()) / 1000
+		resp += "*20\r\n" +
+			respBulk("count") + respInt(int64(entry.count)) +
+			respBulk("reason") + respBulk(entry.reason) +
+			respBulk("context") + respBulk(entry.context) +
+			respBulk("object") + respBulk(entry.object) +
+			respBulk("username") + respBulk(entry.username) +
+			respBulk("age-seconds") + respBulk(strconv.FormatFloat(age, 'f', 3, 64)) +
+			respBulk("client-info") + respBulk(entry.clientInfo) +
+			respBulk("entry-id") + respInt(entry.entryID) +
+			respBulk("timestamp-created") + respInt(entry.created.UnixMilli()) +
+			respBulk("timestamp-last-updated") + respInt(entry.updated.UnixMilli())
+	}
+	conn.Write([]byte(resp))
+}
+
+// saveACLFile writes the users the way ACL LIST shows them, one per line
+func saveACLFile(path string) error {
+	var content strings.Builder
+	aclMutex.RLock()
+	for _, u := range sortedUsers() {
+		content.WriteString(u.describe() + "\n")
+	}
+	aclMutex.RUnlock()
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content.String()), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadACLFile replaces the users with the ones of an ACL file, or changes
+// nothing when the file has an error. Users that are still there keep
+// their clients, the clients of the others are disconnected. A file
+// without a default user leaves it as it is.
+func loadACLFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Error loading ACLs, opening file '%s': %v", path, err)
+	}
+
+	loaded := make(map[string]*aclUser)
+	var problems []string
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			problems = append(problems, fmt.Sprintf("%s:%d: line should start with user keyword", path, i+1))
+			continue
+		}
+		name := fields[1]
+		if loaded[name] != nil {
+			problems = append(problems, fmt.Sprintf("%s:%d: Duplicate user '%s' found", path, i+1, name))
+			continue
+		}
+		u, err := parseUserRules(name, fields[2:])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s:%d: %v", path, i+1, err))
+			continue
+		}
+		loaded[name] = u
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, ". ") + ". WARNING: ACL errors detected, no change to the currently active ACL rules was performed")
+	}
+	removed := make(map[*aclUser]bool)
+	aclMutex.Lock()
+	if loaded["default"] == nil {
+		loaded["default"] = aclUsers["default"].clone()
+	}
+	for name, u := range aclUsers {
+		if replacement := loaded[name]; replacement != nil {
+			*u = *replacement
+			loaded[name] = u
+		} else {
+			removed[u] = true
+		}
+	}
+	aclUsers = loaded
+	aclMutex.Unlock()
+
+	disconnectUsers(removed)
+	return nil
+}
+
+// parseUserRules returns a new user with the rules of an ACL file line
+func parseUserRules(name string, rules []string) (*aclUser, error) {
+	rules, err := mergeSelectorArgs(rules)
+	if err != nil {
+		return nil, err
+	}
+	u := newUser(name)
+	for _, rule := range rules {
+		if err := u.setRule(rule); err != nil {
+			return nil, fmt.Errorf("Error in applying operation '%s': %v", rule, err)
+		}
+	}
+	return u, nil
+}
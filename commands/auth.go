@@ -0,0 +1,55 @@
+package commands
+
+import "net"
+
+// A connection runs nothing but AUTH until it has logged in as an ACL
+// user. New connections are logged in as the default user while that one
+// needs no password, which is the case without requirepass.
+
+const (
+	noAuthReply    = "-NOAUTH Authentication required.\r\n"
+	wrongPassReply = "-WRONGPASS invalid username-password pair or user is disabled.\r\n"
+)
+
+// authRequired tells whether conn has to AUTH before running command.
+// Commands that do not come from a client connection never do.
+func authRequired(conn net.Conn, command string) bool {
+	if command == "AUTH" {
+		return false
+	}
+	info := lookupClient(conn)
+	return info != nil && info.user.Load() == nil
+}
+
+// handleAuth is AUTH password, which logs in as the default user, and
+// AUTH username password
+func handleAuth(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'auth' command\r\n"))
+		return
+	}
+	if len(args) > 3 {
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+
+	username, password := "default", args[len(args)-1]
+	if len(args) == 3 {
+		username = args[1]
+	} else if DefaultUserHasNoPassword() {
+		conn.Write([]byte("-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n"))
+		return
+	}
+
+	// A user without password takes any
+	u := authenticate(username, password)
+	if u == nil {
+		addACLLogEntry(conn, aclDeniedAuth, "toplevel", "AUTH", username)
+		conn.Write([]byte(wrongPassReply))
+		return
+	}
+	if info := lookupClient(callerOf(conn)); info != nil {
+		info.user.Store(u)
+	}
+	conn.Write([]byte("+OK\r\n"))
+}
@@ -3,9 +3,12 @@ package commands
 import (
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/kushalsdesk/redis_with_go/rdb"
 	"github.com/kushalsdesk/redis_with_go/store"
 )
 
@@ -65,6 +68,57 @@ func handleConfigGet(args []string, conn net.Conn) {
 
 }
 
+// saveRDB writes the keyspace and the function libraries to the RDB file
+// the server loads at startup
+func saveRDB() error {
+	config := store.GetConfig()
+	path := filepath.Join(config.Dir, config.DBFilename)
+	if err := rdb.Save(path, libraryCodes()); err != nil {
+		return err
+	}
+	fmt.Printf("💾 DB saved on disk at %s\n", path)
+	return nil
+}
+
+func handleSave(args []string, conn net.Conn) {
+	if err := saveRDB(); err != nil {
+		fmt.Printf("❌ Failed to save RDB: %v\n", err)
+		conn.Write([]byte("-ERR " + err.Error() + "\r\n"))
+		return
+	}
+	conn.Write([]byte("+OK\r\n"))
+}
+
+// handleShutdown is SHUTDOWN [NOSAVE|SAVE], saving unless told NOSAVE.
+// SHUTDOWN NOSAVE is also the way out of a script that wrote to the
+// dataset and cannot be killed, so it runs while a script holds the
+// command section.
+func handleShutdown(args []string, conn net.Conn) {
+	save := true
+	for _, arg := range args[1:] {
+		switch strings.ToUpper(arg) {
+		case "NOSAVE":
+			save = false
+		case "SAVE":
+			save = true
+		default:
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+	}
+
+	if save {
+		if err := saveRDB(); err != nil {
+			fmt.Printf("❌ Failed to save RDB: %v\n", err)
+			conn.Write([]byte("-ERR Errors trying to SHUTDOWN. Check logs.\r\n"))
+			return
+		}
+	}
+
+	fmt.Println("🛑 Shutdown requested, exiting")
+	os.Exit(0)
+}
+
 func handleInfo(args []string, conn net.Conn) {
 	replState := store.GetReplicationState()
 	section := ""
@@ -0,0 +1,336 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+func parseBitOffset(arg string) (uint64, bool) {
+	offset, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || offset > store.MaxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+func handleSetBit(args []string, conn net.Conn) {
+	if len(args) != 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'setbit' command\r\n"))
+		return
+	}
+
+	offset, ok := parseBitOffset(args[2])
+	if !ok {
+		conn.Write([]byte("-ERR bit offset is not an integer or out of range\r\n"))
+		return
+	}
+	if args[3] != "0" && args[3] != "1" {
+		conn.Write([]byte("-ERR bit is not an integer or out of range\r\n"))
+		return
+	}
+
+	old, err := store.SetBit(args[1], offset, args[3] == "1")
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	PropagateCommand(args)
+	writeInteger(conn, int64(old))
+}
+
+func handleGetBit(args []string, conn net.Conn) {
+	if len(args) != 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'getbit' command\r\n"))
+		return
+	}
+
+	offset, ok := parseBitOffset(args[2])
+	if !ok {
+		conn.Write([]byte("-ERR bit offset is not an integer or out of range\r\n"))
+		return
+	}
+
+	bit, err := store.GetBit(args[1], offset)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	writeInteger(conn, int64(bit))
+}
+
+// parseBitUnit parses the optional BYTE|BIT argument of BITCOUNT/BITPOS
+func parseBitUnit(arg string) (isBit bool, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "BYTE":
+		return false, true
+	case "BIT":
+		return true, true
+	}
+	return false, false
+}
+
+func handleBitCount(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'bitcount' command\r\n"))
+		return
+	}
+
+	var start, end int64
+	hasRange, isBit := false, false
+
+	switch len(args) {
+	case 2:
+	case 4, 5:
+		var err1, err2 error
+		start, err1 = strconv.ParseInt(args[2], 10, 64)
+		end, err2 = strconv.ParseInt(args[3], 10, 64)
+		if err1 != nil || err2 != nil {
+			conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+			return
+		}
+		if len(args) == 5 {
+			var ok bool
+			if isBit, ok = parseBitUnit(args[4]); !ok {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				return
+			}
+		}
+		hasRange = true
+	default:
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+
+	count, err := store.BitCount(args[1], start, end, hasRange, isBit)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	writeInteger(conn, count)
+}
+
+func handleBitPos(args []string, conn net.Conn) {
+	if len(args) < 3 || len(args) > 6 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'bitpos' command\r\n"))
+		return
+	}
+
+	bit, err := strconv.Atoi(args[2])
+	if err != nil {
+		conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+		return
+	}
+	if bit != 0 && bit != 1 {
+		conn.Write([]byte("-ERR The bit argument must be 1 or 0.\r\n"))
+		return
+	}
+
+	var start, end int64
+	hasStart, hasEnd, isBit := len(args) > 3, len(args) > 4, false
+	if hasStart {
+		if start, err = strconv.ParseInt(args[3], 10, 64); err != nil {
+			conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+			return
+		}
+	}
+	if hasEnd {
+		if end, err = strconv.ParseInt(args[4], 10, 64); err != nil {
+			conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+			return
+		}
+	}
+	if len(args) == 6 {
+		var ok bool
+		if isBit, ok = parseBitUnit(args[5]); !ok {
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+	}
+
+	pos, err := store.BitPos(args[1], bit, start, end, hasStart, hasEnd, isBit)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	writeInteger(conn, pos)
+}
+
+func handleBitOp(args []string, conn net.Conn) {
+	if len(args) < 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'bitop' command\r\n"))
+		return
+	}
+
+	op := strings.ToUpper(args[1])
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 4 {
+			conn.Write([]byte("-ERR BITOP NOT must be called with a single source key.\r\n"))
+			return
+		}
+	default:
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+
+	length, err := store.BitOp(op, args[2], args[3:])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	PropagateCommand(args)
+	writeInteger(conn, int64(length))
+}
+
+// parseBitFieldType parses i<bits> (1-64) and u<bits> (1-63)
+func parseBitFieldType(arg string) (signed bool, width uint, ok bool) {
+	if len(arg) < 2 {
+		return false, 0, false
+	}
+
+	switch arg[0] {
+	case 'i', 'I':
+		signed = true
+	case 'u', 'U':
+	default:
+		return false, 0, false
+	}
+
+	n, err := strconv.Atoi(arg[1:])
+	if err != nil || n < 1 || (signed && n > 64) || (!signed && n > 63) {
+		return false, 0, false
+	}
+	return signed, uint(n), true
+}
+
+// parseBitFieldOffset parses a bit offset, or #N meaning N times the type width
+func parseBitFieldOffset(arg string, width uint) (uint64, bool) {
+	multiply := strings.HasPrefix(arg, "#")
+	if multiply {
+		arg = arg[1:]
+	}
+
+	offset, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if multiply {
+		offset *= uint64(width)
+	}
+	if offset+uint64(width)-1 > store.MaxBitOffset {
+		return 0, false
+	}
+	return offset, true
+}
+
+// handleBitField implements BITFIELD and, with readOnly, BITFIELD_RO
+func handleBitField(args []string, conn net.Conn, readOnly bool) {
+	command := strings.ToLower(args[0])
+	if len(args) < 2 {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", command)))
+		return
+	}
+
+	var ops []store.BitFieldOp
+	overflow := store.OverflowWrap
+
+	for i := 2; i < len(args); i++ {
+		sub := strings.ToUpper(args[i])
+
+		if sub == "OVERFLOW" {
+			if i+1 >= len(args) {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				return
+			}
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = store.OverflowWrap
+			case "SAT":
+				overflow = store.OverflowSat
+			case "FAIL":
+				overflow = store.OverflowFail
+			default:
+				conn.Write([]byte("-ERR Invalid OVERFLOW type specified\r\n"))
+				return
+			}
+			i++
+			continue
+		}
+
+		var kind store.BitFieldOpKind
+		argc := 2
+		switch sub {
+		case "GET":
+			kind = store.BitFieldGet
+		case "SET":
+			kind, argc = store.BitFieldSet, 3
+		case "INCRBY":
+			kind, argc = store.BitFieldIncrBy, 3
+		default:
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+
+		if i+argc >= len(args) {
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+
+		signed, width, ok := parseBitFieldType(args[i+1])
+		if !ok {
+			conn.Write([]byte("-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.\r\n"))
+			return
+		}
+		offset, ok := parseBitFieldOffset(args[i+2], width)
+		if !ok {
+			conn.Write([]byte("-ERR bit offset is not an integer or out of range\r\n"))
+			return
+		}
+
+		op := store.BitFieldOp{Kind: kind, Signed: signed, Bits: width, Offset: offset, Overflow: overflow}
+		if argc == 3 {
+			value, err := strconv.ParseInt(args[i+3], 10, 64)
+			if err != nil {
+				conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+				return
+			}
+			op.Value = value
+		}
+
+		if readOnly && kind != store.BitFieldGet {
+			conn.Write([]byte("-ERR BITFIELD_RO only supports the GET subcommand\r\n"))
+			return
+		}
+
+		ops = append(ops, op)
+		i += argc
+	}
+
+	results, changed, err := store.BitField(args[1], ops)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if changed {
+		PropagateCommand(args)
+	}
+
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(results)))
+	for _, result := range results {
+		if result == nil {
+			resp.WriteString("$-1\r\n")
+		} else {
+			resp.WriteString(fmt.Sprintf(":%d\r\n", *result))
+		}
+	}
+	conn.Write([]byte(resp.String()))
+}
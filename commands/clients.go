@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// clientInfo is kept for every network connection while it is open.
+// Blocking commands use it to give up when the connection closes and to be
+// found by CLIENT UNBLOCK.
+type clientInfo struct {
+	id     int64
+	closed chan struct{}
+	user   atomic.Pointer[aclUser] // nil until the client AUTHs
+}
+
+var (
+	clients      = make(map[net.Conn]*clientInfo)
+	clientsMutex sync.RWMutex
+	nextClientID atomic.Int64
+)
+
+// RegisterClient gives a new connection its client id. It is logged in as
+// the default user when that one needs no password.
+func RegisterClient(conn net.Conn) {
+	info := &clientInfo{
+		id:     nextClientID.Add(1),
+		closed: make(chan struct{}),
+	}
+	info.user.Store(defaultUserOpen())
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	clients[conn] = info
+}
+
+// UnregisterClient is called once the connection stopped delivering input.
+// A command blocked for this client gives up, and its transaction state is
+// dropped.
+func UnregisterClient(conn net.Conn) {
+	clientsMutex.Lock()
+	info, exists := clients[conn]
+	delete(clients, conn)
+	clientsMutex.Unlock()
+
+	if exists {
+		close(info.closed)
+	}
+	clearTransactionState(conn)
+}
+
+// lookupClient returns nil for connections that are not clients, like the
+// MockConn of commands run by EXEC or from the replication stream. Those
+// never block.
+func lookupClient(conn net.Conn) *clientInfo {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	return clients[conn]
+}
+
+func (info *clientInfo) clientID() int64 {
+	if info == nil {
+		return 0
+	}
+	return info.id
+}
+
+// waitForData waits until waiter is served, the timeout (0 = forever) passes,
+// the client disconnects or CLIENT UNBLOCK wakes it. It returns nil when
+// the wait ended without data, and the UNBLOCKED error for CLIENT UNBLOCK
+// ERROR. The command section is left while waiting, so a transaction can
+// run in the meantime.
+func waitForData(info *clientInfo, waiter *store.Waiter, timeout time.Duration) (*store.BlockingResult, error) {
+	store.EndCommand()
+	defer store.BeginCommand()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case result := <-waiter.Response:
+		return blockingOutcome(result)
+	case <-expired:
+	case <-info.closed:
+	}
+
+	if store.UnregisterWaiter(waiter) {
+		return nil, nil
+	}
+	// Served while giving up, the result is already on its way
+	return blockingOutcome(<-waiter.Response)
+}
+
+func blockingOutcome(result store.BlockingResult) (*store.BlockingResult, error) {
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	if result.TimedOut {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+func handleClient(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'client' command\r\n"))
+		return
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "ID":
+		if len(args) != 2 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'client|id' command\r\n"))
+			return
+		}
+		writeInteger(conn, lookupClient(conn).clientID())
+	case "UNBLOCK":
+		handleClientUnblock(args, conn)
+	default:
+		conn.Write([]byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try CLIENT HELP.\r\n", args[1])))
+	}
+}
+
+func handleClientUnblock(args []string, conn net.Conn) {
+	if len(args) != 3 && len(args) != 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'client|unblock' command\r\n"))
+		return
+	}
+
+	id, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+		return
+	}
+
+	withError := false
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3]) {
+		case "TIMEOUT":
+		case "ERROR":
+			withError = true
+		default:
+			conn.Write([]byte("-ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR\r\n"))
+			return
+		}
+	}
+
+	if store.UnblockClient(id, withError) {
+		writeInteger(conn, 1)
+	} else {
+		writeInteger(conn, 0)
+	}
+}
@@ -0,0 +1,156 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+)
+
+// keySpec tells which arguments of a command are keys and how the command
+// accesses them. Most commands have their keys in a range of positions, the
+// others find them in their arguments.
+type keySpec struct {
+	first, last, step int // last is counted from the end when negative
+	access            keyAccess
+	find              func(args []string) []keyRef
+}
+
+type keyAccess int
+
+const (
+	keyRead keyAccess = 1 << iota
+	keyWrite
+	keyReadWrite = keyRead | keyWrite
+)
+
+// keyRef is a key argument of a command
+type keyRef struct {
+	pos    int
+	access keyAccess
+}
+
+var noKeys = keySpec{}
+
+func oneKey(access keyAccess) keySpec {
+	return keyRange(1, 1, 1, access)
+}
+
+func keyRange(first, last, step int, access keyAccess) keySpec {
+	return keySpec{first: first, last: last, step: step, access: access}
+}
+
+func keysFrom(find func(args []string) []keyRef) keySpec {
+	return keySpec{find: find}
+}
+
+// commandKeys returns the key arguments of a command that fits its arity
+func (info commandInfo) commandKeys(args []string) []keyRef {
+	spec := info.keys
+	if spec.find != nil {
+		return spec.find(args)
+	}
+	if spec.first == 0 {
+		return nil
+	}
+
+	last := spec.last
+	if last < 0 {
+		last += len(args)
+	}
+	var keys []keyRef
+	for pos := spec.first; pos <= last && pos < len(args); pos += spec.step {
+		keys = append(keys, keyRef{pos, spec.access})
+	}
+	return keys
+}
+
+// numKeysAt finds the keys that follow their count at pos, like those of
+// EVAL and LMPOP
+func numKeysAt(pos int, access keyAccess) func(args []string) []keyRef {
+	return func(args []string) []keyRef {
+		if pos >= len(args) {
+			return nil
+		}
+		n, err := strconv.Atoi(args[pos])
+		if err != nil || n < 0 {
+			return nil
+		}
+		var keys []keyRef
+		for i := pos + 1; i <= pos+n && i < len(args); i++ {
+			keys = append(keys, keyRef{i, access})
+		}
+		return keys
+	}
+}
+
+// streamsKeys finds the keys of XREAD and XREADGROUP, the first half of
+// what follows STREAMS
+func streamsKeys(access keyAccess) func(args []string) []keyRef {
+	return func(args []string) []keyRef {
+		for i := 1; i < len(args); i++ {
+			if !strings.EqualFold(args[i], "STREAMS") {
+				continue
+			}
+			var keys []keyRef
+			n := (len(args) - i - 1) / 2
+			for pos := i + 1; pos <= i+n; pos++ {
+				keys = append(keys, keyRef{pos, access})
+			}
+			return keys
+		}
+		return nil
+	}
+}
+
+// subcommandKey finds the key that follows the subcommand, as in
+// OBJECT ENCODING key
+func subcommandKey(access keyAccess) func(args []string) []keyRef {
+	return func(args []string) []keyRef {
+		if len(args) < 3 {
+			return nil
+		}
+		return []keyRef{{2, access}}
+	}
+}
+
+// storeKeys finds the destination of a command that stores what it reads
+// from the keys from first to last
+func storeKeys(destination int, access keyAccess, first, last int) func(args []string) []keyRef {
+	return func(args []string) []keyRef {
+		keys := []keyRef{{destination, access}}
+		end := last
+		if end < 0 {
+			end += len(args)
+		}
+		for pos := first; pos <= end && pos < len(args); pos++ {
+			keys = append(keys, keyRef{pos, keyRead})
+		}
+		return keys
+	}
+}
+
+// bitopKeys finds the keys of BITOP operation destkey key [key ...]
+func bitopKeys(args []string) []keyRef {
+	keys := []keyRef{{2, keyWrite}}
+	for pos := 3; pos < len(args); pos++ {
+		keys = append(keys, keyRef{pos, keyRead})
+	}
+	return keys
+}
+
+// moveKeys finds the source and destination of LMOVE and its relatives
+func moveKeys(args []string) []keyRef {
+	return []keyRef{{1, keyReadWrite}, {2, keyWrite}}
+}
+
+// geoRadiusKeys finds the key of GEORADIUS and GEORADIUSBYMEMBER and the
+// ones of their STORE and STOREDIST options
+func geoRadiusKeys(args []string) []keyRef {
+	keys := []keyRef{{1, keyRead}}
+	for i := 5; i+1 < len(args); i++ {
+		if strings.EqualFold(args[i], "STORE") || strings.EqualFold(args[i], "STOREDIST") {
+			keys = append(keys, keyRef{i + 1, keyWrite})
+			i++
+		}
+	}
+	return keys
+}
@@ -0,0 +1,262 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commandInfo describes a command the way Redis' command table does. A
+// positive arity is the exact number of arguments including the command
+// name, a negative one the minimum. The ACL categories of a command are
+// the ones listed plus those that follow from its flags, and keys tells
+// which of its arguments are keys.
+type commandInfo struct {
+	arity      int
+	flags      commandFlags
+	categories aclCategories
+	keys       keySpec
+}
+
+type commandFlags int
+
+const (
+	flagWrite     commandFlags = 1 << iota // may modify the dataset
+	flagReadonly                           // only reads the dataset
+	flagNoScript                           // cannot be called from a script
+	flagContainer                          // has subcommands, ACL rules may name them as command|subcommand
+)
+
+type aclCategories uint32
+
+const (
+	catKeyspace aclCategories = 1 << iota
+	catRead
+	catWrite
+	catSet
+	catSortedSet
+	catList
+	catHash
+	catString
+	catBitmap
+	catHyperLogLog
+	catGeo
+	catStream
+	catPubSub
+	catAdmin
+	catFast
+	catSlow
+	catBlocking
+	catDangerous
+	catConnection
+	catTransaction
+	catScripting
+)
+
+// aclCategoryNames are the names of the categories, in the order of their
+// bits
+var aclCategoryNames = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string",
+	"bitmap", "hyperloglog", "geo", "stream", "pubsub", "admin", "fast",
+	"slow", "blocking", "dangerous", "connection", "transaction", "scripting",
+}
+
+var commandTable = map[string]commandInfo{
+	// Connection and server
+	"AUTH":     {-2, flagNoScript, catConnection | catFast, noKeys},
+	"ACL":      {-2, flagNoScript | flagContainer, catAdmin | catDangerous, noKeys},
+	"PING":     {-1, 0, catConnection | catFast, noKeys},
+	"ECHO":     {2, 0, catConnection | catFast, noKeys},
+	"CLIENT":   {-2, flagNoScript | flagContainer, catConnection, noKeys},
+	"INFO":     {-1, 0, catDangerous, noKeys},
+	"CONFIG":   {-2, flagNoScript | flagContainer, catAdmin | catDangerous, noKeys},
+	"SAVE":     {1, flagNoScript, catAdmin | catDangerous, noKeys},
+	"SHUTDOWN": {-1, flagNoScript, catAdmin | catDangerous, noKeys},
+	"PSYNC":    {-3, flagNoScript, catAdmin | catDangerous, noKeys},
+	"REPLCONF": {-1, flagNoScript, catAdmin | catDangerous, noKeys},
+	"WAIT":     {3, flagNoScript, catConnection, noKeys},
+
+	// Transactions
+	"MULTI":     {1, flagNoScript, catTransaction | catFast, noKeys},
+	"EXEC":      {1, flagNoScript, catTransaction, noKeys},
+	"DISCARD":   {1, flagNoScript, catTransaction | catFast, noKeys},
+	"UNDO":      {-1, flagNoScript, catTransaction | catFast, noKeys},
+	"WATCH":     {-2, flagNoScript, catTransaction | catFast, keyRange(1, -1, 1, keyRead)},
+	"UNWATCH":   {1, flagNoScript, catTransaction | catFast, noKeys},
+	"TXQUEUE":   {-2, flagNoScript, catTransaction | catFast, noKeys},
+	"SAVEPOINT": {2, flagNoScript, catTransaction | catFast, noKeys},
+	"ROLLBACK":  {3, flagNoScript, catTransaction | catFast, noKeys},
+
+	// Keys
+	"TYPE":        {2, flagReadonly, catKeyspace | catFast, oneKey(keyRead)},
+	"OBJECT":      {-2, flagReadonly | flagContainer, catKeyspace, keysFrom(subcommandKey(keyRead))},
+	"SCAN":        {-2, flagReadonly, catKeyspace, noKeys},
+	"TTL":         {2, flagReadonly, catKeyspace | catFast, oneKey(keyRead)},
+	"PTTL":        {2, flagReadonly, catKeyspace | catFast, oneKey(keyRead)},
+	"EXPIRETIME":  {2, flagReadonly, catKeyspace | catFast, oneKey(keyRead)},
+	"PEXPIRETIME": {2, flagReadonly, catKeyspace | catFast, oneKey(keyRead)},
+	"EXPIRE":      {-3, flagWrite, catKeyspace | catFast, oneKey(keyWrite)},
+	"PEXPIRE":     {-3, flagWrite, catKeyspace | catFast, oneKey(keyWrite)},
+	"EXPIREAT":    {-3, flagWrite, catKeyspace | catFast, oneKey(keyWrite)},
+	"PEXPIREAT":   {-3, flagWrite, catKeyspace | catFast, oneKey(keyWrite)},
+	"PERSIST":     {2, flagWrite, catKeyspace | catFast, oneKey(keyWrite)},
+
+	// Strings
+	"GET":         {2, flagReadonly, catString | catFast, oneKey(keyRead)},
+	"MGET":        {-2, flagReadonly, catString | catFast, keyRange(1, -1, 1, keyRead)},
+	"STRLEN":      {2, flagReadonly, catString | catFast, oneKey(keyRead)},
+	"GETRANGE":    {4, flagReadonly, catString, oneKey(keyRead)},
+	"LCS":         {-3, flagReadonly, catString, keyRange(1, 2, 1, keyRead)},
+	"SET":         {-3, flagWrite, catString, oneKey(keyWrite)},
+	"SETNX":       {3, flagWrite, catString | catFast, oneKey(keyWrite)},
+	"SETEX":       {4, flagWrite, catString, oneKey(keyWrite)},
+	"PSETEX":      {4, flagWrite, catString, oneKey(keyWrite)},
+	"GETSET":      {3, flagWrite, catString | catFast, oneKey(keyReadWrite)},
+	"MSET":        {-3, flagWrite, catString, keyRange(1, -1, 2, keyWrite)},
+	"MSETNX":      {-3, flagWrite, catString, keyRange(1, -1, 2, keyWrite)},
+	"GETDEL":      {2, flagWrite, catString | catFast, oneKey(keyReadWrite)},
+	"GETEX":       {-2, flagWrite, catString | catFast, oneKey(keyReadWrite)},
+	"APPEND":      {3, flagWrite, catString | catFast, oneKey(keyWrite)},
+	"SETRANGE":    {4, flagWrite, catString, oneKey(keyWrite)},
+	"INCR":        {2, flagWrite, catString | catFast, oneKey(keyReadWrite)},
+	"INCRBY":      {3, flagWrite, catString | catFast, oneKey(keyReadWrite)},
+	"DECR":        {2, flagWrite, catString | catFast, oneKey(keyReadWrite)},
+	"DECRBY":      {3, flagWrite, catString | catFast, oneKey(keyReadWrite)},
+	"INCRBYFLOAT": {3, flagWrite, catString | catFast, oneKey(keyReadWrite)},
+
+	// Bitmaps and HyperLogLogs
+	"GETBIT":      {3, flagReadonly, catBitmap | catFast, oneKey(keyRead)},
+	"SETBIT":      {4, flagWrite, catBitmap, oneKey(keyReadWrite)},
+	"BITCOUNT":    {-2, flagReadonly, catBitmap, oneKey(keyRead)},
+	"BITPOS":      {-3, flagReadonly, catBitmap, oneKey(keyRead)},
+	"BITOP":       {-4, flagWrite, catBitmap, keysFrom(bitopKeys)},
+	"BITFIELD":    {-2, flagWrite, catBitmap, oneKey(keyReadWrite)},
+	"BITFIELD_RO": {-2, flagReadonly, catBitmap | catFast, oneKey(keyRead)},
+	"PFADD":       {-2, flagWrite, catHyperLogLog | catFast, oneKey(keyWrite)},
+	"PFCOUNT":     {-2, flagReadonly, catHyperLogLog, keyRange(1, -1, 1, keyRead)},
+	"PFMERGE":     {-2, flagWrite, catHyperLogLog, keysFrom(storeKeys(1, keyReadWrite, 2, -1))},
+	"PFDEBUG":     {3, flagWrite, catHyperLogLog | catAdmin | catDangerous, keyRange(2, 2, 1, keyReadWrite)},
+
+	// Lists
+	"LPUSH":      {-3, flagWrite, catList | catFast, oneKey(keyWrite)},
+	"RPUSH":      {-3, flagWrite, catList | catFast, oneKey(keyWrite)},
+	"LPUSHX":     {-3, flagWrite, catList | catFast, oneKey(keyWrite)},
+	"RPUSHX":     {-3, flagWrite, catList | catFast, oneKey(keyWrite)},
+	"LPOP":       {-2, flagWrite, catList | catFast, oneKey(keyReadWrite)},
+	"RPOP":       {-2, flagWrite, catList | catFast, oneKey(keyReadWrite)},
+	"LINDEX":     {3, flagReadonly, catList, oneKey(keyRead)},
+	"LRANGE":     {4, flagReadonly, catList, oneKey(keyRead)},
+	"LLEN":       {2, flagReadonly, catList | catFast, oneKey(keyRead)},
+	"LPOS":       {-3, flagReadonly, catList, oneKey(keyRead)},
+	"LSET":       {4, flagWrite, catList, oneKey(keyWrite)},
+	"LINSERT":    {5, flagWrite, catList, oneKey(keyWrite)},
+	"LREM":       {4, flagWrite, catList, oneKey(keyWrite)},
+	"LTRIM":      {4, flagWrite, catList, oneKey(keyWrite)},
+	"LMOVE":      {5, flagWrite, catList, keysFrom(moveKeys)},
+	"RPOPLPUSH":  {3, flagWrite, catList, keysFrom(moveKeys)},
+	"LMPOP":      {-4, flagWrite, catList, keysFrom(numKeysAt(1, keyReadWrite))},
+	"BLPOP":      {-3, flagWrite, catList | catBlocking, keyRange(1, -2, 1, keyReadWrite)},
+	"BRPOP":      {-3, flagWrite, catList | catBlocking, keyRange(1, -2, 1, keyReadWrite)},
+	"BLMOVE":     {6, flagWrite, catList | catBlocking, keysFrom(moveKeys)},
+	"BRPOPLPUSH": {4, flagWrite, catList | catBlocking, keysFrom(moveKeys)},
+	"BLMPOP":     {-5, flagWrite, catList | catBlocking, keysFrom(numKeysAt(2, keyReadWrite))},
+
+	// Sorted sets and geo
+	"ZADD":                 {-4, flagWrite, catSortedSet | catFast, oneKey(keyWrite)},
+	"ZREM":                 {-3, flagWrite, catSortedSet | catFast, oneKey(keyWrite)},
+	"ZSCORE":               {3, flagReadonly, catSortedSet | catFast, oneKey(keyRead)},
+	"ZCARD":                {2, flagReadonly, catSortedSet | catFast, oneKey(keyRead)},
+	"ZRANGE":               {-4, flagReadonly, catSortedSet, oneKey(keyRead)},
+	"ZSCAN":                {-3, flagReadonly, catSortedSet, oneKey(keyRead)},
+	"GEOADD":               {-5, flagWrite, catGeo, oneKey(keyWrite)},
+	"GEOPOS":               {-2, flagReadonly, catGeo, oneKey(keyRead)},
+	"GEODIST":              {-4, flagReadonly, catGeo, oneKey(keyRead)},
+	"GEOHASH":              {-2, flagReadonly, catGeo, oneKey(keyRead)},
+	"GEOSEARCH":            {-7, flagReadonly, catGeo, oneKey(keyRead)},
+	"GEOSEARCHSTORE":       {-8, flagWrite, catGeo, keysFrom(storeKeys(1, keyWrite, 2, 2))},
+	"GEORADIUS":            {-6, flagWrite, catGeo, keysFrom(geoRadiusKeys)},
+	"GEORADIUS_RO":         {-6, flagReadonly, catGeo, oneKey(keyRead)},
+	"GEORADIUSBYMEMBER":    {-5, flagWrite, catGeo, keysFrom(geoRadiusKeys)},
+	"GEORADIUSBYMEMBER_RO": {-5, flagReadonly, catGeo, oneKey(keyRead)},
+
+	// Streams
+	"XADD":       {-5, flagWrite, catStream | catFast, oneKey(keyWrite)},
+	"XRANGE":     {-4, flagReadonly, catStream, oneKey(keyRead)},
+	"XREVRANGE":  {-4, flagReadonly, catStream, oneKey(keyRead)},
+	"XLEN":       {2, flagReadonly, catStream | catFast, oneKey(keyRead)},
+	"XREAD":      {-4, flagReadonly, catStream | catBlocking, keysFrom(streamsKeys(keyRead))},
+	"XREADGROUP": {-7, flagWrite, catStream | catBlocking, keysFrom(streamsKeys(keyReadWrite))},
+	"XDEL":       {-3, flagWrite, catStream | catFast, oneKey(keyWrite)},
+	"XTRIM":      {-4, flagWrite, catStream, oneKey(keyWrite)},
+	"XSETID":     {-3, flagWrite, catStream | catFast, oneKey(keyWrite)},
+	"XGROUP":     {-2, flagWrite | flagContainer, catStream, keysFrom(subcommandKey(keyWrite))},
+	"XACK":       {-4, flagWrite, catStream | catFast, oneKey(keyReadWrite)},
+	"XPENDING":   {-3, flagReadonly, catStream, oneKey(keyRead)},
+	"XCLAIM":     {-6, flagWrite, catStream | catFast, oneKey(keyReadWrite)},
+	"XAUTOCLAIM": {-6, flagWrite, catStream | catFast, oneKey(keyReadWrite)},
+	"XINFO":      {-2, flagReadonly | flagContainer, catStream, keysFrom(subcommandKey(keyRead))},
+
+	// Scripting
+	"EVAL":       {-3, flagNoScript, catScripting, keysFrom(numKeysAt(2, keyReadWrite))},
+	"EVALSHA":    {-3, flagNoScript, catScripting, keysFrom(numKeysAt(2, keyReadWrite))},
+	"EVAL_RO":    {-3, flagNoScript, catScripting, keysFrom(numKeysAt(2, keyRead))},
+	"EVALSHA_RO": {-3, flagNoScript, catScripting, keysFrom(numKeysAt(2, keyRead))},
+	"SCRIPT":     {-2, flagNoScript | flagContainer, catScripting, noKeys},
+	"FCALL":      {-3, flagNoScript, catScripting, keysFrom(numKeysAt(2, keyReadWrite))},
+	"FCALL_RO":   {-3, flagNoScript, catScripting, keysFrom(numKeysAt(2, keyRead))},
+	"FUNCTION":   {-2, flagNoScript | flagContainer, catScripting, noKeys},
+}
+
+// subcommandCategories are the categories of the subcommands that do not
+// share those of their command
+var subcommandCategories = map[string]aclCategories{
+	"ACL|CAT":          catSlow,
+	"ACL|WHOAMI":       catSlow,
+	"CLIENT|ID":        catConnection | catSlow,
+	"CLIENT|UNBLOCK":   catAdmin | catConnection | catSlow | catDangerous,
+	"FUNCTION|LOAD":    catWrite | catScripting | catSlow,
+	"FUNCTION|DELETE":  catWrite | catScripting | catSlow,
+	"FUNCTION|FLUSH":   catWrite | catScripting | catSlow,
+	"FUNCTION|RESTORE": catWrite | catScripting | catSlow,
+}
+
+// aclCategories returns all the categories of the command
+func (info commandInfo) aclCategories() aclCategories {
+	categories := info.categories
+	if info.flags&flagWrite != 0 {
+		categories |= catWrite
+	}
+	if info.flags&flagReadonly != 0 {
+		categories |= catRead
+	}
+	if categories&catFast == 0 {
+		categories |= catSlow
+	}
+	return categories
+}
+
+// checkCommand returns the error Redis gives before running a command that
+// does not exist or has the wrong number of arguments, "" when it is fine
+func checkCommand(args []string) string {
+	info, exists := commandTable[strings.ToUpper(args[0])]
+	if !exists {
+		var quoted strings.Builder
+		for _, arg := range args[1:] {
+			fmt.Fprintf(&quoted, "'%s' ", arg)
+		}
+		return fmt.Sprintf("-ERR unknown command '%s', with args beginning with: %s\r\n", args[0], quoted.String())
+	}
+
+	if !info.acceptsArgs(len(args)) {
+		return fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(args[0]))
+	}
+	return ""
+}
+
+// acceptsArgs tells whether n arguments, the command name included, fit the
+// arity
+func (info commandInfo) acceptsArgs(n int) bool {
+	if info.arity > 0 {
+		return n == info.arity
+	}
+	return n >= -info.arity
+}
@@ -3,9 +3,106 @@ package commands
 import (
 	"net"
 	"strings"
+
+	"github.com/kushalsdesk/redis_with_go/store"
 )
 
+// Dispatch runs a command from a client connection. The command holds the
+// store's command section for as long as it runs, EXEC and scripts hold it
+// exclusively so that no other client runs anything in the middle of them.
+// SCRIPT KILL, FUNCTION KILL and FUNCTION STATS run outside the section, to
+// reach a script that holds it. Clients that have to AUTH get NOAUTH for
+// anything else, and NOPERM for what their ACL user may not run.
 func Dispatch(args []string, conn net.Conn) {
+	if len(args) > 0 && authRequired(conn, strings.ToUpper(args[0])) {
+		conn.Write([]byte(noAuthReply))
+		return
+	}
+	if len(args) > 0 {
+		context := "toplevel"
+		if getTransactionState(conn).InTransaction {
+			context = "multi"
+		}
+		if msg := aclCheck(conn, args, context); msg != "" {
+			abortTransaction(conn)
+			conn.Write([]byte("-NOPERM " + msg + "\r\n"))
+			return
+		}
+	}
+	if runsDuringScripts(args) {
+		dispatch(args, conn)
+		return
+	}
+
+	exclusive := len(args) > 0 && isExclusiveCommand(strings.ToUpper(args[0]))
+	if !enterSection(exclusive, conn) {
+		return
+	}
+	if exclusive {
+		defer store.EndExclusive()
+	} else {
+		defer store.EndCommand()
+	}
+	dispatch(args, conn)
+}
+
+func isExclusiveCommand(command string) bool {
+	switch command {
+	case "EXEC", "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO":
+		return true
+	}
+	return false
+}
+
+func runsDuringScripts(args []string) bool {
+	if len(args) != 2 {
+		return false
+	}
+	switch strings.ToUpper(args[0]) + " " + strings.ToUpper(args[1]) {
+	case "SCRIPT KILL", "FUNCTION KILL", "FUNCTION STATS", "SHUTDOWN NOSAVE":
+		return true
+	}
+	return false
+}
+
+// enterSection waits for the command section, or replies BUSY and gives up
+// once a script has held it for longer than busy-reply-threshold
+func enterSection(exclusive bool, conn net.Conn) bool {
+	begin, tryBegin, end := store.BeginCommand, store.TryBeginCommand, store.EndCommand
+	if exclusive {
+		begin, tryBegin, end = store.BeginExclusive, store.TryBeginExclusive, store.EndExclusive
+	}
+
+	if scriptIsBusy() {
+		conn.Write([]byte(busyReply()))
+		return false
+	}
+	if tryBegin() {
+		return true
+	}
+
+	entered := make(chan struct{})
+	go func() {
+		begin()
+		close(entered)
+	}()
+	select {
+	case <-entered:
+		return true
+	case <-scriptBusySignal():
+		// The section is released as soon as the waiter gets it
+		go func() {
+			<-entered
+			end()
+		}()
+		conn.Write([]byte(busyReply()))
+		return false
+	}
+}
+
+// dispatch runs a command inside a section that is already held: from
+// Dispatch, or for the commands of EXEC and the replication stream
+func dispatch(args []string, conn net.Conn) {
 	if len(args) == 0 {
 		conn.Write([]byte("-ERR unknown command\r\n"))
 		return
@@ -14,6 +111,13 @@ func Dispatch(args []string, conn net.Conn) {
 	command := strings.ToUpper(args[0])
 
 	if ShouldQueueCommand(conn, command) {
+		// Like Redis, a command that cannot run is refused right away and
+		// fails the whole transaction at EXEC
+		if errMsg := checkCommand(args); errMsg != "" {
+			abortTransaction(conn)
+			conn.Write([]byte(errMsg))
+			return
+		}
 		QueueCommand(conn, args)
 		return
 	}
@@ -28,39 +132,124 @@ func Dispatch(args []string, conn net.Conn) {
 		handleDiscard(args, conn)
 	case "UNDO":
 		handleUndo(args, conn)
+	case "WATCH":
+		handleWatch(args, conn)
+	case "UNWATCH":
+		handleUnwatch(args, conn)
+	case "TXQUEUE":
+		handleTxQueue(args, conn)
+	case "SAVEPOINT":
+		handleSavepoint(args, conn)
+	case "ROLLBACK":
+		handleRollback(args, conn)
+
+	// Connection commands
+	case "AUTH":
+		handleAuth(args, conn)
+	case "ACL":
+		handleACL(args, conn)
 
 	// Read commands
 	case "PING":
 		handlePing(conn)
 	case "ECHO":
 		handleEcho(args, conn)
+	case "CLIENT":
+		handleClient(args, conn)
 	case "INFO":
 		handleInfo(args, conn)
 	case "CONFIG":
 		handleConfig(args, conn)
+	case "SAVE":
+		handleSave(args, conn)
+	case "SHUTDOWN":
+		handleShutdown(args, conn)
 	case "GET":
 		handleGet(args, conn)
+	case "MGET":
+		handleMGet(args, conn)
+	case "STRLEN":
+		handleStrLen(args, conn)
+	case "GETRANGE":
+		handleGetRange(args, conn)
+	case "LCS":
+		handleLCS(args, conn)
+	case "GETBIT":
+		handleGetBit(args, conn)
+	case "BITCOUNT":
+		handleBitCount(args, conn)
+	case "BITPOS":
+		handleBitPos(args, conn)
+	case "BITFIELD_RO":
+		handleBitField(args, conn, true)
+	case "ZSCORE":
+		handleZScore(args, conn)
+	case "ZCARD":
+		handleZCard(args, conn)
+	case "ZRANGE":
+		handleZRange(args, conn)
+	case "ZSCAN":
+		handleZScan(args, conn)
+	case "GEOPOS":
+		handleGeoPos(args, conn)
+	case "GEODIST":
+		handleGeoDist(args, conn)
+	case "GEOHASH":
+		handleGeoHash(args, conn)
+	case "GEOSEARCH":
+		handleGeoSearch(args, conn, geoSearch, true)
+	case "GEORADIUS_RO":
+		handleGeoSearch(args, conn, geoRadius, true)
+	case "GEORADIUSBYMEMBER_RO":
+		handleGeoSearch(args, conn, geoRadiusByMember, true)
 	case "LINDEX":
 		handleLIndex(args, conn)
 	case "LRANGE":
 		handleLRange(args, conn)
 	case "LLEN":
 		handleLLen(args, conn)
+	case "LPOS":
+		handleLPos(args, conn)
 	case "TYPE":
 		handleType(args, conn)
+	case "OBJECT":
+		handleObject(args, conn)
+	case "SCAN":
+		handleScan(args, conn)
 	case "XRANGE":
 		handleXRange(args, conn)
+	case "XREVRANGE":
+		handleXRevRange(args, conn)
+	case "XLEN":
+		handleXLen(args, conn)
+	case "XINFO":
+		handleXInfo(args, conn)
 	case "XREAD":
 		handleXRead(args, conn)
+	case "XPENDING":
+		handleXPending(args, conn)
 	case "BLPOP":
 		handleBLPop(args, conn)
 	case "BRPOP":
 		handleBRPop(args, conn)
+	case "BLMOVE":
+		handleBLMove(args, conn)
+	case "BRPOPLPUSH":
+		handleBRPopLPush(args, conn)
+	case "LMPOP":
+		handleLMPop(args, conn)
+	case "BLMPOP":
+		handleBLMPop(args, conn)
+	case "TTL":
+		handleTTL(args, conn)
+	case "PTTL":
+		handlePTTL(args, conn)
+	case "EXPIRETIME":
+		handleExpireTime(args, conn)
+	case "PEXPIRETIME":
+		handlePExpireTime(args, conn)
 
 	// Write commands
-	case "SET":
-		handleSet(args, conn)
-		PropagateCommand(args)
 	case "LPUSH":
 		handleLPush(args, conn)
 		PropagateCommand(args)
@@ -73,9 +262,21 @@ func Dispatch(args []string, conn net.Conn) {
 	case "RPOP":
 		handleRPop(args, conn)
 		PropagateCommand(args)
-	case "XADD":
-		handleXAdd(args, conn)
+	case "XDEL":
+		handleXDel(args, conn)
+	case "XTRIM":
+		handleXTrim(args, conn)
+	case "XSETID":
+		handleXSetID(args, conn)
 		PropagateCommand(args)
+	case "XGROUP":
+		if handleXGroup(args, conn) {
+			PropagateCommand(args)
+		}
+	case "XACK":
+		if handleXAck(args, conn) {
+			PropagateCommand(args)
+		}
 	case "INCR":
 		handleIncr(args, conn)
 		PropagateCommand(args)
@@ -89,6 +290,110 @@ func Dispatch(args []string, conn net.Conn) {
 		handleDecrBy(args, conn)
 		PropagateCommand(args)
 
+	// Write commands that propagate a rewritten form themselves
+	case "EXPIRE":
+		handleExpire(args, conn)
+	case "PEXPIRE":
+		handlePExpire(args, conn)
+	case "EXPIREAT":
+		handleExpireAt(args, conn)
+	case "PEXPIREAT":
+		handlePExpireAt(args, conn)
+	case "PERSIST":
+		handlePersist(args, conn)
+	case "SET":
+		handleSet(args, conn)
+	case "SETNX":
+		handleSetNX(args, conn)
+	case "SETEX":
+		handleSetEx(args, conn, "EX")
+	case "PSETEX":
+		handleSetEx(args, conn, "PX")
+	case "GETSET":
+		handleGetSet(args, conn)
+	case "MSET":
+		handleMSet(args, conn, false)
+	case "MSETNX":
+		handleMSet(args, conn, true)
+	case "GETDEL":
+		handleGetDel(args, conn)
+	case "GETEX":
+		handleGetEx(args, conn)
+	case "APPEND":
+		handleAppend(args, conn)
+	case "SETRANGE":
+		handleSetRange(args, conn)
+	case "INCRBYFLOAT":
+		handleIncrByFloat(args, conn)
+	case "SETBIT":
+		handleSetBit(args, conn)
+	case "BITOP":
+		handleBitOp(args, conn)
+	case "BITFIELD":
+		handleBitField(args, conn, false)
+	case "PFADD":
+		handlePFAdd(args, conn)
+	case "PFCOUNT":
+		handlePFCount(args, conn)
+	case "PFMERGE":
+		handlePFMerge(args, conn)
+	case "PFDEBUG":
+		handlePFDebug(args, conn)
+	case "LPUSHX":
+		handlePushExisting(args, conn, true)
+	case "RPUSHX":
+		handlePushExisting(args, conn, false)
+	case "LSET":
+		handleLSet(args, conn)
+	case "LINSERT":
+		handleLInsert(args, conn)
+	case "LREM":
+		handleLRem(args, conn)
+	case "LTRIM":
+		handleLTrim(args, conn)
+	case "LMOVE":
+		handleLMove(args, conn)
+	case "RPOPLPUSH":
+		handleRPopLPush(args, conn)
+	case "ZADD":
+		handleZAdd(args, conn)
+	case "ZREM":
+		handleZRem(args, conn)
+	case "GEOADD":
+		handleGeoAdd(args, conn)
+	case "GEORADIUS":
+		handleGeoSearch(args, conn, geoRadius, false)
+	case "GEORADIUSBYMEMBER":
+		handleGeoSearch(args, conn, geoRadiusByMember, false)
+	case "GEOSEARCHSTORE":
+		handleGeoSearch(args, conn, geoSearchStore, false)
+	case "XADD":
+		handleXAdd(args, conn)
+	case "XREADGROUP":
+		handleXReadGroup(args, conn)
+	case "XCLAIM":
+		handleXClaim(args, conn)
+	case "XAUTOCLAIM":
+		handleXAutoClaim(args, conn)
+
+	// Scripting commands
+	case "EVAL":
+		handleEval(args, conn, false)
+	case "EVAL_RO":
+		handleEval(args, conn, true)
+	case "EVALSHA":
+		handleEvalSha(args, conn, false)
+	case "EVALSHA_RO":
+		handleEvalSha(args, conn, true)
+	case "SCRIPT":
+		handleScript(args, conn)
+	case "FCALL":
+		handleFcall(args, conn, false)
+	case "FCALL_RO":
+		handleFcall(args, conn, true)
+	case "FUNCTION":
+		handleFunction(args, conn)
+
 	// Replication commands
 	case "PSYNC":
 		handlePsync(args, conn)
@@ -100,4 +405,11 @@ func Dispatch(args []string, conn net.Conn) {
 	default:
 		conn.Write([]byte("-ERR unknown command\r\n"))
 	}
+
+	// Like Redis, clients blocked on lists this command filled are served
+	// after it has replied and propagated. Commands run by EXEC or from the
+	// replication stream leave that to the top level call.
+	if _, nested := conn.(*MockConn); !nested {
+		serveBlockedClients()
+	}
 }
@@ -0,0 +1,187 @@
+package commands
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// handleExpireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT.
+// Relative TTLs are resolved to an absolute time here so that every variant
+// replicates as PEXPIREAT and replicas never re-apply the TTL to their own clock.
+func handleExpireGeneric(args []string, conn net.Conn, unit time.Duration, absolute bool) {
+	name := strings.ToLower(args[0])
+	if len(args) < 3 {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", name)))
+		return
+	}
+
+	key := args[1]
+	amount, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+		return
+	}
+
+	cond, errMsg := parseExpireCondition(args[3:])
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	whenMs, ok := resolveExpireMillis(amount, unit, absolute)
+	if !ok {
+		conn.Write([]byte(fmt.Sprintf("-ERR invalid expire time in '%s' command\r\n", name)))
+		return
+	}
+
+	applied, deleted := store.SetExpiry(key, time.UnixMilli(whenMs), cond)
+	if !applied {
+		conn.Write([]byte(":0\r\n"))
+		return
+	}
+
+	if deleted {
+		PropagateCommand([]string{"DEL", key})
+	} else {
+		PropagateCommand([]string{"PEXPIREAT", key, strconv.FormatInt(whenMs, 10)})
+	}
+	conn.Write([]byte(":1\r\n"))
+}
+
+func parseExpireCondition(options []string) (store.ExpireCondition, string) {
+	cond := store.ExpireAlways
+	for _, opt := range options {
+		switch strings.ToUpper(opt) {
+		case "NX":
+			cond |= store.ExpireNX
+		case "XX":
+			cond |= store.ExpireXX
+		case "GT":
+			cond |= store.ExpireGT
+		case "LT":
+			cond |= store.ExpireLT
+		default:
+			return cond, fmt.Sprintf("-ERR Unsupported option %s\r\n", opt)
+		}
+	}
+
+	if cond&store.ExpireNX != 0 && cond != store.ExpireNX {
+		return cond, "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n"
+	}
+	if cond&store.ExpireGT != 0 && cond&store.ExpireLT != 0 {
+		return cond, "-ERR GT and LT options at the same time are not compatible\r\n"
+	}
+	return cond, ""
+}
+
+// resolveExpireMillis converts an EXPIRE-family argument into unix milliseconds,
+// reporting false when the result does not fit in an int64
+func resolveExpireMillis(amount int64, unit time.Duration, absolute bool) (int64, bool) {
+	if unit == time.Second {
+		if amount > math.MaxInt64/1000 || amount < math.MinInt64/1000 {
+			return 0, false
+		}
+		amount *= 1000
+	}
+
+	if absolute {
+		return amount, true
+	}
+
+	now := time.Now().UnixMilli()
+	if amount > math.MaxInt64-now {
+		return 0, false
+	}
+	return now + amount, true
+}
+
+func handleExpire(args []string, conn net.Conn) {
+	handleExpireGeneric(args, conn, time.Second, false)
+}
+
+func handlePExpire(args []string, conn net.Conn) {
+	handleExpireGeneric(args, conn, time.Millisecond, false)
+}
+
+func handleExpireAt(args []string, conn net.Conn) {
+	handleExpireGeneric(args, conn, time.Second, true)
+}
+
+func handlePExpireAt(args []string, conn net.Conn) {
+	handleExpireGeneric(args, conn, time.Millisecond, true)
+}
+
+// handleTTLGeneric implements TTL, PTTL, EXPIRETIME and PEXPIRETIME,
+// which all reply -2 for a missing key and -1 for a key without expiry
+func handleTTLGeneric(args []string, conn net.Conn, unit time.Duration, absolute bool) {
+	if len(args) != 2 {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(args[0]))))
+		return
+	}
+
+	expiry, hasExpiry, exists := store.GetExpiry(args[1])
+	if !exists {
+		conn.Write([]byte(":-2\r\n"))
+		return
+	}
+	if !hasExpiry {
+		conn.Write([]byte(":-1\r\n"))
+		return
+	}
+
+	var result int64
+	if absolute {
+		result = expiry.UnixMilli()
+		if unit == time.Second {
+			result /= 1000
+		}
+	} else {
+		remaining := time.Until(expiry).Milliseconds()
+		if remaining < 0 {
+			remaining = 0
+		}
+		result = remaining
+		if unit == time.Second {
+			result = (remaining + 500) / 1000
+		}
+	}
+
+	conn.Write([]byte(fmt.Sprintf(":%d\r\n", result)))
+}
+
+func handleTTL(args []string, conn net.Conn) {
+	handleTTLGeneric(args, conn, time.Second, false)
+}
+
+func handlePTTL(args []string, conn net.Conn) {
+	handleTTLGeneric(args, conn, time.Millisecond, false)
+}
+
+func handleExpireTime(args []string, conn net.Conn) {
+	handleTTLGeneric(args, conn, time.Second, true)
+}
+
+func handlePExpireTime(args []string, conn net.Conn) {
+	handleTTLGeneric(args, conn, time.Millisecond, true)
+}
+
+func handlePersist(args []string, conn net.Conn) {
+	if len(args) != 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'persist' command\r\n"))
+		return
+	}
+
+	if !store.Persist(args[1]) {
+		conn.Write([]byte(":0\r\n"))
+		return
+	}
+
+	PropagateCommand(args)
+	conn.Write([]byte(":1\r\n"))
+}
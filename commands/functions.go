@@ -0,0 +1,596 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/lua"
+	"github.com/kushalsdesk/redis_with_go/rdb"
+)
+
+// FUNCTION libraries are Lua code that registers named functions when it is
+// loaded, FCALL then runs them like EVAL runs a script. Libraries are loaded
+// from the RDB file at startup, and LOAD, DELETE, FLUSH and RESTORE are
+// replicated like write commands.
+
+// functionLoadTimeout bounds how long the code of a library may run while
+// it registers its functions
+const functionLoadTimeout = 500 * time.Millisecond
+
+type functionFlags int
+
+const (
+	functionNoWrites functionFlags = 1 << iota
+	functionAllowOOM
+	functionAllowStale
+	functionNoCluster
+	functionAllowCrossSlotKeys
+)
+
+// functionFlagNames is in the order FUNCTION LIST gives flags
+var functionFlagNames = []struct {
+	name string
+	flag functionFlags
+}{
+	{"no-writes", functionNoWrites},
+	{"allow-oom", functionAllowOOM},
+	{"allow-stale", functionAllowStale},
+	{"no-cluster", functionNoCluster},
+	{"allow-cross-slot-keys", functionAllowCrossSlotKeys},
+}
+
+type functionLibrary struct {
+	name      string
+	code      string
+	functions map[string]*libraryFunction
+}
+
+type libraryFunction struct {
+	name        string
+	description string // "" when none was given
+	flags       functionFlags
+	fn          *lua.Function
+}
+
+var (
+	libraries      = make(map[string]*functionLibrary)
+	functions      = make(map[string]*libraryFunction) // of every library, by name
+	functionsMutex sync.RWMutex
+)
+
+// LoadFunctionLibrary compiles a library and registers its functions, it
+// is how libraries of the RDB file are loaded
+func LoadFunctionLibrary(code string) error {
+	lib, err := compileLibrary(code)
+	if err != nil {
+		return err
+	}
+	return installLibraries([]*functionLibrary{lib}, "APPEND")
+}
+
+// libraryMetadata reads the "#!lua name=<library>" line libraries start
+// with, and returns the code with that line left empty so that line numbers
+// do not change
+func libraryMetadata(code string) (string, string, error) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", "", errors.New("ERR Missing library metadata")
+	}
+	shebang, rest, found := strings.Cut(code, "\n")
+	if !found {
+		return "", "", errors.New("ERR Invalid library metadata")
+	}
+
+	parts := strings.Fields(shebang[2:])
+	if len(parts) == 0 || !strings.EqualFold(parts[0], "lua") {
+		engine := ""
+		if len(parts) > 0 {
+			engine = parts[0]
+		}
+		return "", "", fmt.Errorf("ERR Engine '%s' not found", engine)
+	}
+	name := ""
+	for _, part := range parts[1:] {
+		value, isName := strings.CutPrefix(part, "name=")
+		if !isName {
+			return "", "", fmt.Errorf("ERR Invalid metadata value given: %s", part)
+		}
+		if name != "" {
+			return "", "", errors.New("ERR Invalid metadata value, name argument was given multiple times")
+		}
+		name = value
+	}
+	if name == "" {
+		return "", "", errors.New("ERR Library name was not given")
+	}
+	return name, "\n" + rest, nil
+}
+
+// validFunctionName tells whether a library or function name only has
+// letters, digits and underscores
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// compileLibrary runs the code of a library, which may only register
+// functions and log, and returns the library with the functions it
+// registered
+func compileLibrary(code string) (*functionLibrary, error) {
+	name, body, err := libraryMetadata(code)
+	if err != nil {
+		return nil, err
+	}
+	if !validFunctionName(name) {
+		return nil, errors.New("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+
+	chunk, err := lua.Compile(body, "user_function")
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %v", err)
+	}
+
+	lib := &functionLibrary{name: name, code: code, functions: make(map[string]*libraryFunction)}
+	s := lua.NewState()
+	s.StrictGlobals = true
+	deadline := time.Now().Add(functionLoadTimeout)
+	s.Hook = func() error {
+		if time.Now().After(deadline) {
+			return errors.New("FUNCTION LOAD timeout")
+		}
+		return nil
+	}
+	s.Globals.Set("redis", loadLibrary(lib))
+	for _, name := range []string{"string", "table", "math", "redis"} {
+		s.Globals.Get(name).(*lua.Table).SetReadonly(true)
+	}
+	s.Globals.SetReadonly(true)
+
+	if _, err := s.Call(chunk); err != nil {
+		msg := err.Error()
+		if t, ok := err.(*lua.Error).Value.(*lua.Table); ok {
+			msg, _ = t.GetString("err").(string)
+		}
+		return nil, fmt.Errorf("ERR Error registering functions: %s", singleLine(msg))
+	}
+	if len(lib.functions) == 0 {
+		return nil, errors.New("ERR No functions registered")
+	}
+	return lib, nil
+}
+
+// loadLibrary is the redis table of library code while it loads
+func loadLibrary(lib *functionLibrary) *lua.Table {
+	redis := lua.NewTable()
+	redis.Set("register_function", &lua.GoFunction{Name: "register_function", Fn: func(s *lua.State, args []lua.Value) []lua.Value {
+		registerFunction(s, lib, args)
+		return nil
+	}})
+
+	setScriptLog(redis)
+	return redis
+}
+
+// registerFunction is redis.register_function, called either with a name
+// and a callback or with a table of named arguments
+func registerFunction(s *lua.State, lib *functionLibrary, args []lua.Value) {
+	f := &libraryFunction{}
+	var callback lua.Value
+
+	switch {
+	case len(args) == 2:
+		name, ok := args[0].(string)
+		if !ok {
+			s.Error("function_name argument given to redis.register_function must be a string")
+		}
+		f.name, callback = name, args[1]
+
+	case len(args) == 1:
+		t, ok := args[0].(*lua.Table)
+		if !ok {
+			s.Error("calling redis.register_function with a single argument is only applicable to Lua table (representing named arguments).")
+		}
+		var key, value lua.Value
+		for {
+			key, value, ok = t.Next(key)
+			if !ok || key == nil {
+				break
+			}
+			switch key {
+			case "function_name":
+				name, isString := value.(string)
+				if !isString {
+					s.Error("function_name argument given to redis.register_function must be a string")
+				}
+				f.name = name
+			case "callback":
+				callback = value
+			case "description":
+				description, isString := value.(string)
+				if !isString {
+					s.Error("description argument given to redis.register_function must be a string")
+				}
+				f.description = description
+			case "flags":
+				flags, isTable := value.(*lua.Table)
+				if !isTable {
+					s.Error("flags argument to redis.register_function must be a table representing function flags")
+				}
+				f.flags = parseFunctionFlags(s, flags)
+			default:
+				s.Error("unknown argument given to redis.register_function")
+			}
+		}
+
+	default:
+		s.Error("wrong number of arguments to redis.register_function")
+	}
+
+	fn, ok := callback.(*lua.Function)
+	if !ok {
+		s.Error("callback argument given to redis.register_function must be a function")
+	}
+	if !validFunctionName(f.name) {
+		s.Error("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	if _, exists := lib.functions[f.name]; exists {
+		s.Error("Function already exists in the library")
+	}
+	f.fn = fn
+	lib.functions[f.name] = f
+}
+
+func parseFunctionFlags(s *lua.State, flags *lua.Table) functionFlags {
+	var result functionFlags
+	for i := 1; i <= flags.Len(); i++ {
+		name, _ := flags.Get(float64(i)).(string)
+		known := false
+		for _, flag := range functionFlagNames {
+			if flag.name == name {
+				result |= flag.flag
+				known = true
+			}
+		}
+		if !known {
+			s.Error("unknown flag given")
+		}
+	}
+	return result
+}
+
+// installLibraries registers libraries, all of them or none, with the
+// policies of FUNCTION RESTORE: APPEND fails on a library that exists
+// already, REPLACE replaces it and FLUSH removes all libraries first. A
+// function name can only be used by one library.
+func installLibraries(libs []*functionLibrary, policy string) error {
+	functionsMutex.Lock()
+	defer functionsMutex.Unlock()
+
+	newLibraries := make(map[string]*functionLibrary)
+	if policy != "FLUSH" {
+		newLibraries = maps.Clone(libraries)
+	}
+	for _, lib := range libs {
+		if _, exists := newLibraries[lib.name]; exists && policy == "APPEND" {
+			return fmt.Errorf("ERR Library '%s' already exists", lib.name)
+		}
+		newLibraries[lib.name] = lib
+	}
+
+	newFunctions := make(map[string]*libraryFunction)
+	for _, lib := range newLibraries {
+		for name, f := range lib.functions {
+			if _, exists := newFunctions[name]; exists {
+				return fmt.Errorf("ERR Function %s already exists", name)
+			}
+			newFunctions[name] = f
+		}
+	}
+	libraries, functions = newLibraries, newFunctions
+	return nil
+}
+
+func lookupFunction(name string) *libraryFunction {
+	functionsMutex.RLock()
+	defer functionsMutex.RUnlock()
+	return functions[name]
+}
+
+// sortedLibraries returns the libraries by name
+func sortedLibraries() []*functionLibrary {
+	functionsMutex.RLock()
+	defer functionsMutex.RUnlock()
+
+	libs := make([]*functionLibrary, 0, len(libraries))
+	for _, lib := range libraries {
+		libs = append(libs, lib)
+	}
+	sort.Slice(libs, func(i, j int) bool { return libs[i].name < libs[j].name })
+	return libs
+}
+
+// libraryCodes returns the code of the libraries by name, the way they are
+// dumped and saved
+func libraryCodes() []string {
+	var codes []string
+	for _, lib := range sortedLibraries() {
+		codes = append(codes, lib.code)
+	}
+	return codes
+}
+
+// handleFcall is FCALL and FCALL_RO
+func handleFcall(args []string, conn net.Conn, readOnly bool) {
+	if len(args) < 3 {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(args[0]))))
+		return
+	}
+
+	f := lookupFunction(args[1])
+	if f == nil {
+		conn.Write([]byte("-ERR Function not found\r\n"))
+		return
+	}
+	keys, argv, err := scriptKeys(args[2:])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	noWrites := f.flags&functionNoWrites != 0
+	if readOnly && !noWrites {
+		conn.Write([]byte("-ERR Can not execute a script with write flag using *_ro command.\r\n"))
+		return
+	}
+	runScript(conn, args, f.name, f.fn, keys, argv, noWrites, true)
+}
+
+func handleFunction(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'function' command\r\n"))
+		return
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "LOAD":
+		handleFunctionLoad(args, conn)
+	case "DELETE":
+		handleFunctionDelete(args, conn)
+	case "FLUSH":
+		handleFunctionFlush(args, conn)
+	case "LIST":
+		handleFunctionList(args, conn)
+	case "DUMP":
+		handleFunctionDump(args, conn)
+	case "RESTORE":
+		handleFunctionRestore(args, conn)
+	case "STATS":
+		handleFunctionStats(args, conn)
+	case "KILL":
+		if len(args) != 2 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'function|kill' command\r\n"))
+			return
+		}
+		handleScriptKill(conn, true)
+	default:
+		conn.Write([]byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try FUNCTION HELP.\r\n", args[1])))
+	}
+}
+
+// handleFunctionLoad is FUNCTION LOAD [REPLACE] code
+func handleFunctionLoad(args []string, conn net.Conn) {
+	if len(args) < 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'function|load' command\r\n"))
+		return
+	}
+	policy := "APPEND"
+	for _, option := range args[2 : len(args)-1] {
+		if !strings.EqualFold(option, "REPLACE") {
+			conn.Write([]byte(fmt.Sprintf("-ERR Unknown option given: %s\r\n", option)))
+			return
+		}
+		policy = "REPLACE"
+	}
+
+	lib, err := compileLibrary(args[len(args)-1])
+	if err == nil {
+		err = installLibraries([]*functionLibrary{lib}, policy)
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	writeBulkString(conn, lib.name)
+	PropagateCommand(args)
+}
+
+func handleFunctionDelete(args []string, conn net.Conn) {
+	if len(args) != 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'function|delete' command\r\n"))
+		return
+	}
+
+	functionsMutex.Lock()
+	lib, exists := libraries[args[2]]
+	if exists {
+		delete(libraries, lib.name)
+		for name := range lib.functions {
+			delete(functions, name)
+		}
+	}
+	functionsMutex.Unlock()
+
+	if !exists {
+		conn.Write([]byte("-ERR Library not found\r\n"))
+		return
+	}
+	conn.Write([]byte("+OK\r\n"))
+	PropagateCommand(args)
+}
+
+func handleFunctionFlush(args []string, conn net.Conn) {
+	if len(args) > 3 || (len(args) == 3 && !strings.EqualFold(args[2], "SYNC") && !strings.EqualFold(args[2], "ASYNC")) {
+		conn.Write([]byte("-ERR FUNCTION FLUSH only supports SYNC|ASYNC option\r\n"))
+		return
+	}
+
+	functionsMutex.Lock()
+	libraries = make(map[string]*functionLibrary)
+	functions = make(map[string]*libraryFunction)
+	functionsMutex.Unlock()
+
+	conn.Write([]byte("+OK\r\n"))
+	PropagateCommand(args)
+}
+
+// handleFunctionList is FUNCTION LIST [LIBRARYNAME pattern] [WITHCODE]
+func handleFunctionList(args []string, conn net.Conn) {
+	pattern, withCode := "", false
+	for i := 2; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "WITHCODE") && !withCode:
+			withCode = true
+		case strings.EqualFold(args[i], "LIBRARYNAME") && i+1 < len(args) && pattern == "":
+			pattern = args[i+1]
+			i++
+		default:
+			conn.Write([]byte(fmt.Sprintf("-ERR Unknown argument %s\r\n", args[i])))
+			return
+		}
+	}
+
+	var entries []string
+	for _, lib := range sortedLibraries() {
+		if pattern != "" && !stringMatch(pattern, lib.name, false) {
+			continue
+		}
+
+		names := make([]string, 0, len(lib.functions))
+		for name := range lib.functions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		functionsList := fmt.Sprintf("*%d\r\n", len(names))
+		for _, name := range names {
+			functionsList += "*6\r\n" + respBulk("name") + respBulk(name) +
+				respBulk("description") + formatDescription(lib.functions[name].description) +
+				respBulk("flags") + formatFunctionFlags(lib.functions[name].flags)
+		}
+
+		fields := 6
+		entry := respBulk("library_name") + respBulk(lib.name) +
+			respBulk("engine") + respBulk("LUA") +
+			respBulk("functions") + functionsList
+		if withCode {
+			fields += 2
+			entry += respBulk("library_code") + respBulk(lib.code)
+		}
+		entries = append(entries, fmt.Sprintf("*%d\r\n", fields)+entry)
+	}
+	conn.Write([]byte(fmt.Sprintf("*%d\r\n", len(entries)) + strings.Join(entries, "")))
+}
+
+func formatDescription(description string) string {
+	if description == "" {
+		return "$-1\r\n"
+	}
+	return respBulk(description)
+}
+
+func formatFunctionFlags(flags functionFlags) string {
+	var names []string
+	for _, flag := range functionFlagNames {
+		if flags&flag.flag != 0 {
+			names = append(names, flag.name)
+		}
+	}
+	return string(EncodeRESPArray(names))
+}
+
+func handleFunctionDump(args []string, conn net.Conn) {
+	if len(args) != 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'function|dump' command\r\n"))
+		return
+	}
+
+	writeBulkString(conn, string(rdb.DumpFunctions(libraryCodes())))
+}
+
+// handleFunctionRestore is FUNCTION RESTORE payload [FLUSH|APPEND|REPLACE]
+func handleFunctionRestore(args []string, conn net.Conn) {
+	if len(args) != 3 && len(args) != 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'function|restore' command\r\n"))
+		return
+	}
+	policy := "APPEND"
+	if len(args) == 4 {
+		policy = strings.ToUpper(args[3])
+		if policy != "FLUSH" && policy != "APPEND" && policy != "REPLACE" {
+			conn.Write([]byte("-ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.\r\n"))
+			return
+		}
+	}
+
+	codes, err := rdb.RestoreFunctions([]byte(args[2]))
+	if err != nil {
+		conn.Write([]byte(fmt.Sprintf("-ERR %v\r\n", err)))
+		return
+	}
+	libs := make([]*functionLibrary, 0, len(codes))
+	for _, code := range codes {
+		lib, err := compileLibrary(code)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		libs = append(libs, lib)
+	}
+
+	if err := installLibraries(libs, policy); err != nil {
+		writeError(conn, err)
+		return
+	}
+	conn.Write([]byte("+OK\r\n"))
+	PropagateCommand(args)
+}
+
+// handleFunctionStats reports the running script and the number of
+// libraries and functions. Like FUNCTION KILL it is answered while a script
+// runs.
+func handleFunctionStats(args []string, conn net.Conn) {
+	if len(args) != 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'function|stats' command\r\n"))
+		return
+	}
+
+	running := "$-1\r\n"
+	scriptMutex.Lock()
+	if rs := currentScript; rs != nil {
+		running = "*6\r\n" + respBulk("name") + respBulk(rs.name) +
+			respBulk("command") + string(EncodeRESPArray(rs.command)) +
+			respBulk("duration_ms") + respInt(time.Since(rs.started).Milliseconds())
+	}
+	scriptMutex.Unlock()
+
+	functionsMutex.RLock()
+	libraryCount, functionCount := len(libraries), len(functions)
+	functionsMutex.RUnlock()
+
+	conn.Write([]byte("*4\r\n" + respBulk("running_script") + running +
+		respBulk("engines") + "*2\r\n" + respBulk("LUA") +
+		"*4\r\n" + respBulk("libraries_count") + respInt(int64(libraryCount)) +
+		respBulk("functions_count") + respInt(int64(functionCount))))
+}
@@ -0,0 +1,429 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// geoSearchKind selects which of the GEOSEARCH/GEORADIUS forms is parsed
+type geoSearchKind int
+
+const (
+	geoRadius geoSearchKind = iota
+	geoRadiusByMember
+	geoSearch
+	geoSearchStore
+)
+
+// geoSearchRequest is a parsed search command together with its reply options
+type geoSearchRequest struct {
+	query     store.GeoQuery
+	withDist  bool
+	withHash  bool
+	withCoord bool
+	storeKey  string
+	storeDist bool
+}
+
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+func parseGeoUnit(arg string) (float64, string) {
+	conversion, ok := geoUnits[strings.ToLower(arg)]
+	if !ok {
+		return 0, "-ERR unsupported unit provided. please use M, KM, FT, MI\r\n"
+	}
+	return conversion, ""
+}
+
+// parseLongLat parses a longitude/latitude pair and checks it can be indexed
+func parseLongLat(lonArg, latArg string) (float64, float64, string) {
+	longitude, err1 := strconv.ParseFloat(lonArg, 64)
+	latitude, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, "-ERR value is not a valid float\r\n"
+	}
+	if !store.GeoValidCoordinates(longitude, latitude) {
+		return 0, 0, fmt.Sprintf("-ERR invalid longitude,latitude pair %f,%f\r\n", longitude, latitude)
+	}
+	return longitude, latitude, ""
+}
+
+func parseRadius(radiusArg, unitArg string) (float64, float64, string) {
+	radius, err := strconv.ParseFloat(radiusArg, 64)
+	if err != nil {
+		return 0, 0, "-ERR need numeric radius\r\n"
+	}
+	if radius < 0 {
+		return 0, 0, "-ERR radius cannot be negative\r\n"
+	}
+	conversion, errMsg := parseGeoUnit(unitArg)
+	return radius, conversion, errMsg
+}
+
+// formatCoordinate renders a coordinate with 17 decimals and trailing zeros
+// trimmed, matching Redis's human long double replies
+func formatCoordinate(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func formatDistance(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+func handleGeoAdd(args []string, conn net.Conn) {
+	if len(args) < 5 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'geoadd' command\r\n"))
+		return
+	}
+
+	var opts store.ZAddOptions
+	ch := false
+
+	i := 2
+parseOptions:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "CH":
+			ch = true
+		default:
+			break parseOptions
+		}
+	}
+
+	if (len(args)-i)%3 != 0 || (opts.NX && opts.XX) {
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+
+	// GEOADD is a ZADD with the coordinates turned into geohash scores, and
+	// replicas get exactly that ZADD
+	zaddArgs := append([]string{"ZADD"}, args[1:i]...)
+	entries := make([]store.ZSetEntry, 0, (len(args)-i)/3)
+	for j := i; j < len(args); j += 3 {
+		longitude, latitude, errMsg := parseLongLat(args[j], args[j+1])
+		if errMsg != "" {
+			conn.Write([]byte(errMsg))
+			return
+		}
+
+		score, _ := store.GeoEncodeScore(longitude, latitude)
+		entries = append(entries, store.ZSetEntry{Member: args[j+2], Score: score})
+		zaddArgs = append(zaddArgs, strconv.FormatUint(uint64(score), 10), args[j+2])
+	}
+
+	result, err := store.ZAdd(args[1], entries, opts)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if result.Added+result.Updated > 0 {
+		PropagateCommand(zaddArgs)
+	}
+
+	changed := result.Added
+	if ch {
+		changed += result.Updated
+	}
+	writeInteger(conn, int64(changed))
+}
+
+func handleGeoPos(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'geopos' command\r\n"))
+		return
+	}
+
+	positions, err := store.GeoPosition(args[1], args[2:])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(positions)))
+	for _, position := range positions {
+		if position == nil {
+			resp.WriteString("*-1\r\n")
+			continue
+		}
+		longitude, latitude := formatCoordinate(position[0]), formatCoordinate(position[1])
+		resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(longitude), longitude, len(latitude), latitude))
+	}
+	conn.Write([]byte(resp.String()))
+}
+
+func handleGeoDist(args []string, conn net.Conn) {
+	if len(args) < 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'geodist' command\r\n"))
+		return
+	}
+	if len(args) > 5 {
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+
+	conversion := 1.0
+	if len(args) == 5 {
+		var errMsg string
+		if conversion, errMsg = parseGeoUnit(args[4]); errMsg != "" {
+			conn.Write([]byte(errMsg))
+			return
+		}
+	}
+
+	dist, ok, err := store.GeoDistance(args[1], args[2], args[3])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	if !ok {
+		writeNullBulk(conn)
+		return
+	}
+	writeBulkString(conn, formatDistance(dist/conversion))
+}
+
+func handleGeoHash(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'geohash' command\r\n"))
+		return
+	}
+
+	hashes, found, err := store.GeoHashes(args[1], args[2:])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(hashes)))
+	for i, hash := range hashes {
+		if !found[i] {
+			resp.WriteString("$-1\r\n")
+			continue
+		}
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(hash), hash))
+	}
+	conn.Write([]byte(resp.String()))
+}
+
+// parseGeoSearch parses GEORADIUS, GEORADIUSBYMEMBER (and their _RO forms),
+// GEOSEARCH and GEOSEARCHSTORE. The legacy forms take the center and radius
+// positionally and STORE/STOREDIST as options, GEOSEARCH takes everything
+// as options. On error it returns the RESP error to send back.
+func parseGeoSearch(args []string, kind geoSearchKind, readOnly bool) (geoSearchRequest, string) {
+	var req geoSearchRequest
+	q := &req.query
+	command := strings.ToUpper(args[0])
+
+	var base int
+	fromMember, fromLonLat, byRadius, byBox := false, false, false, false
+
+	switch kind {
+	case geoRadius:
+		base = 6
+		var errMsg string
+		if q.Longitude, q.Latitude, errMsg = parseLongLat(args[2], args[3]); errMsg != "" {
+			return req, errMsg
+		}
+		if q.Radius, q.Conversion, errMsg = parseRadius(args[4], args[5]); errMsg != "" {
+			return req, errMsg
+		}
+	case geoRadiusByMember:
+		base = 5
+		q.FromMember, q.UseMember = args[2], true
+		var errMsg string
+		if q.Radius, q.Conversion, errMsg = parseRadius(args[3], args[4]); errMsg != "" {
+			return req, errMsg
+		}
+	case geoSearch:
+		base = 2
+	case geoSearchStore:
+		base = 3
+		req.storeKey = args[1]
+	}
+
+	searchForm := kind == geoSearch || kind == geoSearchStore
+	remaining := len(args) - base
+
+	for i := 0; i < remaining; i++ {
+		arg := strings.ToUpper(args[base+i])
+		next := func(n int) string { return args[base+i+n] }
+
+		switch {
+		case arg == "WITHDIST":
+			req.withDist = true
+		case arg == "WITHHASH":
+			req.withHash = true
+		case arg == "WITHCOORD":
+			req.withCoord = true
+		case arg == "ANY":
+			q.Any = true
+		case arg == "ASC":
+			q.Sort = store.GeoSortAsc
+		case arg == "DESC":
+			q.Sort = store.GeoSortDesc
+		case arg == "COUNT" && i+1 < remaining:
+			count, err := strconv.ParseInt(next(1), 10, 64)
+			if err != nil {
+				return req, "-ERR value is not an integer or out of range\r\n"
+			}
+			if count <= 0 {
+				return req, "-ERR COUNT must be > 0\r\n"
+			}
+			q.Count = int(count)
+			i++
+		case (arg == "STORE" || arg == "STOREDIST") && i+1 < remaining && !readOnly && !searchForm:
+			req.storeKey = next(1)
+			req.storeDist = arg == "STOREDIST"
+			i++
+		case arg == "STOREDIST" && kind == geoSearchStore:
+			req.storeDist = true
+		case arg == "FROMMEMBER" && i+1 < remaining && searchForm && !fromLonLat:
+			q.FromMember, q.UseMember = next(1), true
+			fromMember = true
+			i++
+		case arg == "FROMLONLAT" && i+2 < remaining && searchForm && !fromMember:
+			var errMsg string
+			if q.Longitude, q.Latitude, errMsg = parseLongLat(next(1), next(2)); errMsg != "" {
+				return req, errMsg
+			}
+			fromLonLat = true
+			i += 2
+		case arg == "BYRADIUS" && i+2 < remaining && searchForm && !byBox:
+			var errMsg string
+			if q.Radius, q.Conversion, errMsg = parseRadius(next(1), next(2)); errMsg != "" {
+				return req, errMsg
+			}
+			byRadius = true
+			i += 2
+		case arg == "BYBOX" && i+3 < remaining && searchForm && !byRadius:
+			width, err1 := strconv.ParseFloat(next(1), 64)
+			height, err2 := strconv.ParseFloat(next(2), 64)
+			if err1 != nil {
+				return req, "-ERR need numeric width\r\n"
+			}
+			if err2 != nil {
+				return req, "-ERR need numeric height\r\n"
+			}
+			if width < 0 || height < 0 {
+				return req, "-ERR height or width cannot be negative\r\n"
+			}
+			var errMsg string
+			if q.Conversion, errMsg = parseGeoUnit(next(3)); errMsg != "" {
+				return req, errMsg
+			}
+			q.ByBox, q.Width, q.Height = true, width, height
+			byBox = true
+			i += 3
+		default:
+			return req, "-ERR syntax error\r\n"
+		}
+	}
+
+	if req.storeKey != "" && (req.withDist || req.withHash || req.withCoord) {
+		if kind == geoSearchStore {
+			return req, "-ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options\r\n"
+		}
+		return req, "-ERR STORE option in GEORADIUS is not compatible with WITHDIST, WITHHASH and WITHCOORD options\r\n"
+	}
+	if searchForm && !fromMember && !fromLonLat {
+		return req, fmt.Sprintf("-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s\r\n", command)
+	}
+	if searchForm && !byRadius && !byBox {
+		return req, fmt.Sprintf("-ERR exactly one of BYRADIUS and BYBOX can be specified for %s\r\n", command)
+	}
+	if q.Any && q.Count == 0 {
+		return req, "-ERR the ANY argument requires COUNT argument\r\n"
+	}
+
+	return req, ""
+}
+
+// handleGeoSearch implements GEOSEARCH, GEOSEARCHSTORE and the legacy
+// GEORADIUS family
+func handleGeoSearch(args []string, conn net.Conn, kind geoSearchKind, readOnly bool) {
+	minArgs := map[geoSearchKind]int{geoRadius: 6, geoRadiusByMember: 5, geoSearch: 7, geoSearchStore: 8}[kind]
+	if len(args) < minArgs {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(args[0]))))
+		return
+	}
+
+	req, errMsg := parseGeoSearch(args, kind, readOnly)
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	source := args[1]
+	if kind == geoSearchStore {
+		source = args[2]
+	}
+
+	if req.storeKey != "" {
+		stored, err := store.GeoSearchStore(req.storeKey, source, req.query, req.storeDist)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		PropagateCommand(args)
+		writeInteger(conn, int64(stored))
+		return
+	}
+
+	points, err := store.GeoSearch(source, req.query)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	writeGeoPoints(conn, points, req)
+}
+
+// writeGeoPoints replies with bare member names, or with one nested array
+// per member when any WITH* option was given
+func writeGeoPoints(conn net.Conn, points []store.GeoPoint, req geoSearchRequest) {
+	options := 0
+	for _, enabled := range []bool{req.withDist, req.withHash, req.withCoord} {
+		if enabled {
+			options++
+		}
+	}
+
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(points)))
+	for _, point := range points {
+		if options > 0 {
+			resp.WriteString(fmt.Sprintf("*%d\r\n", options+1))
+		}
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(point.Member), point.Member))
+
+		if req.withDist {
+			dist := formatDistance(point.Dist)
+			resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(dist), dist))
+		}
+		if req.withHash {
+			resp.WriteString(fmt.Sprintf(":%d\r\n", uint64(point.Score)))
+		}
+		if req.withCoord {
+			longitude, latitude := formatCoordinate(point.Longitude), formatCoordinate(point.Latitude)
+			resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(longitude), longitude, len(latitude), latitude))
+		}
+	}
+	conn.Write([]byte(resp.String()))
+}
@@ -0,0 +1,125 @@
+package commands
+
+// stringMatch reports whether str matches the glob-style pattern, with the
+// same rules as Redis: * matches any sequence, ? any single byte, [abc] and
+// [a-z] a set or range (negated with ^), and \ escapes the next byte.
+func stringMatch(pattern, str string, nocase bool) bool {
+	return matchFrom(pattern, str, nocase, 0)
+}
+
+// matchFrom guards against patterns like "a*a*a*a*b" blowing up exponentially
+// by bounding the recursion depth the way Redis does
+func matchFrom(pattern, str string, nocase bool, nesting int) bool {
+	if nesting > 1000 {
+		return false
+	}
+
+	p, s := 0, 0
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for i := s; i <= len(str); i++ {
+				if matchFrom(pattern[p+1:], str[i:], nocase, nesting+1) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if s >= len(str) {
+				return false
+			}
+			s++
+
+		case '[':
+			if s >= len(str) {
+				return false
+			}
+			p++
+			negate := p < len(pattern) && pattern[p] == '^'
+			if negate {
+				p++
+			}
+
+			matched := false
+			for p < len(pattern) && pattern[p] != ']' {
+				switch {
+				case pattern[p] == '\\' && p+1 < len(pattern):
+					p++
+					if equalByte(pattern[p], str[s], nocase) {
+						matched = true
+					}
+				case p+2 < len(pattern) && pattern[p+1] == '-':
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					c := str[s]
+					if nocase {
+						start, end, c = lowerByte(start), lowerByte(end), lowerByte(c)
+					}
+					if c >= start && c <= end {
+						matched = true
+					}
+					p += 2
+				default:
+					if equalByte(pattern[p], str[s], nocase) {
+						matched = true
+					}
+				}
+				p++
+			}
+
+			// An unterminated class behaves as if it were closed at the end
+			if p >= len(pattern) {
+				p = len(pattern) - 1
+			}
+			if matched == negate {
+				return false
+			}
+			s++
+
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+
+		default:
+			if s >= len(str) || !equalByte(pattern[p], str[s], nocase) {
+				return false
+			}
+			s++
+		}
+
+		p++
+		if s >= len(str) {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			break
+		}
+	}
+
+	return p == len(pattern) && s == len(str)
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return lowerByte(a) == lowerByte(b)
+	}
+	return a == b
+}
+
+func lowerByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
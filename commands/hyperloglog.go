@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+func handlePFAdd(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'pfadd' command\r\n"))
+		return
+	}
+
+	updated, err := store.PFAdd(args[1], args[2:])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if updated {
+		PropagateCommand(args)
+		writeInteger(conn, 1)
+		return
+	}
+	writeInteger(conn, 0)
+}
+
+func handlePFCount(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'pfcount' command\r\n"))
+		return
+	}
+
+	count, cacheUpdated, err := store.PFCount(args[1:])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	// Refreshing the cached cardinality changes the stored bytes, Redis
+	// replicates that so replicas keep byte-identical values
+	if cacheUpdated {
+		PropagateCommand(args)
+	}
+	writeInteger(conn, int64(count))
+}
+
+func handlePFMerge(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'pfmerge' command\r\n"))
+		return
+	}
+
+	if err := store.PFMerge(args[1], args[2:]); err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	PropagateCommand(args)
+	conn.Write([]byte("+OK\r\n"))
+}
+
+func handlePFDebug(args []string, conn net.Conn) {
+	if len(args) != 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'pfdebug' command\r\n"))
+		return
+	}
+
+	key := args[2]
+	switch strings.ToUpper(args[1]) {
+	case "GETREG":
+		registers, converted, err := store.PFDebugRegisters(key)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		if converted {
+			PropagateCommand(args)
+		}
+
+		var resp strings.Builder
+		resp.WriteString(fmt.Sprintf("*%d\r\n", len(registers)))
+		for _, register := range registers {
+			resp.WriteString(fmt.Sprintf(":%d\r\n", register))
+		}
+		conn.Write([]byte(resp.String()))
+
+	case "DECODE":
+		decoded, err := store.PFDebugDecode(key)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		conn.Write([]byte("+" + decoded + "\r\n"))
+
+	case "ENCODING":
+		encoding, err := store.PFDebugEncoding(key)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		conn.Write([]byte("+" + encoding + "\r\n"))
+
+	case "TODENSE":
+		converted, err := store.PFDebugToDense(key)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		if converted {
+			PropagateCommand(args)
+			writeInteger(conn, 1)
+			return
+		}
+		writeInteger(conn, 0)
+
+	default:
+		conn.Write([]byte(fmt.Sprintf("-ERR Unknown PFDEBUG subcommand '%s'\r\n", args[1])))
+	}
+}
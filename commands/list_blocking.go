@@ -2,127 +2,274 @@ package commands
 
 import (
 	"fmt"
+	"math"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kushalsdesk/redis_with_go/store"
 )
 
+// parseBlockingTimeout parses a timeout in seconds, 0 meaning forever
+func parseBlockingTimeout(arg string) (time.Duration, string) {
+	timeout, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return 0, "-ERR timeout is not a float or out of range\r\n"
+	}
+	if timeout < 0 {
+		return 0, "-ERR timeout is negative\r\n"
+	}
+	return time.Duration(timeout * float64(time.Second)), ""
+}
+
+// listOpCommand is the non-blocking command replicas receive for a list op
+// that was served, popping exactly the elements the master popped
+func listOpCommand(op store.ListBlockingOp, result store.BlockingResult) []string {
+	from := "RIGHT"
+	if op.Left {
+		from = "LEFT"
+	}
+
+	if op.Destination != "" {
+		to := "RIGHT"
+		if op.ToLeft {
+			to = "LEFT"
+		}
+		return []string{"LMOVE", result.Key, op.Destination, from, to}
+	}
+
+	command := "RPOP"
+	if op.Left {
+		command = "LPOP"
+	}
+	if op.Count > 0 {
+		return []string{command, result.Key, strconv.Itoa(len(result.Elements))}
+	}
+	return []string{command, result.Key}
+}
+
+// popOrBlock runs op, waiting up to timeout (0 = forever) for one of its keys
+// to be filled when block is set. It returns nil when nothing was popped and
+// false when an error was already written. Commands that don't come from a
+// client connection (EXEC, the replication stream) never block, like in Redis.
+func popOrBlock(conn net.Conn, op store.ListBlockingOp, block bool, timeout time.Duration) (*store.BlockingResult, bool) {
+	info := lookupClient(conn)
+
+	result, waiter, err := store.ListPopOrBlock(op, info.clientID(), block && info != nil)
+	if err != nil {
+		writeError(conn, err)
+		return nil, false
+	}
+	if result != nil {
+		PropagateCommand(listOpCommand(op, *result))
+		return result, true
+	}
+	if waiter == nil {
+		return nil, true
+	}
+
+	result, err = waitForData(info, waiter, timeout)
+	if err != nil {
+		writeError(conn, err)
+		return nil, false
+	}
+	return result, true
+}
+
+// serveBlockedClients hands what the last command wrote to clients blocked
+// on it. Each served list pop or group read is propagated before the client
+// hears about it, so anything the client does next reaches replicas after it.
+func serveBlockedClients() {
+	for _, served := range store.ServeReadyKeys() {
+		switch waiter := served.Waiter; {
+		case served.Result.Err != nil:
+		case waiter.ListOp != nil:
+			PropagateCommand(listOpCommand(*waiter.ListOp, served.Result))
+		case waiter.GroupRead != nil:
+			entries := len(served.Result.Streams[0].Entries)
+			PropagateCommand(groupReadCommand(*waiter.GroupRead, served.Result.Key, ">", entries))
+		}
+		served.Waiter.Response <- served.Result
+	}
+}
+
 func handleBLPop(args []string, conn net.Conn) {
+	handleBlockingPop(args, conn, true)
+}
+
+func handleBRPop(args []string, conn net.Conn) {
+	handleBlockingPop(args, conn, false)
+}
+
+func handleBlockingPop(args []string, conn net.Conn, left bool) {
 	if len(args) < 3 {
-		conn.Write([]byte("-ERR wrong number of arguments for 'blpop' command\r\n"))
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(args[0]))))
 		return
 	}
 
 	// last argument is timeout
-	timeoutStr := args[len(args)-1]
-	timeout, err := strconv.ParseFloat(timeoutStr, 64)
-	if err != nil {
-		conn.Write([]byte("-ERR timeout is not a float or out of range\r\n"))
+	timeout, errMsg := parseBlockingTimeout(args[len(args)-1])
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	op := store.ListBlockingOp{Keys: args[1 : len(args)-1], Left: left}
+	result, ok := popOrBlock(conn, op, true, timeout)
+	if !ok {
+		return
+	}
+	if result == nil {
+		conn.Write([]byte("$-1\r\n"))
 		return
 	}
 
-	// as Keys are all arguments except the last one(timeout)
-	keys := args[1 : len(args)-1]
+	element := result.Elements[0]
+	resp := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(result.Key), result.Key, len(element), element)
+	conn.Write([]byte(resp))
+}
 
-	//tyring out immediate pop first
-	key, element, found := store.ListBlockingPopImmediate(keys, true)
-	if found {
-		resp := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(key), key, len(element), element)
-		conn.Write([]byte(resp))
+func handleBLMove(args []string, conn net.Conn) {
+	if len(args) != 6 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'blmove' command\r\n"))
 		return
 	}
 
-	// if timeout is 0, block indefinitely
-	var timeoutDuration time.Duration
-	if timeout == 0 {
-		timeoutDuration = time.Duration(0)
-	} else {
-		timeoutDuration = time.Duration(timeout * float64(time.Second))
+	fromLeft, ok1 := parseListEnd(args[3])
+	toLeft, ok2 := parseListEnd(args[4])
+	if !ok1 || !ok2 {
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
 	}
 
-	//Register for blocking
-	client := store.RegisterBlockingClient(keys, true, timeoutDuration)
-	defer store.UnregisterBlockingClient(client)
+	blockingMove(args[1], args[2], fromLeft, toLeft, args[5], conn)
+}
 
-	//wait for result or timeout
-	if timeout == 0 {
-		result := <-client.Response
-		if result.Success {
-			resp := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(result.Key), result.Key, len(result.Value), result.Value)
-			conn.Write([]byte(resp))
-		} else {
-			conn.Write([]byte("$-1\r\n"))
-		}
-	} else {
-		// block with timeout
-		select {
-		case result := <-client.Response:
-			if result.Success {
-				resp := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(result.Key), result.Key, len(result.Value), result.Value)
-				conn.Write([]byte(resp))
-			} else {
-				conn.Write([]byte("$-1\r\n"))
-			}
-		case <-time.After(timeoutDuration):
-			conn.Write([]byte("$-1\r\n"))
-		}
+func handleBRPopLPush(args []string, conn net.Conn) {
+	if len(args) != 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'brpoplpush' command\r\n"))
+		return
 	}
+
+	blockingMove(args[1], args[2], false, true, args[3], conn)
 }
 
-func handleBRPop(args []string, conn net.Conn) {
-	if len(args) < 3 {
-		conn.Write([]byte("-ERR wrong number of arguments for 'brpop' command\r\n"))
+func blockingMove(source, destination string, fromLeft, toLeft bool, timeoutArg string, conn net.Conn) {
+	timeout, errMsg := parseBlockingTimeout(timeoutArg)
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
 		return
 	}
 
-	timeoutStr := args[len(args)-1]
-	timeout, err := strconv.ParseFloat(timeoutStr, 64)
-	if err != nil {
-		conn.Write([]byte("-ERR timeout is not a float or out of range\r\n"))
+	op := store.ListBlockingOp{
+		Keys:        []string{source},
+		Left:        fromLeft,
+		Destination: destination,
+		ToLeft:      toLeft,
+	}
+	result, ok := popOrBlock(conn, op, true, timeout)
+	if !ok {
+		return
+	}
+	if result == nil {
+		writeNullBulk(conn)
+		return
+	}
+	writeBulkString(conn, result.Elements[0])
+}
+
+// parseMPop parses the numkeys key [key ...] LEFT|RIGHT [COUNT count] part
+// shared by LMPOP and BLMPOP
+func parseMPop(args []string) (store.ListBlockingOp, string) {
+	var op store.ListBlockingOp
+
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys <= 0 {
+		return op, "-ERR numkeys should be greater than 0\r\n"
+	}
+	if numKeys > len(args)-2 {
+		return op, "-ERR syntax error\r\n"
+	}
+	op.Keys = args[1 : numKeys+1]
+
+	rest := args[numKeys+1:]
+	left, ok := parseListEnd(rest[0])
+	if !ok {
+		return op, "-ERR syntax error\r\n"
+	}
+	op.Left = left
+	op.Count = 1
+
+	rest = rest[1:]
+	if len(rest) == 0 {
+		return op, ""
+	}
+	if len(rest) != 2 || strings.ToUpper(rest[0]) != "COUNT" {
+		return op, "-ERR syntax error\r\n"
+	}
+	count, err := strconv.Atoi(rest[1])
+	if err != nil || count <= 0 {
+		return op, "-ERR count should be greater than 0\r\n"
+	}
+	op.Count = count
+
+	return op, ""
+}
+
+func handleLMPop(args []string, conn net.Conn) {
+	if len(args) < 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'lmpop' command\r\n"))
 		return
 	}
 
-	keys := args[1 : len(args)-1]
-	key, element, found := store.ListBlockingPopImmediate(keys, false)
-	if found {
-		resp := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(key), key, len(element), element)
-		conn.Write([]byte(resp))
+	op, errMsg := parseMPop(args[1:])
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
 		return
 	}
 
-	var timeoutDuration time.Duration
-	if timeout == 0 {
-		timeoutDuration = time.Duration(0)
-	} else {
-		timeoutDuration = time.Duration(timeout * float64(time.Second))
+	result, ok := popOrBlock(conn, op, false, 0)
+	if ok {
+		writeMPopReply(conn, result)
 	}
+}
 
-	// Regsitering for blocking
-	client := store.RegisterBlockingClient(keys, false, timeoutDuration)
-	defer store.UnregisterBlockingClient(client)
+func handleBLMPop(args []string, conn net.Conn) {
+	if len(args) < 5 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'blmpop' command\r\n"))
+		return
+	}
 
-	// waiting for result or timeout
-	if timeout == 0 {
-		result := <-client.Response
-		if result.Success {
-			resp := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(result.Key), result.Key, len(result.Value), result.Value)
-			conn.Write([]byte(resp))
-		} else {
-			conn.Write([]byte("$-1\r\n"))
-		}
-	} else {
-		select {
-		case result := <-client.Response:
-			if result.Success {
-				resp := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(result.Key), result.Key, len(result.Value), result.Value)
-				conn.Write([]byte(resp))
-			} else {
-				conn.Write([]byte("$-1\r\n"))
-			}
-		case <-time.After(timeoutDuration):
-			conn.Write([]byte("$-1\r\n"))
-		}
+	timeout, errMsg := parseBlockingTimeout(args[1])
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	op, errMsg := parseMPop(args[2:])
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	result, ok := popOrBlock(conn, op, true, timeout)
+	if ok {
+		writeMPopReply(conn, result)
+	}
+}
+
+// writeMPopReply writes the key and the popped elements, or a null array
+func writeMPopReply(conn net.Conn, result *store.BlockingResult) {
+	if result == nil {
+		conn.Write([]byte("*-1\r\n"))
+		return
+	}
+
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n", len(result.Key), result.Key, len(result.Elements)))
+	for _, element := range result.Elements {
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(element), element))
 	}
+	conn.Write([]byte(resp.String()))
 }
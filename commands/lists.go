@@ -2,8 +2,10 @@ package commands
 
 import (
 	"fmt"
+	"math"
 	"net"
 	"strconv"
+	"strings"
 
 	"github.com/kushalsdesk/redis_with_go/store"
 )
@@ -236,3 +238,247 @@ func handleLPop(args []string, conn net.Conn) {
 	}
 	conn.Write([]byte(resp))
 }
+
+func handlePushExisting(args []string, conn net.Conn, left bool) {
+	if len(args) < 3 {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(args[0]))))
+		return
+	}
+
+	length, err := store.ListPushExisting(args[1], args[2:], left)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if length > 0 {
+		PropagateCommand(args)
+	}
+	writeInteger(conn, int64(length))
+}
+
+func handleLSet(args []string, conn net.Conn) {
+	if len(args) != 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'lset' command\r\n"))
+		return
+	}
+
+	index, err := strconv.Atoi(args[2])
+	if err != nil {
+		conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+		return
+	}
+
+	if err := store.ListSet(args[1], index, args[3]); err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	PropagateCommand(args)
+	conn.Write([]byte("+OK\r\n"))
+}
+
+func handleLInsert(args []string, conn net.Conn) {
+	if len(args) != 5 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'linsert' command\r\n"))
+		return
+	}
+
+	var before bool
+	switch strings.ToUpper(args[2]) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+
+	length, err := store.ListInsert(args[1], before, args[3], args[4])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if length > 0 {
+		PropagateCommand(args)
+	}
+	writeInteger(conn, int64(length))
+}
+
+func handleLRem(args []string, conn net.Conn) {
+	if len(args) != 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'lrem' command\r\n"))
+		return
+	}
+
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+		return
+	}
+
+	removed, err := store.ListRemove(args[1], count, args[3])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if removed > 0 {
+		PropagateCommand(args)
+	}
+	writeInteger(conn, int64(removed))
+}
+
+func handleLTrim(args []string, conn net.Conn) {
+	if len(args) != 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'ltrim' command\r\n"))
+		return
+	}
+
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+		return
+	}
+
+	if err := store.ListTrim(args[1], start, stop); err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	PropagateCommand(args)
+	conn.Write([]byte("+OK\r\n"))
+}
+
+func handleLPos(args []string, conn net.Conn) {
+	if len(args) < 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'lpos' command\r\n"))
+		return
+	}
+
+	rank, count, maxLen := 1, 0, 0
+	hasCount := false
+
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+
+		value, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+			return
+		}
+
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if value == 0 {
+				conn.Write([]byte("-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list\r\n"))
+				return
+			}
+			if value == math.MinInt64 {
+				conn.Write([]byte("-ERR value is out of range\r\n"))
+				return
+			}
+			rank = int(value)
+		case "COUNT":
+			if value < 0 {
+				conn.Write([]byte("-ERR COUNT can't be negative\r\n"))
+				return
+			}
+			count, hasCount = int(value), true
+		case "MAXLEN":
+			if value < 0 {
+				conn.Write([]byte("-ERR MAXLEN can't be negative\r\n"))
+				return
+			}
+			maxLen = int(value)
+		default:
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+	}
+
+	// Without COUNT only the first match is wanted
+	limit := count
+	if !hasCount {
+		limit = 1
+	}
+
+	matches, err := store.ListPosition(args[1], args[2], rank, limit, maxLen)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if !hasCount {
+		if len(matches) == 0 {
+			writeNullBulk(conn)
+			return
+		}
+		writeInteger(conn, int64(matches[0]))
+		return
+	}
+
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(matches)))
+	for _, match := range matches {
+		resp.WriteString(fmt.Sprintf(":%d\r\n", match))
+	}
+	conn.Write([]byte(resp.String()))
+}
+
+// parseListEnd parses the LEFT|RIGHT arguments of LMOVE
+func parseListEnd(arg string) (left bool, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+func handleLMove(args []string, conn net.Conn) {
+	if len(args) != 5 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'lmove' command\r\n"))
+		return
+	}
+
+	fromLeft, ok1 := parseListEnd(args[3])
+	toLeft, ok2 := parseListEnd(args[4])
+	if !ok1 || !ok2 {
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+
+	moveListElement(args, conn, fromLeft, toLeft)
+}
+
+func handleRPopLPush(args []string, conn net.Conn) {
+	if len(args) != 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'rpoplpush' command\r\n"))
+		return
+	}
+
+	moveListElement(args, conn, false, true)
+}
+
+func moveListElement(args []string, conn net.Conn, fromLeft, toLeft bool) {
+	element, moved, err := store.ListMove(args[1], args[2], fromLeft, toLeft)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	if !moved {
+		writeNullBulk(conn)
+		return
+	}
+
+	PropagateCommand(args)
+	writeBulkString(conn, element)
+}
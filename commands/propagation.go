@@ -3,10 +3,15 @@ package commands
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/kushalsdesk/redis_with_go/store"
 )
 
+// propagationMutex keeps the replication stream in the order commands were
+// propagated, concurrent writes to a replica connection would interleave
+var propagationMutex sync.Mutex
+
 var writeCommands = map[string]bool{
 	"SET":    true,
 	"DEL":    true,
@@ -19,6 +24,53 @@ var writeCommands = map[string]bool{
 	"DECR":   true,
 	"DECRBY": true,
 	"XADD":   true,
+
+	"PEXPIREAT": true,
+	"PERSIST":   true,
+	"SETNX":     true,
+	"MSET":      true,
+	"MSETNX":    true,
+	"APPEND":    true,
+	"SETRANGE":  true,
+	"SETBIT":    true,
+	"BITOP":     true,
+	"BITFIELD":  true,
+	"PFADD":     true,
+	"PFCOUNT":   true,
+	"PFMERGE":   true,
+	"PFDEBUG":   true,
+	"LPUSHX":    true,
+	"RPUSHX":    true,
+	"LSET":      true,
+	"LINSERT":   true,
+	"LREM":      true,
+	"LTRIM":     true,
+	"LMOVE":     true,
+	"RPOPLPUSH": true,
+	"ZADD":      true,
+	"ZREM":      true,
+	"XGROUP":    true,
+	"XDEL":      true,
+	"XTRIM":     true,
+	"XSETID":    true,
+	"XACK":      true,
+
+	"GEORADIUS":         true,
+	"GEORADIUSBYMEMBER": true,
+	"GEOSEARCHSTORE":    true,
+	"XREADGROUP":        true,
+	"XCLAIM":            true,
+
+	// Only the subcommands that change libraries are propagated
+	"FUNCTION": true,
+}
+
+// Keys the master expires are deleted on replicas through an explicit DEL,
+// replicas never expire keys on their own
+func init() {
+	store.SetExpiredKeyHandler(func(key string) {
+		PropagateCommand([]string{"DEL", key})
+	})
 }
 
 func IsWriteCommand(command string) bool {
@@ -45,6 +97,60 @@ func PropagateCommand(args []string) {
 		return
 	}
 
+	propagationMutex.Lock()
+	defer propagationMutex.Unlock()
+
+	if batchingPropagation {
+		propagationBatch = append(propagationBatch, args)
+		return
+	}
+	propagate([][]string{args})
+}
+
+// While EXEC or a script runs, the commands it propagates are collected so
+// replicas get them wrapped in MULTI/EXEC, as one unit
+var (
+	batchingPropagation bool
+	propagationBatch    [][]string
+)
+
+// beginPropagationBatch starts collecting propagated commands. It returns
+// false when a batch is already being collected, for a script run by EXEC,
+// and only the caller that started the batch ends it.
+func beginPropagationBatch() bool {
+	propagationMutex.Lock()
+	defer propagationMutex.Unlock()
+	if batchingPropagation {
+		return false
+	}
+	batchingPropagation = true
+	propagationBatch = nil
+	return true
+}
+
+// endPropagationBatch propagates what was collected inside MULTI/EXEC. A
+// transaction that wrote nothing propagates nothing.
+func endPropagationBatch() {
+	propagationMutex.Lock()
+	defer propagationMutex.Unlock()
+
+	batch := propagationBatch
+	batchingPropagation = false
+	propagationBatch = nil
+
+	if len(batch) == 0 {
+		return
+	}
+	wrapped := make([][]string, 0, len(batch)+2)
+	wrapped = append(wrapped, []string{"MULTI"})
+	wrapped = append(wrapped, batch...)
+	wrapped = append(wrapped, []string{"EXEC"})
+	propagate(wrapped)
+}
+
+// propagate writes commands to every replica in one go. Callers hold
+// propagationMutex.
+func propagate(commands [][]string) {
 	replState := store.GetReplicationState()
 	if replState.Role != "master" {
 		return
@@ -55,23 +161,30 @@ func PropagateCommand(args []string) {
 		return
 	}
 
-	respCommand := EncodeRESPArray(args)
-	cmdSize := store.EstimateCommandSize(args)
-	fmt.Printf("📡 Propagating to %d replicas: %v (size ~%d bytes)\n", len(replicas), args, cmdSize)
+	var stream []byte
+	var cmdSize int64
+	for _, args := range commands {
+		stream = append(stream, EncodeRESPArray(args)...)
+		cmdSize += store.EstimateCommandSize(args)
+	}
+	fmt.Printf("📡 Propagating to %d replicas: %v (size ~%d bytes)\n", len(replicas), commands, cmdSize)
 
-	successCount := 0
 	for _, replica := range replicas {
-		go func(r *store.ReplicationConnection) {
-			_, err := r.Connection.Write(respCommand)
-			if err != nil {
-				fmt.Printf("❌ Propagation failed to %s: %v\n", r.Address, err)
-				store.RemoveReplicaByConnection(r.Connection)
-			} else {
-				successCount++
-			}
-		}(replica)
+		if _, err := replica.Connection.Write(stream); err != nil {
+			fmt.Printf("❌ Propagation failed to %s: %v\n", replica.Address, err)
+			store.RemoveReplicaByConnection(replica.Connection)
+		}
 	}
 
 	store.UpdateMasterOffset(cmdSize)
 	fmt.Printf("✅ Command propagated successfully\n")
 }
+
+// ExecuteReplicated applies a command received from the master through the
+// regular handlers and returns the reply it would have produced. The reply is
+// only useful for logging, replicas never answer the replication stream.
+func ExecuteReplicated(args []string) string {
+	mockConn := &MockConn{responses: []string{}}
+	dispatch(args, mockConn)
+	return strings.Join(mockConn.responses, "")
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/kushalsdesk/redis_with_go/rdb"
 	"github.com/kushalsdesk/redis_with_go/store"
 )
 
@@ -28,13 +29,15 @@ func handlePsync(args []string, conn net.Conn) {
 	conn.Write([]byte(response))
 
 	store.AddReplicaWithConnection(conn)
-	sendEmptyRDB(conn)
+	sendRDB(conn)
 }
 
-func sendEmptyRDB(conn net.Conn) {
-	emptyRDB := generateEmptyRDB()
-	rdbResponse := fmt.Sprintf("$%d\r\n", len(emptyRDB))
-	fullResponse := append([]byte(rdbResponse), emptyRDB...)
+// sendRDB sends the full-sync RDB. It carries the function libraries but
+// no keys, the replica starts from an empty dataset.
+func sendRDB(conn net.Conn) {
+	payload := rdb.FunctionsSnapshot(libraryCodes())
+	rdbResponse := fmt.Sprintf("$%d\r\n", len(payload))
+	fullResponse := append([]byte(rdbResponse), payload...)
 
 	_, err := conn.Write(fullResponse)
 	if err != nil {
@@ -42,26 +45,7 @@ func sendEmptyRDB(conn net.Conn) {
 		return
 	}
 
-	fmt.Printf("📦 Sent empty RDB (%d bytes) to replica\n", len(emptyRDB))
-}
-
-func generateEmptyRDB() []byte {
-	return []byte{
-		0x52, 0x45, 0x44, 0x49, 0x53, // "REDIS"
-		0x30, 0x30, 0x31, 0x31, // "0011"
-		0xfa, 0x09, 0x72, 0x65, 0x64, 0x69, 0x73, 0x2d, 0x76, 0x65, 0x72, // redis-ver
-		0x05, 0x37, 0x2e, 0x32, 0x2e, 0x30, // "7.2.0"
-		0xfa, 0x0a, 0x72, 0x65, 0x64, 0x69, 0x73, 0x2d, 0x62, 0x69, 0x74, 0x73, // redis-bits
-		0xc0, 0x40, // 64
-		0xfa, 0x05, 0x63, 0x74, 0x69, 0x6d, 0x65, // ctime
-		0xc2, 0x6d, 0x08, 0xbc, 0x65, // timestamp
-		0xfa, 0x08, 0x75, 0x73, 0x65, 0x64, 0x2d, 0x6d, 0x65, 0x6d, // used-mem
-		0xc2, 0xb0, 0xc4, 0x10, 0x00, // memory value
-		0xfa, 0x08, 0x61, 0x6f, 0x66, 0x2d, 0x62, 0x61, 0x73, 0x65, // aof-base
-		0xc0, 0x00, // 0
-		0xff,                                           // EOF
-		0xf0, 0x6e, 0x3b, 0xfe, 0xc0, 0xff, 0x5a, 0xa2, // CRC64 checksum
-	}
+	fmt.Printf("📦 Sent RDB (%d bytes) to replica\n", len(payload))
 }
 
 func handleReplconf(args []string, conn net.Conn) {
@@ -0,0 +1,129 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// scanOptions holds the options shared by SCAN and the per-type scan commands
+type scanOptions struct {
+	Pattern  string
+	Count    int
+	TypeName string
+}
+
+var scanTypeNames = map[string]bool{
+	"string": true,
+	"list":   true,
+	"stream": true,
+	"zset":   true,
+}
+
+// parseScanOptions parses [MATCH pattern] [COUNT count] and, for SCAN only,
+// [TYPE type]. On error it returns the RESP error to send back.
+func parseScanOptions(args []string, allowType bool) (scanOptions, string) {
+	opts := scanOptions{Pattern: "*", Count: 10}
+
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return opts, "-ERR syntax error\r\n"
+		}
+
+		option := strings.ToUpper(args[i])
+		value := args[i+1]
+
+		switch {
+		case option == "MATCH":
+			opts.Pattern = value
+		case option == "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return opts, "-ERR value is not an integer or out of range\r\n"
+			}
+			if count < 1 {
+				return opts, "-ERR syntax error\r\n"
+			}
+			opts.Count = count
+		case option == "TYPE" && allowType:
+			typeName := strings.ToLower(value)
+			if !scanTypeNames[typeName] {
+				return opts, fmt.Sprintf("-ERR unknown type name '%s'\r\n", value)
+			}
+			opts.TypeName = typeName
+		default:
+			return opts, "-ERR syntax error\r\n"
+		}
+	}
+
+	return opts, ""
+}
+
+func parseScanCursor(cursorStr string) (uint64, bool) {
+	cursor, err := strconv.ParseUint(cursorStr, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return cursor, true
+}
+
+// matches reports whether item passes the MATCH filter
+func (o scanOptions) matches(item string) bool {
+	return o.Pattern == "*" || stringMatch(o.Pattern, item, false)
+}
+
+func writeScanReply(conn net.Conn, cursor uint64, items []string) {
+	cursorStr := strconv.FormatUint(cursor, 10)
+
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n", len(cursorStr), cursorStr, len(items)))
+	for _, item := range items {
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(item), item))
+	}
+	conn.Write([]byte(resp.String()))
+}
+
+func handleScan(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'scan' command\r\n"))
+		return
+	}
+
+	cursor, ok := parseScanCursor(args[1])
+	if !ok {
+		conn.Write([]byte("-ERR invalid cursor\r\n"))
+		return
+	}
+
+	opts, errMsg := parseScanOptions(args[2:], true)
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	keys, next := store.ScanKeys(cursor, opts.Count)
+
+	// Filtering happens after the walk, like Redis, so a call may return
+	// fewer keys than COUNT (or none) while the cursor keeps advancing
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !opts.matches(key) {
+			continue
+		}
+
+		// GetKeyType also drops keys that expired since the walk
+		keyType := store.GetKeyType(key)
+		if keyType == "none" {
+			continue
+		}
+		if opts.TypeName != "" && keyType != opts.TypeName {
+			continue
+		}
+		result = append(result, key)
+	}
+
+	writeScanReply(conn, next, result)
+}
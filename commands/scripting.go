@@ -0,0 +1,373 @@
+package commands
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/lua"
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// EVAL, EVALSHA and their _RO variants run Lua scripts with the interpreter
+// of package lua. Dispatch holds the command section exclusively while a
+// script runs, like for EXEC, so a script is atomic. Replicas receive the
+// writes the script made, wrapped in MULTI/EXEC, rather than the script.
+
+const (
+	busyScriptReply   = "-BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.\r\n"
+	busyFunctionReply = "-BUSY Redis is busy running a script. You can only call FUNCTION KILL or SHUTDOWN NOSAVE.\r\n"
+)
+
+var errScriptKilled = errors.New("Script killed by user with SCRIPT KILL...")
+
+type script struct {
+	body string
+	fn   *lua.Function
+}
+
+var (
+	scripts      = make(map[string]*script) // by SHA1 of the body
+	scriptsMutex sync.RWMutex
+)
+
+// loadScript compiles a script into the cache, unless it is there already,
+// and returns its SHA1
+func loadScript(body string) (string, *script, error) {
+	sum := sha1.Sum([]byte(body))
+	sha := hex.EncodeToString(sum[:])
+
+	scriptsMutex.RLock()
+	cached := scripts[sha]
+	scriptsMutex.RUnlock()
+	if cached != nil {
+		return sha, cached, nil
+	}
+
+	fn, err := lua.Compile(body, "user_script")
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script (new function): %v", err)
+	}
+	cached = &script{body: body, fn: fn}
+
+	scriptsMutex.Lock()
+	scripts[sha] = cached
+	scriptsMutex.Unlock()
+	return sha, cached, nil
+}
+
+func lookupScript(sha string) *script {
+	scriptsMutex.RLock()
+	defer scriptsMutex.RUnlock()
+	return scripts[strings.ToLower(sha)]
+}
+
+// runningScript is the script being run. SCRIPT KILL, FUNCTION KILL and
+// the commands waiting for it look at it from other goroutines.
+type runningScript struct {
+	function bool     // run by FCALL, killed by FUNCTION KILL
+	name     string   // the function, or the SHA1 of the script
+	command  []string // the command that runs it
+	caller   net.Conn // the client that runs it, whose user its commands run as
+	started  time.Time
+
+	killed atomic.Bool
+	wrote  atomic.Bool
+
+	busy  bool // guarded by scriptMutex
+	timer *time.Timer
+}
+
+var (
+	scriptMutex   sync.Mutex
+	currentScript *runningScript
+
+	// scriptBusy is closed while the running script is past
+	// busy-reply-threshold, commands waiting for the command section give
+	// up with BUSY then
+	scriptBusy = make(chan struct{})
+)
+
+func startScript(function bool, name string, command []string, caller net.Conn) *runningScript {
+	rs := &runningScript{function: function, name: name, command: command, caller: caller, started: time.Now()}
+
+	scriptMutex.Lock()
+	defer scriptMutex.Unlock()
+	currentScript = rs
+	if threshold := store.GetConfig().BusyReplyThreshold; threshold > 0 {
+		rs.timer = time.AfterFunc(threshold, func() {
+			scriptMutex.Lock()
+			defer scriptMutex.Unlock()
+			if currentScript == rs && !rs.busy {
+				rs.busy = true
+				close(scriptBusy)
+			}
+		})
+	}
+	return rs
+}
+
+func endScript(rs *runningScript) {
+	scriptMutex.Lock()
+	defer scriptMutex.Unlock()
+
+	if rs.timer != nil {
+		rs.timer.Stop()
+	}
+	if rs.busy {
+		scriptBusy = make(chan struct{})
+	}
+	currentScript = nil
+}
+
+func scriptBusySignal() <-chan struct{} {
+	scriptMutex.Lock()
+	defer scriptMutex.Unlock()
+	return scriptBusy
+}
+
+func scriptIsBusy() bool {
+	scriptMutex.Lock()
+	defer scriptMutex.Unlock()
+	return currentScript != nil && currentScript.busy
+}
+
+// busyReply is the BUSY error, naming the command that can kill the script
+func busyReply() string {
+	scriptMutex.Lock()
+	defer scriptMutex.Unlock()
+	if currentScript != nil && currentScript.function {
+		return busyFunctionReply
+	}
+	return busyScriptReply
+}
+
+// scriptKeys splits the arguments after the script, numkeys first, into
+// KEYS and ARGV
+func scriptKeys(args []string) ([]string, []string, error) {
+	numkeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, errors.New("ERR value is not an integer or out of range")
+	}
+	if numkeys < 0 {
+		return nil, nil, errors.New("ERR Number of keys can't be negative")
+	}
+	if numkeys > len(args)-1 {
+		return nil, nil, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	return args[1 : 1+numkeys], args[1+numkeys:], nil
+}
+
+// handleEval is EVAL and EVAL_RO. The script is cached, so EVALSHA can run
+// it afterwards.
+func handleEval(args []string, conn net.Conn, readOnly bool) {
+	if len(args) < 3 {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(args[0]))))
+		return
+	}
+	keys, argv, err := scriptKeys(args[2:])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	sha, sc, err := loadScript(args[1])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	runScript(conn, args, sha, sc.fn, keys, argv, readOnly, false)
+}
+
+// handleEvalSha is EVALSHA and EVALSHA_RO
+func handleEvalSha(args []string, conn net.Conn, readOnly bool) {
+	if len(args) < 3 {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(args[0]))))
+		return
+	}
+	keys, argv, err := scriptKeys(args[2:])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	sc := lookupScript(args[1])
+	if sc == nil {
+		conn.Write([]byte("-NOSCRIPT No matching script. Please use EVAL.\r\n"))
+		return
+	}
+	runScript(conn, args, strings.ToLower(args[1]), sc.fn, keys, argv, readOnly, false)
+}
+
+// runScript runs a script, or a function of a library. Scripts find KEYS
+// and ARGV as globals, functions receive them as their two arguments.
+func runScript(conn net.Conn, command []string, name string, fn *lua.Function, keys, argv []string, readOnly, function bool) {
+	rs := startScript(function, name, command, callerOf(conn))
+	defer endScript(rs)
+
+	var state *lua.State
+	var callArgs []lua.Value
+	if function {
+		state = newScriptState(readOnly, rs, nil)
+		callArgs = []lua.Value{stringsTable(keys), stringsTable(argv)}
+	} else {
+		state = newScriptState(readOnly, rs, map[string]lua.Value{
+			"KEYS": stringsTable(keys),
+			"ARGV": stringsTable(argv),
+		})
+	}
+
+	batched := beginPropagationBatch()
+	results, err := state.Call(fn, callArgs...)
+	if batched {
+		endPropagationBatch()
+	}
+
+	if err != nil {
+		conn.Write([]byte(scriptErrorReply(err.(*lua.Error), name)))
+		return
+	}
+	var reply lua.Value
+	if len(results) > 0 {
+		reply = results[0]
+	}
+	var resp strings.Builder
+	writeScriptReply(&resp, reply, 0)
+	conn.Write([]byte(resp.String()))
+}
+
+// newScriptState prepares an interpreter for one run with the redis
+// library and the given globals. Like in Redis the globals and libraries
+// are read-only, and reading an undefined global is an error.
+func newScriptState(readOnly bool, rs *runningScript, globals map[string]lua.Value) *lua.State {
+	s := lua.NewState()
+	s.StrictGlobals = true
+	s.Hook = func() error {
+		if rs.killed.Load() {
+			return errScriptKilled
+		}
+		return nil
+	}
+
+	for name, value := range globals {
+		s.Globals.Set(name, value)
+	}
+	s.Globals.Set("redis", redisLibrary(readOnly, rs))
+	for _, name := range []string{"string", "table", "math", "redis"} {
+		s.Globals.Get(name).(*lua.Table).SetReadonly(true)
+	}
+	s.Globals.SetReadonly(true)
+	return s
+}
+
+func stringsTable(values []string) *lua.Table {
+	t := lua.NewTable()
+	for _, value := range values {
+		t.Append(value)
+	}
+	return t
+}
+
+// scriptErrorReply formats an error the script did not catch the way Redis
+// does, with the script or function and the line it was raised at
+func scriptErrorReply(err *lua.Error, name string) string {
+	var msg string
+	if t, ok := err.Value.(*lua.Table); ok {
+		msg, _ = t.GetString("err").(string)
+	}
+	if msg == "" {
+		msg = "ERR " + err.Error()
+	}
+	return fmt.Sprintf("-%s script: %s, on @%s:%d.\r\n", singleLine(msg), name, err.Chunk, err.Line)
+}
+
+// singleLine keeps an error or status reply on one line
+func singleLine(msg string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(msg)
+}
+
+func handleScript(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'script' command\r\n"))
+		return
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "LOAD":
+		if len(args) != 3 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'script|load' command\r\n"))
+			return
+		}
+		sha, _, err := loadScript(args[2])
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		writeBulkString(conn, sha)
+
+	case "EXISTS":
+		if len(args) < 3 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'script|exists' command\r\n"))
+			return
+		}
+		var resp strings.Builder
+		resp.WriteString(fmt.Sprintf("*%d\r\n", len(args)-2))
+		for _, sha := range args[2:] {
+			if lookupScript(sha) != nil {
+				resp.WriteString(":1\r\n")
+			} else {
+				resp.WriteString(":0\r\n")
+			}
+		}
+		conn.Write([]byte(resp.String()))
+
+	case "FLUSH":
+		if len(args) > 3 || (len(args) == 3 && !strings.EqualFold(args[2], "SYNC") && !strings.EqualFold(args[2], "ASYNC")) {
+			conn.Write([]byte("-ERR SCRIPT FLUSH only support SYNC|ASYNC option\r\n"))
+			return
+		}
+		scriptsMutex.Lock()
+		scripts = make(map[string]*script)
+		scriptsMutex.Unlock()
+		conn.Write([]byte("+OK\r\n"))
+
+	case "KILL":
+		if len(args) != 2 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'script|kill' command\r\n"))
+			return
+		}
+		handleScriptKill(conn, false)
+
+	default:
+		conn.Write([]byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try SCRIPT HELP.\r\n", args[1])))
+	}
+}
+
+// handleScriptKill is SCRIPT KILL and FUNCTION KILL, each only stops what
+// its own command runs. The script stops at its next check, unless it has
+// written already: stopping it then would leave half of its changes.
+func handleScriptKill(conn net.Conn, function bool) {
+	scriptMutex.Lock()
+	defer scriptMutex.Unlock()
+
+	switch {
+	case currentScript == nil:
+		conn.Write([]byte("-NOTBUSY No scripts in execution right now.\r\n"))
+	case currentScript.wrote.Load():
+		conn.Write([]byte("-UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.\r\n"))
+	case currentScript.function && !function:
+		conn.Write([]byte(busyFunctionReply))
+	case !currentScript.function && function:
+		conn.Write([]byte(busyScriptReply))
+	default:
+		currentScript.killed.Store(true)
+		conn.Write([]byte("+OK\r\n"))
+	}
+}
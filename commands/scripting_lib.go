@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kushalsdesk/redis_with_go/lua"
+)
+
+// Log levels of redis.log, as in Redis
+const (
+	scriptLogDebug = iota
+	scriptLogVerbose
+	scriptLogNotice
+	scriptLogWarning
+)
+
+// redisLibrary builds the redis table scripts talk to the server through
+func redisLibrary(readOnly bool, rs *runningScript) *lua.Table {
+	lib := lua.NewTable()
+	register := func(name string, fn func(s *lua.State, args []lua.Value) []lua.Value) {
+		lib.Set(name, &lua.GoFunction{Name: name, Fn: fn})
+	}
+
+	register("call", func(s *lua.State, args []lua.Value) []lua.Value {
+		return []lua.Value{scriptCall(s, args, readOnly, rs, true)}
+	})
+	register("pcall", func(s *lua.State, args []lua.Value) []lua.Value {
+		return []lua.Value{scriptCall(s, args, readOnly, rs, false)}
+	})
+
+	register("error_reply", func(s *lua.State, args []lua.Value) []lua.Value {
+		msg, ok := singleStringArg(args)
+		if !ok {
+			return []lua.Value{replyTable("err", "ERR wrong number or type of arguments")}
+		}
+		return []lua.Value{replyTable("err", msg)}
+	})
+	register("status_reply", func(s *lua.State, args []lua.Value) []lua.Value {
+		msg, ok := singleStringArg(args)
+		if !ok {
+			return []lua.Value{replyTable("err", "ERR wrong number or type of arguments")}
+		}
+		return []lua.Value{replyTable("ok", msg)}
+	})
+
+	register("sha1hex", func(s *lua.State, args []lua.Value) []lua.Value {
+		str, ok := singleStringArg(args)
+		if !ok {
+			s.Error("wrong number of arguments")
+		}
+		sum := sha1.Sum([]byte(str))
+		return []lua.Value{hex.EncodeToString(sum[:])}
+	})
+
+	// Scripts always replicate by their effects, there is nothing to switch
+	register("replicate_commands", func(s *lua.State, args []lua.Value) []lua.Value {
+		return []lua.Value{true}
+	})
+
+	setScriptLog(lib)
+	return lib
+}
+
+// setScriptLog adds redis.log and its levels to a redis library
+func setScriptLog(lib *lua.Table) {
+	lib.Set("log", &lua.GoFunction{Name: "log", Fn: scriptLog})
+	lib.Set("LOG_DEBUG", float64(scriptLogDebug))
+	lib.Set("LOG_VERBOSE", float64(scriptLogVerbose))
+	lib.Set("LOG_NOTICE", float64(scriptLogNotice))
+	lib.Set("LOG_WARNING", float64(scriptLogWarning))
+}
+
+func scriptLog(s *lua.State, args []lua.Value) []lua.Value {
+	if len(args) < 2 {
+		s.Error("redis.log() requires two arguments or more.")
+	}
+	level, ok := args[0].(float64)
+	if !ok {
+		s.Error("First argument must be a number (log level).")
+	}
+	if level < scriptLogDebug || level > scriptLogWarning {
+		s.Error("Invalid debug level.")
+	}
+
+	parts := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		if str, ok := lua.ToString(arg); ok {
+			parts = append(parts, str)
+		}
+	}
+	// Like the default verbosity of Redis, debug and verbose are dropped
+	if level >= scriptLogNotice {
+		fmt.Printf("📜 Script: %s\n", strings.Join(parts, " "))
+	}
+	return nil
+}
+
+func singleStringArg(args []lua.Value) (string, bool) {
+	if len(args) != 1 {
+		return "", false
+	}
+	return lua.ToString(args[0])
+}
+
+func replyTable(field, msg string) *lua.Table {
+	t := lua.NewTable()
+	t.Set(field, msg)
+	return t
+}
+
+// scriptCall runs a command for redis.call, which raises the command's
+// error, and redis.pcall, which returns it as a table with an err field
+func scriptCall(s *lua.State, args []lua.Value, readOnly bool, rs *runningScript, raise bool) lua.Value {
+	fail := func(msg string) lua.Value {
+		if raise {
+			s.Raise(replyTable("err", msg))
+		}
+		return replyTable("err", msg)
+	}
+
+	if len(args) == 0 {
+		return fail("ERR Please specify at least one argument for this redis lib call")
+	}
+	command := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := arg.(string)
+		if n, isNumber := arg.(float64); isNumber {
+			str, ok = lua.FormatNumber(n), true
+		}
+		if !ok {
+			return fail("ERR Lua redis lib command arguments must be strings or integers")
+		}
+		command[i] = str
+	}
+
+	info, exists := commandTable[strings.ToUpper(command[0])]
+	switch {
+	case !exists:
+		return fail("ERR Unknown Redis command called from script")
+	case !info.acceptsArgs(len(command)):
+		return fail("ERR Wrong number of args calling Redis command from script")
+	case info.flags&flagNoScript != 0:
+		return fail("ERR This Redis command is not allowed from script")
+	case readOnly && info.flags&flagWrite != 0:
+		return fail("ERR Write commands are not allowed from read-only scripts.")
+	}
+	if msg := aclCheck(rs.caller, command, "lua"); msg != "" {
+		return fail("ERR ACL failure in script: " + msg)
+	}
+
+	mock := &MockConn{}
+	dispatch(command, mock)
+	reply := strings.Join(mock.responses, "")
+	if info.flags&flagWrite != 0 && !strings.HasPrefix(reply, "-") {
+		rs.wrote.Store(true)
+	}
+
+	value, _ := respToLua(reply)
+	if t, isTable := value.(*lua.Table); isTable && raise && t.GetString("err") != nil {
+		s.Raise(value)
+	}
+	return value
+}
+
+// respToLua converts the first reply in resp the way Redis hands replies to
+// scripts and returns what follows it
+func respToLua(resp string) (lua.Value, string) {
+	line, rest, found := strings.Cut(resp, "\r\n")
+	if !found || line == "" {
+		return false, ""
+	}
+
+	switch line[0] {
+	case '+':
+		return replyTable("ok", line[1:]), rest
+	case '-':
+		return replyTable("err", line[1:]), rest
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return float64(n), rest
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 || n+2 > len(rest) {
+			return false, rest
+		}
+		return rest[:n], rest[n+2:]
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return false, rest
+		}
+		t := lua.NewTable()
+		for i := 0; i < n; i++ {
+			var element lua.Value
+			element, rest = respToLua(rest)
+			t.Append(element)
+		}
+		return t, rest
+	}
+	return false, rest
+}
+
+// maxScriptReplyDepth bounds the nesting of a script's reply, a table that
+// contains itself would go on forever
+const maxScriptReplyDepth = 100
+
+// writeScriptReply converts the value a script returned into its reply: a
+// number is truncated to an integer, false and nil are a null, and a table
+// is an error, a status or an array of its elements up to the first nil
+func writeScriptReply(resp *strings.Builder, v lua.Value, depth int) {
+	switch v := v.(type) {
+	case string:
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
+	case float64:
+		resp.WriteString(fmt.Sprintf(":%d\r\n", int64(v)))
+	case bool:
+		if v {
+			resp.WriteString(":1\r\n")
+		} else {
+			resp.WriteString("$-1\r\n")
+		}
+	case *lua.Table:
+		if depth >= maxScriptReplyDepth {
+			resp.WriteString("-ERR reached lua stack limit\r\n")
+			return
+		}
+		if msg, ok := v.GetString("err").(string); ok {
+			resp.WriteString("-" + singleLine(msg) + "\r\n")
+			return
+		}
+		if msg, ok := v.GetString("ok").(string); ok {
+			resp.WriteString("+" + singleLine(msg) + "\r\n")
+			return
+		}
+		var elements []lua.Value
+		for i := 1; ; i++ {
+			element := v.Get(float64(i))
+			if element == nil {
+				break
+			}
+			elements = append(elements, element)
+		}
+		resp.WriteString(fmt.Sprintf("*%d\r\n", len(elements)))
+		for _, element := range elements {
+			writeScriptReply(resp, element, depth+1)
+		}
+	default:
+		resp.WriteString("$-1\r\n")
+	}
+}
@@ -0,0 +1,252 @@
+package commands
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// formatScore renders a score the way Redis does (%.17g with the shortest
+// digits that round-trip): plain notation unless the exponent is below -4 or
+// at least 17, and inf/-inf for infinities
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	}
+	if math.IsInf(score, -1) {
+		return "-inf"
+	}
+
+	// The shortest digits in exponent form tell which notation %.17g picks
+	scientific := strconv.FormatFloat(score, 'e', -1, 64)
+	exp, _ := strconv.Atoi(scientific[strings.IndexByte(scientific, 'e')+1:])
+	if exp < -4 || exp >= 17 {
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// parseScore parses a score, accepting inf/+inf/-inf like Redis
+func parseScore(arg string) (float64, bool) {
+	score, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+func handleZAdd(args []string, conn net.Conn) {
+	if len(args) < 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'zadd' command\r\n"))
+		return
+	}
+
+	var opts store.ZAddOptions
+	ch := false
+
+	i := 2
+parseOptions:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		case "CH":
+			ch = true
+		case "INCR":
+			opts.Incr = true
+		default:
+			break parseOptions
+		}
+	}
+
+	elements := len(args) - i
+	if elements == 0 || elements%2 != 0 {
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+	if opts.NX && opts.XX {
+		conn.Write([]byte("-ERR XX and NX options at the same time are not compatible\r\n"))
+		return
+	}
+	if (opts.GT && opts.NX) || (opts.LT && opts.NX) || (opts.GT && opts.LT) {
+		conn.Write([]byte("-ERR GT, LT, and/or NX options at the same time are not compatible\r\n"))
+		return
+	}
+	if opts.Incr && elements > 2 {
+		conn.Write([]byte("-ERR INCR option supports a single increment-element pair\r\n"))
+		return
+	}
+
+	entries := make([]store.ZSetEntry, 0, elements/2)
+	for j := i; j < len(args); j += 2 {
+		score, ok := parseScore(args[j])
+		if !ok {
+			conn.Write([]byte("-ERR value is not a valid float\r\n"))
+			return
+		}
+		entries = append(entries, store.ZSetEntry{Member: args[j+1], Score: score})
+	}
+
+	result, err := store.ZAdd(args[1], entries, opts)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if result.Added+result.Updated > 0 {
+		PropagateCommand(args)
+	}
+
+	if opts.Incr {
+		if result.Aborted {
+			writeNullBulk(conn)
+			return
+		}
+		writeBulkString(conn, formatScore(result.Score))
+		return
+	}
+
+	changed := result.Added
+	if ch {
+		changed += result.Updated
+	}
+	writeInteger(conn, int64(changed))
+}
+
+func handleZRem(args []string, conn net.Conn) {
+	if len(args) < 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'zrem' command\r\n"))
+		return
+	}
+
+	removed, err := store.ZRem(args[1], args[2:])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if removed > 0 {
+		PropagateCommand(args)
+	}
+	writeInteger(conn, int64(removed))
+}
+
+func handleZScore(args []string, conn net.Conn) {
+	if len(args) != 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'zscore' command\r\n"))
+		return
+	}
+
+	score, exists, err := store.ZScore(args[1], args[2])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	if !exists {
+		writeNullBulk(conn)
+		return
+	}
+	writeBulkString(conn, formatScore(score))
+}
+
+func handleZCard(args []string, conn net.Conn) {
+	if len(args) != 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'zcard' command\r\n"))
+		return
+	}
+
+	count, err := store.ZCard(args[1])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	writeInteger(conn, int64(count))
+}
+
+func handleZRange(args []string, conn net.Conn) {
+	if len(args) != 4 && len(args) != 5 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'zrange' command\r\n"))
+		return
+	}
+
+	withScores := false
+	if len(args) == 5 {
+		if strings.ToUpper(args[4]) != "WITHSCORES" {
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+		withScores = true
+	}
+
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+		return
+	}
+
+	entries, err := store.ZRange(args[1], start, stop)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	count := len(entries)
+	if withScores {
+		count *= 2
+	}
+
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*%d\r\n", count))
+	for _, entry := range entries {
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(entry.Member), entry.Member))
+		if withScores {
+			score := formatScore(entry.Score)
+			resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(score), score))
+		}
+	}
+	conn.Write([]byte(resp.String()))
+}
+
+func handleZScan(args []string, conn net.Conn) {
+	if len(args) < 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'zscan' command\r\n"))
+		return
+	}
+
+	cursor, ok := parseScanCursor(args[2])
+	if !ok {
+		conn.Write([]byte("-ERR invalid cursor\r\n"))
+		return
+	}
+
+	opts, errMsg := parseScanOptions(args[3:], false)
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	entries, next, err := store.ZScan(args[1], cursor, opts.Count)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	items := make([]string, 0, len(entries)*2)
+	for _, entry := range entries {
+		if opts.matches(entry.Member) {
+			items = append(items, entry.Member, formatScore(entry.Score))
+		}
+	}
+	writeScanReply(conn, next, items)
+}
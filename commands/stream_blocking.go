@@ -10,132 +10,117 @@ import (
 	"github.com/kushalsdesk/redis_with_go/store"
 )
 
-type StreamReadResult struct {
-	StreamKey string
-	Entries   []store.StreamEntry
+// xreadRequest holds the arguments of XREAD and XREADGROUP
+type xreadRequest struct {
+	count       int   // -1 for no limit
+	blockMillis int64 // -1 when not blocking
+	group       store.StreamGroupRead
+	keys        []string
+	ids         []string
 }
 
-func handleXRead(args []string, conn net.Conn) {
-	if len(args) < 4 {
-		conn.Write([]byte("-ERR wrong number of arguments for 'xread' command\r\n"))
-		return
-	}
-
-	var count int = -1         // no limit
-	var blockMillis int64 = -1 // non-blocking
-	var streamsIndex int = -1
+// parseXRead parses the options and streams of XREAD, or of XREADGROUP
+// when withGroup is set, returning the error reply on failure
+func parseXRead(args []string, withGroup bool) (*xreadRequest, string) {
+	req := &xreadRequest{count: -1, blockMillis: -1}
+	streamsIndex := -1
+	hasGroup := false
 
 	i := 1
 	for i < len(args) {
-		switch strings.ToUpper(args[i]) {
-		case "COUNT":
+		switch option := strings.ToUpper(args[i]); {
+		case option == "COUNT":
 			if i+1 >= len(args) {
-				// conn.Write([]byte("-ERR syntax error\r\n"))
-				fmt.Fprintf(conn, "-ERR syntax error\r\n")
-				return
+				return nil, "-ERR syntax error\r\n"
 			}
-			var err error
-			count, err = strconv.Atoi(args[i+1])
+			count, err := strconv.Atoi(args[i+1])
 			if err != nil || count <= 0 {
-				conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
-				return
+				return nil, "-ERR value is not an integer or out of range\r\n"
 			}
+			req.count = count
 			i += 2
-		case "BLOCK":
+		case option == "BLOCK":
 			if i+1 >= len(args) {
-				conn.Write([]byte("-ERR syntax error\r\n"))
-				return
+				return nil, "-ERR syntax error\r\n"
 			}
-			var err error
-			blockMillis, err = strconv.ParseInt(args[i+1], 10, 64)
+			blockMillis, err := strconv.ParseInt(args[i+1], 10, 64)
 			if err != nil || blockMillis < 0 {
-				conn.Write([]byte("-ERR timeout is not a float or out range\r\n"))
-				return
+				return nil, "-ERR timeout is not a float or out range\r\n"
 			}
+			req.blockMillis = blockMillis
 			i += 2
-		case "STREAMS":
+		case option == "GROUP" && withGroup:
+			if i+2 >= len(args) {
+				return nil, "-ERR syntax error\r\n"
+			}
+			req.group.Group, req.group.Consumer = args[i+1], args[i+2]
+			hasGroup = true
+			i += 3
+		case option == "NOACK" && withGroup:
+			req.group.NoAck = true
+			i++
+		case option == "STREAMS":
 			streamsIndex = i + 1
 			i = len(args)
 		default:
-			fmt.Fprintf(conn, "-ERR unknown option: %s\r\n", args[i])
-			return
+			return nil, fmt.Sprintf("-ERR unknown option: %s\r\n", args[i])
 		}
 	}
 	if streamsIndex == -1 {
-		conn.Write([]byte("-ERR syntax error\r\n"))
-		return
+		return nil, "-ERR syntax error\r\n"
+	}
+	if withGroup && !hasGroup {
+		return nil, "-ERR Missing GROUP option for XREADGROUP\r\n"
 	}
 
 	streamArgs := args[streamsIndex:]
-	if len(streamArgs)%2 != 0 {
-		conn.Write([]byte("-ERR Unbalanced XREAD list of streams: for each stream key an ID or '$' must be specified\r\n"))
-		return
+	if len(streamArgs) == 0 || len(streamArgs)%2 != 0 {
+		return nil, fmt.Sprintf("-ERR Unbalanced %s list of streams: for each stream key an ID or '$' must be specified\r\n", strings.ToUpper(args[0]))
 	}
 
 	numStreams := len(streamArgs) / 2
-	streamKeys := streamArgs[:numStreams]
-	streamIDs := streamArgs[numStreams:]
+	req.keys = streamArgs[:numStreams]
+	req.ids = streamArgs[numStreams:]
+	return req, ""
+}
 
-	//basic blocking
-	if blockMillis >= 0 {
-		handleAdvancedBlockingXRead(streamKeys, streamIDs, blockMillis, count, conn)
+func handleXRead(args []string, conn net.Conn) {
+	if len(args) < 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xread' command\r\n"))
 		return
 	}
 
-	handleNonBlockingXRead(streamKeys, streamIDs, count, conn)
-
-}
-
-func handleNonBlockingXRead(streamKeys, streamIDs []string, count int, conn net.Conn) {
-	results, _ := store.StreamReadFromImmediate(streamKeys, streamIDs, count)
-	response := formatXReadResponse(results)
-	fmt.Fprint(conn, response)
+	req, errMsg := parseXRead(args, false)
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
 
-}
+	info := lookupClient(conn)
+	blocking := req.blockMillis >= 0 && info != nil
 
-func handleAdvancedBlockingXRead(streamKeys, streamIDs []string, blockMillis int64, count int, conn net.Conn) {
-	// trying immediate read
-	results, hasData := store.StreamReadFromImmediate(streamKeys, streamIDs, count)
-	if hasData {
-		response := formatXReadResponse(results)
-		fmt.Fprint(conn, response)
+	results, waiter, err := store.StreamReadOrBlock(req.keys, req.ids, req.count, info.clientID(), blocking)
+	if err != nil {
+		writeError(conn, err)
 		return
 	}
-
-	var timeout time.Duration
-	if blockMillis == 0 {
-		timeout = 0
-	} else {
-		timeout = time.Duration(blockMillis) * time.Millisecond
+	if waiter == nil {
+		fmt.Fprint(conn, formatXReadResponse(results))
+		return
 	}
 
-	client := store.RegisterStreamBlockingClient(streamKeys, streamIDs, count, timeout)
-	defer store.UnregisterStreamBlockingClient(client)
-
-	// Waiting for data/timeout
-	if timeout > 0 {
-		select {
-		case result := <-client.Response:
-			if result.Success {
-				response := formatXReadResponse(result.Results)
-				fmt.Fprint(conn, response)
-
-			} else {
-				fmt.Fprintf(conn, "*-1\r\n")
-			}
-		case <-time.After(timeout):
-			fmt.Fprintf(conn, "*-1\r\n")
-		}
-	} else {
-		// blocking indefinitely
-		result := <-client.Response
-		if result.Success {
-			response := formatXReadResponse(result.Results)
-			fmt.Fprint(conn, response)
-		} else {
-			fmt.Fprint(conn, "*-1\r\n")
-		}
+	// BLOCK 0 waits forever
+	timeout := time.Duration(req.blockMillis) * time.Millisecond
+	result, err := waitForData(info, waiter, timeout)
+	if err != nil {
+		writeError(conn, err)
+		return
 	}
+	if result == nil {
+		fmt.Fprint(conn, "*-1\r\n")
+		return
+	}
+	fmt.Fprint(conn, formatXReadResponse(result.Streams))
 }
 
 func formatXReadResponse(results []store.StreamReadResult) string {
@@ -150,14 +135,7 @@ func formatXReadResponse(results []store.StreamReadResult) string {
 			len(result.StreamKey), result.StreamKey, len(result.Entries))
 
 		for _, entry := range result.Entries {
-			fieldCount := len(entry.Fields) * 2
-			response += fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n",
-				len(entry.ID), entry.ID, fieldCount)
-
-			for field, value := range entry.Fields {
-				response += fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n",
-					len(field), field, len(value), value)
-			}
+			response += formatStreamEntry(entry)
 		}
 	}
 
@@ -0,0 +1,214 @@
+package commands
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// xclaimCommand is the XCLAIM replicas receive for an entry claimed or
+// dropped on the master. TIME and RETRYCOUNT carry the exact delivery state,
+// FORCE creates the pending entry should the replica not have it yet.
+func xclaimCommand(key, group, consumer string, pending store.PendingEntry, lastID store.StreamID) []string {
+	return []string{"XCLAIM", key, group, consumer, "0", pending.ID.String(),
+		"TIME", strconv.FormatInt(pending.DeliveryTime.UnixMilli(), 10),
+		"RETRYCOUNT", strconv.FormatInt(pending.DeliveryCount, 10),
+		"FORCE", "JUSTID", "LASTID", lastID.String()}
+}
+
+// propagateClaim replicates each claimed or dropped entry, or the new last
+// ID of the group when that is all that changed
+func propagateClaim(key, group, consumer string, result *store.ClaimResult) {
+	for _, claimed := range result.Claimed {
+		PropagateCommand(xclaimCommand(key, group, consumer, claimed.Pending, result.LastID))
+	}
+	for _, deleted := range result.Deleted {
+		PropagateCommand(xclaimCommand(key, group, consumer, deleted, result.LastID))
+	}
+	if result.LastIDChanged && len(result.Claimed) == 0 && len(result.Deleted) == 0 {
+		PropagateCommand([]string{"XGROUP", "SETID", key, group, result.LastID.String(),
+			"ENTRIESREAD", strconv.FormatInt(result.EntriesRead, 10)})
+	}
+}
+
+func formatClaimedEntries(claimed []store.ClaimedEntry, justID bool) string {
+	if !justID {
+		entries := make([]store.StreamEntry, len(claimed))
+		for i, c := range claimed {
+			entries[i] = c.Entry
+		}
+		return formatXRangeResponse(entries)
+	}
+
+	response := fmt.Sprintf("*%d\r\n", len(claimed))
+	for _, c := range claimed {
+		id := c.Entry.ID.String()
+		response += fmt.Sprintf("$%d\r\n%s\r\n", len(id), id)
+	}
+	return response
+}
+
+// parseMinIdle parses the min-idle-time of XCLAIM and XAUTOCLAIM, negative
+// values meaning no minimum
+func parseMinIdle(arg, command string) (time.Duration, string) {
+	minIdle, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Sprintf("-ERR Invalid min-idle-time argument for %s\r\n", command)
+	}
+	return time.Duration(max(minIdle, 0)) * time.Millisecond, ""
+}
+
+func handleXClaim(args []string, conn net.Conn) {
+	if len(args) < 6 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xclaim' command\r\n"))
+		return
+	}
+	key, group, consumer := args[1], args[2], args[3]
+
+	opts := store.ClaimOptions{RetryCount: -1}
+	var errMsg string
+	if opts.MinIdle, errMsg = parseMinIdle(args[4], "XCLAIM"); errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	// IDs run up to the first argument that isn't one
+	i := 5
+	var ids []store.StreamID
+	for ; i < len(args); i++ {
+		id, err := store.ParseStreamIDArg(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	now := time.Now()
+	deliveryMillis := int64(-1)
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "FORCE":
+			opts.Force = true
+			continue
+		case "JUSTID":
+			opts.JustID = true
+			continue
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+		default:
+			conn.Write([]byte(fmt.Sprintf("-ERR Unrecognized XCLAIM option '%s'\r\n", args[i])))
+			return
+		}
+
+		if i+1 >= len(args) {
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+		i++
+
+		if option == "LASTID" {
+			lastID, err := store.ParseStreamIDArg(args[i], 0)
+			if err != nil {
+				writeError(conn, err)
+				return
+			}
+			opts.LastID = lastID
+			continue
+		}
+
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			conn.Write([]byte(fmt.Sprintf("-ERR Invalid %s option argument for XCLAIM\r\n", option)))
+			return
+		}
+		switch option {
+		case "IDLE":
+			deliveryMillis = now.UnixMilli() - n
+		case "TIME":
+			deliveryMillis = n
+		case "RETRYCOUNT":
+			opts.RetryCount = n
+		}
+	}
+
+	// A delivery time in the future or before the epoch is taken as now
+	opts.DeliveryTime = now
+	if deliveryMillis >= 0 && deliveryMillis <= now.UnixMilli() {
+		opts.DeliveryTime = time.UnixMilli(deliveryMillis)
+	}
+
+	result, err := store.StreamClaim(key, group, consumer, ids, opts)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	propagateClaim(key, group, consumer, result)
+
+	conn.Write([]byte(formatClaimedEntries(result.Claimed, opts.JustID)))
+}
+
+func handleXAutoClaim(args []string, conn net.Conn) {
+	if len(args) < 6 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xautoclaim' command\r\n"))
+		return
+	}
+	key, group, consumer := args[1], args[2], args[3]
+
+	minIdle, errMsg := parseMinIdle(args[4], "XAUTOCLAIM")
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+	start, err := parseStreamRangeID(args[5], true)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	count := 100
+	justID := false
+	for i := 6; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				return
+			}
+			// Ten attempts are made per entry asked for
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n < 1 || n > math.MaxInt64/10 {
+				conn.Write([]byte("-ERR COUNT must be > 0\r\n"))
+				return
+			}
+			count = int(n)
+			i++
+		case "JUSTID":
+			justID = true
+		default:
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+	}
+
+	result, err := store.StreamAutoClaim(key, group, consumer, minIdle, start, count, justID)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	propagateClaim(key, group, consumer, result)
+
+	cursor := result.Cursor.String()
+	response := fmt.Sprintf("*3\r\n$%d\r\n%s\r\n", len(cursor), cursor)
+	response += formatClaimedEntries(result.Claimed, justID)
+	response += fmt.Sprintf("*%d\r\n", len(result.Deleted))
+	for _, deleted := range result.Deleted {
+		id := deleted.ID.String()
+		response += fmt.Sprintf("$%d\r\n%s\r\n", len(id), id)
+	}
+	conn.Write([]byte(response))
+}
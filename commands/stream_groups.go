@@ -0,0 +1,388 @@
+package commands
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// parseEntriesRead parses the ENTRIESREAD option of XGROUP CREATE/SETID
+// found at args[i], returning -1 when it is absent
+func parseEntriesRead(args []string, i int) (int64, string) {
+	if i == len(args) {
+		return -1, ""
+	}
+	if i+2 != len(args) || strings.ToUpper(args[i]) != "ENTRIESREAD" {
+		return 0, "-ERR syntax error\r\n"
+	}
+	entriesRead, err := strconv.ParseInt(args[i+1], 10, 64)
+	if err != nil {
+		return 0, "-ERR value is not an integer or out of range\r\n"
+	}
+	if entriesRead < -1 {
+		return 0, "-ERR value for ENTRIESREAD must be positive or -1\r\n"
+	}
+	return entriesRead, ""
+}
+
+// handleXGroup reports whether it changed a group, and so has to be
+// propagated
+func handleXGroup(args []string, conn net.Conn) bool {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xgroup' command\r\n"))
+		return false
+	}
+
+	subcommand := strings.ToUpper(args[1])
+	wrongArity := fmt.Sprintf("-ERR wrong number of arguments for 'xgroup|%s' command\r\n", strings.ToLower(args[1]))
+
+	switch subcommand {
+	case "CREATE":
+		if len(args) < 5 {
+			conn.Write([]byte(wrongArity))
+			return false
+		}
+		i := 5
+		mkstream := false
+		if i < len(args) && strings.ToUpper(args[i]) == "MKSTREAM" {
+			mkstream = true
+			i++
+		}
+		entriesRead, errMsg := parseEntriesRead(args, i)
+		if errMsg != "" {
+			conn.Write([]byte(errMsg))
+			return false
+		}
+		if err := store.StreamGroupCreate(args[2], args[3], args[4], mkstream, entriesRead); err != nil {
+			writeError(conn, err)
+			return false
+		}
+		conn.Write([]byte("+OK\r\n"))
+		return true
+
+	case "SETID":
+		if len(args) < 5 {
+			conn.Write([]byte(wrongArity))
+			return false
+		}
+		entriesRead, errMsg := parseEntriesRead(args, 5)
+		if errMsg != "" {
+			conn.Write([]byte(errMsg))
+			return false
+		}
+		if err := store.StreamGroupSetID(args[2], args[3], args[4], entriesRead); err != nil {
+			writeError(conn, err)
+			return false
+		}
+		conn.Write([]byte("+OK\r\n"))
+		return true
+
+	case "DESTROY":
+		if len(args) != 4 {
+			conn.Write([]byte(wrongArity))
+			return false
+		}
+		destroyed, err := store.StreamGroupDestroy(args[2], args[3])
+		if err != nil {
+			writeError(conn, err)
+			return false
+		}
+		if destroyed {
+			writeInteger(conn, 1)
+		} else {
+			writeInteger(conn, 0)
+		}
+		return destroyed
+
+	case "CREATECONSUMER":
+		if len(args) != 5 {
+			conn.Write([]byte(wrongArity))
+			return false
+		}
+		created, err := store.StreamGroupCreateConsumer(args[2], args[3], args[4])
+		if err != nil {
+			writeError(conn, err)
+			return false
+		}
+		if created {
+			writeInteger(conn, 1)
+		} else {
+			writeInteger(conn, 0)
+		}
+		return created
+
+	case "DELCONSUMER":
+		if len(args) != 5 {
+			conn.Write([]byte(wrongArity))
+			return false
+		}
+		pending, deleted, err := store.StreamGroupDelConsumer(args[2], args[3], args[4])
+		if err != nil {
+			writeError(conn, err)
+			return false
+		}
+		writeInteger(conn, int64(pending))
+		return deleted
+
+	default:
+		conn.Write([]byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try XGROUP HELP.\r\n", args[1])))
+		return false
+	}
+}
+
+// groupReadCommand is the XREADGROUP replicas receive for a read of one
+// stream. New entries are read with the number the master delivered as
+// COUNT, so the replica hands out exactly the same ones.
+func groupReadCommand(read store.StreamGroupRead, key, id string, count int) []string {
+	command := []string{"XREADGROUP", "GROUP", read.Group, read.Consumer}
+	if count > 0 {
+		command = append(command, "COUNT", strconv.Itoa(count))
+	}
+	if read.NoAck {
+		command = append(command, "NOACK")
+	}
+	return append(command, "STREAMS", key, id)
+}
+
+func handleXReadGroup(args []string, conn net.Conn) {
+	if len(args) < 7 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xreadgroup' command\r\n"))
+		return
+	}
+
+	req, errMsg := parseXRead(args, true)
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	ids := make([]string, len(req.ids))
+	for i, id := range req.ids {
+		if id == ">" {
+			ids[i] = id
+			continue
+		}
+		if id == "$" {
+			conn.Write([]byte("-ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.\r\n"))
+			return
+		}
+		parsed, err := store.ParseStreamIDArg(id, 0)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		ids[i] = parsed.String()
+	}
+
+	info := lookupClient(conn)
+	blocking := req.blockMillis >= 0 && info != nil
+
+	results, created, waiter, err := store.StreamReadGroupOrBlock(req.group, req.keys, ids, req.count, info.clientID(), blocking)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if created {
+		for _, key := range req.keys {
+			PropagateCommand([]string{"XGROUP", "CREATECONSUMER", key, req.group.Group, req.group.Consumer})
+		}
+	}
+	for _, result := range results {
+		if len(result.Entries) == 0 {
+			continue
+		}
+		for i, key := range req.keys {
+			if key != result.StreamKey {
+				continue
+			}
+			count := req.count
+			if ids[i] == ">" {
+				count = len(result.Entries)
+			}
+			PropagateCommand(groupReadCommand(req.group, key, ids[i], count))
+			break
+		}
+	}
+
+	if waiter == nil {
+		fmt.Fprint(conn, formatXReadResponse(results))
+		return
+	}
+
+	// BLOCK 0 waits forever
+	timeout := time.Duration(req.blockMillis) * time.Millisecond
+	result, err := waitForData(info, waiter, timeout)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	if result == nil {
+		fmt.Fprint(conn, "*-1\r\n")
+		return
+	}
+	fmt.Fprint(conn, formatXReadResponse(result.Streams))
+}
+
+// handleXAck reports whether it acknowledged anything, and so has to be
+// propagated
+func handleXAck(args []string, conn net.Conn) bool {
+	if len(args) < 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xack' command\r\n"))
+		return false
+	}
+
+	ids := make([]store.StreamID, 0, len(args)-3)
+	for _, arg := range args[3:] {
+		id, err := store.ParseStreamIDArg(arg, 0)
+		if err != nil {
+			writeError(conn, err)
+			return false
+		}
+		ids = append(ids, id)
+	}
+
+	acked, err := store.StreamAck(args[1], args[2], ids)
+	if err != nil {
+		writeError(conn, err)
+		return false
+	}
+	writeInteger(conn, int64(acked))
+	return acked > 0
+}
+
+// parseStreamRangeID parses a bound of a stream ID range: "-" and "+" for
+// the smallest and largest ID, a "(" prefix for an exclusive bound. A start
+// without sequence starts at sequence 0 of its millisecond, an end runs to
+// the last sequence.
+func parseStreamRangeID(arg string, isStart bool) (store.StreamID, error) {
+	switch arg {
+	case "-":
+		return store.StreamID{}, nil
+	case "+":
+		return store.MaxStreamID, nil
+	}
+
+	exclusive := strings.HasPrefix(arg, "(")
+	missingSeq := int64(0)
+	if !isStart {
+		missingSeq = math.MaxInt64
+	}
+	idArg := strings.TrimPrefix(arg, "(")
+	parsed, err := store.ParseStreamIDArg(idArg, missingSeq)
+	if err != nil || !exclusive {
+		return parsed, err
+	}
+
+	if isStart {
+		if parsed.Sequence < math.MaxInt64 {
+			parsed.Sequence++
+		} else if parsed.Timestamp < math.MaxInt64 {
+			parsed.Timestamp, parsed.Sequence = parsed.Timestamp+1, 0
+		} else {
+			return store.StreamID{}, fmt.Errorf("ERR invalid start ID for the interval")
+		}
+	} else {
+		if parsed.Sequence > 0 {
+			parsed.Sequence--
+		} else if parsed.Timestamp > 0 {
+			parsed.Timestamp, parsed.Sequence = parsed.Timestamp-1, math.MaxInt64
+		} else {
+			return store.StreamID{}, fmt.Errorf("ERR invalid end ID for the interval")
+		}
+	}
+	return parsed, nil
+}
+
+func handleXPending(args []string, conn net.Conn) {
+	if len(args) < 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xpending' command\r\n"))
+		return
+	}
+	key, group := args[1], args[2]
+
+	if len(args) == 3 {
+		summary, err := store.StreamPendingSummary(key, group)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		writePendingSummary(conn, summary)
+		return
+	}
+
+	var r store.PendingRange
+	i := 3
+	if strings.ToUpper(args[i]) == "IDLE" {
+		if i+1 >= len(args) {
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+		idle, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+			return
+		}
+		r.MinIdle = time.Duration(idle) * time.Millisecond
+		i += 2
+	}
+	if len(args)-i != 3 && len(args)-i != 4 {
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+
+	var err error
+	if r.Start, err = parseStreamRangeID(args[i], true); err != nil {
+		writeError(conn, err)
+		return
+	}
+	if r.End, err = parseStreamRangeID(args[i+1], false); err != nil {
+		writeError(conn, err)
+		return
+	}
+	count, err := strconv.ParseInt(args[i+2], 10, 64)
+	if err != nil {
+		conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+		return
+	}
+	r.Count = int(max(count, 0))
+	if len(args)-i == 4 {
+		r.Consumer = args[i+3]
+	}
+
+	pending, err := store.StreamPendingRange(key, group, r)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	response := fmt.Sprintf("*%d\r\n", len(pending))
+	for _, p := range pending {
+		id := p.ID.String()
+		response += fmt.Sprintf("*4\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n:%d\r\n:%d\r\n",
+			len(id), id, len(p.Consumer), p.Consumer, p.Idle.Milliseconds(), p.DeliveryCount)
+	}
+	conn.Write([]byte(response))
+}
+
+func writePendingSummary(conn net.Conn, summary store.PendingSummary) {
+	if summary.Count == 0 {
+		conn.Write([]byte("*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n"))
+		return
+	}
+
+	minID, maxID := summary.MinID.String(), summary.MaxID.String()
+	response := fmt.Sprintf("*4\r\n:%d\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n*%d\r\n",
+		summary.Count, len(minID), minID, len(maxID), maxID, len(summary.Consumers))
+	for _, consumer := range summary.Consumers {
+		pending := strconv.Itoa(consumer.Pending)
+		response += fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+			len(consumer.Name), consumer.Name, len(pending), pending)
+	}
+	conn.Write([]byte(response))
+}
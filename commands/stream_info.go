@@ -0,0 +1,196 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// XINFO replies are maps, sent as flat [name, value, ...] arrays over RESP2
+
+func respBulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func respInt(n int64) string {
+	return fmt.Sprintf(":%d\r\n", n)
+}
+
+// unixMillis renders a time for XINFO, -1 for a zero time
+func unixMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return -1
+	}
+	return t.UnixMilli()
+}
+
+func handleXInfo(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xinfo' command\r\n"))
+		return
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "STREAM":
+		handleXInfoStream(args, conn)
+	case "GROUPS":
+		if len(args) != 3 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'xinfo|groups' command\r\n"))
+			return
+		}
+		groups, err := store.StreamGroupsInfo(args[2])
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		response := fmt.Sprintf("*%d\r\n", len(groups))
+		for _, group := range groups {
+			response += "*12\r\n" +
+				respBulk("name") + respBulk(group.Name) +
+				respBulk("consumers") + respInt(int64(group.Consumers)) +
+				respBulk("pending") + respInt(int64(group.Pending)) +
+				respBulk("last-delivered-id") + respBulk(group.LastID.String()) +
+				respBulk("entries-read") + formatEntriesRead(group.EntriesRead) +
+				respBulk("lag") + formatLag(group)
+		}
+		conn.Write([]byte(response))
+	case "CONSUMERS":
+		if len(args) != 4 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'xinfo|consumers' command\r\n"))
+			return
+		}
+		consumers, err := store.StreamConsumersInfo(args[2], args[3])
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		now := time.Now()
+		response := fmt.Sprintf("*%d\r\n", len(consumers))
+		for _, consumer := range consumers {
+			inactive := int64(-1)
+			if !consumer.ActiveTime.IsZero() {
+				inactive = now.Sub(consumer.ActiveTime).Milliseconds()
+			}
+			response += "*8\r\n" +
+				respBulk("name") + respBulk(consumer.Name) +
+				respBulk("pending") + respInt(int64(consumer.Pending)) +
+				respBulk("idle") + respInt(now.Sub(consumer.SeenTime).Milliseconds()) +
+				respBulk("inactive") + respInt(inactive)
+		}
+		conn.Write([]byte(response))
+	default:
+		conn.Write([]byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try XINFO HELP.\r\n", args[1])))
+	}
+}
+
+func formatEntriesRead(entriesRead int64) string {
+	if entriesRead < 0 {
+		return "$-1\r\n"
+	}
+	return respInt(entriesRead)
+}
+
+func formatLag(group store.GroupInfo) string {
+	if !group.LagKnown {
+		return "$-1\r\n"
+	}
+	return respInt(group.Lag)
+}
+
+// handleXInfoStream serves XINFO STREAM key [FULL [COUNT count]]. FULL
+// lists the first COUNT entries (10 by default, 0 for all) and every group
+// with its PEL and consumers.
+func handleXInfoStream(args []string, conn net.Conn) {
+	if len(args) < 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xinfo|stream' command\r\n"))
+		return
+	}
+
+	full := false
+	count := 10
+	switch {
+	case len(args) == 3:
+	case strings.ToUpper(args[3]) != "FULL":
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	case len(args) == 4:
+		full = true
+	case len(args) == 6 && strings.ToUpper(args[4]) == "COUNT":
+		n, err := strconv.Atoi(args[5])
+		if err != nil {
+			conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+			return
+		}
+		full = true
+		count = max(n, 0)
+	default:
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+
+	info, err := store.StreamInfoOf(args[2], full, count)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	response := respBulk("length") + respInt(int64(info.Length)) +
+		respBulk("radix-tree-keys") + respInt(int64(info.RadixTreeKeys)) +
+		respBulk("radix-tree-nodes") + respInt(int64(info.RadixTreeNodes)) +
+		respBulk("last-generated-id") + respBulk(info.LastID.String()) +
+		respBulk("max-deleted-entry-id") + respBulk(info.MaxDeletedID.String()) +
+		respBulk("entries-added") + respInt(info.EntriesAdded) +
+		respBulk("recorded-first-entry-id") + respBulk(info.FirstID.String())
+
+	if !full {
+		response = "*20\r\n" + response +
+			respBulk("groups") + respInt(int64(info.Groups)) +
+			respBulk("first-entry") + formatOptionalEntry(info.FirstEntry) +
+			respBulk("last-entry") + formatOptionalEntry(info.LastEntry)
+		conn.Write([]byte(response))
+		return
+	}
+
+	response = "*18\r\n" + response +
+		respBulk("entries") + formatXRangeResponse(info.Entries) +
+		respBulk("groups") + fmt.Sprintf("*%d\r\n", len(info.GroupDetails))
+	for _, group := range info.GroupDetails {
+		response += "*14\r\n" +
+			respBulk("name") + respBulk(group.Name) +
+			respBulk("last-delivered-id") + respBulk(group.LastID.String()) +
+			respBulk("entries-read") + formatEntriesRead(group.EntriesRead) +
+			respBulk("lag") + formatLag(group) +
+			respBulk("pel-count") + respInt(int64(group.Pending)) +
+			respBulk("pending") + fmt.Sprintf("*%d\r\n", len(group.PEL))
+		for _, pending := range group.PEL {
+			response += "*4\r\n" + respBulk(pending.ID.String()) + respBulk(pending.Consumer) +
+				respInt(pending.DeliveryTime.UnixMilli()) + respInt(pending.DeliveryCount)
+		}
+
+		response += respBulk("consumers") + fmt.Sprintf("*%d\r\n", len(group.ConsumerDetails))
+		for _, consumer := range group.ConsumerDetails {
+			response += "*10\r\n" +
+				respBulk("name") + respBulk(consumer.Name) +
+				respBulk("seen-time") + respInt(unixMillis(consumer.SeenTime)) +
+				respBulk("active-time") + respInt(unixMillis(consumer.ActiveTime)) +
+				respBulk("pel-count") + respInt(int64(consumer.Pending)) +
+				respBulk("pending") + fmt.Sprintf("*%d\r\n", len(consumer.PEL))
+			for _, pending := range consumer.PEL {
+				response += "*3\r\n" + respBulk(pending.ID.String()) +
+					respInt(pending.DeliveryTime.UnixMilli()) + respInt(pending.DeliveryCount)
+			}
+		}
+	}
+	conn.Write([]byte(response))
+}
+
+func formatOptionalEntry(entry *store.StreamEntry) string {
+	if entry == nil {
+		return "$-1\r\n"
+	}
+	return formatStreamEntry(*entry)
+}
@@ -3,71 +3,332 @@ package commands
 import (
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/kushalsdesk/redis_with_go/store"
 )
 
+// parseStreamTrim parses "MAXLEN|MINID [=|~] threshold [LIMIT count]" at
+// args[i], returning the index of the argument after it
+func parseStreamTrim(args []string, i int) (*store.StreamTrim, int, string) {
+	trim := &store.StreamTrim{Limit: -1}
+	strategy := strings.ToUpper(args[i])
+	i++
+
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		trim.Approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, "-ERR syntax error\r\n"
+	}
+
+	if strategy == "MAXLEN" {
+		maxLen, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return nil, 0, "-ERR value is not an integer or out of range\r\n"
+		}
+		if maxLen < 0 {
+			return nil, 0, "-ERR The MAXLEN argument must be >= 0.\r\n"
+		}
+		trim.MaxLen = maxLen
+	} else {
+		minID, err := store.ParseStreamIDArg(args[i], 0)
+		if err != nil {
+			return nil, 0, fmt.Sprintf("-%s\r\n", err.Error())
+		}
+		trim.MinID = &minID
+	}
+	i++
+
+	if i+1 < len(args) && strings.ToUpper(args[i]) == "LIMIT" {
+		limit, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return nil, 0, "-ERR value is not an integer or out of range\r\n"
+		}
+		if limit < 0 {
+			return nil, 0, "-ERR The LIMIT argument must be >= 0.\r\n"
+		}
+		if !trim.Approx {
+			return nil, 0, "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n"
+		}
+		trim.Limit = limit
+		i += 2
+	}
+	return trim, i, ""
+}
+
+// exactTrim replaces the trim clause at args[start:end] with MAXLEN = length.
+// A ~ trim only removes whole blocks, and replicas do not lay out their
+// blocks like the master, so they get the exact length the master ended
+// up with instead.
+func exactTrim(args []string, start, end int, length int) []string {
+	rewritten := append([]string(nil), args[:start]...)
+	rewritten = append(rewritten, "MAXLEN", "=", strconv.Itoa(length))
+	return append(rewritten, args[end:]...)
+}
+
 func handleXAdd(args []string, conn net.Conn) {
-	// eg: XADD KEY id field1 field2 field3 field4
-	if len(args) < 4 {
+	// eg: XADD KEY [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] id field1 value1 ...
+	if len(args) < 5 {
 		conn.Write([]byte("-ERR wrong number of arguments for 'xadd' command\r\n"))
 		return
 	}
 
 	key := args[1]
-	id := args[2]
-	fieldArgs := args[3:]
+	var opts store.XAddOptions
+	trimStart, trimEnd := 0, 0
+
+	i := 2
+parseOptions:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			opts.NoMkStream = true
+		case "MAXLEN", "MINID":
+			trim, next, errMsg := parseStreamTrim(args, i)
+			if errMsg != "" {
+				conn.Write([]byte(errMsg))
+				return
+			}
+			opts.Trim = trim
+			trimStart, trimEnd = i, next
+			i = next - 1
+		default:
+			break parseOptions
+		}
+	}
 
-	// fields must come in pairs
-	if len(fieldArgs)%2 != 0 {
+	// fields must come in pairs after the ID
+	idIndex := i
+	fieldArgs := args[min(idIndex+1, len(args)):]
+	if len(fieldArgs) == 0 || len(fieldArgs)%2 != 0 {
 		conn.Write([]byte("-ERR wrong number of arguments for 'xadd' command\r\n"))
 		return
 	}
 
-	// parsing field-value pair
-	fields := make(map[string]string)
-	for i := 0; i < len(fieldArgs); i += 2 {
-		fields[fieldArgs[i]] = fieldArgs[i+1]
+	resultID, length, err := store.StreamAdd(key, args[idIndex], fieldArgs, opts)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	if resultID == "" {
+		writeNullBulk(conn)
+		return
+	}
+
+	// Replicas get the ID the master generated
+	propagated := append([]string(nil), args...)
+	propagated[idIndex] = resultID
+	if opts.Trim != nil && opts.Trim.Approx {
+		propagated = exactTrim(propagated, trimStart, trimEnd, length)
 	}
+	PropagateCommand(propagated)
 
-	resultID, err := store.StreamAdd(key, id, fields)
+	writeBulkString(conn, resultID)
+}
+
+func handleXRange(args []string, conn net.Conn) {
+	handleRangeGeneric(args, conn, false)
+}
+
+func handleXRevRange(args []string, conn net.Conn) {
+	handleRangeGeneric(args, conn, true)
+}
+
+// handleRangeGeneric serves XRANGE key start end [COUNT n] and XREVRANGE,
+// which takes the end before the start
+func handleRangeGeneric(args []string, conn net.Conn, reverse bool) {
+	if len(args) != 4 && len(args) != 6 {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(args[0]))))
+		return
+	}
+
+	key := args[1]
+	startArg, endArg := args[2], args[3]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+
+	start, err := parseStreamRangeID(startArg, true)
 	if err != nil {
-		if err.Error() == "WRONGTYPE Operation against a key holding the wrong kind of value" {
-			conn.Write([]byte("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"))
-		} else {
-			conn.Write([]byte(fmt.Sprintf("-ERR %s\r\n", err.Error())))
+		writeError(conn, err)
+		return
+	}
+	end, err := parseStreamRangeID(endArg, false)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	count := -1
+	if len(args) == 6 {
+		if strings.ToUpper(args[4]) != "COUNT" {
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
 		}
+		n, err := strconv.Atoi(args[5])
+		if err != nil {
+			conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+			return
+		}
+		count = max(n, 0)
+	}
+
+	entries, err := store.StreamRange(key, start, end, count, reverse)
+	if err != nil {
+		writeError(conn, err)
 		return
 	}
-	resp := fmt.Sprintf("$%d\r\n%s\r\n", len(resultID), resultID)
+
+	resp := formatXRangeResponse(entries)
 	conn.Write([]byte(resp))
+}
+
+func handleXLen(args []string, conn net.Conn) {
+	if len(args) != 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xlen' command\r\n"))
+		return
+	}
 
+	length, err := store.StreamLen(args[1])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	writeInteger(conn, int64(length))
 }
 
-func handleXRange(args []string, conn net.Conn) {
+func handleXDel(args []string, conn net.Conn) {
+	if len(args) < 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xdel' command\r\n"))
+		return
+	}
+
+	ids := make([]store.StreamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, err := store.ParseStreamIDArg(arg, 0)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	deleted, err := store.StreamDelete(args[1], ids)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	if deleted > 0 {
+		PropagateCommand(args)
+	}
+	writeInteger(conn, int64(deleted))
+}
+
+func handleXTrim(args []string, conn net.Conn) {
 	if len(args) < 4 {
-		conn.Write([]byte("-ERR wrong number of arguments for 'xrange' command\r\n"))
+		conn.Write([]byte("-ERR wrong number of arguments for 'xtrim' command\r\n"))
 		return
 	}
 
-	key := args[1]
-	start := args[2]
-	end := args[3]
+	strategy := strings.ToUpper(args[2])
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+	trim, next, errMsg := parseStreamTrim(args, 2)
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+	if next != len(args) {
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
 
-	entries, err := store.StreamRange(key, start, end)
+	removed, length, err := store.StreamTrimKey(args[1], *trim)
 	if err != nil {
-		if err.Error() == "WRONGTYPE Operation against a key holding the wrong kind of value" {
-			conn.Write([]byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		writeError(conn, err)
+		return
+	}
+	if removed > 0 {
+		if trim.Approx {
+			PropagateCommand(exactTrim(args, 2, next, length))
 		} else {
-			conn.Write([]byte(fmt.Sprintf("-ERR %s\r\n", err.Error())))
-			// fmt.Fprintf(conn, "-ERR %s\r\n", err.Error())
+			PropagateCommand(args)
 		}
+	}
+	writeInteger(conn, int64(removed))
+}
+
+func handleXSetID(args []string, conn net.Conn) {
+	if len(args) < 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'xsetid' command\r\n"))
 		return
 	}
 
-	resp := formatXRangeResponse(entries)
-	conn.Write([]byte(resp))
+	lastID, err := store.ParseStreamIDArg(args[2], 0)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	entriesAdded := int64(-1)
+	var maxDeletedID store.StreamID
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "ENTRIESADDED":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+				return
+			}
+			if n < 0 {
+				conn.Write([]byte("-ERR entries_added must be positive\r\n"))
+				return
+			}
+			entriesAdded = n
+		case "MAXDELETEDID":
+			id, err := store.ParseStreamIDArg(args[i+1], 0)
+			if err != nil {
+				writeError(conn, err)
+				return
+			}
+			maxDeletedID = id
+		default:
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+	}
 
+	if err := store.StreamSetID(args[1], lastID, entriesAdded, maxDeletedID); err != nil {
+		writeError(conn, err)
+		return
+	}
+	conn.Write([]byte("+OK\r\n"))
+}
+
+// formatStreamEntry renders an entry as [ID, [field1, value1, ...]]. An
+// entry pending in a group but deleted from the stream has nil Fields and
+// is rendered as [ID, nil].
+func formatStreamEntry(entry store.StreamEntry) string {
+	id := entry.ID.String()
+	if entry.Fields == nil {
+		return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*-1\r\n", len(id), id)
+	}
+
+	var response strings.Builder
+	fmt.Fprintf(&response, "*2\r\n$%d\r\n%s\r\n*%d\r\n", len(id), id, len(entry.Fields))
+	for _, s := range entry.Fields {
+		fmt.Fprintf(&response, "$%d\r\n%s\r\n", len(s), s)
+	}
+	return response.String()
 }
 
 func formatXRangeResponse(entries []store.StreamEntry) string {
@@ -76,20 +337,8 @@ func formatXRangeResponse(entries []store.StreamEntry) string {
 	}
 
 	response := fmt.Sprintf("*%d\r\n", len(entries))
-
 	for _, entry := range entries {
-		// Each entry is an array of [ID, [field1, value1, field2, value2, ...]]
-		fieldCount := len(entry.Fields) * 2
-		entryResponse := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n",
-			len(entry.ID), entry.ID, fieldCount)
-
-		for field, value := range entry.Fields {
-			entryResponse += fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n",
-				len(field), field, len(value), value)
-		}
-
-		response += entryResponse
+		response += formatStreamEntry(entry)
 	}
-
 	return response
 }
@@ -25,6 +25,88 @@ func handleGet(args []string, conn net.Conn) {
 	}
 }
 
+// parseExpireOption turns EX/PX/EXAT/PXAT <n> into an absolute expiry.
+// On error it returns the RESP error to send back.
+func parseExpireOption(option, arg, command string) (*time.Time, string) {
+	amount, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return nil, "-ERR value is not an integer or out of range\r\n"
+	}
+
+	invalid := fmt.Sprintf("-ERR invalid expire time in '%s' command\r\n", command)
+	if amount <= 0 {
+		return nil, invalid
+	}
+
+	unit := time.Millisecond
+	if option == "EX" || option == "EXAT" {
+		unit = time.Second
+	}
+	absolute := option == "EXAT" || option == "PXAT"
+
+	whenMs, ok := resolveExpireMillis(amount, unit, absolute)
+	if !ok {
+		return nil, invalid
+	}
+
+	expiry := time.UnixMilli(whenMs)
+	return &expiry, ""
+}
+
+func parseSetOptions(options []string) (store.SetOptions, string) {
+	var opts store.SetOptions
+	expireSet := false
+
+	for i := 0; i < len(options); i++ {
+		option := strings.ToUpper(options[i])
+		switch option {
+		case "NX", "XX":
+			if opts.Condition != store.SetAlways {
+				return opts, "-ERR syntax error\r\n"
+			}
+			opts.Condition = store.SetNX
+			if option == "XX" {
+				opts.Condition = store.SetXX
+			}
+		case "GET":
+			opts.Get = true
+		case "KEEPTTL":
+			if expireSet {
+				return opts, "-ERR syntax error\r\n"
+			}
+			opts.KeepTTL = true
+			expireSet = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expireSet || i+1 >= len(options) {
+				return opts, "-ERR syntax error\r\n"
+			}
+			expiry, errMsg := parseExpireOption(option, options[i+1], "set")
+			if errMsg != "" {
+				return opts, errMsg
+			}
+			opts.Expiry = expiry
+			expireSet = true
+			i++
+		default:
+			return opts, "-ERR syntax error\r\n"
+		}
+	}
+
+	return opts, ""
+}
+
+// setPropagationArgs rewrites a successful SET for replicas: NX/XX/GET have
+// already been decided on the master and relative TTLs become PXAT
+func setPropagationArgs(key, val string, opts store.SetOptions) []string {
+	args := []string{"SET", key, val}
+	if opts.Expiry != nil {
+		args = append(args, "PXAT", strconv.FormatInt(opts.Expiry.UnixMilli(), 10))
+	} else if opts.KeepTTL {
+		args = append(args, "KEEPTTL")
+	}
+	return args
+}
+
 func handleSet(args []string, conn net.Conn) {
 	if len(args) < 3 {
 		conn.Write([]byte("-ERR wrong number of arguments for SET\r\n"))
@@ -32,15 +114,499 @@ func handleSet(args []string, conn net.Conn) {
 	}
 	key := args[1]
 	val := args[2]
-	var ttl time.Duration
 
-	if len(args) == 5 && strings.ToUpper(args[3]) == "EX" {
-		seconds, err := strconv.Atoi(args[4])
-		if err == nil {
-			ttl = time.Duration(seconds) * time.Second
-		}
+	opts, errMsg := parseSetOptions(args[3:])
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	result, err := store.SetWithOptions(key, val, opts)
+	if err != nil {
+		writeError(conn, err)
+		return
 	}
 
-	store.Set(key, val, ttl)
+	if result.Applied {
+		PropagateCommand(setPropagationArgs(key, val, opts))
+	}
+
+	switch {
+	case opts.Get && result.OldExists:
+		writeBulkString(conn, result.Old)
+	case opts.Get || !result.Applied:
+		writeNullBulk(conn)
+	default:
+		conn.Write([]byte("+OK\r\n"))
+	}
+}
+
+func handleSetNX(args []string, conn net.Conn) {
+	if len(args) != 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'setnx' command\r\n"))
+		return
+	}
+
+	result, _ := store.SetWithOptions(args[1], args[2], store.SetOptions{Condition: store.SetNX})
+	if !result.Applied {
+		conn.Write([]byte(":0\r\n"))
+		return
+	}
+
+	PropagateCommand(args)
+	conn.Write([]byte(":1\r\n"))
+}
+
+// handleSetEx implements SETEX (seconds) and PSETEX (milliseconds)
+func handleSetEx(args []string, conn net.Conn, option string) {
+	command := strings.ToLower(args[0])
+	if len(args) != 4 {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", command)))
+		return
+	}
+
+	key, val := args[1], args[3]
+	expiry, errMsg := parseExpireOption(option, args[2], command)
+	if errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	opts := store.SetOptions{Expiry: expiry}
+	store.SetWithOptions(key, val, opts)
+	PropagateCommand(setPropagationArgs(key, val, opts))
 	conn.Write([]byte("+OK\r\n"))
 }
+
+func handleGetSet(args []string, conn net.Conn) {
+	if len(args) != 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'getset' command\r\n"))
+		return
+	}
+
+	opts := store.SetOptions{Get: true}
+	result, err := store.SetWithOptions(args[1], args[2], opts)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	PropagateCommand(setPropagationArgs(args[1], args[2], opts))
+	if result.OldExists {
+		writeBulkString(conn, result.Old)
+	} else {
+		writeNullBulk(conn)
+	}
+}
+
+// handleMSet implements MSET and MSETNX
+func handleMSet(args []string, conn net.Conn, onlyIfNoneExist bool) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		conn.Write([]byte(fmt.Sprintf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(args[0]))))
+		return
+	}
+
+	pairs := make([][2]string, 0, (len(args)-1)/2)
+	for i := 1; i < len(args); i += 2 {
+		pairs = append(pairs, [2]string{args[i], args[i+1]})
+	}
+
+	applied := store.MultiSet(pairs, onlyIfNoneExist)
+	if applied {
+		PropagateCommand(args)
+	}
+
+	if !onlyIfNoneExist {
+		conn.Write([]byte("+OK\r\n"))
+	} else if applied {
+		conn.Write([]byte(":1\r\n"))
+	} else {
+		conn.Write([]byte(":0\r\n"))
+	}
+}
+
+func handleMGet(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'mget' command\r\n"))
+		return
+	}
+
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(args)-1))
+	for _, key := range args[1:] {
+		// Keys holding other types are reported as missing, never as an error
+		if val, ok := store.Get(key); ok {
+			resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+		} else {
+			resp.WriteString("$-1\r\n")
+		}
+	}
+	conn.Write([]byte(resp.String()))
+}
+
+func handleGetDel(args []string, conn net.Conn) {
+	if len(args) != 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'getdel' command\r\n"))
+		return
+	}
+
+	val, exists, err := store.GetDel(args[1])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	if !exists {
+		writeNullBulk(conn)
+		return
+	}
+
+	PropagateCommand([]string{"DEL", args[1]})
+	writeBulkString(conn, val)
+}
+
+func handleGetEx(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'getex' command\r\n"))
+		return
+	}
+
+	key := args[1]
+	var expiry *time.Time
+	persist := false
+
+	options := args[2:]
+	for i := 0; i < len(options); i++ {
+		option := strings.ToUpper(options[i])
+		switch option {
+		case "PERSIST":
+			if expiry != nil || persist {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				return
+			}
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expiry != nil || persist || i+1 >= len(options) {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				return
+			}
+			var errMsg string
+			expiry, errMsg = parseExpireOption(option, options[i+1], "getex")
+			if errMsg != "" {
+				conn.Write([]byte(errMsg))
+				return
+			}
+			i++
+		default:
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+	}
+
+	val, exists, deleted, err := store.GetEx(key, expiry, persist)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	if !exists {
+		writeNullBulk(conn)
+		return
+	}
+
+	switch {
+	case deleted:
+		PropagateCommand([]string{"DEL", key})
+	case persist:
+		PropagateCommand([]string{"PERSIST", key})
+	case expiry != nil:
+		PropagateCommand([]string{"PEXPIREAT", key, strconv.FormatInt(expiry.UnixMilli(), 10)})
+	}
+	writeBulkString(conn, val)
+}
+
+func handleAppend(args []string, conn net.Conn) {
+	if len(args) != 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'append' command\r\n"))
+		return
+	}
+
+	length, err := store.Append(args[1], args[2])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	PropagateCommand(args)
+	writeInteger(conn, int64(length))
+}
+
+func handleStrLen(args []string, conn net.Conn) {
+	if len(args) != 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'strlen' command\r\n"))
+		return
+	}
+
+	val, _, err := store.GetString(args[1])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+	writeInteger(conn, int64(len(val)))
+}
+
+func handleGetRange(args []string, conn net.Conn) {
+	if len(args) != 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'getrange' command\r\n"))
+		return
+	}
+
+	start, err1 := strconv.ParseInt(args[2], 10, 64)
+	end, err2 := strconv.ParseInt(args[3], 10, 64)
+	if err1 != nil || err2 != nil {
+		conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+		return
+	}
+
+	val, _, err := store.GetString(args[1])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	length := int64(len(val))
+	if start < 0 && end < 0 && start > end {
+		writeBulkString(conn, "")
+		return
+	}
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	if length == 0 || start > end {
+		writeBulkString(conn, "")
+		return
+	}
+
+	writeBulkString(conn, val[start:end+1])
+}
+
+func handleSetRange(args []string, conn net.Conn) {
+	if len(args) != 4 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'setrange' command\r\n"))
+		return
+	}
+
+	offset, err := strconv.Atoi(args[2])
+	if err != nil {
+		conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+		return
+	}
+	if offset < 0 {
+		conn.Write([]byte("-ERR offset is out of range\r\n"))
+		return
+	}
+
+	length, err := store.SetRange(args[1], offset, args[3])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if len(args[3]) > 0 {
+		PropagateCommand(args)
+	}
+	writeInteger(conn, int64(length))
+}
+
+func handleIncrByFloat(args []string, conn net.Conn) {
+	if len(args) != 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'incrbyfloat' command\r\n"))
+		return
+	}
+
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		conn.Write([]byte("-ERR value is not a valid float\r\n"))
+		return
+	}
+
+	newValue, err := store.IncrementByFloat(args[1], amount)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	// Float formatting may differ between servers, so replicas get the
+	// resulting value rather than the increment
+	PropagateCommand([]string{"SET", args[1], newValue, "KEEPTTL"})
+	writeBulkString(conn, newValue)
+}
+
+// lcsMatch is one contiguous run shared by both strings, as reported by LCS IDX
+type lcsMatch struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+func handleLCS(args []string, conn net.Conn) {
+	if len(args) < 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'lcs' command\r\n"))
+		return
+	}
+
+	var getLen, getIdx, withMatchLen bool
+	minMatchLen := 0
+
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "LEN":
+			getLen = true
+		case "IDX":
+			getIdx = true
+		case "WITHMATCHLEN":
+			withMatchLen = true
+		case "MINMATCHLEN":
+			if i+1 >= len(args) {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				return
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+				return
+			}
+			if n < 0 {
+				n = 0
+			}
+			minMatchLen = n
+			i++
+		default:
+			conn.Write([]byte("-ERR syntax error\r\n"))
+			return
+		}
+	}
+
+	if getLen && getIdx {
+		conn.Write([]byte("-ERR If you want both the length and indexes, please just use IDX.\r\n"))
+		return
+	}
+
+	a, _, err := store.GetString(args[1])
+	if err != nil {
+		conn.Write([]byte("-ERR The specified keys must contain string values\r\n"))
+		return
+	}
+	b, _, err := store.GetString(args[2])
+	if err != nil {
+		conn.Write([]byte("-ERR The specified keys must contain string values\r\n"))
+		return
+	}
+
+	lcs, matches := longestCommonSubsequence(a, b, getIdx, minMatchLen)
+
+	switch {
+	case getLen:
+		writeInteger(conn, int64(len(lcs)))
+	case getIdx:
+		var resp strings.Builder
+		resp.WriteString(fmt.Sprintf("*4\r\n$7\r\nmatches\r\n*%d\r\n", len(matches)))
+		for _, m := range matches {
+			if withMatchLen {
+				resp.WriteString("*3\r\n")
+			} else {
+				resp.WriteString("*2\r\n")
+			}
+			resp.WriteString(fmt.Sprintf("*2\r\n:%d\r\n:%d\r\n*2\r\n:%d\r\n:%d\r\n", m.aStart, m.aEnd, m.bStart, m.bEnd))
+			if withMatchLen {
+				resp.WriteString(fmt.Sprintf(":%d\r\n", m.aEnd-m.aStart+1))
+			}
+		}
+		resp.WriteString(fmt.Sprintf("$3\r\nlen\r\n:%d\r\n", len(lcs)))
+		conn.Write([]byte(resp.String()))
+	default:
+		writeBulkString(conn, lcs)
+	}
+}
+
+// longestCommonSubsequence fills the classic dynamic programming table and
+// walks it back from the end, collecting matching runs (last ones first,
+// like Redis) when withMatches is set
+func longestCommonSubsequence(a, b string, withMatches bool, minMatchLen int) (string, []lcsMatch) {
+	alen, blen := len(a), len(b)
+	width := blen + 1
+	table := make([]uint32, (alen+1)*(blen+1))
+	at := func(i, j int) uint32 { return table[i*width+j] }
+
+	for i := 1; i <= alen; i++ {
+		for j := 1; j <= blen; j++ {
+			if a[i-1] == b[j-1] {
+				table[i*width+j] = at(i-1, j-1) + 1
+			} else if left, up := at(i, j-1), at(i-1, j); left > up {
+				table[i*width+j] = left
+			} else {
+				table[i*width+j] = up
+			}
+		}
+	}
+
+	result := make([]byte, at(alen, blen))
+	idx := len(result)
+	var matches []lcsMatch
+
+	// aStart == alen means no run is being tracked
+	aStart, aEnd, bStart, bEnd := alen, 0, 0, 0
+	i, j := alen, blen
+	for i > 0 && j > 0 {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+
+			if aStart == alen {
+				aStart, aEnd = i-1, i-1
+				bStart, bEnd = j-1, j-1
+			} else if aStart == i && bStart == j {
+				// Contiguous with the current run, extend it backwards
+				aStart--
+				bStart--
+			} else {
+				emit = true
+			}
+			if aStart == 0 || bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if at(i-1, j) > at(i, j-1) {
+				i--
+			} else {
+				j--
+			}
+			if aStart != alen {
+				emit = true
+			}
+		}
+
+		if emit && withMatches {
+			if matchLen := aEnd - aStart + 1; minMatchLen == 0 || matchLen >= minMatchLen {
+				matches = append(matches, lcsMatch{aStart, aEnd, bStart, bEnd})
+			}
+		}
+		if emit {
+			aStart = alen
+		}
+	}
+
+	return string(result), matches
+}
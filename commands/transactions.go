@@ -7,11 +7,24 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/kushalsdesk/redis_with_go/store"
 )
 
 type TransactionState struct {
 	InTransaction  bool
 	QueuedCommands [][]string
+
+	// Savepoints maps the names of SAVEPOINT to queue positions
+	Savepoints map[string]int
+
+	// Aborted is set when a command was refused while queueing, EXEC then
+	// discards the transaction with EXECABORT
+	Aborted bool
+
+	// Watch holds the keys of WATCH, nil until the client watches one.
+	// It is released with the state by EXEC, DISCARD and disconnects.
+	Watch *store.Watch
 }
 
 var (
@@ -39,10 +52,27 @@ func setTransactionState(conn net.Conn, state *TransactionState) {
 	transactionStates[conn] = state
 }
 
+// clearTransactionState drops the transaction of conn and unwatches its
+// keys, after EXEC, DISCARD or when the client disconnects
 func clearTransactionState(conn net.Conn) {
 	transactionMutex.Lock()
-	defer transactionMutex.Unlock()
+	state, exists := transactionStates[conn]
 	delete(transactionStates, conn)
+	transactionMutex.Unlock()
+
+	if exists && state.Watch != nil {
+		state.Watch.Release()
+	}
+}
+
+// abortTransaction fails the transaction of conn, if it is in one
+func abortTransaction(conn net.Conn) {
+	transactionMutex.Lock()
+	defer transactionMutex.Unlock()
+
+	if state, exists := transactionStates[conn]; exists && state.InTransaction {
+		state.Aborted = true
+	}
 }
 
 func ShouldQueueCommand(conn net.Conn, command string) bool {
@@ -51,7 +81,9 @@ func ShouldQueueCommand(conn net.Conn, command string) bool {
 		command != "EXEC" &&
 		command != "DISCARD" &&
 		command != "MULTI" &&
-		command != "UNDO"
+		command != "UNDO" &&
+		command != "WATCH" &&
+		!isEditorCommand(command)
 }
 
 func QueueCommand(conn net.Conn, args []string) {
@@ -81,6 +113,7 @@ func handleMulti(args []string, conn net.Conn) {
 	state := getTransactionState(conn)
 
 	if state.InTransaction {
+		abortTransaction(conn)
 		conn.Write([]byte("-ERR MULTI calls can not be nested\r\n"))
 		return
 	}
@@ -88,6 +121,7 @@ func handleMulti(args []string, conn net.Conn) {
 	newState := &TransactionState{
 		InTransaction:  true,
 		QueuedCommands: [][]string{},
+		Watch:          state.Watch,
 	}
 	setTransactionState(conn, newState)
 
@@ -97,6 +131,15 @@ func handleMulti(args []string, conn net.Conn) {
 // MockConn for testing transaction execution
 type MockConn struct {
 	responses []string
+	caller    net.Conn // the client EXEC runs the command for, nil for the replication stream
+}
+
+// callerOf returns the client a command runs for
+func callerOf(conn net.Conn) net.Conn {
+	if mock, ok := conn.(*MockConn); ok {
+		return mock.caller
+	}
+	return conn
 }
 
 func (m *MockConn) Write(b []byte) (int, error) {
@@ -125,26 +168,49 @@ func handleExec(args []string, conn net.Conn) {
 		return
 	}
 
+	if state.Aborted {
+		clearTransactionState(conn)
+		conn.Write([]byte("-EXECABORT Transaction discarded because of previous errors.\r\n"))
+		return
+	}
+
+	// A watched key was modified: abort with a null array
+	if state.Watch != nil && state.Watch.Dirty() {
+		clearTransactionState(conn)
+		conn.Write([]byte("*-1\r\n"))
+		return
+	}
+
 	if len(state.QueuedCommands) == 0 {
 		clearTransactionState(conn)
 		conn.Write([]byte("*0\r\n"))
 		return
 	}
 
+	// Dispatch holds the command section exclusively for EXEC, so the
+	// commands run back to back. Each reply is kept whole, however many
+	// writes it took.
+	beginPropagationBatch()
 	results := make([]string, len(state.QueuedCommands))
 
 	for i, queueArgs := range state.QueuedCommands {
-		mockConn := &MockConn{responses: []string{}}
+		// The user may have lost the permission since the command was queued
+		if msg := aclCheck(conn, queueArgs, "multi"); msg != "" {
+			results[i] = "-NOPERM " + msg + "\r\n"
+			continue
+		}
+		mockConn := &MockConn{responses: []string{}, caller: conn}
 
-		Dispatch(queueArgs, mockConn)
+		dispatch(queueArgs, mockConn)
 
 		if len(mockConn.responses) > 0 {
-			results[i] = mockConn.responses[0]
+			results[i] = strings.Join(mockConn.responses, "")
 		} else {
 			results[i] = "+OK\r\n"
 		}
 	}
 
+	endPropagationBatch()
 	clearTransactionState(conn)
 
 	resp := fmt.Sprintf("*%d\r\n", len(results))
@@ -173,58 +239,85 @@ func handleDiscard(args []string, conn net.Conn) {
 	conn.Write([]byte("+OK\r\n"))
 }
 
-func handleUndo(args []string, conn net.Conn) {
-	undoCount := 1
-	if len(args) == 2 {
-		count, err := strconv.Atoi(args[1])
-		if err != nil || count <= 0 {
-			conn.Write([]byte("-ERR invalid undo count\r\n"))
-			return
-		}
-		undoCount = count
-	} else if len(args) > 2 {
-		conn.Write([]byte("-ERR wrong number of arguments for 'undo' command\r\n"))
+// handleWatch watches keys for the next EXEC, which fails if any of them is
+// written to, expires or is deleted in the meantime
+func handleWatch(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'watch' command\r\n"))
 		return
 	}
 
-	state := getTransactionState(conn)
-	if !state.InTransaction {
-		conn.Write([]byte("-ERR UNDO without MULTI\r\n"))
-		return
+	transactionMutex.Lock()
+	state, exists := transactionStates[conn]
+	if !exists {
+		state = &TransactionState{QueuedCommands: [][]string{}}
+		transactionStates[conn] = state
 	}
-
-	if len(state.QueuedCommands) == 0 {
-		conn.Write([]byte("*0\r\n"))
+	if state.InTransaction {
+		state.Aborted = true
+		transactionMutex.Unlock()
+		conn.Write([]byte("-ERR WATCH inside MULTI is not allowed\r\n"))
 		return
 	}
-
-	if undoCount > len(state.QueuedCommands) {
-		resp := fmt.Sprintf("-ERR cannot undo %d commands, only %d queued\r\n", undoCount, len(state.QueuedCommands))
-		conn.Write([]byte(resp))
-		return
+	if state.Watch == nil {
+		state.Watch = store.NewWatch()
 	}
+	watch := state.Watch
+	transactionMutex.Unlock()
 
-	removedCommands := state.QueuedCommands[len(state.QueuedCommands)-undoCount:]
+	watch.Add(args[1:]...)
+	conn.Write([]byte("+OK\r\n"))
+}
 
-	//removing from queue
-	transactionMutex.Lock()
-	state.QueuedCommands = state.QueuedCommands[:len(state.QueuedCommands)-undoCount]
-	transactionStates[conn] = state
-	transactionMutex.Unlock()
+// handleUnwatch forgets every watched key of the client
+func handleUnwatch(args []string, conn net.Conn) {
+	if len(args) != 1 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'unwatch' command\r\n"))
+		return
+	}
 
-	totalElements := undoCount + 2
-	resp := fmt.Sprintf("*%d\r\n", totalElements)
+	if watch := getTransactionState(conn).Watch; watch != nil {
+		watch.Release()
+	}
+	conn.Write([]byte("+OK\r\n"))
+}
 
-	summary := fmt.Sprintf("Removed %d commands:", undoCount)
-	resp += fmt.Sprintf("$%d\r\n%s\r\n", len(summary), summary)
+func handleUndo(args []string, conn net.Conn) {
+	if len(args) == 3 && strings.EqualFold(args[1], "AT") {
+		handleUndoAt(args[2], conn)
+		return
+	}
 
-	for _, cmd := range removedCommands {
-		cmdStr := strings.Join(cmd, " ")
-		resp += fmt.Sprintf("$%d\r\n%s\r\n", len(cmdStr), cmdStr)
+	undoCount := 1
+	if len(args) == 2 {
+		count, err := strconv.Atoi(args[1])
+		if err != nil || count <= 0 {
+			conn.Write([]byte("-ERR invalid undo count\r\n"))
+			return
+		}
+		undoCount = count
+	} else if len(args) > 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'undo' command\r\n"))
+		return
 	}
 
-	remainingInfo := fmt.Sprintf("%d commands remaining in queue", len(state.QueuedCommands))
-	resp += fmt.Sprintf("$%d\r\n%s\r\n", len(remainingInfo), remainingInfo)
+	// Replies like UNDO AT, with the removed commands and their positions
+	editQueue(conn, "UNDO", func(state *TransactionState) {
+		if len(state.QueuedCommands) == 0 {
+			conn.Write([]byte("*0\r\n"))
+			return
+		}
+		if undoCount > len(state.QueuedCommands) {
+			conn.Write([]byte(fmt.Sprintf("-ERR cannot undo %d commands, only %d queued\r\n", undoCount, len(state.QueuedCommands))))
+			return
+		}
 
-	conn.Write([]byte(resp))
+		first := len(state.QueuedCommands) - undoCount
+		removed := state.QueuedCommands[first:]
+		state.QueuedCommands = state.QueuedCommands[:first]
+		for name, at := range state.Savepoints {
+			state.Savepoints[name] = min(at, first)
+		}
+		writeQueuedCommands(conn, first, removed)
+	})
 }
@@ -0,0 +1,213 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The transaction editor works on the queue of the current MULTI: TXQUEUE
+// LIST, REPLACE and SAVEPOINTS, UNDO AT, SAVEPOINT and ROLLBACK TO. Queue
+// positions are 0-based, negative ones count from the end like list indexes.
+
+// editQueue runs fn on the transaction of conn under transactionMutex, or
+// replies the "without MULTI" error for command
+func editQueue(conn net.Conn, command string, fn func(state *TransactionState)) {
+	transactionMutex.Lock()
+	state, exists := transactionStates[conn]
+	if !exists || !state.InTransaction {
+		transactionMutex.Unlock()
+		conn.Write([]byte(fmt.Sprintf("-ERR %s without MULTI\r\n", command)))
+		return
+	}
+	defer transactionMutex.Unlock()
+	fn(state)
+}
+
+// queueIndex resolves a queue position, false when it is out of range
+func queueIndex(state *TransactionState, arg string) (int, bool) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, false
+	}
+	if index < 0 {
+		index += len(state.QueuedCommands)
+	}
+	return index, index >= 0 && index < len(state.QueuedCommands)
+}
+
+// writeQueuedCommands replies with one [index, [arg, ...]] pair per command
+func writeQueuedCommands(conn net.Conn, first int, queued [][]string) {
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(queued)))
+	for i, args := range queued {
+		resp.WriteString(fmt.Sprintf("*2\r\n:%d\r\n", first+i))
+		resp.Write(EncodeRESPArray(args))
+	}
+	conn.Write([]byte(resp.String()))
+}
+
+// writeSavepoints replies with one [name, position] pair per savepoint, in
+// queue order
+func writeSavepoints(conn net.Conn, state *TransactionState) {
+	names := make([]string, 0, len(state.Savepoints))
+	for name := range state.Savepoints {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if state.Savepoints[names[i]] != state.Savepoints[names[j]] {
+			return state.Savepoints[names[i]] < state.Savepoints[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var resp strings.Builder
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(names)))
+	for _, name := range names {
+		resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n:%d\r\n", len(name), name, state.Savepoints[name]))
+	}
+	conn.Write([]byte(resp.String()))
+}
+
+// isEditorCommand reports the commands that act on the queue instead of
+// being queued
+func isEditorCommand(command string) bool {
+	switch command {
+	case "TXQUEUE", "SAVEPOINT", "ROLLBACK":
+		return true
+	}
+	return false
+}
+
+func handleTxQueue(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'txqueue' command\r\n"))
+		return
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "LIST":
+		if len(args) != 2 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'txqueue|list' command\r\n"))
+			return
+		}
+		editQueue(conn, "TXQUEUE", func(state *TransactionState) {
+			writeQueuedCommands(conn, 0, state.QueuedCommands)
+		})
+
+	case "SAVEPOINTS":
+		if len(args) != 2 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'txqueue|savepoints' command\r\n"))
+			return
+		}
+		editQueue(conn, "TXQUEUE", func(state *TransactionState) {
+			writeSavepoints(conn, state)
+		})
+
+	case "REPLACE":
+		if len(args) < 4 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'txqueue|replace' command\r\n"))
+			return
+		}
+		handleTxQueueReplace(args[2], args[3:], conn)
+
+	default:
+		conn.Write([]byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try TXQUEUE HELP.\r\n", args[1])))
+	}
+}
+
+// handleTxQueueReplace swaps the command at a position for another one,
+// which is checked like a freshly queued command. A refused replacement
+// leaves the queue and the transaction as they were.
+func handleTxQueueReplace(position string, replacement []string, conn net.Conn) {
+	command := strings.ToUpper(replacement[0])
+	if getTransactionState(conn).InTransaction && !ShouldQueueCommand(conn, command) {
+		conn.Write([]byte(fmt.Sprintf("-ERR %s cannot be queued\r\n", command)))
+		return
+	}
+	if errMsg := checkCommand(replacement); errMsg != "" {
+		conn.Write([]byte(errMsg))
+		return
+	}
+
+	editQueue(conn, "TXQUEUE", func(state *TransactionState) {
+		index, ok := queueIndex(state, position)
+		if !ok {
+			conn.Write([]byte("-ERR index out of range\r\n"))
+			return
+		}
+		replaced := state.QueuedCommands[index]
+		state.QueuedCommands[index] = replacement
+		writeQueuedCommands(conn, index, [][]string{replaced})
+	})
+}
+
+// handleUndoAt removes the command at a position and replies with it.
+// Savepoints after it move down with the rest of the queue.
+func handleUndoAt(position string, conn net.Conn) {
+	editQueue(conn, "UNDO", func(state *TransactionState) {
+		index, ok := queueIndex(state, position)
+		if !ok {
+			conn.Write([]byte("-ERR index out of range\r\n"))
+			return
+		}
+		removed := state.QueuedCommands[index]
+		state.QueuedCommands = append(state.QueuedCommands[:index], state.QueuedCommands[index+1:]...)
+		for name, at := range state.Savepoints {
+			if at > index {
+				state.Savepoints[name] = at - 1
+			}
+		}
+		writeQueuedCommands(conn, index, [][]string{removed})
+	})
+}
+
+// handleSavepoint names the current end of the queue. Reusing a name moves
+// the savepoint.
+func handleSavepoint(args []string, conn net.Conn) {
+	if len(args) != 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'savepoint' command\r\n"))
+		return
+	}
+
+	editQueue(conn, "SAVEPOINT", func(state *TransactionState) {
+		if state.Savepoints == nil {
+			state.Savepoints = make(map[string]int)
+		}
+		state.Savepoints[args[1]] = len(state.QueuedCommands)
+		conn.Write([]byte("+OK\r\n"))
+	})
+}
+
+// handleRollback is ROLLBACK TO name: it drops every command queued after
+// the savepoint, and the savepoints set after it, and replies with the
+// dropped commands
+func handleRollback(args []string, conn net.Conn) {
+	if len(args) != 3 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'rollback' command\r\n"))
+		return
+	}
+	if !strings.EqualFold(args[1], "TO") {
+		conn.Write([]byte("-ERR syntax error\r\n"))
+		return
+	}
+
+	editQueue(conn, "ROLLBACK", func(state *TransactionState) {
+		at, exists := state.Savepoints[args[2]]
+		if !exists {
+			conn.Write([]byte(fmt.Sprintf("-ERR no such savepoint '%s'\r\n", args[2])))
+			return
+		}
+
+		removed := state.QueuedCommands[at:]
+		state.QueuedCommands = state.QueuedCommands[:at]
+		for name, position := range state.Savepoints {
+			if position > at {
+				delete(state.Savepoints, name)
+			}
+		}
+		writeQueuedCommands(conn, at, removed)
+	})
+}
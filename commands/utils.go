@@ -3,6 +3,7 @@ package commands
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/kushalsdesk/redis_with_go/store"
 )
@@ -18,3 +19,45 @@ func handleType(args []string, conn net.Conn) {
 	resp := fmt.Sprintf("+%s\r\n", keyType)
 	conn.Write([]byte(resp))
 }
+
+func handleObject(args []string, conn net.Conn) {
+	if len(args) < 2 {
+		conn.Write([]byte("-ERR wrong number of arguments for 'object' command\r\n"))
+		return
+	}
+
+	subcommand := strings.ToUpper(args[1])
+	switch subcommand {
+	case "ENCODING":
+		if len(args) != 3 {
+			conn.Write([]byte("-ERR wrong number of arguments for 'object|encoding' command\r\n"))
+			return
+		}
+		encoding, exists := store.ObjectEncoding(args[2])
+		if !exists {
+			writeNullBulk(conn)
+			return
+		}
+		writeBulkString(conn, encoding)
+	default:
+		conn.Write([]byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try OBJECT HELP.\r\n", args[1])))
+	}
+}
+
+// writeError sends err as a RESP error. Store errors already start with the
+// error code (ERR, WRONGTYPE, ...), so they are written as is.
+func writeError(conn net.Conn, err error) {
+	conn.Write([]byte(fmt.Sprintf("-%s\r\n", err.Error())))
+}
+
+func writeBulkString(conn net.Conn, s string) {
+	conn.Write([]byte(fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)))
+}
+
+func writeNullBulk(conn net.Conn) {
+	conn.Write([]byte("$-1\r\n"))
+}
+
+func writeInteger(conn net.Conn, n int64) {
+	conn.Write([]byte(fmt.Sprintf(":%d\r\n", n)))
+}
@@ -48,7 +48,16 @@ func handleWait(args []string, conn net.Conn) {
 		conn.Write([]byte(resp))
 		return
 	}
+	// Like blocking commands, WAIT does not wait inside EXEC: it reports
+	// the replicas that are caught up right now
+	if _, nested := conn.(*MockConn); nested {
+		conn.Write([]byte(fmt.Sprintf(":%d\r\n", countACKedReplicas())))
+		return
+	}
+
+	store.EndCommand()
 	acked := waitForACKs(numReplicas, timeoutMs, targetOffset)
+	store.BeginCommand()
 	resp := fmt.Sprintf(":%d\r\n", acked)
 	conn.Write([]byte(resp))
 }
@@ -62,14 +71,7 @@ func waitForACKs(numReplicas, timeoutMs int, targetOffset int64) int {
 			return ackedCount
 		}
 
-		lags := store.GetAllReplicaLags()
-		currentAcked := 0
-		for _, lag := range lags {
-			if lag <= 0 {
-				currentAcked++
-			}
-		}
-		ackedCount = currentAcked
+		ackedCount = countACKedReplicas()
 
 		if ackedCount >= numReplicas {
 			fmt.Printf("✅ WAIT succeeded: %d replicas ACKed (target %d)\n", ackedCount, targetOffset)
@@ -80,3 +82,15 @@ func waitForACKs(numReplicas, timeoutMs int, targetOffset int64) int {
 	}
 	return ackedCount
 }
+
+// countACKedReplicas returns how many replicas acknowledged everything
+// propagated to them
+func countACKedReplicas() int {
+	acked := 0
+	for _, lag := range store.GetAllReplicaLags() {
+		if lag <= 0 {
+			acked++
+		}
+	}
+	return acked
+}
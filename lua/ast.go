@@ -0,0 +1,179 @@
+package lua
+
+// The parser resolves every name while parsing: locals become slots of the
+// function's frame, names from enclosing functions become upvalues and
+// anything else is a global. The interpreter walks the resulting tree.
+
+// funcProto is a compiled function body
+type funcProto struct {
+	chunk     string
+	name      string
+	line      int
+	numParams int
+	isVararg  bool
+	params    []*localVar
+	numSlots  int
+	upvals    []upvalDesc
+	body      *block
+}
+
+// localVar is one local declaration. Locals captured by a closure live in a
+// cell so that the closure and the frame share them, the others are stored
+// in their frame slot directly.
+type localVar struct {
+	name     string
+	slot     int
+	captured bool
+}
+
+// cell holds a captured local
+type cell struct {
+	v Value
+}
+
+// upvalDesc tells a closure where to find an upvalue when it is created: a
+// local of the enclosing frame or one of the enclosing function's upvalues
+type upvalDesc struct {
+	name      string
+	fromLocal bool
+	index     int
+}
+
+type block struct {
+	stmts []stmt
+}
+
+type stmt interface{}
+
+type expr interface{}
+
+type (
+	localStmt struct {
+		vars  []*localVar
+		exprs []expr
+		line  int
+	}
+	localFunctionStmt struct {
+		v    *localVar
+		fn   *funcProto
+		line int
+	}
+	assignStmt struct {
+		targets []expr
+		exprs   []expr
+		line    int
+	}
+	callStmt struct {
+		call expr
+		line int
+	}
+	doStmt struct {
+		body *block
+	}
+	whileStmt struct {
+		cond expr
+		body *block
+		line int
+	}
+	repeatStmt struct {
+		body *block
+		cond expr
+		line int
+	}
+	ifStmt struct {
+		conds  []expr
+		blocks []*block
+		orElse *block
+		line   int
+	}
+	numForStmt struct {
+		v                  *localVar
+		start, limit, step expr
+		body               *block
+		line               int
+	}
+	genForStmt struct {
+		vars  []*localVar
+		exprs []expr
+		body  *block
+		line  int
+	}
+	returnStmt struct {
+		exprs []expr
+		line  int
+	}
+	breakStmt struct{}
+)
+
+type (
+	constExpr struct {
+		v Value
+	}
+	varargExpr struct{}
+	localExpr  struct{ v *localVar }
+	upvalExpr  struct {
+		index int
+		name  string
+	}
+	globalExpr struct {
+		name string
+		line int
+	}
+	indexExpr struct {
+		obj, key expr
+		line     int
+	}
+	callExpr struct {
+		fn   expr
+		args []expr
+		line int
+	}
+	methodCallExpr struct {
+		obj  expr
+		name string
+		args []expr
+		line int
+	}
+	functionExpr struct {
+		proto *funcProto
+	}
+	binaryExpr struct {
+		op          tokenType
+		left, right expr
+		line        int
+	}
+	andExpr struct {
+		left, right expr
+	}
+	orExpr struct {
+		left, right expr
+	}
+	unaryExpr struct {
+		op      tokenType // '-' as a char token, tokNot or '#'
+		operand expr
+		line    int
+	}
+	tableExpr struct {
+		items []tableItem
+		line  int
+	}
+	parenExpr struct {
+		inner expr
+	}
+)
+
+// tableItem is one field of a table constructor, key is nil for
+// positional items
+type tableItem struct {
+	key, value expr
+}
+
+// isMultiValued reports the expressions that can produce more than one
+// value at the end of a list
+func isMultiValued(e expr) bool {
+	switch e.(type) {
+	case *callExpr, *methodCallExpr, *varargExpr:
+		return true
+	}
+	return false
+}
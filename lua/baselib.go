@@ -0,0 +1,269 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Argument helpers shared by the libraries. n is the 1-based argument
+// position used in error messages.
+
+func (s *State) argError(n int, fname, msg string) {
+	s.Error("bad argument #%d to '%s' (%s)", n, fname, msg)
+}
+
+func (s *State) typeError(args []Value, n int, fname, expected string) {
+	got := "no value"
+	if n <= len(args) {
+		got = TypeName(args[n-1])
+	}
+	s.argError(n, fname, fmt.Sprintf("%s expected, got %s", expected, got))
+}
+
+func arg(args []Value, n int) Value {
+	if n <= len(args) {
+		return args[n-1]
+	}
+	return nil
+}
+
+func (s *State) checkAny(args []Value, n int, fname string) Value {
+	if n > len(args) {
+		s.argError(n, fname, "value expected")
+	}
+	return args[n-1]
+}
+
+func (s *State) checkTable(args []Value, n int, fname string) *Table {
+	t, ok := arg(args, n).(*Table)
+	if !ok {
+		s.typeError(args, n, fname, "table")
+	}
+	return t
+}
+
+func (s *State) checkString(args []Value, n int, fname string) string {
+	str, ok := ToString(arg(args, n))
+	if !ok {
+		s.typeError(args, n, fname, "string")
+	}
+	return str
+}
+
+func (s *State) checkNumber(args []Value, n int, fname string) float64 {
+	num, ok := ToNumber(arg(args, n))
+	if !ok {
+		s.typeError(args, n, fname, "number")
+	}
+	return num
+}
+
+// checkInt truncates like lua_tointeger
+func (s *State) checkInt(args []Value, n int, fname string) int {
+	return toInt(s.checkNumber(args, n, fname))
+}
+
+func (s *State) optInt(args []Value, n int, fname string, def int) int {
+	if arg(args, n) == nil {
+		return def
+	}
+	return s.checkInt(args, n, fname)
+}
+
+func (s *State) optString(args []Value, n int, fname string, def string) string {
+	if arg(args, n) == nil {
+		return def
+	}
+	return s.checkString(args, n, fname)
+}
+
+func toInt(n float64) int {
+	switch {
+	case math.IsNaN(n):
+		return 0
+	case n >= math.MaxInt64:
+		return math.MaxInt64
+	case n <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int(n)
+}
+
+// nextFunction is the next() builtin, generic for loops over pairs()
+// recognize it and walk the table directly
+var nextFunction *GoFunction
+
+func init() {
+	nextFunction = &GoFunction{Name: "next", Fn: baseNext}
+}
+
+func baseNext(s *State, args []Value) []Value {
+	t := s.checkTable(args, 1, "next")
+	key, value, ok := t.Next(arg(args, 2))
+	if !ok {
+		s.Error("invalid key to 'next'")
+	}
+	if key == nil {
+		return []Value{nil}
+	}
+	return []Value{key, value}
+}
+
+func openBase(s *State) {
+	g := s.Globals
+	g.Set("_G", g)
+	g.Set("_VERSION", "Lua 5.1")
+	g.Set("next", nextFunction)
+
+	s.Register("assert", func(s *State, args []Value) []Value {
+		v := s.checkAny(args, 1, "assert")
+		if !Truthy(v) {
+			if len(args) > 1 {
+				s.Raise(args[1])
+			}
+			s.Error("assertion failed!")
+		}
+		return args
+	})
+
+	s.Register("error", func(s *State, args []Value) []Value {
+		v := arg(args, 1)
+		level := s.optInt(args, 2, "error", 1)
+		if msg, ok := v.(string); ok && level > 0 {
+			v = s.where() + msg
+		}
+		s.Raise(v)
+		return nil
+	})
+
+	s.Register("pairs", func(s *State, args []Value) []Value {
+		t := s.checkTable(args, 1, "pairs")
+		return []Value{nextFunction, t, nil}
+	})
+
+	ipairsIterator := &GoFunction{Name: "ipairs_iterator", Fn: func(s *State, args []Value) []Value {
+		t := args[0].(*Table)
+		i := args[1].(float64) + 1
+		v := t.Get(i)
+		if v == nil {
+			return []Value{nil}
+		}
+		return []Value{i, v}
+	}}
+	s.Register("ipairs", func(s *State, args []Value) []Value {
+		t := s.checkTable(args, 1, "ipairs")
+		return []Value{ipairsIterator, t, float64(0)}
+	})
+
+	s.Register("pcall", func(s *State, args []Value) []Value {
+		fn := s.checkAny(args, 1, "pcall")
+		results, err := s.pcall(fn, args[1:])
+		if err != nil {
+			return []Value{false, err.Value}
+		}
+		return append([]Value{true}, results...)
+	})
+
+	s.Register("xpcall", func(s *State, args []Value) []Value {
+		fn := s.checkAny(args, 1, "xpcall")
+		handler := arg(args, 2)
+		results, err := s.pcall(fn, nil)
+		if err != nil {
+			var handled Value
+			if results := s.call(handler, []Value{err.Value}, s.line, ""); len(results) > 0 {
+				handled = results[0]
+			}
+			return []Value{false, handled}
+		}
+		return append([]Value{true}, results...)
+	})
+
+	s.Register("select", func(s *State, args []Value) []Value {
+		if str, ok := arg(args, 1).(string); ok && str == "#" {
+			return []Value{float64(len(args) - 1)}
+		}
+		n := s.checkInt(args, 1, "select")
+		if n < 0 {
+			n += len(args)
+		}
+		if n < 1 {
+			s.argError(1, "select", "index out of range")
+		}
+		if n >= len(args) {
+			return nil
+		}
+		return args[n:]
+	})
+
+	s.Register("tonumber", func(s *State, args []Value) []Value {
+		base := s.optInt(args, 2, "tonumber", 10)
+		if base == 10 {
+			n, ok := ToNumber(s.checkAny(args, 1, "tonumber"))
+			if !ok {
+				return []Value{nil}
+			}
+			return []Value{n}
+		}
+		if base < 2 || base > 36 {
+			s.argError(2, "tonumber", "base out of range")
+		}
+		str := strings.ToLower(strings.TrimSpace(s.checkString(args, 1, "tonumber")))
+		n, err := strconv.ParseInt(str, base, 64)
+		if err != nil {
+			return []Value{nil}
+		}
+		return []Value{float64(n)}
+	})
+
+	s.Register("tostring", func(s *State, args []Value) []Value {
+		return []Value{tostring(s.checkAny(args, 1, "tostring"))}
+	})
+
+	s.Register("type", func(s *State, args []Value) []Value {
+		return []Value{TypeName(s.checkAny(args, 1, "type"))}
+	})
+
+	s.Register("unpack", tableUnpack)
+
+	s.Register("rawget", func(s *State, args []Value) []Value {
+		t := s.checkTable(args, 1, "rawget")
+		return []Value{t.Get(s.checkAny(args, 2, "rawget"))}
+	})
+
+	s.Register("rawset", func(s *State, args []Value) []Value {
+		t := s.checkTable(args, 1, "rawset")
+		s.checkAny(args, 3, "rawset")
+		if t.readonly {
+			s.Error("Attempt to modify a readonly table")
+		}
+		if err := t.Set(args[1], args[2]); err != nil {
+			s.Error("%s", err)
+		}
+		return []Value{t}
+	})
+
+	s.Register("rawequal", func(s *State, args []Value) []Value {
+		a := s.checkAny(args, 1, "rawequal")
+		b := s.checkAny(args, 2, "rawequal")
+		return []Value{rawEqual(a, b)}
+	})
+}
+
+func tableUnpack(s *State, args []Value) []Value {
+	t := s.checkTable(args, 1, "unpack")
+	i := s.optInt(args, 2, "unpack", 1)
+	j := s.optInt(args, 3, "unpack", t.Len())
+	if i > j {
+		return nil
+	}
+	if j-i >= 8000 {
+		s.Error("too many results to unpack")
+	}
+	values := make([]Value, 0, j-i+1)
+	for k := i; k <= j; k++ {
+		values = append(values, t.Get(float64(k)))
+	}
+	return values
+}
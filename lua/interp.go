@@ -0,0 +1,710 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Function is a Lua function: a compiled body and the upvalues it closes over
+type Function struct {
+	proto  *funcProto
+	upvals []*cell
+}
+
+// Error is a Lua error being raised. Value is what error() was called with,
+// a message string for runtime errors. Fatal errors cannot be caught by
+// pcall, they end the script.
+type Error struct {
+	Value Value
+	Fatal bool
+
+	// Chunk and Line tell which script line raised the error
+	Chunk string
+	Line  int
+}
+
+func (e *Error) Error() string {
+	if s, ok := ToString(e.Value); ok {
+		return s
+	}
+	return tostring(e.Value)
+}
+
+const (
+	maxCallDepth    = 10000
+	defaultHookTick = 10000
+)
+
+// State is an interpreter with its globals. It is not safe for concurrent
+// use.
+type State struct {
+	Globals *Table
+
+	// StrictGlobals makes reading a global that does not exist an error
+	// instead of nil
+	StrictGlobals bool
+
+	// Hook, when set, is called every HookInterval loop iterations and
+	// function calls. An error it returns ends the script as a fatal error.
+	Hook         func() error
+	HookInterval int
+
+	stringLib *Table
+	line      int // line of the running statement
+	chunk     string
+	depth     int
+	ticks     int
+}
+
+// NewState returns a state with the base, string, table and math libraries
+// loaded
+func NewState() *State {
+	s := &State{Globals: NewTable(), HookInterval: defaultHookTick}
+	openBase(s)
+	openString(s)
+	openTable(s)
+	openMath(s)
+	return s
+}
+
+// Register sets a global Go function
+func (s *State) Register(name string, fn func(s *State, args []Value) []Value) {
+	s.Globals.Set(name, &GoFunction{Name: name, Fn: fn})
+}
+
+// Line returns the line the running script is at
+func (s *State) Line() int {
+	return s.line
+}
+
+// Error raises a runtime error from a Go function, prefixed with the
+// position of the calling script line
+func (s *State) Error(format string, args ...interface{}) {
+	s.Raise(s.where() + fmt.Sprintf(format, args...))
+}
+
+// Raise raises v as the error value, like error(v, 0)
+func (s *State) Raise(v Value) {
+	panic(&Error{Value: v, Chunk: s.chunk, Line: s.line})
+}
+
+func (s *State) where() string {
+	if s.chunk == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d: ", s.chunk, s.line)
+}
+
+func (s *State) runtimeError(line int, format string, args ...interface{}) {
+	s.line = line
+	s.Error(format, args...)
+}
+
+// Call calls fn in protected mode and returns its results, or the error it
+// raised
+func (s *State) Call(fn Value, args ...Value) (results []Value, err error) {
+	depth, line, chunk := s.depth, s.line, s.chunk
+	defer func() {
+		if r := recover(); r != nil {
+			luaErr, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			s.depth, s.line, s.chunk = depth, line, chunk
+			err = luaErr
+		}
+	}()
+	return s.call(fn, args, line, ""), nil
+}
+
+// pcall is Call for scripts: fatal errors go through
+func (s *State) pcall(fn Value, args []Value) (results []Value, luaErr *Error) {
+	depth, line, chunk := s.depth, s.line, s.chunk
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok || e.Fatal {
+				panic(r)
+			}
+			s.depth, s.line, s.chunk = depth, line, chunk
+			luaErr = e
+		}
+	}()
+	return s.call(fn, args, line, ""), nil
+}
+
+// tick runs the hook every HookInterval ticks
+func (s *State) tick() {
+	if s.Hook == nil {
+		return
+	}
+	s.ticks++
+	if s.ticks < s.HookInterval {
+		return
+	}
+	s.ticks = 0
+	if err := s.Hook(); err != nil {
+		panic(&Error{Value: err.Error(), Fatal: true, Chunk: s.chunk, Line: s.line})
+	}
+}
+
+// call calls any value, desc names it in the error when it is not callable
+func (s *State) call(fn Value, args []Value, line int, desc string) []Value {
+	switch fn := fn.(type) {
+	case *Function:
+		return s.callLua(fn, args, line)
+	case *GoFunction:
+		s.line = line
+		s.tick()
+		return fn.Fn(s, args)
+	}
+	s.runtimeError(line, "attempt to call %s", describeValue(desc, fn))
+	return nil
+}
+
+func describeValue(desc string, v Value) string {
+	if desc == "" {
+		return fmt.Sprintf("a %s value", TypeName(v))
+	}
+	return fmt.Sprintf("%s (a %s value)", desc, TypeName(v))
+}
+
+type frame struct {
+	fn      *Function
+	slots   []Value
+	varargs []Value
+	ret     []Value
+}
+
+func (f *frame) get(v *localVar) Value {
+	if v.captured {
+		return f.slots[v.slot].(*cell).v
+	}
+	return f.slots[v.slot]
+}
+
+func (f *frame) set(v *localVar, value Value) {
+	if v.captured {
+		f.slots[v.slot].(*cell).v = value
+		return
+	}
+	f.slots[v.slot] = value
+}
+
+// declare gives a local a fresh variable, so closures made in earlier loop
+// iterations keep their own
+func (f *frame) declare(v *localVar, value Value) {
+	if v.captured {
+		f.slots[v.slot] = &cell{value}
+		return
+	}
+	f.slots[v.slot] = value
+}
+
+func (s *State) callLua(fn *Function, args []Value, line int) []Value {
+	if s.depth >= maxCallDepth {
+		s.runtimeError(line, "stack overflow")
+	}
+	s.depth++
+	s.tick()
+
+	proto := fn.proto
+	f := &frame{fn: fn, slots: make([]Value, proto.numSlots)}
+	for i, param := range proto.params {
+		var arg Value
+		if i < len(args) {
+			arg = args[i]
+		}
+		f.declare(param, arg)
+	}
+	if proto.isVararg && len(args) > proto.numParams {
+		f.varargs = args[proto.numParams:]
+	}
+
+	callerLine, callerChunk := s.line, s.chunk
+	s.chunk = proto.chunk
+	s.execBlock(f, proto.body)
+	s.line, s.chunk = callerLine, callerChunk
+	s.depth--
+	return f.ret
+}
+
+// Statements
+
+const (
+	flowNormal = iota
+	flowBreak
+	flowReturn
+)
+
+func (s *State) execBlock(f *frame, b *block) int {
+	for _, st := range b.stmts {
+		if flow := s.exec(f, st); flow != flowNormal {
+			return flow
+		}
+	}
+	return flowNormal
+}
+
+func (s *State) exec(f *frame, st stmt) int {
+	switch st := st.(type) {
+	case *localStmt:
+		s.line = st.line
+		if len(st.vars) == 1 && len(st.exprs) == 1 {
+			f.declare(st.vars[0], s.eval(f, st.exprs[0]))
+			return flowNormal
+		}
+		values := s.evalList(f, st.exprs, len(st.vars))
+		for i, v := range st.vars {
+			f.declare(v, values[i])
+		}
+
+	case *localFunctionStmt:
+		s.line = st.line
+		f.declare(st.v, nil)
+		f.set(st.v, s.closure(f, st.fn))
+
+	case *assignStmt:
+		s.line = st.line
+		if len(st.targets) == 1 && len(st.exprs) == 1 {
+			s.assign(f, st.targets[0], s.eval(f, st.exprs[0]))
+			return flowNormal
+		}
+		// Table and key of every target are evaluated before any assignment
+		type target struct {
+			obj, key Value
+		}
+		targets := make([]target, len(st.targets))
+		for i, t := range st.targets {
+			if index, ok := t.(*indexExpr); ok {
+				targets[i] = target{s.eval(f, index.obj), s.eval(f, index.key)}
+			}
+		}
+		values := s.evalList(f, st.exprs, len(st.targets))
+		for i, t := range st.targets {
+			if index, ok := t.(*indexExpr); ok {
+				s.setIndex(targets[i].obj, targets[i].key, values[i], index.line, describe(index.obj))
+			} else {
+				s.assign(f, t, values[i])
+			}
+		}
+
+	case *callStmt:
+		s.line = st.line
+		s.evalMulti(f, st.call)
+
+	case *doStmt:
+		return s.execBlock(f, st.body)
+
+	case *whileStmt:
+		for {
+			s.line = st.line
+			if !Truthy(s.eval(f, st.cond)) {
+				break
+			}
+			s.tick()
+			if flow := s.execBlock(f, st.body); flow == flowBreak {
+				break
+			} else if flow == flowReturn {
+				return flow
+			}
+		}
+
+	case *repeatStmt:
+		for {
+			s.tick()
+			if flow := s.execBlock(f, st.body); flow == flowBreak {
+				break
+			} else if flow == flowReturn {
+				return flow
+			}
+			if Truthy(s.eval(f, st.cond)) {
+				break
+			}
+		}
+
+	case *ifStmt:
+		s.line = st.line
+		for i, cond := range st.conds {
+			if Truthy(s.eval(f, cond)) {
+				return s.execBlock(f, st.blocks[i])
+			}
+		}
+		if st.orElse != nil {
+			return s.execBlock(f, st.orElse)
+		}
+
+	case *numForStmt:
+		return s.execNumFor(f, st)
+
+	case *genForStmt:
+		return s.execGenFor(f, st)
+
+	case *returnStmt:
+		s.line = st.line
+		f.ret = s.evalList(f, st.exprs, -1)
+		return flowReturn
+
+	case *breakStmt:
+		return flowBreak
+	}
+	return flowNormal
+}
+
+func (s *State) execNumFor(f *frame, st *numForStmt) int {
+	s.line = st.line
+	start, ok := ToNumber(s.eval(f, st.start))
+	if !ok {
+		s.runtimeError(st.line, "'for' initial value must be a number")
+	}
+	limit, ok := ToNumber(s.eval(f, st.limit))
+	if !ok {
+		s.runtimeError(st.line, "'for' limit must be a number")
+	}
+	step := 1.0
+	if st.step != nil {
+		if step, ok = ToNumber(s.eval(f, st.step)); !ok {
+			s.runtimeError(st.line, "'for' step must be a number")
+		}
+	}
+
+	for i := start; (step > 0 && i <= limit) || (step <= 0 && i >= limit); i += step {
+		s.tick()
+		f.declare(st.v, i)
+		if flow := s.execBlock(f, st.body); flow == flowBreak {
+			break
+		} else if flow == flowReturn {
+			return flow
+		}
+	}
+	return flowNormal
+}
+
+func (s *State) execGenFor(f *frame, st *genForStmt) int {
+	s.line = st.line
+	init := s.evalList(f, st.exprs, 3)
+	iterator, state, control := init[0], init[1], init[2]
+
+	for {
+		s.tick()
+		var results []Value
+		if iterator == nextFunction {
+			// pairs() iterates without going through a call
+			t, ok := state.(*Table)
+			if !ok {
+				results = s.call(iterator, []Value{state, control}, st.line, "")
+			} else {
+				key, value, ok := t.Next(control)
+				if !ok {
+					s.runtimeError(st.line, "invalid key to 'next'")
+				}
+				results = []Value{key, value}
+			}
+		} else {
+			results = s.call(iterator, []Value{state, control}, st.line, "")
+		}
+
+		if len(results) == 0 || results[0] == nil {
+			return flowNormal
+		}
+		control = results[0]
+		for i, v := range st.vars {
+			var value Value
+			if i < len(results) {
+				value = results[i]
+			}
+			f.declare(v, value)
+		}
+		if flow := s.execBlock(f, st.body); flow == flowBreak {
+			return flowNormal
+		} else if flow == flowReturn {
+			return flow
+		}
+	}
+}
+
+func (s *State) assign(f *frame, target expr, value Value) {
+	switch t := target.(type) {
+	case *localExpr:
+		f.set(t.v, value)
+	case *upvalExpr:
+		f.fn.upvals[t.index].v = value
+	case *globalExpr:
+		s.setIndex(s.Globals, t.name, value, t.line, "")
+	case *indexExpr:
+		obj := s.eval(f, t.obj)
+		key := s.eval(f, t.key)
+		s.setIndex(obj, key, value, t.line, describe(t.obj))
+	}
+}
+
+func (s *State) setIndex(obj, key, value Value, line int, desc string) {
+	t, ok := obj.(*Table)
+	if !ok {
+		s.runtimeError(line, "attempt to index %s", describeValue(desc, obj))
+	}
+	if t.readonly {
+		s.runtimeError(line, "Attempt to modify a readonly table")
+	}
+	if err := t.Set(key, value); err != nil {
+		s.runtimeError(line, "%s", err)
+	}
+}
+
+func (s *State) index(obj, key Value, line int, desc string) Value {
+	switch o := obj.(type) {
+	case *Table:
+		return o.Get(key)
+	case string:
+		return s.stringLib.Get(key)
+	}
+	s.runtimeError(line, "attempt to index %s", describeValue(desc, obj))
+	return nil
+}
+
+// describe names a variable for error messages like Lua does, "" for
+// anything that is not a variable
+func describe(e expr) string {
+	switch e := e.(type) {
+	case *localExpr:
+		return fmt.Sprintf("local '%s'", e.v.name)
+	case *upvalExpr:
+		return fmt.Sprintf("upvalue '%s'", e.name)
+	case *globalExpr:
+		return fmt.Sprintf("global '%s'", e.name)
+	case *indexExpr:
+		if key, ok := e.key.(*constExpr); ok {
+			if name, ok := key.v.(string); ok {
+				return fmt.Sprintf("field '%s'", name)
+			}
+		}
+	}
+	return ""
+}
+
+// Expressions
+
+func (s *State) closure(f *frame, proto *funcProto) *Function {
+	fn := &Function{proto: proto, upvals: make([]*cell, len(proto.upvals))}
+	for i, desc := range proto.upvals {
+		if desc.fromLocal {
+			fn.upvals[i] = f.slots[desc.index].(*cell)
+		} else {
+			fn.upvals[i] = f.fn.upvals[desc.index]
+		}
+	}
+	return fn
+}
+
+// eval evaluates an expression to a single value
+func (s *State) eval(f *frame, e expr) Value {
+	switch e := e.(type) {
+	case *constExpr:
+		return e.v
+	case *localExpr:
+		return f.get(e.v)
+	case *upvalExpr:
+		return f.fn.upvals[e.index].v
+	case *globalExpr:
+		value := s.Globals.Get(e.name)
+		if value == nil && s.StrictGlobals {
+			s.runtimeError(e.line, "Script attempted to access nonexistent global variable '%s'", e.name)
+		}
+		return value
+	case *indexExpr:
+		obj := s.eval(f, e.obj)
+		return s.index(obj, s.eval(f, e.key), e.line, describe(e.obj))
+	case *callExpr, *methodCallExpr, *varargExpr:
+		if values := s.evalMulti(f, e); len(values) > 0 {
+			return values[0]
+		}
+		return nil
+	case *parenExpr:
+		return s.eval(f, e.inner)
+	case *functionExpr:
+		return s.closure(f, e.proto)
+	case *andExpr:
+		left := s.eval(f, e.left)
+		if !Truthy(left) {
+			return left
+		}
+		return s.eval(f, e.right)
+	case *orExpr:
+		left := s.eval(f, e.left)
+		if Truthy(left) {
+			return left
+		}
+		return s.eval(f, e.right)
+	case *binaryExpr:
+		return s.binary(f, e)
+	case *unaryExpr:
+		return s.unary(f, e)
+	case *tableExpr:
+		return s.table(f, e)
+	}
+	panic(fmt.Sprintf("lua: unknown expression %T", e))
+}
+
+// evalMulti evaluates an expression to all of its values
+func (s *State) evalMulti(f *frame, e expr) []Value {
+	switch e := e.(type) {
+	case *varargExpr:
+		return f.varargs
+	case *callExpr:
+		fn := s.eval(f, e.fn)
+		args := s.evalList(f, e.args, -1)
+		return s.call(fn, args, e.line, describe(e.fn))
+	case *methodCallExpr:
+		obj := s.eval(f, e.obj)
+		fn := s.index(obj, e.name, e.line, describe(e.obj))
+		args := append([]Value{obj}, s.evalList(f, e.args, -1)...)
+		return s.call(fn, args, e.line, fmt.Sprintf("method '%s'", e.name))
+	}
+	return []Value{s.eval(f, e)}
+}
+
+// evalList evaluates an expression list, the last expression expanding to
+// all of its values. With want >= 0 the result is adjusted to want values.
+func (s *State) evalList(f *frame, exprs []expr, want int) []Value {
+	var values []Value
+	if want >= 0 {
+		values = make([]Value, 0, max(want, len(exprs)))
+	}
+	for i, e := range exprs {
+		if i == len(exprs)-1 && isMultiValued(e) && (want < 0 || len(values) < want) {
+			values = append(values, s.evalMulti(f, e)...)
+		} else {
+			values = append(values, s.eval(f, e))
+		}
+	}
+	if want >= 0 {
+		for len(values) < want {
+			values = append(values, nil)
+		}
+		values = values[:want]
+	}
+	return values
+}
+
+func (s *State) table(f *frame, e *tableExpr) Value {
+	t := NewTable()
+	var list []Value
+	for i, item := range e.items {
+		if item.key == nil {
+			if i == len(e.items)-1 && isMultiValued(item.value) {
+				list = append(list, s.evalMulti(f, item.value)...)
+			} else {
+				list = append(list, s.eval(f, item.value))
+			}
+			continue
+		}
+		key := s.eval(f, item.key)
+		if err := t.Set(key, s.eval(f, item.value)); err != nil {
+			s.runtimeError(e.line, "%s", err)
+		}
+	}
+	t.setList(list)
+	return t
+}
+
+func (s *State) unary(f *frame, e *unaryExpr) Value {
+	v := s.eval(f, e.operand)
+	switch e.op {
+	case tokNot:
+		return !Truthy(v)
+	case charToken('-'):
+		if n, ok := ToNumber(v); ok {
+			return -n
+		}
+		s.runtimeError(e.line, "attempt to perform arithmetic on %s", describeValue(describe(e.operand), v))
+	case charToken('#'):
+		switch v := v.(type) {
+		case string:
+			return float64(len(v))
+		case *Table:
+			return float64(v.Len())
+		}
+		s.runtimeError(e.line, "attempt to get length of %s", describeValue(describe(e.operand), v))
+	}
+	return nil
+}
+
+func (s *State) binary(f *frame, e *binaryExpr) Value {
+	a := s.eval(f, e.left)
+	b := s.eval(f, e.right)
+
+	switch e.op {
+	case tokEq:
+		return rawEqual(a, b)
+	case tokNe:
+		return !rawEqual(a, b)
+	case charToken('<'):
+		return s.less(a, b, e.line)
+	case tokLe:
+		return !s.less(b, a, e.line)
+	case charToken('>'):
+		return s.less(b, a, e.line)
+	case tokGe:
+		return !s.less(a, b, e.line)
+	case tokConcat:
+		as, aok := ToString(a)
+		bs, bok := ToString(b)
+		if !aok || !bok {
+			culprit, desc := a, describe(e.left)
+			if aok {
+				culprit, desc = b, describe(e.right)
+			}
+			s.runtimeError(e.line, "attempt to concatenate %s", describeValue(desc, culprit))
+		}
+		return as + bs
+	}
+
+	x, xok := ToNumber(a)
+	y, yok := ToNumber(b)
+	if !xok || !yok {
+		culprit, desc := a, describe(e.left)
+		if xok {
+			culprit, desc = b, describe(e.right)
+		}
+		s.runtimeError(e.line, "attempt to perform arithmetic on %s", describeValue(desc, culprit))
+	}
+	return arith(e.op, x, y)
+}
+
+func arith(op tokenType, x, y float64) float64 {
+	switch op {
+	case charToken('+'):
+		return x + y
+	case charToken('-'):
+		return x - y
+	case charToken('*'):
+		return x * y
+	case charToken('/'):
+		return x / y
+	case charToken('%'):
+		return x - math.Floor(x/y)*y
+	case charToken('^'):
+		return math.Pow(x, y)
+	}
+	panic("lua: unknown arithmetic operator")
+}
+
+func (s *State) less(a, b Value, line int) bool {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return x < y
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y) < 0
+		}
+	}
+	if ta, tb := TypeName(a), TypeName(b); ta == tb {
+		s.runtimeError(line, "attempt to compare two %s values", ta)
+	} else {
+		s.runtimeError(line, "attempt to compare %s with %s", ta, tb)
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokName
+	tokNumber
+	tokString
+
+	// Keywords
+	tokAnd
+	tokBreak
+	tokDo
+	tokElse
+	tokElseif
+	tokEnd
+	tokFalse
+	tokFor
+	tokFunction
+	tokIf
+	tokIn
+	tokLocal
+	tokNil
+	tokNot
+	tokOr
+	tokRepeat
+	tokReturn
+	tokThen
+	tokTrue
+	tokUntil
+	tokWhile
+
+	// Symbols longer than one character, single characters are their byte
+	// value offset by tokChar
+	tokConcat // ..
+	tokDots   // ...
+	tokEq     // ==
+	tokGe     // >=
+	tokLe     // <=
+	tokNe     // ~=
+	tokChar
+)
+
+var keywords = map[string]tokenType{
+	"and": tokAnd, "break": tokBreak, "do": tokDo, "else": tokElse,
+	"elseif": tokElseif, "end": tokEnd, "false": tokFalse, "for": tokFor,
+	"function": tokFunction, "if": tokIf, "in": tokIn, "local": tokLocal,
+	"nil": tokNil, "not": tokNot, "or": tokOr, "repeat": tokRepeat,
+	"return": tokReturn, "then": tokThen, "true": tokTrue, "until": tokUntil,
+	"while": tokWhile,
+}
+
+func charToken(c byte) tokenType {
+	return tokChar + tokenType(c)
+}
+
+type token struct {
+	typ  tokenType
+	text string // names and strings, and the source text of anything else
+	num  float64
+	line int
+}
+
+type lexer struct {
+	src   string
+	pos   int
+	line  int
+	chunk string
+}
+
+// syntaxError is raised through a panic while parsing and recovered by
+// Compile
+type syntaxError struct {
+	msg string
+}
+
+func (l *lexer) error(near, format string, args ...interface{}) {
+	msg := fmt.Sprintf("%s:%d: %s", l.chunk, l.line, fmt.Sprintf(format, args...))
+	if near != "" {
+		msg += fmt.Sprintf(" near '%s'", near)
+	}
+	panic(&syntaxError{msg})
+}
+
+func (l *lexer) peekByte(offset int) byte {
+	if l.pos+offset < len(l.src) {
+		return l.src[l.pos+offset]
+	}
+	return 0
+}
+
+// next scans the following token
+func (l *lexer) next() token {
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.src) {
+			return token{typ: tokEOF, text: "<eof>", line: l.line}
+		}
+		if l.src[l.pos] == '-' && l.peekByte(1) == '-' {
+			l.skipComment()
+			continue
+		}
+		break
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case isAlpha(c):
+		for l.pos < len(l.src) && (isAlpha(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		name := l.src[start:l.pos]
+		if typ, isKeyword := keywords[name]; isKeyword {
+			return token{typ: typ, text: name, line: l.line}
+		}
+		return token{typ: tokName, text: name, line: l.line}
+
+	case isDigit(c) || c == '.' && isDigit(l.peekByte(1)):
+		return l.number()
+
+	case c == '"' || c == '\'':
+		return l.shortString(c)
+
+	case c == '[' && (l.peekByte(1) == '[' || l.peekByte(1) == '='):
+		if level := l.longBracketLevel(); level >= 0 {
+			line := l.line
+			return token{typ: tokString, text: l.longString(level), line: line}
+		}
+	}
+
+	l.pos++
+	two := l.src[start:min(start+2, len(l.src))]
+	switch {
+	case strings.HasPrefix(l.src[start:], "..."):
+		l.pos += 2
+		return token{typ: tokDots, text: "...", line: l.line}
+	case two == "..":
+		l.pos++
+		return token{typ: tokConcat, text: "..", line: l.line}
+	case two == "==":
+		l.pos++
+		return token{typ: tokEq, text: "==", line: l.line}
+	case two == ">=":
+		l.pos++
+		return token{typ: tokGe, text: ">=", line: l.line}
+	case two == "<=":
+		l.pos++
+		return token{typ: tokLe, text: "<=", line: l.line}
+	case two == "~=":
+		l.pos++
+		return token{typ: tokNe, text: "~=", line: l.line}
+	}
+	if strings.IndexByte("+-*/%^#<>=(){}[];:,.", c) < 0 {
+		l.error(string(c), "unexpected symbol")
+	}
+	return token{typ: charToken(c), text: string(c), line: l.line}
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\n':
+			l.line++
+		case ' ', '\t', '\r', '\f', '\v':
+		default:
+			return
+		}
+		l.pos++
+	}
+}
+
+func (l *lexer) skipComment() {
+	l.pos += 2
+	if l.peekByte(0) == '[' {
+		if level := l.longBracketLevel(); level >= 0 {
+			l.longString(level)
+			return
+		}
+	}
+	for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+		l.pos++
+	}
+}
+
+// longBracketLevel returns the number of '=' of the [==[ opening at pos,
+// -1 if there is none
+func (l *lexer) longBracketLevel() int {
+	i := l.pos + 1
+	for i < len(l.src) && l.src[i] == '=' {
+		i++
+	}
+	if i < len(l.src) && l.src[i] == '[' {
+		return i - l.pos - 1
+	}
+	return -1
+}
+
+func (l *lexer) longString(level int) string {
+	l.pos += level + 2
+	// A newline right after the opening bracket is skipped
+	if l.peekByte(0) == '\r' {
+		l.pos++
+	}
+	if l.peekByte(0) == '\n' {
+		l.line++
+		l.pos++
+	}
+
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(l.src[l.pos:], closing)
+	if end < 0 {
+		l.pos = len(l.src)
+		l.error("<eof>", "unfinished long string")
+	}
+	text := l.src[l.pos : l.pos+end]
+	l.line += strings.Count(text, "\n")
+	l.pos += end + len(closing)
+	return text
+}
+
+func (l *lexer) shortString(quote byte) token {
+	line := l.line
+	start := l.pos
+	l.pos++
+	var sb strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			l.error("<eof>", "unfinished string")
+		}
+		c := l.src[l.pos]
+		switch c {
+		case quote:
+			l.pos++
+			return token{typ: tokString, text: sb.String(), line: line}
+		case '\n':
+			l.error(l.src[start:l.pos], "unfinished string")
+		case '\\':
+			l.pos++
+			l.escape(&sb)
+			continue
+		}
+		sb.WriteByte(c)
+		l.pos++
+	}
+}
+
+func (l *lexer) escape(sb *strings.Builder) {
+	c := l.peekByte(0)
+	l.pos++
+	switch c {
+	case 'a':
+		sb.WriteByte('\a')
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case 'v':
+		sb.WriteByte('\v')
+	case '\n':
+		l.line++
+		sb.WriteByte('\n')
+	case '\\', '"', '\'':
+		sb.WriteByte(c)
+	default:
+		if !isDigit(c) {
+			if c == 0 {
+				l.error("<eof>", "unfinished string")
+			}
+			sb.WriteByte(c) // Lua 5.1 keeps unknown escapes as the character
+			return
+		}
+		// \ddd, up to three decimal digits
+		n := int(c - '0')
+		for i := 0; i < 2 && isDigit(l.peekByte(0)); i++ {
+			n = n*10 + int(l.peekByte(0)-'0')
+			l.pos++
+		}
+		if n > 255 {
+			l.error("", "escape sequence too large")
+		}
+		sb.WriteByte(byte(n))
+	}
+}
+
+func (l *lexer) number() token {
+	start := l.pos
+	if l.src[l.pos] == '0' && (l.peekByte(1) == 'x' || l.peekByte(1) == 'X') {
+		l.pos += 2
+	}
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if (c == '+' || c == '-') && (l.src[l.pos-1] == 'e' || l.src[l.pos-1] == 'E') &&
+			!strings.HasPrefix(l.src[start:], "0x") && !strings.HasPrefix(l.src[start:], "0X") {
+			l.pos++
+			continue
+		}
+		if !isAlpha(c) && !isDigit(c) && c != '.' {
+			break
+		}
+		l.pos++
+	}
+
+	text := l.src[start:l.pos]
+	n, ok := parseNumber(text)
+	if !ok {
+		l.error(text, "malformed number")
+	}
+	return token{typ: tokNumber, text: text, num: n, line: l.line}
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
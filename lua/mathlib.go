@@ -0,0 +1,109 @@
+package lua
+
+import (
+	"math"
+	"math/rand"
+)
+
+func openMath(s *State) {
+	lib := NewTable()
+	register := func(name string, fn func(s *State, args []Value) []Value) {
+		lib.Set(name, &GoFunction{Name: name, Fn: fn})
+	}
+	unary := func(name string, fn func(float64) float64) {
+		register(name, func(s *State, args []Value) []Value {
+			return []Value{fn(s.checkNumber(args, 1, name))}
+		})
+	}
+
+	lib.Set("pi", math.Pi)
+	lib.Set("huge", math.Inf(1))
+
+	unary("abs", math.Abs)
+	unary("ceil", math.Ceil)
+	unary("floor", math.Floor)
+	unary("sqrt", math.Sqrt)
+	unary("exp", math.Exp)
+	unary("log10", math.Log10)
+	unary("sin", math.Sin)
+	unary("cos", math.Cos)
+	unary("tan", math.Tan)
+	unary("asin", math.Asin)
+	unary("acos", math.Acos)
+	unary("atan", math.Atan)
+	unary("sinh", math.Sinh)
+	unary("cosh", math.Cosh)
+	unary("tanh", math.Tanh)
+	unary("deg", func(x float64) float64 { return x * 180 / math.Pi })
+	unary("rad", func(x float64) float64 { return x * math.Pi / 180 })
+
+	register("log", func(s *State, args []Value) []Value {
+		return []Value{math.Log(s.checkNumber(args, 1, "log"))}
+	})
+	register("pow", func(s *State, args []Value) []Value {
+		return []Value{math.Pow(s.checkNumber(args, 1, "pow"), s.checkNumber(args, 2, "pow"))}
+	})
+	register("atan2", func(s *State, args []Value) []Value {
+		return []Value{math.Atan2(s.checkNumber(args, 1, "atan2"), s.checkNumber(args, 2, "atan2"))}
+	})
+	register("fmod", func(s *State, args []Value) []Value {
+		return []Value{math.Mod(s.checkNumber(args, 1, "fmod"), s.checkNumber(args, 2, "fmod"))}
+	})
+	register("modf", func(s *State, args []Value) []Value {
+		integer, fraction := math.Modf(s.checkNumber(args, 1, "modf"))
+		return []Value{integer, fraction}
+	})
+	register("frexp", func(s *State, args []Value) []Value {
+		fraction, exp := math.Frexp(s.checkNumber(args, 1, "frexp"))
+		return []Value{fraction, float64(exp)}
+	})
+	register("ldexp", func(s *State, args []Value) []Value {
+		return []Value{math.Ldexp(s.checkNumber(args, 1, "ldexp"), s.checkInt(args, 2, "ldexp"))}
+	})
+	register("min", func(s *State, args []Value) []Value {
+		result := s.checkNumber(args, 1, "min")
+		for i := 2; i <= len(args); i++ {
+			result = math.Min(result, s.checkNumber(args, i, "min"))
+		}
+		return []Value{result}
+	})
+	register("max", func(s *State, args []Value) []Value {
+		result := s.checkNumber(args, 1, "max")
+		for i := 2; i <= len(args); i++ {
+			result = math.Max(result, s.checkNumber(args, i, "max"))
+		}
+		return []Value{result}
+	})
+
+	// The generator is seeded the same way for every state, so that a script
+	// does the same thing on the master and its replicas
+	random := rand.New(rand.NewSource(0))
+	register("random", func(s *State, args []Value) []Value {
+		r := random.Float64()
+		switch len(args) {
+		case 0:
+			return []Value{r}
+		case 1:
+			upper := s.checkInt(args, 1, "random")
+			if upper < 1 {
+				s.argError(1, "random", "interval is empty")
+			}
+			return []Value{math.Floor(r*float64(upper)) + 1}
+		case 2:
+			lower := s.checkInt(args, 1, "random")
+			upper := s.checkInt(args, 2, "random")
+			if lower > upper {
+				s.argError(2, "random", "interval is empty")
+			}
+			return []Value{math.Floor(r*float64(upper-lower+1)) + float64(lower)}
+		}
+		s.Error("wrong number of arguments")
+		return nil
+	})
+	register("randomseed", func(s *State, args []Value) []Value {
+		random.Seed(int64(s.checkInt(args, 1, "randomseed")))
+		return nil
+	})
+
+	s.Globals.Set("math", lib)
+}
@@ -0,0 +1,648 @@
+package lua
+
+import "fmt"
+
+type parser struct {
+	lex      *lexer
+	tok      token
+	ahead    token
+	hasAhead bool
+	fs       *funcState
+}
+
+// funcState tracks the scopes of the function being parsed
+type funcState struct {
+	parent  *funcState
+	proto   *funcProto
+	active  []*localVar // locals in scope, innermost last
+	upvals  map[string]int
+	inLoops int
+}
+
+// Compile parses a chunk. The chunk name prefixes error messages, the
+// result is a vararg function that can be called with State.Call.
+func Compile(source, chunk string) (fn *Function, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			syntaxErr, ok := r.(*syntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", syntaxErr.msg)
+		}
+	}()
+
+	p := &parser{lex: &lexer{src: source, line: 1, chunk: chunk}}
+	p.advance()
+
+	proto := &funcProto{name: "main chunk", line: 0, isVararg: true}
+	p.openFunction(proto)
+	proto.body = p.block()
+	if p.tok.typ != tokEOF {
+		p.errorNear("'<eof>' expected")
+	}
+	p.closeFunction()
+	return &Function{proto: proto}, nil
+}
+
+func (p *parser) advance() {
+	if p.hasAhead {
+		p.tok = p.ahead
+		p.hasAhead = false
+		return
+	}
+	p.tok = p.lex.next()
+}
+
+func (p *parser) peek() token {
+	if !p.hasAhead {
+		p.ahead = p.lex.next()
+		p.hasAhead = true
+	}
+	return p.ahead
+}
+
+func (p *parser) errorNear(msg string) {
+	p.lex.line = p.tok.line
+	p.lex.error(p.tok.text, "%s", msg)
+}
+
+func (p *parser) check(typ tokenType, what string) {
+	if p.tok.typ != typ {
+		p.errorNear(fmt.Sprintf("'%s' expected", what))
+	}
+}
+
+func (p *parser) expect(typ tokenType, what string) {
+	p.check(typ, what)
+	p.advance()
+}
+
+// expectMatch expects the token closing what opened at line
+func (p *parser) expectMatch(typ tokenType, what, opener string, line int) {
+	if p.tok.typ == typ {
+		p.advance()
+		return
+	}
+	if line == p.tok.line {
+		p.errorNear(fmt.Sprintf("'%s' expected", what))
+	}
+	p.errorNear(fmt.Sprintf("'%s' expected (to close '%s' at line %d)", what, opener, line))
+}
+
+func (p *parser) name() string {
+	p.check(tokName, "<name>")
+	name := p.tok.text
+	p.advance()
+	return name
+}
+
+func (p *parser) accept(typ tokenType) bool {
+	if p.tok.typ == typ {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+// Scopes
+
+func (p *parser) openFunction(proto *funcProto) {
+	proto.chunk = p.lex.chunk
+	p.fs = &funcState{parent: p.fs, proto: proto, upvals: make(map[string]int)}
+}
+
+func (p *parser) closeFunction() {
+	p.fs = p.fs.parent
+}
+
+// declareLocal creates a local, it comes into scope with activate
+func (p *parser) declareLocal(name string) *localVar {
+	return &localVar{name: name}
+}
+
+func (p *parser) activate(vars ...*localVar) {
+	fs := p.fs
+	for _, v := range vars {
+		v.slot = len(fs.active)
+		fs.active = append(fs.active, v)
+		if len(fs.active) > fs.proto.numSlots {
+			fs.proto.numSlots = len(fs.active)
+		}
+		if len(fs.active) > 200 {
+			p.errorNear("too many local variables (limit is 200)")
+		}
+	}
+}
+
+// scope returns a mark to pass to closeScope when the block ends
+func (p *parser) scope() int {
+	return len(p.fs.active)
+}
+
+func (p *parser) closeScope(mark int) {
+	p.fs.active = p.fs.active[:mark]
+}
+
+// resolve turns a name into a local, upvalue or global reference
+func (p *parser) resolve(name string, line int) expr {
+	if v := findLocal(p.fs, name); v != nil {
+		return &localExpr{v}
+	}
+	if index, found := p.findUpval(p.fs, name); found {
+		return &upvalExpr{index: index, name: name}
+	}
+	return &globalExpr{name: name, line: line}
+}
+
+func findLocal(fs *funcState, name string) *localVar {
+	for i := len(fs.active) - 1; i >= 0; i-- {
+		if fs.active[i].name == name {
+			return fs.active[i]
+		}
+	}
+	return nil
+}
+
+// findUpval returns the upvalue index of name in fs, adding it and the
+// upvalues of the enclosing functions it goes through when needed
+func (p *parser) findUpval(fs *funcState, name string) (int, bool) {
+	if index, exists := fs.upvals[name]; exists {
+		return index, true
+	}
+	if fs.parent == nil {
+		return 0, false
+	}
+
+	desc := upvalDesc{name: name}
+	if v := findLocal(fs.parent, name); v != nil {
+		v.captured = true
+		desc.fromLocal = true
+		desc.index = v.slot
+	} else if index, found := p.findUpval(fs.parent, name); found {
+		desc.index = index
+	} else {
+		return 0, false
+	}
+
+	index := len(fs.proto.upvals)
+	fs.proto.upvals = append(fs.proto.upvals, desc)
+	fs.upvals[name] = index
+	return index, true
+}
+
+// Statements
+
+func blockFollows(typ tokenType) bool {
+	switch typ {
+	case tokElse, tokElseif, tokEnd, tokUntil, tokEOF:
+		return true
+	}
+	return false
+}
+
+func (p *parser) block() *block {
+	mark := p.scope()
+	b := p.blockNoScope()
+	p.closeScope(mark)
+	return b
+}
+
+// blockNoScope parses statements in the current scope, repeat-until needs
+// the body's locals visible in its condition
+func (p *parser) blockNoScope() *block {
+	b := &block{}
+	for !blockFollows(p.tok.typ) {
+		if p.tok.typ == tokReturn {
+			b.stmts = append(b.stmts, p.returnStat())
+			break
+		}
+		if p.tok.typ == tokBreak {
+			if p.fs.inLoops == 0 {
+				p.errorNear("no loop to break")
+			}
+			p.advance()
+			p.accept(charToken(';'))
+			b.stmts = append(b.stmts, &breakStmt{})
+			break
+		}
+		if s := p.statement(); s != nil {
+			b.stmts = append(b.stmts, s)
+		}
+		p.accept(charToken(';'))
+	}
+	return b
+}
+
+func (p *parser) returnStat() stmt {
+	line := p.tok.line
+	p.advance()
+	s := &returnStmt{line: line}
+	if !blockFollows(p.tok.typ) && p.tok.typ != charToken(';') {
+		s.exprs = p.exprList()
+	}
+	p.accept(charToken(';'))
+	if !blockFollows(p.tok.typ) {
+		p.errorNear("'<eof>' expected")
+	}
+	return s
+}
+
+func (p *parser) statement() stmt {
+	line := p.tok.line
+	switch p.tok.typ {
+	case tokIf:
+		return p.ifStat(line)
+	case tokWhile:
+		p.advance()
+		cond := p.expr()
+		p.expect(tokDo, "do")
+		body := p.loopBody()
+		p.expectMatch(tokEnd, "end", "while", line)
+		return &whileStmt{cond: cond, body: body, line: line}
+	case tokDo:
+		p.advance()
+		body := p.block()
+		p.expectMatch(tokEnd, "end", "do", line)
+		return &doStmt{body: body}
+	case tokFor:
+		return p.forStat(line)
+	case tokRepeat:
+		p.advance()
+		mark := p.scope()
+		p.fs.inLoops++
+		body := p.blockNoScope()
+		p.fs.inLoops--
+		p.expectMatch(tokUntil, "until", "repeat", line)
+		cond := p.expr()
+		p.closeScope(mark)
+		return &repeatStmt{body: body, cond: cond, line: line}
+	case tokFunction:
+		return p.functionStat(line)
+	case tokLocal:
+		p.advance()
+		if p.accept(tokFunction) {
+			v := p.declareLocal(p.name())
+			p.activate(v) // in scope in its own body, for recursion
+			return &localFunctionStmt{v: v, fn: p.functionBody(v.name, line, false), line: line}
+		}
+		return p.localStat(line)
+	}
+	return p.exprStat(line)
+}
+
+func (p *parser) loopBody() *block {
+	p.fs.inLoops++
+	body := p.block()
+	p.fs.inLoops--
+	return body
+}
+
+func (p *parser) ifStat(line int) stmt {
+	s := &ifStmt{line: line}
+	p.advance()
+	s.conds = append(s.conds, p.expr())
+	p.expect(tokThen, "then")
+	s.blocks = append(s.blocks, p.block())
+	for p.tok.typ == tokElseif {
+		p.advance()
+		s.conds = append(s.conds, p.expr())
+		p.expect(tokThen, "then")
+		s.blocks = append(s.blocks, p.block())
+	}
+	if p.accept(tokElse) {
+		s.orElse = p.block()
+	}
+	p.expectMatch(tokEnd, "end", "if", line)
+	return s
+}
+
+func (p *parser) forStat(line int) stmt {
+	p.advance()
+	first := p.name()
+
+	if p.tok.typ == charToken('=') {
+		p.advance()
+		s := &numForStmt{line: line}
+		s.start = p.expr()
+		p.expect(charToken(','), ",")
+		s.limit = p.expr()
+		if p.accept(charToken(',')) {
+			s.step = p.expr()
+		}
+		p.expect(tokDo, "do")
+		mark := p.scope()
+		s.v = p.declareLocal(first)
+		p.activate(s.v)
+		s.body = p.loopBody()
+		p.closeScope(mark)
+		p.expectMatch(tokEnd, "end", "for", line)
+		return s
+	}
+
+	if p.tok.typ != charToken(',') && p.tok.typ != tokIn {
+		p.errorNear("'=' or 'in' expected")
+	}
+	names := []string{first}
+	for p.accept(charToken(',')) {
+		names = append(names, p.name())
+	}
+	p.expect(tokIn, "in")
+	s := &genForStmt{line: line}
+	s.exprs = p.exprList()
+	p.expect(tokDo, "do")
+	mark := p.scope()
+	for _, name := range names {
+		s.vars = append(s.vars, p.declareLocal(name))
+	}
+	p.activate(s.vars...)
+	s.body = p.loopBody()
+	p.closeScope(mark)
+	p.expectMatch(tokEnd, "end", "for", line)
+	return s
+}
+
+// functionStat is "function a.b.c:m() end", an assignment
+func (p *parser) functionStat(line int) stmt {
+	p.advance()
+	nameLine := p.tok.line
+	name := p.name()
+	fullName := name
+	var target expr = p.resolve(name, nameLine)
+	isMethod := false
+	for p.tok.typ == charToken('.') || p.tok.typ == charToken(':') {
+		isMethod = p.tok.typ == charToken(':')
+		p.advance()
+		field := p.name()
+		fullName += "." + field
+		target = &indexExpr{obj: target, key: &constExpr{field}, line: nameLine}
+		if isMethod {
+			break
+		}
+	}
+	fn := p.functionBody(fullName, line, isMethod)
+	return &assignStmt{targets: []expr{target}, exprs: []expr{&functionExpr{fn}}, line: line}
+}
+
+func (p *parser) localStat(line int) stmt {
+	s := &localStmt{line: line}
+	s.vars = append(s.vars, p.declareLocal(p.name()))
+	for p.accept(charToken(',')) {
+		s.vars = append(s.vars, p.declareLocal(p.name()))
+	}
+	if p.accept(charToken('=')) {
+		s.exprs = p.exprList()
+	}
+	// The values are evaluated before the new locals come into scope
+	p.activate(s.vars...)
+	return s
+}
+
+func (p *parser) exprStat(line int) stmt {
+	e := p.suffixedExpr()
+	if p.tok.typ == charToken('=') || p.tok.typ == charToken(',') {
+		targets := []expr{e}
+		for p.accept(charToken(',')) {
+			targets = append(targets, p.suffixedExpr())
+		}
+		for _, target := range targets {
+			switch target.(type) {
+			case *localExpr, *upvalExpr, *globalExpr, *indexExpr:
+			default:
+				p.errorNear("syntax error")
+			}
+		}
+		p.expect(charToken('='), "=")
+		return &assignStmt{targets: targets, exprs: p.exprList(), line: line}
+	}
+
+	switch e.(type) {
+	case *callExpr, *methodCallExpr:
+		return &callStmt{call: e, line: line}
+	}
+	p.errorNear("syntax error")
+	return nil
+}
+
+// functionBody parses parameters and body up to the closing end
+func (p *parser) functionBody(name string, line int, isMethod bool) *funcProto {
+	proto := &funcProto{name: name, line: line}
+	p.openFunction(proto)
+	if isMethod {
+		proto.params = append(proto.params, p.declareLocal("self"))
+	}
+
+	p.expect(charToken('('), "(")
+	if p.tok.typ != charToken(')') {
+		for {
+			if p.accept(tokDots) {
+				proto.isVararg = true
+				break
+			}
+			proto.params = append(proto.params, p.declareLocal(p.name()))
+			if !p.accept(charToken(',')) {
+				break
+			}
+		}
+	}
+	p.expect(charToken(')'), ")")
+	proto.numParams = len(proto.params)
+	p.activate(proto.params...)
+
+	proto.body = p.block()
+	p.expectMatch(tokEnd, "end", "function", line)
+	p.closeFunction()
+	return proto
+}
+
+// Expressions
+
+func (p *parser) exprList() []expr {
+	list := []expr{p.expr()}
+	for p.accept(charToken(',')) {
+		list = append(list, p.expr())
+	}
+	return list
+}
+
+func (p *parser) expr() expr {
+	return p.subExpr(0)
+}
+
+// binaryPriority returns the left and right priorities of a binary operator,
+// 0 when the token is not one
+func binaryPriority(typ tokenType) (int, int) {
+	switch typ {
+	case charToken('+'), charToken('-'):
+		return 6, 6
+	case charToken('*'), charToken('/'), charToken('%'):
+		return 7, 7
+	case charToken('^'):
+		return 10, 9 // right associative
+	case tokConcat:
+		return 5, 4 // right associative
+	case tokEq, tokNe, charToken('<'), tokLe, charToken('>'), tokGe:
+		return 3, 3
+	case tokAnd:
+		return 2, 2
+	case tokOr:
+		return 1, 1
+	}
+	return 0, 0
+}
+
+const unaryPriority = 8
+
+func (p *parser) subExpr(limit int) expr {
+	var e expr
+	switch p.tok.typ {
+	case tokNot, charToken('-'), charToken('#'):
+		op, line := p.tok.typ, p.tok.line
+		p.advance()
+		e = &unaryExpr{op: op, operand: p.subExpr(unaryPriority), line: line}
+	default:
+		e = p.simpleExpr()
+	}
+
+	for {
+		op := p.tok.typ
+		left, right := binaryPriority(op)
+		if left == 0 || left <= limit {
+			return e
+		}
+		line := p.tok.line
+		p.advance()
+		rhs := p.subExpr(right)
+		switch op {
+		case tokAnd:
+			e = &andExpr{e, rhs}
+		case tokOr:
+			e = &orExpr{e, rhs}
+		default:
+			e = &binaryExpr{op: op, left: e, right: rhs, line: line}
+		}
+	}
+}
+
+func (p *parser) simpleExpr() expr {
+	tok := p.tok
+	switch tok.typ {
+	case tokNumber:
+		p.advance()
+		return &constExpr{tok.num}
+	case tokString:
+		p.advance()
+		return &constExpr{tok.text}
+	case tokNil:
+		p.advance()
+		return &constExpr{nil}
+	case tokTrue:
+		p.advance()
+		return &constExpr{true}
+	case tokFalse:
+		p.advance()
+		return &constExpr{false}
+	case tokDots:
+		if !p.fs.proto.isVararg {
+			p.errorNear("cannot use '...' outside a vararg function")
+		}
+		p.advance()
+		return &varargExpr{}
+	case charToken('{'):
+		return p.tableConstructor()
+	case tokFunction:
+		p.advance()
+		return &functionExpr{p.functionBody("anonymous", tok.line, false)}
+	}
+	return p.suffixedExpr()
+}
+
+func (p *parser) primaryExpr() expr {
+	switch p.tok.typ {
+	case tokName:
+		line := p.tok.line
+		return p.resolve(p.name(), line)
+	case charToken('('):
+		line := p.tok.line
+		p.advance()
+		e := p.expr()
+		p.expectMatch(charToken(')'), ")", "(", line)
+		return &parenExpr{e}
+	}
+	p.errorNear("unexpected symbol")
+	return nil
+}
+
+func (p *parser) suffixedExpr() expr {
+	e := p.primaryExpr()
+	for {
+		line := p.tok.line
+		switch p.tok.typ {
+		case charToken('.'):
+			p.advance()
+			e = &indexExpr{obj: e, key: &constExpr{p.name()}, line: line}
+		case charToken('['):
+			p.advance()
+			key := p.expr()
+			p.expect(charToken(']'), "]")
+			e = &indexExpr{obj: e, key: key, line: line}
+		case charToken(':'):
+			p.advance()
+			name := p.name()
+			e = &methodCallExpr{obj: e, name: name, args: p.callArgs(), line: line}
+		case charToken('('), charToken('{'), tokString:
+			e = &callExpr{fn: e, args: p.callArgs(), line: line}
+		default:
+			return e
+		}
+	}
+}
+
+func (p *parser) callArgs() []expr {
+	switch p.tok.typ {
+	case tokString:
+		s := p.tok.text
+		p.advance()
+		return []expr{&constExpr{s}}
+	case charToken('{'):
+		return []expr{p.tableConstructor()}
+	case charToken('('):
+		line := p.tok.line
+		p.advance()
+		if p.accept(charToken(')')) {
+			return nil
+		}
+		args := p.exprList()
+		p.expectMatch(charToken(')'), ")", "(", line)
+		return args
+	}
+	p.errorNear("function arguments expected")
+	return nil
+}
+
+func (p *parser) tableConstructor() expr {
+	line := p.tok.line
+	p.expect(charToken('{'), "{")
+	t := &tableExpr{line: line}
+	for p.tok.typ != charToken('}') {
+		switch {
+		case p.tok.typ == tokName && p.peek().typ == charToken('='):
+			key := p.name()
+			p.advance()
+			t.items = append(t.items, tableItem{key: &constExpr{key}, value: p.expr()})
+		case p.tok.typ == charToken('['):
+			p.advance()
+			key := p.expr()
+			p.expect(charToken(']'), "]")
+			p.expect(charToken('='), "=")
+			t.items = append(t.items, tableItem{key: key, value: p.expr()})
+		default:
+			t.items = append(t.items, tableItem{value: p.expr()})
+		}
+		if !p.accept(charToken(',')) && !p.accept(charToken(';')) {
+			break
+		}
+	}
+	p.expectMatch(charToken('}'), "}", "{", line)
+	return t
+}
@@ -0,0 +1,383 @@
+package lua
+
+// Lua patterns, a port of the matcher in Lua 5.1's lstrlib.c. Positions
+// are byte offsets into the subject.
+
+const (
+	maxCaptures     = 32
+	capUnfinished   = -1
+	capPosition     = -2
+	maxMatchRecurse = 200
+)
+
+type capture struct {
+	init int
+	len  int
+}
+
+type matchState struct {
+	s       *State
+	src     string
+	pattern string
+	level   int
+	capture [maxCaptures]capture
+	depth   int
+}
+
+func (ms *matchState) error(format string, args ...interface{}) {
+	ms.s.Error(format, args...)
+}
+
+func (ms *matchState) checkCapture(l byte) int {
+	i := int(l) - '1'
+	if i < 0 || i >= ms.level || ms.capture[i].len == capUnfinished {
+		ms.error("invalid capture index")
+	}
+	return i
+}
+
+func (ms *matchState) captureToClose() int {
+	for level := ms.level - 1; level >= 0; level-- {
+		if ms.capture[level].len == capUnfinished {
+			return level
+		}
+	}
+	ms.error("invalid pattern capture")
+	return 0
+}
+
+// classEnd returns the position after the single character class at p
+func (ms *matchState) classEnd(p int) int {
+	pat := ms.pattern
+	c := pat[p]
+	p++
+	switch c {
+	case '%':
+		if p >= len(pat) {
+			ms.error("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if p < len(pat) && pat[p] == '^' {
+			p++
+		}
+		for { // look for a ']'
+			if p >= len(pat) {
+				ms.error("malformed pattern (missing ']')")
+			}
+			c := pat[p]
+			p++
+			if c == '%' && p < len(pat) {
+				p++ // skip escapes (e.g. '%]')
+			}
+			if p < len(pat) && pat[p] == ']' {
+				return p + 1
+			}
+			if p >= len(pat) {
+				ms.error("malformed pattern (missing ']')")
+			}
+		}
+	}
+	return p
+}
+
+func isLower(c byte) bool  { return c >= 'a' && c <= 'z' }
+func isUpper(c byte) bool  { return c >= 'A' && c <= 'Z' }
+func isLetter(c byte) bool { return isLower(c) || isUpper(c) }
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+func isCntrl(c byte) bool { return c < 32 || c == 127 }
+func isPunct(c byte) bool {
+	return c > 32 && c < 127 && !isLetter(c) && !isDigit(c)
+}
+func isXDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func singleClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 {
+	case 'a':
+		res = isLetter(c)
+	case 'c':
+		res = isCntrl(c)
+	case 'd':
+		res = isDigit(c)
+	case 'l':
+		res = isLower(c)
+	case 'p':
+		res = isPunct(c)
+	case 's':
+		res = isSpace(c)
+	case 'u':
+		res = isUpper(c)
+	case 'w':
+		res = isLetter(c) || isDigit(c)
+	case 'x':
+		res = isXDigit(c)
+	case 'z':
+		res = c == 0
+	default:
+		return cl == c
+	}
+	if isUpper(cl) {
+		return !res
+	}
+	return res
+}
+
+// matchBracketClass matches c against the set from p ('[') to ec (']')
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	pat := ms.pattern
+	sig := true
+	if pat[p+1] == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < ec; p++ {
+		if pat[p] == '%' {
+			p++
+			if singleClass(c, pat[p]) {
+				return sig
+			}
+		} else if pat[p+1] == '-' && p+2 < ec {
+			p += 2
+			if pat[p-2] <= c && c <= pat[p] {
+				return sig
+			}
+		} else if pat[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pattern[p] {
+	case '.':
+		return true
+	case '%':
+		return singleClass(c, ms.pattern[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	}
+	return ms.pattern[p] == c
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pattern) {
+		ms.error("unbalanced pattern")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pattern[p] {
+		return -1
+	}
+	b, e := ms.pattern[p], ms.pattern[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		if ms.src[s] == e {
+			cont--
+			if cont == 0 {
+				return s + 1
+			}
+		} else if ms.src[s] == b {
+			cont++
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- { // try with maximum repetitions
+		if res := ms.match(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.match(s, ep+1); res != -1 {
+			return res
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= maxCaptures {
+		ms.error("too many captures")
+	}
+	ms.capture[ms.level] = capture{init: s, len: what}
+	ms.level++
+	res := ms.match(s, p)
+	if res == -1 {
+		ms.level-- // undo capture
+	}
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init
+	res := ms.match(s, p)
+	if res == -1 {
+		ms.capture[l].len = capUnfinished
+	}
+	return res
+}
+
+func (ms *matchState) matchCapture(s int, l byte) int {
+	i := ms.checkCapture(l)
+	captured := ms.src[ms.capture[i].init : ms.capture[i].init+ms.capture[i].len]
+	if len(ms.src)-s >= len(captured) && ms.src[s:s+len(captured)] == captured {
+		return s + len(captured)
+	}
+	return -1
+}
+
+// match returns the end of the match of the pattern from p against the
+// subject from s, -1 if it does not match
+func (ms *matchState) match(s, p int) int {
+	ms.depth++
+	if ms.depth > maxMatchRecurse {
+		ms.error("pattern too complex")
+	}
+	defer func() { ms.depth-- }()
+
+	pat := ms.pattern
+	for {
+		if p >= len(pat) {
+			return s // end of pattern
+		}
+		switch pat[p] {
+		case '(':
+			if p+1 < len(pat) && pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(pat) {
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			if p+1 < len(pat) {
+				switch pat[p+1] {
+				case 'b':
+					s = ms.matchBalance(s, p+2)
+					if s == -1 {
+						return -1
+					}
+					p += 4
+					continue
+				case 'f':
+					p += 2
+					if p >= len(pat) || pat[p] != '[' {
+						ms.error("missing '[' after '%%f' in pattern")
+					}
+					ep := ms.classEnd(p)
+					var prev, cur byte
+					if s > 0 {
+						prev = ms.src[s-1]
+					}
+					if s < len(ms.src) {
+						cur = ms.src[s]
+					}
+					if !ms.matchBracketClass(prev, p, ep-1) && ms.matchBracketClass(cur, p, ep-1) {
+						p = ep
+						continue
+					}
+					return -1
+				}
+				if isDigit(pat[p+1]) {
+					s = ms.matchCapture(s, pat[p+1])
+					if s == -1 {
+						return -1
+					}
+					p += 2
+					continue
+				}
+			}
+		}
+
+		// default: a single character class with an optional repetition
+		ep := ms.classEnd(p)
+		m := ms.singleMatch(s, p, ep)
+		if ep < len(pat) {
+			switch pat[ep] {
+			case '?':
+				if m {
+					if res := ms.match(s+1, ep+1); res != -1 {
+						return res
+					}
+				}
+				p = ep + 1
+				continue
+			case '*':
+				return ms.maxExpand(s, p, ep)
+			case '+':
+				if !m {
+					return -1
+				}
+				return ms.maxExpand(s+1, p, ep)
+			case '-':
+				return ms.minExpand(s, p, ep)
+			}
+		}
+		if !m {
+			return -1
+		}
+		s++
+		p = ep
+	}
+}
+
+// getCapture returns capture i, or the whole match when the pattern has
+// no captures
+func (ms *matchState) getCapture(i, s, e int) Value {
+	if i >= ms.level {
+		if i == 0 {
+			return ms.src[s:e]
+		}
+		ms.error("invalid capture index")
+	}
+	c := ms.capture[i]
+	switch c.len {
+	case capUnfinished:
+		ms.error("unfinished capture")
+	case capPosition:
+		return float64(c.init + 1)
+	}
+	return ms.src[c.init : c.init+c.len]
+}
+
+// captures returns all the captures of a match, the whole match if there
+// are none
+func (ms *matchState) captures(s, e int, wholeIfNone bool) []Value {
+	n := ms.level
+	if n == 0 && wholeIfNone {
+		n = 1
+	}
+	values := make([]Value, n)
+	for i := range values {
+		values[i] = ms.getCapture(i, s, e)
+	}
+	return values
+}
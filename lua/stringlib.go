@@ -0,0 +1,363 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+)
+
+// specials are the characters that make find() use patterns
+const specials = "^$*+?.([%-"
+
+func openString(s *State) {
+	lib := NewTable()
+	register := func(name string, fn func(s *State, args []Value) []Value) {
+		lib.Set(name, &GoFunction{Name: name, Fn: fn})
+	}
+
+	register("len", func(s *State, args []Value) []Value {
+		return []Value{float64(len(s.checkString(args, 1, "len")))}
+	})
+	register("lower", func(s *State, args []Value) []Value {
+		return []Value{strings.Map(asciiLower, s.checkString(args, 1, "lower"))}
+	})
+	register("upper", func(s *State, args []Value) []Value {
+		return []Value{strings.Map(asciiUpper, s.checkString(args, 1, "upper"))}
+	})
+	register("rep", func(s *State, args []Value) []Value {
+		str := s.checkString(args, 1, "rep")
+		n := s.checkInt(args, 2, "rep")
+		if n <= 0 || str == "" {
+			return []Value{""}
+		}
+		if len(str)*n/n != len(str) || len(str)*n > 512*1024*1024 {
+			s.Error("resulting string too large")
+		}
+		return []Value{strings.Repeat(str, n)}
+	})
+	register("reverse", func(s *State, args []Value) []Value {
+		str := []byte(s.checkString(args, 1, "reverse"))
+		for i, j := 0, len(str)-1; i < j; i, j = i+1, j-1 {
+			str[i], str[j] = str[j], str[i]
+		}
+		return []Value{string(str)}
+	})
+	register("sub", func(s *State, args []Value) []Value {
+		str := s.checkString(args, 1, "sub")
+		start, end := stringRange(len(str), s.optInt(args, 2, "sub", 1), s.optInt(args, 3, "sub", -1))
+		if start > end {
+			return []Value{""}
+		}
+		return []Value{str[start-1 : end]}
+	})
+	register("byte", func(s *State, args []Value) []Value {
+		str := s.checkString(args, 1, "byte")
+		i := s.optInt(args, 2, "byte", 1)
+		start, end := stringRange(len(str), i, s.optInt(args, 3, "byte", i))
+		var values []Value
+		for k := start; k <= end; k++ {
+			values = append(values, float64(str[k-1]))
+		}
+		return values
+	})
+	register("char", func(s *State, args []Value) []Value {
+		buf := make([]byte, len(args))
+		for i := range args {
+			c := s.checkInt(args, i+1, "char")
+			if c < 0 || c > 255 {
+				s.argError(i+1, "char", "invalid value")
+			}
+			buf[i] = byte(c)
+		}
+		return []Value{string(buf)}
+	})
+	register("format", stringFormat)
+	register("find", func(s *State, args []Value) []Value {
+		return stringFind(s, args, true)
+	})
+	register("match", func(s *State, args []Value) []Value {
+		return stringFind(s, args, false)
+	})
+	register("gmatch", stringGmatch)
+	register("gsub", stringGsub)
+
+	s.Globals.Set("string", lib)
+	s.stringLib = lib
+}
+
+func asciiLower(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
+
+func asciiUpper(r rune) rune {
+	if r >= 'a' && r <= 'z' {
+		return r - 'a' + 'A'
+	}
+	return r
+}
+
+// stringRange turns Lua's 1-based, possibly negative, inclusive bounds into
+// clamped 1-based ones
+func stringRange(length, start, end int) (int, int) {
+	if start < 0 {
+		start = max(length+start+1, 1)
+	} else if start == 0 {
+		start = 1
+	}
+	if end < 0 {
+		end = length + end + 1
+	} else if end > length {
+		end = length
+	}
+	return start, end
+}
+
+// stringFind is string.find, or string.match when find is false
+func stringFind(s *State, args []Value, find bool) []Value {
+	fname := "match"
+	if find {
+		fname = "find"
+	}
+	str := s.checkString(args, 1, fname)
+	pattern := s.checkString(args, 2, fname)
+	init := s.optInt(args, 3, fname, 1)
+	if init < 0 {
+		init = max(len(str)+init+1, 1)
+	} else if init == 0 {
+		init = 1
+	}
+	if init > len(str)+1 {
+		return []Value{nil}
+	}
+
+	if find && (Truthy(arg(args, 4)) || !strings.ContainsAny(pattern, specials)) {
+		// A plain search
+		if i := strings.Index(str[init-1:], pattern); i >= 0 {
+			return []Value{float64(init + i), float64(init + i + len(pattern) - 1)}
+		}
+		return []Value{nil}
+	}
+
+	ms := &matchState{s: s, src: str, pattern: pattern}
+	p := 0
+	anchor := len(pattern) > 0 && pattern[0] == '^'
+	if anchor {
+		p = 1
+	}
+	for start := init - 1; ; start++ {
+		ms.level = 0
+		if end := ms.match(start, p); end != -1 {
+			if find {
+				return append([]Value{float64(start + 1), float64(end)}, ms.captures(start, end, false)...)
+			}
+			return ms.captures(start, end, true)
+		}
+		if anchor || start >= len(str) {
+			return []Value{nil}
+		}
+	}
+}
+
+func stringGmatch(s *State, args []Value) []Value {
+	str := s.checkString(args, 1, "gmatch")
+	pattern := s.checkString(args, 2, "gmatch")
+	position := 0
+
+	iterator := &GoFunction{Name: "gmatch_iterator", Fn: func(s *State, _ []Value) []Value {
+		ms := &matchState{s: s, src: str, pattern: pattern}
+		for ; position <= len(str); position++ {
+			ms.level = 0
+			if end := ms.match(position, 0); end != -1 {
+				start := position
+				if end == start {
+					position = end + 1 // empty match, go at least one step
+				} else {
+					position = end
+				}
+				return ms.captures(start, end, true)
+			}
+		}
+		return []Value{nil}
+	}}
+	return []Value{iterator}
+}
+
+func stringGsub(s *State, args []Value) []Value {
+	str := s.checkString(args, 1, "gsub")
+	pattern := s.checkString(args, 2, "gsub")
+	repl := arg(args, 3)
+	switch repl.(type) {
+	case float64, string, *Table, *Function, *GoFunction:
+	default:
+		s.argError(3, "gsub", "string/function/table expected")
+	}
+	maxN := s.optInt(args, 4, "gsub", len(str)+1)
+
+	p := 0
+	anchor := len(pattern) > 0 && pattern[0] == '^'
+	if anchor {
+		p = 1
+	}
+
+	ms := &matchState{s: s, src: str, pattern: pattern}
+	var out strings.Builder
+	position, n := 0, 0
+	for n < maxN {
+		ms.level = 0
+		end := ms.match(position, p)
+		if end != -1 {
+			n++
+			out.WriteString(gsubValue(ms, position, end, repl))
+		}
+		switch {
+		case end != -1 && end > position:
+			position = end
+		case position < len(str):
+			out.WriteByte(str[position])
+			position++
+		default:
+			position = len(str) + 1
+		}
+		if position > len(str) || anchor {
+			break
+		}
+	}
+	if position <= len(str) {
+		out.WriteString(str[position:])
+	}
+	return []Value{out.String(), float64(n)}
+}
+
+// gsubValue returns the replacement of one match
+func gsubValue(ms *matchState, start, end int, repl Value) string {
+	s := ms.s
+	whole := ms.src[start:end]
+
+	var value Value
+	switch r := repl.(type) {
+	case float64, string:
+		template, _ := ToString(r)
+		var sb strings.Builder
+		for i := 0; i < len(template); i++ {
+			c := template[i]
+			if c != '%' || i+1 == len(template) {
+				sb.WriteByte(c)
+				continue
+			}
+			i++
+			switch d := template[i]; {
+			case d == '0':
+				sb.WriteString(whole)
+			case isDigit(d):
+				capture, _ := ToString(ms.getCapture(int(d-'1'), start, end))
+				sb.WriteString(capture)
+			default:
+				sb.WriteByte(d)
+			}
+		}
+		return sb.String()
+	case *Table:
+		value = r.Get(ms.getCapture(0, start, end))
+	default:
+		results := s.call(r, ms.captures(start, end, true), s.line, "")
+		if len(results) > 0 {
+			value = results[0]
+		}
+	}
+
+	if !Truthy(value) {
+		return whole // keep the original text
+	}
+	str, ok := ToString(value)
+	if !ok {
+		s.Error("invalid replacement value (a %s)", TypeName(value))
+	}
+	return str
+}
+
+func stringFormat(s *State, args []Value) []Value {
+	format := s.checkString(args, 1, "format")
+	var out strings.Builder
+	n := 1
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			out.WriteByte(c)
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			out.WriteByte('%')
+			continue
+		}
+
+		// Flags, width and precision are passed on to fmt, which reads them
+		// like C does
+		spec := i
+		for i < len(format) && strings.IndexByte("-+ #0", format[i]) >= 0 {
+			i++
+		}
+		for i < len(format) && isDigit(format[i]) {
+			i++
+		}
+		if i < len(format) && format[i] == '.' {
+			i++
+			for i < len(format) && isDigit(format[i]) {
+				i++
+			}
+		}
+		if i >= len(format) {
+			s.Error("invalid option '%%' to 'format'")
+		}
+		flags := format[spec:i]
+		verb := format[i]
+		n++
+
+		switch verb {
+		case 'd', 'i':
+			fmt.Fprintf(&out, "%"+flags+"d", int64(s.checkNumber(args, n, "format")))
+		case 'c':
+			out.WriteByte(byte(s.checkInt(args, n, "format")))
+		case 'o', 'x', 'X':
+			fmt.Fprintf(&out, "%"+flags+string(verb), uint64(int64(s.checkNumber(args, n, "format"))))
+		case 'u':
+			fmt.Fprintf(&out, "%"+flags+"d", uint64(int64(s.checkNumber(args, n, "format"))))
+		case 'e', 'E', 'f', 'g', 'G':
+			fmt.Fprintf(&out, "%"+flags+string(verb), s.checkNumber(args, n, "format"))
+		case 'q':
+			out.WriteString(quoteString(s.checkString(args, n, "format")))
+		case 's':
+			str, ok := ToString(s.checkAny(args, n, "format"))
+			if !ok {
+				str = tostring(args[n-1])
+			}
+			fmt.Fprintf(&out, "%"+flags+"s", str)
+		default:
+			s.Error("invalid option '%%%c' to 'format'", verb)
+		}
+	}
+	return []Value{out.String()}
+}
+
+// quoteString is %q: a string Lua can read back
+func quoteString(str string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '"', '\\', '\n':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\r':
+			sb.WriteString("\\r")
+		case 0:
+			sb.WriteString("\\000")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,111 @@
+package lua
+
+import (
+	"sort"
+	"strings"
+)
+
+func openTable(s *State) {
+	lib := NewTable()
+	register := func(name string, fn func(s *State, args []Value) []Value) {
+		lib.Set(name, &GoFunction{Name: name, Fn: fn})
+	}
+
+	register("getn", func(s *State, args []Value) []Value {
+		return []Value{float64(s.checkTable(args, 1, "getn").Len())}
+	})
+
+	register("insert", func(s *State, args []Value) []Value {
+		t := s.checkWritableTable(args, 1, "insert")
+		n := t.Len()
+		switch len(args) {
+		case 2:
+			t.Set(float64(n+1), args[1])
+		case 3:
+			pos := s.checkInt(args, 2, "insert")
+			for i := n; i >= pos; i-- { // move up elements
+				t.Set(float64(i+1), t.Get(float64(i)))
+			}
+			if err := t.Set(float64(pos), args[2]); err != nil {
+				s.Error("%s", err)
+			}
+		default:
+			s.Error("wrong number of arguments to 'insert'")
+		}
+		return nil
+	})
+
+	register("remove", func(s *State, args []Value) []Value {
+		t := s.checkWritableTable(args, 1, "remove")
+		n := t.Len()
+		pos := s.optInt(args, 2, "remove", n)
+		if n == 0 {
+			return nil
+		}
+		removed := t.Get(float64(pos))
+		for i := pos; i < n; i++ {
+			t.Set(float64(i), t.Get(float64(i+1)))
+		}
+		t.Set(float64(n), nil)
+		return []Value{removed}
+	})
+
+	register("concat", func(s *State, args []Value) []Value {
+		t := s.checkTable(args, 1, "concat")
+		sep := s.optString(args, 2, "concat", "")
+		i := s.optInt(args, 3, "concat", 1)
+		j := s.optInt(args, 4, "concat", t.Len())
+		var sb strings.Builder
+		for k := i; k <= j; k++ {
+			str, ok := ToString(t.Get(float64(k)))
+			if !ok {
+				s.Error("invalid value (at index %d) in table for 'concat'", k)
+			}
+			sb.WriteString(str)
+			if k < j {
+				sb.WriteString(sep)
+			}
+		}
+		return []Value{sb.String()}
+	})
+
+	register("sort", func(s *State, args []Value) []Value {
+		t := s.checkWritableTable(args, 1, "sort")
+		comp := arg(args, 2)
+		if comp != nil {
+			switch comp.(type) {
+			case *Function, *GoFunction:
+			default:
+				s.typeError(args, 2, "sort", "function")
+			}
+		}
+
+		n := t.Len()
+		values := make([]Value, n)
+		for i := range values {
+			values[i] = t.Get(float64(i + 1))
+		}
+		line := s.line
+		sort.SliceStable(values, func(i, j int) bool {
+			if comp == nil {
+				return s.less(values[i], values[j], line)
+			}
+			results := s.call(comp, []Value{values[i], values[j]}, line, "")
+			return len(results) > 0 && Truthy(results[0])
+		})
+		for i, v := range values {
+			t.Set(float64(i+1), v)
+		}
+		return nil
+	})
+
+	s.Globals.Set("table", lib)
+}
+
+func (s *State) checkWritableTable(args []Value, n int, fname string) *Table {
+	t := s.checkTable(args, n, fname)
+	if t.readonly {
+		s.Error("Attempt to modify a readonly table")
+	}
+	return t
+}
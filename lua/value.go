@@ -0,0 +1,385 @@
+// Package lua is a small interpreter for the Lua 5.1 language, enough to run
+// the scripts Redis users write: locals and closures, tables, varargs,
+// multiple returns, pcall/error, and the base, string, table and math
+// libraries. Metatables, coroutines and the io/os libraries are left out.
+//
+// Values are plain Go values: nil, bool, float64, string, *Table, *Function
+// and *GoFunction.
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Value interface{}
+
+// GoFunction is a function implemented in Go. It receives its arguments
+// and returns its results, errors are raised with State.Error.
+type GoFunction struct {
+	Name string
+	Fn   func(s *State, args []Value) []Value
+}
+
+// TypeName returns the Lua type of v as type() does
+func TypeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Function, *GoFunction:
+		return "function"
+	}
+	return "userdata"
+}
+
+// Truthy reports whether v counts as true in a condition: anything but
+// nil and false
+func Truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// FormatNumber formats a number like Lua 5.1 does, with "%.14g"
+func FormatNumber(n float64) string {
+	switch {
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case math.IsNaN(n):
+		return "nan"
+	case n == math.Trunc(n) && math.Abs(n) < 1e15:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// ToString converts numbers and strings to a string, the only values Lua
+// coerces implicitly
+func ToString(v Value) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return FormatNumber(v), true
+	}
+	return "", false
+}
+
+// ToNumber converts numbers and numeric strings to a number
+func ToNumber(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		return parseNumber(v)
+	}
+	return 0, false
+}
+
+// parseNumber parses the numbers Lua accepts in source and tonumber():
+// decimal with optional fraction and exponent, or hexadecimal integers,
+// surrounded by optional whitespace
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+
+	body := strings.TrimLeft(s, "+-")
+	if len(s)-len(body) > 1 {
+		return 0, false
+	}
+	if len(body) > 2 && body[0] == '0' && (body[1] == 'x' || body[1] == 'X') {
+		n, err := strconv.ParseUint(body[2:], 16, 64)
+		if err != nil {
+			return 0, false
+		}
+		if s[0] == '-' {
+			return -float64(n), true
+		}
+		return float64(n), true
+	}
+
+	// strconv accepts more than Lua does: "inf", "nan", "_" and hex floats
+	for _, c := range body {
+		if !(c >= '0' && c <= '9' || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-') {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil && !isRangeError(err) {
+		return 0, false
+	}
+	return n, true
+}
+
+func isRangeError(err error) bool {
+	numErr, ok := err.(*strconv.NumError)
+	return ok && numErr.Err == strconv.ErrRange
+}
+
+// tostring is what tostring() and print() show for any value
+func tostring(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case float64:
+		return FormatNumber(v)
+	case string:
+		return v
+	case *Table:
+		return fmt.Sprintf("table: %p", v)
+	case *Function:
+		return fmt.Sprintf("function: %p", v)
+	case *GoFunction:
+		return fmt.Sprintf("function: builtin: %p", v)
+	}
+	return fmt.Sprintf("userdata: %p", v)
+}
+
+// rawEqual compares like == does without metatables
+func rawEqual(a, b Value) bool {
+	return a == b
+}
+
+// Table is a Lua table. Keys 1..n live in an array, everything else in an
+// insertion-ordered hash so that next() can walk it while fields are
+// cleared, as Lua allows.
+type Table struct {
+	array []Value
+
+	index   map[Value]int // key -> position in entries
+	entries []tableEntry
+	holes   int // entries whose value was set to nil
+
+	readonly bool
+}
+
+type tableEntry struct {
+	key   Value
+	value Value
+}
+
+func NewTable() *Table {
+	return &Table{}
+}
+
+// normalizeKey makes 1.0 and 1 the same key and rejects nil and NaN
+func normalizeKey(key Value) (Value, error) {
+	switch k := key.(type) {
+	case nil:
+		return nil, fmt.Errorf("table index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			return nil, fmt.Errorf("table index is NaN")
+		}
+		if k == 0 {
+			return float64(0), nil // -0 and 0 are one key
+		}
+	}
+	return key, nil
+}
+
+// arrayIndex returns the 0-based array position of key, -1 if it is not
+// a positive integer
+func arrayIndex(key Value) int {
+	n, ok := key.(float64)
+	if !ok || n < 1 || n != math.Trunc(n) || n > math.MaxInt32 {
+		return -1
+	}
+	return int(n) - 1
+}
+
+// Get returns t[key], nil when missing
+func (t *Table) Get(key Value) Value {
+	if i := arrayIndex(key); i >= 0 && i < len(t.array) {
+		return t.array[i]
+	}
+	if t.index == nil {
+		return nil
+	}
+	if key, err := normalizeKey(key); err == nil {
+		if pos, exists := t.index[key]; exists {
+			return t.entries[pos].value
+		}
+	}
+	return nil
+}
+
+// GetString is Get for a string key
+func (t *Table) GetString(key string) Value {
+	return t.Get(key)
+}
+
+// Set assigns t[key] = value, nil removing the key
+func (t *Table) Set(key, value Value) error {
+	key, err := normalizeKey(key)
+	if err != nil {
+		return err
+	}
+
+	if i := arrayIndex(key); i >= 0 {
+		switch {
+		case i < len(t.array):
+			// Cleared slots stay, next() may still be walking past them
+			t.array[i] = value
+			return nil
+		case i == len(t.array) && value != nil:
+			t.array = append(t.array, value)
+			t.deleteHashed(key)
+			t.migrateToArray()
+			return nil
+		}
+	}
+
+	if pos, exists := t.index[key]; exists {
+		if t.entries[pos].value == nil && value != nil {
+			t.holes--
+		} else if t.entries[pos].value != nil && value == nil {
+			t.holes++
+		}
+		t.entries[pos].value = value
+		return nil
+	}
+	if value == nil {
+		return nil
+	}
+
+	if t.index == nil {
+		t.index = make(map[Value]int)
+	}
+	// New keys may drop the holes, next() is undefined across them anyway
+	if t.holes > 16 && t.holes*2 > len(t.entries) {
+		t.compact()
+	}
+	t.index[key] = len(t.entries)
+	t.entries = append(t.entries, tableEntry{key, value})
+	return nil
+}
+
+// migrateToArray moves keys that now extend the array out of the hash
+func (t *Table) migrateToArray() {
+	for t.index != nil {
+		next := float64(len(t.array) + 1)
+		pos, exists := t.index[next]
+		if !exists || t.entries[pos].value == nil {
+			return
+		}
+		t.array = append(t.array, t.entries[pos].value)
+		t.deleteHashed(next)
+	}
+}
+
+func (t *Table) deleteHashed(key Value) {
+	if pos, exists := t.index[key]; exists && t.entries[pos].value != nil {
+		t.entries[pos].value = nil
+		t.holes++
+	}
+}
+
+func (t *Table) compact() {
+	entries := t.entries[:0]
+	for _, entry := range t.entries {
+		if entry.value != nil {
+			t.index[entry.key] = len(entries)
+			entries = append(entries, entry)
+		} else {
+			delete(t.index, entry.key)
+		}
+	}
+	for i := len(entries); i < len(t.entries); i++ {
+		t.entries[i] = tableEntry{}
+	}
+	t.entries = entries
+	t.holes = 0
+}
+
+// Len is the # operator: a border of the table, the last non-nil slot of
+// the array part
+func (t *Table) Len() int {
+	n := len(t.array)
+	for n > 0 && t.array[n-1] == nil {
+		n--
+	}
+	return n
+}
+
+// Append sets t[#t+1] = value
+func (t *Table) Append(value Value) {
+	t.Set(float64(t.Len()+1), value)
+}
+
+// setList stores the positional items of a constructor at 1..n, nils
+// included, like Lua does, so {1, nil, 3} has a length of 3
+func (t *Table) setList(values []Value) {
+	for i, value := range values {
+		if i < len(t.array) {
+			t.array[i] = value
+			continue
+		}
+		t.array = append(t.array, value)
+		t.deleteHashed(float64(i + 1))
+	}
+	t.migrateToArray()
+}
+
+// SetReadonly makes scripts fail to assign to the table, like the
+// libraries and globals Redis protects
+func (t *Table) SetReadonly(readonly bool) {
+	t.readonly = readonly
+}
+
+// Next returns the key and value after key in traversal order, the array
+// first then the hash, with a nil key once the end is reached. ok is false
+// when key is not in the table.
+func (t *Table) Next(key Value) (Value, Value, bool) {
+	start := 0
+	if key != nil {
+		if i := arrayIndex(key); i >= 0 && i < len(t.array) {
+			start = i + 1
+		} else {
+			k, err := normalizeKey(key)
+			if err != nil {
+				return nil, nil, false
+			}
+			pos, exists := t.index[k]
+			if !exists {
+				return nil, nil, false
+			}
+			start = len(t.array) + pos + 1
+		}
+	}
+
+	for i := start; i < len(t.array); i++ {
+		if t.array[i] != nil {
+			return float64(i + 1), t.array[i], true
+		}
+	}
+	for pos := max(start-len(t.array), 0); pos < len(t.entries); pos++ {
+		if entry := t.entries[pos]; entry.value != nil {
+			return entry.key, entry.value, true
+		}
+	}
+	return nil, nil, true
+}
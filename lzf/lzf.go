@@ -0,0 +1,173 @@
+// Package lzf implements the LZF compression format used by Redis for
+// compressed quicklist nodes and RDB strings. The output is byte-compatible
+// with liblzf: a sequence of literal runs and back references into the last
+// 8 KB of output.
+package lzf
+
+import "errors"
+
+const (
+	hashLog    = 14
+	maxLiteral = 1 << 5
+	maxOffset  = 1 << 13
+	maxRef     = (1 << 8) + (1 << 3) // longest back reference, 264 bytes
+
+	// maxExpansion bounds how much longer than its input the output can
+	// be: a 3-byte back reference is the densest encoding
+	maxExpansion = maxRef / 3
+)
+
+var ErrCorrupted = errors.New("lzf: corrupted input")
+
+func hash(in []byte, i int) uint32 {
+	v := uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])
+	return ((v >> (3*8 - hashLog)) - v*5) & (1<<hashLog - 1)
+}
+
+// Compress returns the LZF encoding of in, or nil when it would not be
+// smaller than in
+func Compress(in []byte) []byte {
+	if len(in) < 4 {
+		return nil
+	}
+
+	var table [1 << hashLog]int // positions + 1, 0 meaning empty
+	out := make([]byte, 0, len(in))
+
+	// Each literal run is preceded by a length byte filled in when the run
+	// ends; lit counts the bytes in the current run.
+	litPos := 0
+	out = append(out, 0)
+	lit := 0
+
+	ip := 0
+	for ip < len(in)-2 {
+		h := hash(in, ip)
+		ref := table[h] - 1
+		table[h] = ip + 1
+
+		off := ip - ref - 1
+		if ref >= 0 && off < maxOffset &&
+			in[ref] == in[ip] && in[ref+1] == in[ip+1] && in[ref+2] == in[ip+2] {
+
+			maxLen := min(maxRef, len(in)-ip)
+			length := 3
+			for length < maxLen && in[ref+length] == in[ip+length] {
+				length++
+			}
+
+			// Close the literal run, dropping its length byte if it is empty
+			if lit > 0 {
+				out[litPos] = byte(lit - 1)
+			} else {
+				out = out[:len(out)-1]
+			}
+
+			length -= 2
+			if length < 7 {
+				out = append(out, byte(off>>8)+byte(length<<5))
+			} else {
+				out = append(out, byte(off>>8)+(7<<5), byte(length-7))
+			}
+			out = append(out, byte(off))
+
+			litPos = len(out)
+			out = append(out, 0)
+			lit = 0
+
+			// Index the positions the match covered so later data can refer to them
+			end := ip + length + 2
+			for ip++; ip < end && ip < len(in)-2; ip++ {
+				table[hash(in, ip)] = ip + 1
+			}
+			ip = end
+		} else {
+			out = append(out, in[ip])
+			ip++
+			if lit++; lit == maxLiteral {
+				out[litPos] = maxLiteral - 1
+				litPos = len(out)
+				out = append(out, 0)
+				lit = 0
+			}
+		}
+
+		if len(out) >= len(in) {
+			return nil
+		}
+	}
+
+	for ; ip < len(in); ip++ {
+		out = append(out, in[ip])
+		if lit++; lit == maxLiteral {
+			out[litPos] = maxLiteral - 1
+			litPos = len(out)
+			out = append(out, 0)
+			lit = 0
+		}
+	}
+
+	if lit > 0 {
+		out[litPos] = byte(lit - 1)
+	} else {
+		out = out[:len(out)-1]
+	}
+
+	if len(out) >= len(in) {
+		return nil
+	}
+	return out
+}
+
+// Decompress decodes in, which must expand to exactly outLen bytes. An
+// outLen that in cannot possibly expand to is refused before anything is
+// allocated, it comes from the file being read.
+func Decompress(in []byte, outLen int) ([]byte, error) {
+	if outLen < 0 || outLen > len(in)*maxExpansion {
+		return nil, ErrCorrupted
+	}
+	out := make([]byte, 0, outLen)
+
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < maxLiteral {
+			n := ctrl + 1
+			if ip+n > len(in) || len(out)+n > outLen {
+				return nil, ErrCorrupted
+			}
+			out = append(out, in[ip:ip+n]...)
+			ip += n
+			continue
+		}
+
+		length := ctrl >> 5
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, ErrCorrupted
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, ErrCorrupted
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[ip]) - 1
+		ip++
+
+		length += 2
+		if ref < 0 || len(out)+length > outLen {
+			return nil, ErrCorrupted
+		}
+		// Copy byte by byte, the reference may overlap what is being written
+		for i := 0; i < length; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, ErrCorrupted
+	}
+	return out, nil
+}
@@ -0,0 +1,56 @@
+package rdb
+
+import (
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+)
+
+// RDB files from version 5 on end with a CRC64 of everything before it,
+// 8 bytes little-endian. Redis uses the Jones polynomial, reflected, with
+// no initial or final inversion, and saves 0 when checksums are disabled.
+const checksumVersion = 5
+
+// crc64JonesTable uses the bit-reversed form of 0xad93d23594c935a9
+var crc64JonesTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// crc64Jones is a running checksum. hash/crc64 inverts the CRC before and
+// after each update, so it is inverted around the call to cancel that out.
+type crc64Jones uint64
+
+func (crc *crc64Jones) Write(p []byte) (int, error) {
+	*crc = crc64Jones(^crc64.Update(^uint64(*crc), crc64JonesTable, p))
+	return len(p), nil
+}
+
+// countingReader counts the bytes read from the file, so the offset the
+// parser reached is that count minus what bufio still holds
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// verifyChecksum compares the checksum saved in the file with the CRC64
+// of its first size bytes
+func verifyChecksum(file *os.File, size int64, expected uint64) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind RDB file: %w", err)
+	}
+
+	var crc crc64Jones
+	if _, err := io.CopyN(&crc, file, size); err != nil {
+		return fmt.Errorf("failed to read RDB file for checksum: %w", err)
+	}
+
+	if uint64(crc) != expected {
+		return fmt.Errorf("wrong RDB checksum expected: (%x) got (%x)", expected, uint64(crc))
+	}
+	return nil
+}
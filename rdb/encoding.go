@@ -5,6 +5,10 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"strconv"
+
+	"github.com/kushalsdesk/redis_with_go/lzf"
 )
 
 const (
@@ -75,6 +79,14 @@ func readLength(reader *bufio.Reader) (uint64, bool, error) {
 		return length, false, nil
 
 	case LEN_32BIT:
+		// 0x80 is followed by a 32-bit length, 0x81 by a 64-bit one
+		if firstByte == 0x81 {
+			buf, err := readBytes(reader, 8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
 		buf, err := readBytes(reader, 4)
 		if err != nil {
 			return 0, false, err
@@ -97,6 +109,10 @@ func readString(reader *bufio.Reader) (string, error) {
 		return "", err
 	}
 
+	if isEncoded && length == ENC_LZF {
+		return readLZFString(reader)
+	}
+
 	if isEncoded {
 		intVal, err := readEncodedInteger(reader, byte(length))
 		if err != nil {
@@ -117,6 +133,33 @@ func readString(reader *bufio.Reader) (string, error) {
 	return string(buf), nil
 }
 
+// readLZFString reads a compressed string: its compressed length, its
+// length once decompressed, then the LZF data
+func readLZFString(reader *bufio.Reader) (string, error) {
+	compressedLen, _, err := readLength(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read compressed length: %w", err)
+	}
+	length, _, err := readLength(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read uncompressed length: %w", err)
+	}
+
+	compressed, err := readBytes(reader, int(compressedLen))
+	if err != nil {
+		return "", err
+	}
+	if length > 512*1024*1024 {
+		return "", fmt.Errorf("refusing to decompress to %d bytes", length)
+	}
+	buf, err := lzf.Decompress(compressed, int(length))
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress string: %w", err)
+	}
+
+	return string(buf), nil
+}
+
 func readEncodedInteger(reader *bufio.Reader, encoding byte) (int64, error) {
 	switch encoding {
 	case ENC_INT8:
@@ -143,7 +186,7 @@ func readEncodedInteger(reader *bufio.Reader, encoding byte) (int64, error) {
 		return int64(int32(val)), nil
 
 	case ENC_LZF:
-		return 0, fmt.Errorf("LZF compression not supported yet")
+		return 0, fmt.Errorf("LZF compressed string is not an integer")
 
 	default:
 		return 0, fmt.Errorf("unknown integer encoding: %d", encoding)
@@ -169,3 +212,40 @@ func readUint64(reader *bufio.Reader) (uint64, error) {
 	}
 	return binary.LittleEndian.Uint64(buf), nil
 }
+
+// readDouble reads a double saved as text behind a length byte, with
+// 253, 254 and 255 standing for NaN, +inf and -inf
+func readDouble(reader *bufio.Reader) (float64, error) {
+	length, err := readByte(reader)
+	if err != nil {
+		return 0, err
+	}
+
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	buf, err := readBytes(reader, int(length))
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseFloat(string(buf), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid double '%s'", buf)
+	}
+	return value, nil
+}
+
+// readBinaryDouble reads a double saved as 8 little-endian bytes
+func readBinaryDouble(reader *bufio.Reader) (float64, error) {
+	bits, err := readUint64(reader)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(bits), nil
+}
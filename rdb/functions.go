@@ -0,0 +1,85 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// FUNCTION DUMP payloads hold the function libraries the way an RDB file
+// does, followed like DUMP payloads by the RDB version, 2 bytes
+// little-endian, and the CRC64 of everything before it, 8 bytes
+// little-endian.
+
+// dumpVersion is the RDB version written in payloads, newer ones are
+// refused
+const dumpVersion = 11
+
+// DumpFunctions encodes the code of function libraries into a payload for
+// FUNCTION RESTORE
+func DumpFunctions(codes []string) []byte {
+	var buf []byte
+	for _, code := range codes {
+		buf = append(buf, OpFunction2)
+		buf = appendLength(buf, uint64(len(code)))
+		buf = append(buf, code...)
+	}
+	buf = binary.LittleEndian.AppendUint16(buf, dumpVersion)
+
+	var crc crc64Jones
+	crc.Write(buf)
+	return binary.LittleEndian.AppendUint64(buf, uint64(crc))
+}
+
+// RestoreFunctions returns the code of the libraries in a payload made by
+// DumpFunctions or by Redis
+func RestoreFunctions(payload []byte) ([]string, error) {
+	if len(payload) < 10 {
+		return nil, errors.New("payload version or checksum are wrong")
+	}
+	footer := len(payload) - 10
+	version := binary.LittleEndian.Uint16(payload[footer:])
+	var crc crc64Jones
+	crc.Write(payload[:footer+2])
+	if version > dumpVersion || binary.LittleEndian.Uint64(payload[footer+2:]) != uint64(crc) {
+		return nil, errors.New("payload version or checksum are wrong")
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(payload[:footer]))
+	var codes []string
+	for {
+		opcode, err := reader.ReadByte()
+		if err != nil {
+			return codes, nil
+		}
+		switch opcode {
+		case OpFunction2:
+		case OpFunction:
+			return nil, errors.New("Pre-GA function format not supported")
+		default:
+			return nil, errors.New("given type is not a function")
+		}
+
+		code, err := readString(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read function library: %w", err)
+		}
+		codes = append(codes, code)
+	}
+}
+
+// appendLength appends an RDB length encoding of n
+func appendLength(buf []byte, n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return append(buf, byte(n))
+	case n < 1<<14:
+		return append(buf, byte(n>>8)|LEN_14BIT<<6, byte(n))
+	case n <= 0xFFFFFFFF:
+		return binary.BigEndian.AppendUint32(append(buf, 0x80), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0x81), n)
+	}
+}
@@ -0,0 +1,127 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+)
+
+// parseHashValue reads the field/value pairs of a hash, saved one by one
+// or in a zipmap, ziplist or listpack alternating fields and values
+func parseHashValue(reader *bufio.Reader, valueType byte) ([]string, error) {
+	if valueType == TypeHash {
+		length, _, err := readLength(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hash length: %w", err)
+		}
+
+		pairs := make([]string, 0, min(2*length, 1024))
+		for i := uint64(0); i < 2*length; i++ {
+			s, err := readString(reader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read hash element %d: %w", i, err)
+			}
+			pairs = append(pairs, s)
+		}
+		return pairs, nil
+	}
+
+	data, err := readString(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hash container: %w", err)
+	}
+
+	var pairs []string
+	switch valueType {
+	case TypeZipmap:
+		pairs, err = parseZipmap([]byte(data))
+	case TypeHashZL:
+		pairs, err = parseZiplist([]byte(data))
+	case TypeHashListpack:
+		pairs, err = parseListpack([]byte(data))
+	default:
+		return nil, fmt.Errorf("unsupported hash type: 0x%02X", valueType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("hash container has an odd number of elements: %d", len(pairs))
+	}
+	return pairs, nil
+}
+
+// parseZipmap decodes the hash encoding of Redis before 2.6:
+//
+//	count | field length | field | value length | free | value | free bytes ... | 0xFF
+//
+// where lengths take 1 byte, or 254 and 4 little-endian bytes
+func parseZipmap(data []byte) ([]string, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("zipmap too short: %d bytes", len(data))
+	}
+
+	pos := 1 // The count byte is only a hint past 253
+	readLen := func() (int, error) {
+		if pos >= len(data) {
+			return 0, fmt.Errorf("zipmap ends inside an entry")
+		}
+		b := data[pos]
+		pos++
+		switch {
+		case b < 254:
+			return int(b), nil
+		case b == 254:
+			if pos+4 > len(data) {
+				return 0, fmt.Errorf("zipmap ends inside a length")
+			}
+			n := int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+			return n, nil
+		default:
+			return 0, fmt.Errorf("invalid zipmap length 0x%02X", b)
+		}
+	}
+	readBytes := func(n int) (string, error) {
+		if n < 0 || pos+n > len(data) {
+			return "", fmt.Errorf("zipmap entry out of bounds")
+		}
+		s := string(data[pos : pos+n])
+		pos += n
+		return s, nil
+	}
+
+	var pairs []string
+	for pos < len(data) && data[pos] != 0xFF {
+		fieldLen, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		field, err := readBytes(fieldLen)
+		if err != nil {
+			return nil, err
+		}
+		valueLen, err := readLen()
+		if err != nil {
+			return nil, err
+		}
+		if pos >= len(data) {
+			return nil, fmt.Errorf("zipmap ends inside an entry")
+		}
+		free := int(data[pos])
+		pos++
+		value, err := readBytes(valueLen)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := readBytes(free); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, field, value)
+	}
+
+	if pos != len(data)-1 {
+		return nil, fmt.Errorf("zipmap does not end with 0xFF")
+	}
+	return pairs, nil
+}
@@ -4,128 +4,235 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/kushalsdesk/redis_with_go/store"
 )
 
-// LoadRDB is the main entry point for loading an RDB file
-func LoadRDB(filepath string) error {
-	// Checking if file exists
-	if _, err := os.Stat(filepath); os.IsNotExist(err) {
-		return fmt.Errorf("RDB file not found: %s", filepath)
-	}
+// ParseOptions tunes how Parse reads a file
+type ParseOptions struct {
+	// VerifyChecksum compares the CRC64 saved at the end of the file with
+	// the one of its contents
+	VerifyChecksum bool
+}
+
+// AuxField is a metadata field of the file, such as redis-ver
+type AuxField struct {
+	Key   string
+	Value string
+}
+
+// Info describes a file read by Parse
+type Info struct {
+	Version   int
+	Aux       []AuxField
+	Functions []string // code of the function libraries
+	Keys      int
+
+	// Checksum is the one saved in the file, 0 when it was saved without
+	// one or before version 5. ChecksumVerified is false when it was not
+	// checked.
+	Checksum         uint64
+	ChecksumVerified bool
+}
+
+// ParseError is where in the file parsing stopped and why
+type ParseError struct {
+	Offset int64
+	Err    error
+}
 
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Parse reads an RDB file, calling fn with every key in file order. An
+// error from fn stops parsing and is returned as is, errors in the file
+// itself are returned as a *ParseError.
+func Parse(filepath string, opts ParseOptions, fn func(*KeyValue) error) (*Info, error) {
 	// Opening the file
 	file, err := os.Open(filepath)
 	if err != nil {
-		return fmt.Errorf("failed to open RDB file: %w", err)
+		return nil, fmt.Errorf("failed to open RDB file: %w", err)
 	}
 	defer file.Close()
 
-	// Creating buffered reader for efficient reading
-	reader := bufio.NewReader(file)
+	// Creating buffered reader for efficient reading, counting what it
+	// takes from the file to find where the checksum starts
+	counter := &countingReader{reader: file}
+	reader := bufio.NewReader(counter)
+	offset := func() int64 {
+		return counter.n - int64(reader.Buffered())
+	}
+	fail := func(format string, args ...interface{}) error {
+		return &ParseError{Offset: offset(), Err: fmt.Errorf(format, args...)}
+	}
+
+	info := &Info{}
 
 	// Parsing header
 	version, err := parseHeader(reader)
 	if err != nil {
-		return fmt.Errorf("invalid RDB header: %w", err)
-	}
-	fmt.Printf("📋 RDB version: %s\n", version)
-
-	// Skipping metadata
-	err = skipMetadata(reader)
-	if err != nil {
-		return fmt.Errorf("failed to skip metadata: %w", err)
+		return nil, fail("invalid RDB header: %w", err)
 	}
+	info.Version, _ = strconv.Atoi(version)
 
-	// Load databases
-	totalKeys := 0
-	skippedKeys := 0
-	currentDB := -1
+	currentDB := 0
 
 	for {
 		opcode, err := readByte(reader)
 		if err != nil {
-			return fmt.Errorf("failed to read opcode: %w", err)
+			return info, fail("failed to read opcode: %w", err)
 		}
 
 		switch opcode {
 		case OpEOF:
-			fmt.Printf("✅ RDB loading complete: loaded %d keys, skipped %d expired keys\n",
-				totalKeys, skippedKeys)
+			if info.Version < checksumVersion {
+				return info, nil
+			}
 
-			// TODO: Validate CRC64 checksum
-			// For now, we skip checksum validation
-			return nil
+			checksummed := offset()
+			info.Checksum, err = readUint64(reader)
+			if err != nil {
+				return info, fail("failed to read checksum: %w", err)
+			}
+			if info.Checksum == 0 || !opts.VerifyChecksum {
+				return info, nil
+			}
+			if err := verifyChecksum(file, checksummed, info.Checksum); err != nil {
+				return info, &ParseError{Offset: checksummed, Err: err}
+			}
+			info.ChecksumVerified = true
+			return info, nil
 
 		case OpSelectDB:
 			// Database selector
-			dbNum, err := parseDatabaseSelector(reader)
+			currentDB, err = parseDatabaseSelector(reader)
 			if err != nil {
-				return fmt.Errorf("failed to parse database selector: %w", err)
+				return info, fail("failed to parse database selector: %w", err)
 			}
 
-			currentDB = dbNum
-			fmt.Printf("📂 Loading database %d\n", dbNum)
-
-			if dbNum != 0 {
-				fmt.Printf("⚠️  Warning: RDB contains database %d, but only database 0 is supported\n", dbNum)
-			}
-			continue
-
 		case OpResizeDB:
 			err = skipHashTableSize(reader)
 			if err != nil {
-				return fmt.Errorf("failed to skip hash table size: %w", err)
+				return info, fail("failed to skip hash table size: %w", err)
 			}
-			continue
 
 		case OpAux:
-			_, err = readString(reader)
+			var field AuxField
+			field.Key, err = readString(reader)
 			if err != nil {
-				return fmt.Errorf("failed to skip aux key: %w", err)
+				return info, fail("failed to read aux key: %w", err)
 			}
-			_, err = readString(reader)
+			field.Value, err = readString(reader)
 			if err != nil {
-				return fmt.Errorf("failed to skip aux value: %w", err)
+				return info, fail("failed to read aux value: %w", err)
+			}
+			info.Aux = append(info.Aux, field)
+
+		case OpFunction2:
+			code, err := readString(reader)
+			if err != nil {
+				return info, fail("failed to read function library: %w", err)
+			}
+			info.Functions = append(info.Functions, code)
+
+		case OpFunction:
+			return info, fail("pre-GA function format not supported")
+
+		case OpModuleAux:
+			err = skipModuleAux(reader)
+			if err != nil {
+				return info, fail("failed to skip module aux data: %w", err)
 			}
-			continue
 
 		default:
 			err = reader.UnreadByte()
 			if err != nil {
-				return fmt.Errorf("failed to unread byte: %w", err)
+				return info, fail("failed to unread byte: %w", err)
 			}
 
 			// Parsing key-value pair
 			kv, err := parseKeyValuePair(reader)
 			if err != nil {
-				return fmt.Errorf("failed to parse key-value pair at database %d: %w", currentDB, err)
+				return info, fail("failed to parse key-value pair at database %d: %w", currentDB, err)
 			}
+			kv.DB = currentDB
+			info.Keys++
 
-			if kv == nil {
-				// EOF or database selector encountered
-				continue
+			if err := fn(kv); err != nil {
+				return info, err
 			}
+		}
+	}
+}
 
-			// Store the key-value pair
-			loaded, err := storeKeyValue(kv)
-			if err != nil {
-				fmt.Printf("⚠️  Warning: failed to store key '%s': %v\n", kv.Key, err)
-				continue
-			}
+// LoadRDB is the main entry point for loading an RDB file. Keys are only
+// stored once the whole file has been read and its checksum verified, so
+// a corrupt file loads nothing. Function libraries are handed to
+// loadFunction, which compiles and registers them.
+func LoadRDB(filepath string, loadFunction func(code string) error) error {
+	// Checking if file exists
+	if _, err := os.Stat(filepath); os.IsNotExist(err) {
+		return fmt.Errorf("RDB file not found: %s", filepath)
+	}
 
-			if loaded {
-				totalKeys++
-				// if totalKeys%1000 == 0 {
-				// 	fmt.Printf("⏳ Loaded %d keys...\n", totalKeys)
-				// }
-			} else {
-				skippedKeys++
-			}
+	parsed := make([]*KeyValue, 0)
+	warnedDBs := make(map[int]bool)
+	opts := ParseOptions{VerifyChecksum: store.GetConfig().RDBChecksum}
+
+	info, err := Parse(filepath, opts, func(kv *KeyValue) error {
+		if kv.DB != 0 && !warnedDBs[kv.DB] {
+			fmt.Printf("⚠️  Warning: RDB contains database %d, but only database 0 is supported\n", kv.DB)
+			warnedDBs[kv.DB] = true
+		}
+		parsed = append(parsed, kv)
+		return nil
+	})
+	if info != nil {
+		fmt.Printf("📋 RDB version: %04d\n", info.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, code := range info.Functions {
+		if err := loadFunction(code); err != nil {
+			fmt.Printf("⚠️  Warning: failed to load function library: %v\n", err)
+		}
+	}
+	switch {
+	case info.Version < checksumVersion:
+	case info.Checksum == 0:
+		fmt.Printf("ℹ️  RDB file was saved without a checksum\n")
+	case !info.ChecksumVerified:
+		fmt.Printf("ℹ️  RDB checksum verification disabled\n")
+	}
+
+	totalKeys := 0
+	skippedKeys := 0
+	for _, kv := range parsed {
+		loaded, err := storeKeyValue(kv)
+		if err != nil {
+			fmt.Printf("⚠️  Warning: failed to store key '%s': %v\n", kv.Key, err)
+			continue
+		}
+
+		if loaded {
+			totalKeys++
+		} else {
+			skippedKeys++
 		}
 	}
+
+	fmt.Printf("✅ RDB loading complete: loaded %d keys, skipped %d expired keys\n",
+		totalKeys, skippedKeys)
+	return nil
 }
 
 func storeKeyValue(kv *KeyValue) (bool, error) {
@@ -152,7 +259,7 @@ func storeKeyValue(kv *KeyValue) (bool, error) {
 		store.Set(kv.Key, value, ttl)
 		return true, nil
 
-	case TypeList, TypeListQuicklist, TypeListQuicklist2: // MODIFY THIS LINE
+	case TypeList, TypeZiplist, TypeListQuicklist, TypeListQuicklist2:
 		elements, ok := kv.Value.([]string)
 		if !ok {
 			return false, fmt.Errorf("expected list value, got %T", kv.Value)
@@ -172,11 +279,28 @@ func storeKeyValue(kv *KeyValue) (bool, error) {
 
 		return true, nil
 
+	case TypeSortedSet, TypeZSet2, TypeSortedSetZL, TypeSortedSetListpack:
+		entries, ok := kv.Value.([]store.ZSetEntry)
+		if !ok {
+			return false, fmt.Errorf("expected sorted set value, got %T", kv.Value)
+		}
+
+		if err := store.SetSortedSet(kv.Key, entries, ttl); err != nil {
+			return false, err
+		}
+		return true, nil
+
 	case TypeStream, TypeStreamListpack, TypeStreamListpack2:
-		return false, fmt.Errorf("stream type not implemented yet")
+		stream, ok := kv.Value.(*store.Stream)
+		if !ok {
+			return false, fmt.Errorf("expected stream value, got %T", kv.Value)
+		}
+
+		store.SetStream(kv.Key, stream, ttl)
+		return true, nil
 
 	default:
-		return false, fmt.Errorf("unsupported value type: 0x%02X", kv.ValueType)
+		return false, fmt.Errorf("unsupported value type: %s (0x%02X)", kv.TypeName(), kv.ValueType)
 	}
 }
 
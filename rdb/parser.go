@@ -2,7 +2,9 @@ package rdb
 
 import (
 	"bufio"
+	"encoding/binary"
 	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -13,37 +15,86 @@ const (
 	OpExpireTimeMs  = 0xFC
 	OpResizeDB      = 0xFB
 	OpAux           = 0xFA
+	OpFreq          = 0xF9
+	OpIdle          = 0xF8
+	OpModuleAux     = 0xF7
+	OpFunction2     = 0xF5
+	OpFunction      = 0xF6 // function libraries of Redis 7.0 release candidates
 )
 
 const (
-	TypeString        = 0x00
-	TypeList          = 0x01
-	TypeSet           = 0x02
-	TypeSortedSet     = 0x03
-	TypeHash          = 0x04
-	TypeZipmap        = 0x09
-	TypeZiplist       = 0x0A
-	TypeIntset        = 0x0B
-	TypeSortedSetZL   = 0x0C
-	TypeHashZL        = 0x0D
-	TypeListQuicklist = 0x0E
-	TypeStream        = 0x0F
-
-	TypeHashListpack    = 0x10
-	TypeZSet2           = 0x11
-	TypeListQuicklist2  = 0x12
-	TypeStreamListpack  = 0x13
-	TypeHashZipmap2     = 0x14
-	TypeStreamListpack2 = 0x15
+	TypeString      = 0x00
+	TypeList        = 0x01
+	TypeSet         = 0x02
+	TypeSortedSet   = 0x03
+	TypeHash        = 0x04
+	TypeZSet2       = 0x05
+	TypeModule      = 0x06
+	TypeModule2     = 0x07
+	TypeZipmap      = 0x09
+	TypeZiplist     = 0x0A
+	TypeIntset      = 0x0B
+	TypeSortedSetZL = 0x0C
+	TypeHashZL      = 0x0D
+
+	TypeListQuicklist     = 0x0E
+	TypeStream            = 0x0F
+	TypeHashListpack      = 0x10
+	TypeSortedSetListpack = 0x11
+	TypeListQuicklist2    = 0x12
+	TypeStreamListpack    = 0x13
+	TypeSetListpack       = 0x14
+	TypeStreamListpack2   = 0x15
 )
 
+// Opcodes tagging the values modules save
+const (
+	moduleOpcodeEOF    = 0
+	moduleOpcodeSint   = 1
+	moduleOpcodeUint   = 2
+	moduleOpcodeFloat  = 3
+	moduleOpcodeDouble = 4
+	moduleOpcodeString = 5
+)
+
+// Containers of the nodes of a TypeListQuicklist2 list
+const (
+	quicklistNodePlain  = 1 // a single element stored as is
+	quicklistNodePacked = 2 // a listpack
+)
+
+// KeyValue is a key read from a file. Value holds, by ValueType, a string
+// for strings, the elements ([]string) of lists and sets, the field/value
+// pairs ([]string) of hashes, []store.ZSetEntry for sorted sets and
+// *store.Stream for streams.
 type KeyValue struct {
+	DB        int
 	Key       string
 	Value     interface{}
 	ValueType byte
 	Expiry    *time.Time
 }
 
+// TypeName is the name TYPE gives the value
+func (kv *KeyValue) TypeName() string {
+	switch kv.ValueType {
+	case TypeString:
+		return "string"
+	case TypeList, TypeZiplist, TypeListQuicklist, TypeListQuicklist2:
+		return "list"
+	case TypeSet, TypeIntset, TypeSetListpack:
+		return "set"
+	case TypeSortedSet, TypeZSet2, TypeSortedSetZL, TypeSortedSetListpack:
+		return "zset"
+	case TypeHash, TypeZipmap, TypeHashZL, TypeHashListpack:
+		return "hash"
+	case TypeStream, TypeStreamListpack, TypeStreamListpack2:
+		return "stream"
+	default:
+		return "unknown"
+	}
+}
+
 func parseHeader(reader *bufio.Reader) (string, error) {
 	// Read magic string "REDIS"
 	magicBytes, err := readBytes(reader, 5)
@@ -74,35 +125,6 @@ func parseHeader(reader *bufio.Reader) (string, error) {
 	return version, nil
 }
 
-func skipMetadata(reader *bufio.Reader) error {
-	for {
-		opcode, err := readByte(reader)
-		if err != nil {
-			return fmt.Errorf("failed to read opcode while skipping metadata: %w", err)
-		}
-
-		if opcode != OpAux {
-			// Not an aux field, unread the byte by seeking back
-			// Since bufio.Reader doesn't support UnreadByte after ReadByte in all cases,
-			// we'll handle this by peeking first in actual usage
-			// For now, we assume this byte is the start of database section
-			return reader.UnreadByte()
-		}
-
-		//Skipping aux field key
-		_, err = readString(reader)
-		if err != nil {
-			return fmt.Errorf("failed to skip aux key: %w", err)
-		}
-
-		//Skipping aux field value
-		_, err = readString(reader)
-		if err != nil {
-			return fmt.Errorf("failed to skip aux value: %w", err)
-		}
-	}
-}
-
 func parseDatabaseSelector(reader *bufio.Reader) (int, error) {
 	// Read the database number
 	dbNum, isEncoded, err := readLength(reader)
@@ -171,8 +193,16 @@ func parseListValue(reader *bufio.Reader, valueType byte) ([]string, error) {
 	switch valueType {
 	case TypeList:
 		return parseSimpleList(reader)
-	case TypeListQuicklist, TypeListQuicklist2: // MODIFY THIS LINE - handle both versions
-		return parseQuicklist(reader)
+	case TypeZiplist:
+		data, err := readString(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read list ziplist: %w", err)
+		}
+		return parseZiplist([]byte(data))
+	case TypeListQuicklist:
+		return parseQuicklist(reader, false)
+	case TypeListQuicklist2:
+		return parseQuicklist(reader, true)
 	default:
 		return nil, fmt.Errorf("unsupported list type: 0x%02X", valueType)
 	}
@@ -199,64 +229,54 @@ func parseSimpleList(reader *bufio.Reader) ([]string, error) {
 	return elements, nil
 }
 
-func parseQuicklist(reader *bufio.Reader) ([]string, error) {
+// parseQuicklist reads the nodes of a quicklist. They are ziplists in
+// TypeListQuicklist, while in TypeListQuicklist2 each node says whether it
+// is a listpack or a single plain element.
+func parseQuicklist(reader *bufio.Reader, version2 bool) ([]string, error) {
 	nodeCount, _, err := readLength(reader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read quicklist node count: %w", err)
 	}
 
-	if nodeCount == 0 {
-		return []string{}, nil
-	}
-
-	fmt.Printf("🔍 Parsing quicklist with %d nodes\n", nodeCount) // ADD THIS
-
 	allElements := make([]string, 0)
 
 	for i := uint64(0); i < nodeCount; i++ {
+		container := uint64(quicklistNodePacked)
+		if version2 {
+			if container, _, err = readLength(reader); err != nil {
+				return nil, fmt.Errorf("failed to read quicklist node %d container: %w", i, err)
+			}
+			if container != quicklistNodePlain && container != quicklistNodePacked {
+				return nil, fmt.Errorf("quicklist node %d has unknown container %d", i, container)
+			}
+		}
+
 		containerData, err := readString(reader)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read quicklist node %d: %w", i, err)
 		}
 
-		fmt.Printf("🔍 Node %d: %d bytes\n", i, len(containerData)) // ADD THIS
-
-		if len(containerData) == 0 {
+		if container == quicklistNodePlain {
+			allElements = append(allElements, containerData)
 			continue
 		}
 
-		elements, err := parseZiplistOrListpack([]byte(containerData))
+		var elements []string
+		if version2 {
+			elements, err = parseListpack([]byte(containerData))
+		} else {
+			elements, err = parseZiplist([]byte(containerData))
+		}
 		if err != nil {
-			fmt.Printf("⚠️  Warning: failed to parse node %d: %v\n", i, err)
-			continue
+			return nil, fmt.Errorf("quicklist node %d: %w", i, err)
 		}
 
-		fmt.Printf("✅ Node %d: parsed %d elements\n", i, len(elements)) // ADD THIS
+		// Redis skips empty nodes too
 		allElements = append(allElements, elements...)
 	}
 
 	return allElements, nil
 }
-func parseZiplistOrListpack(data []byte) ([]string, error) {
-	if len(data) < 6 {
-		// Try as simple string encoding (fallback)
-		return []string{string(data)}, nil
-	}
-
-	// Check if it's a listpack (starts with total bytes + num entries)
-	// Listpack format: [total_bytes:4][num_entries:2][entries...][end:0xFF]
-
-	// Try parsing as ziplist first (more common)
-	if len(data) >= 10 {
-		result, err := parseZiplist(data)
-		if err == nil && len(result) > 0 {
-			return result, nil
-		}
-	}
-
-	// If ziplist parsing fails, try listpack
-	return parseListpack(data)
-}
 
 func parseListpack(data []byte) ([]string, error) {
 	if len(data) < 7 {
@@ -270,293 +290,310 @@ func parseListpack(data []byte) ([]string, error) {
 	if totalBytes != uint32(len(data)) {
 		return nil, fmt.Errorf("listpack size mismatch: expected %d, got %d", totalBytes, len(data))
 	}
-
-	if numElements == 0 {
-		return []string{}, nil
+	if data[len(data)-1] != 0xFF {
+		return nil, fmt.Errorf("listpack does not end with 0xFF")
 	}
 
-	elements := make([]string, 0, numElements)
+	// 65535 elements means the count did not fit and the listpack has to
+	// be walked to its end
+	capacity := int(numElements)
+	if numElements == 0xFFFF {
+		capacity = 0
+	}
+	elements := make([]string, 0, capacity)
 	offset := 6 // Start after header
 
-	for i := uint16(0); i < numElements && offset < len(data)-1; i++ {
+	for offset < len(data) && data[offset] != 0xFF {
 		element, newOffset, err := parseListpackEntry(data, offset)
 		if err != nil {
-			break
+			return nil, fmt.Errorf("listpack entry %d: %w", len(elements), err)
 		}
 		elements = append(elements, element)
 		offset = newOffset
 	}
 
+	if offset != len(data)-1 {
+		return nil, fmt.Errorf("listpack ends at %d, header says %d", offset+1, totalBytes)
+	}
+	if numElements != 0xFFFF && len(elements) != int(numElements) {
+		return nil, fmt.Errorf("listpack has %d elements, header says %d", len(elements), numElements)
+	}
 	return elements, nil
 }
+
+// parseZiplist decodes a ziplist, integers being returned in decimal:
+//
+//	zlbytes (4) | zltail (4) | zllen (2) | entries... | 0xFF
+//
+// with little-endian header fields. A zllen of 65535 means the count did
+// not fit and the ziplist has to be walked to its end.
 func parseZiplist(data []byte) ([]string, error) {
-	if len(data) < 10 {
+	if len(data) < 11 {
 		return nil, fmt.Errorf("ziplist too short: %d bytes", len(data))
 	}
 
-	// Read number of entries (bytes 8-9)
-	numEntries := uint16(data[8]) | (uint16(data[9]) << 8)
+	totalBytes := binary.LittleEndian.Uint32(data[0:4])
+	tailOffset := binary.LittleEndian.Uint32(data[4:8])
+	numEntries := binary.LittleEndian.Uint16(data[8:10])
 
-	// Sanity check
-	if numEntries > 10000 {
-		return nil, fmt.Errorf("unreasonable entry count: %d", numEntries)
+	if totalBytes != uint32(len(data)) {
+		return nil, fmt.Errorf("ziplist size mismatch: expected %d, got %d", totalBytes, len(data))
 	}
-
-	if numEntries == 0 {
-		return []string{}, nil
+	if tailOffset >= totalBytes {
+		return nil, fmt.Errorf("ziplist tail offset %d out of bounds", tailOffset)
+	}
+	if data[len(data)-1] != 0xFF {
+		return nil, fmt.Errorf("ziplist does not end with 0xFF")
 	}
 
-	elements := make([]string, 0, numEntries)
+	capacity := int(numEntries)
+	if numEntries == 0xFFFF {
+		capacity = 0
+	}
+	elements := make([]string, 0, capacity)
 	offset := 10 // Start after header
 
-	for i := uint16(0); i < numEntries && offset < len(data)-1; i++ {
+	for offset < len(data) && data[offset] != 0xFF {
 		element, newOffset, err := parseZiplistEntry(data, offset)
 		if err != nil {
-			// Stop on error but return what we've parsed so far
-			break
+			return nil, fmt.Errorf("ziplist entry %d: %w", len(elements), err)
 		}
 		elements = append(elements, element)
 		offset = newOffset
 	}
 
-	if len(elements) == 0 {
-		return nil, fmt.Errorf("no valid entries parsed")
+	if offset != len(data)-1 {
+		return nil, fmt.Errorf("ziplist ends at %d, header says %d", offset+1, totalBytes)
+	}
+	if numEntries != 0xFFFF && len(elements) != int(numEntries) {
+		return nil, fmt.Errorf("ziplist has %d entries, header says %d", len(elements), numEntries)
 	}
-
 	return elements, nil
 }
+
+// listpackBacklenSize is how many bytes the back length of an entry of
+// the given size takes, 7 bits of it per byte
+func listpackBacklenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// parseListpackEntry decodes the entry at offset, integers being returned
+// in decimal, and returns the offset of the next one
 func parseListpackEntry(data []byte, offset int) (string, int, error) {
 	if offset >= len(data) {
 		return "", offset, fmt.Errorf("offset out of bounds")
 	}
 
 	encoding := data[offset]
-	offset++
+	rest := data[offset+1:]
 
-	switch {
-	// Small string (length in lower 6 bits)
-	case encoding>>7 == 0:
-		strLen := int(encoding & 0x3F)
-		if offset+strLen > len(data) {
-			return "", offset, fmt.Errorf("string out of bounds")
-		}
-		str := string(data[offset : offset+strLen])
-		offset += strLen
-		// Skip backlen
-		if offset < len(data) {
-			offset++
-		}
-		return str, offset, nil
-
-	// 12-bit string
-	case encoding&0xE0 == 0x80:
-		if offset >= len(data) {
-			return "", offset, fmt.Errorf("unexpected end")
+	// size counts the encoding byte and the data after it
+	var value string
+	var size int
+	readString := func(lengthBytes, strLen int) error {
+		if lengthBytes+strLen > len(rest) {
+			return fmt.Errorf("string out of bounds")
 		}
-		strLen := int((encoding&0x1F)<<8) | int(data[offset])
-		offset++
-		if offset+strLen > len(data) {
-			return "", offset, fmt.Errorf("string out of bounds")
-		}
-		str := string(data[offset : offset+strLen])
-		offset += strLen
-		if offset < len(data) {
-			offset++
-		}
-		return str, offset, nil
-
-	// 32-bit string
-	case encoding&0xF0 == 0xF0 && encoding != 0xFF:
-		if offset+4 > len(data) {
-			return "", offset, fmt.Errorf("unexpected end")
-		}
-		strLen := int(data[offset]) | int(data[offset+1])<<8 | int(data[offset+2])<<16 | int(data[offset+3])<<24
-		offset += 4
-		if offset+strLen > len(data) {
-			return "", offset, fmt.Errorf("string out of bounds")
+		value = string(rest[lengthBytes : lengthBytes+strLen])
+		size = 1 + lengthBytes + strLen
+		return nil
+	}
+	readInt := func(n int) (uint64, error) {
+		if n > len(rest) {
+			return 0, fmt.Errorf("unexpected end")
 		}
-		str := string(data[offset : offset+strLen])
-		offset += strLen
-		if offset < len(data) {
-			offset++
+		var val uint64
+		for i := n - 1; i >= 0; i-- {
+			val = val<<8 | uint64(rest[i])
 		}
-		return str, offset, nil
+		size = 1 + n
+		return val, nil
+	}
 
+	switch {
 	// 7-bit unsigned int
-	case encoding == 0xC0:
-		if offset >= len(data) {
-			return "", offset, fmt.Errorf("unexpected end")
-		}
-		val := int(data[offset])
-		offset++
-		if offset < len(data) {
-			offset++
+	case encoding&0x80 == 0:
+		value, size = strconv.Itoa(int(encoding&0x7F)), 1
+
+	// 6-bit string length
+	case encoding&0xC0 == 0x80:
+		if err := readString(0, int(encoding&0x3F)); err != nil {
+			return "", offset, err
 		}
-		return fmt.Sprintf("%d", val), offset, nil
 
 	// 13-bit signed int
 	case encoding&0xE0 == 0xC0:
-		if offset >= len(data) {
+		if len(rest) < 1 {
 			return "", offset, fmt.Errorf("unexpected end")
 		}
-		val := int((encoding&0x1F)<<8) | int(data[offset])
+		val := int(encoding&0x1F)<<8 | int(rest[0])
 		if val&0x1000 != 0 {
 			val -= 0x2000 // Sign extend
 		}
-		offset++
-		if offset < len(data) {
-			offset++
-		}
-		return fmt.Sprintf("%d", val), offset, nil
+		value, size = strconv.Itoa(val), 2
 
-	// 16-bit integer
-	case encoding == 0xD0:
-		if offset+2 > len(data) {
+	// 12-bit string length
+	case encoding&0xF0 == 0xE0:
+		if len(rest) < 1 {
 			return "", offset, fmt.Errorf("unexpected end")
 		}
-		val := int16(data[offset]) | int16(data[offset+1])<<8
-		offset += 2
-		if offset < len(data) {
-			offset++
+		if err := readString(1, int(encoding&0x0F)<<8|int(rest[0])); err != nil {
+			return "", offset, err
 		}
-		return fmt.Sprintf("%d", val), offset, nil
 
-	// 32-bit integer
-	case encoding == 0xE0:
-		if offset+4 > len(data) {
-			return "", offset, fmt.Errorf("unexpected end")
+	// 32-bit string length
+	case encoding == 0xF0:
+		strLen, err := readInt(4)
+		if err != nil {
+			return "", offset, err
 		}
-		val := int32(data[offset]) | int32(data[offset+1])<<8 | int32(data[offset+2])<<16 | int32(data[offset+3])<<24
-		offset += 4
-		if offset < len(data) {
-			offset++
+		if err := readString(4, int(strLen)); err != nil {
+			return "", offset, err
+		}
+
+	// 16, 24, 32 and 64-bit signed ints
+	case encoding >= 0xF1 && encoding <= 0xF4:
+		n := [...]int{2, 3, 4, 8}[encoding-0xF1]
+		val, err := readInt(n)
+		if err != nil {
+			return "", offset, err
 		}
-		return fmt.Sprintf("%d", val), offset, nil
+		// Sign extend
+		shift := 64 - 8*n
+		value = strconv.FormatInt(int64(val<<shift)>>shift, 10)
 
 	default:
 		return "", offset, fmt.Errorf("unknown listpack encoding: 0x%02X", encoding)
 	}
-}
 
-func parseZiplistEntry(data []byte, offset int) (string, int, error) {
-	if offset >= len(data) {
-		return "", offset, fmt.Errorf("offset out of bounds")
+	// Leaves room for the end byte after the entry
+	next := offset + size + listpackBacklenSize(size)
+	if next >= len(data) {
+		return "", offset, fmt.Errorf("entry out of bounds")
 	}
+	return value, next, nil
+}
 
-	// Skip previous entry length (variable length encoding)
-	prevLen := int(data[offset])
-	if prevLen == 254 {
-		offset += 5 // 0xFE + 4 bytes
+// parseZiplistEntry decodes the entry at offset and returns the offset of
+// the next one. An entry is the length of the previous one (1 byte, or
+// 0xFE and 4 bytes), an encoding, then the data.
+func parseZiplistEntry(data []byte, offset int) (string, int, error) {
+	if data[offset] == 0xFE {
+		offset += 5
 	} else {
-		offset += 1
+		offset++
 	}
-
 	if offset >= len(data) {
 		return "", offset, fmt.Errorf("unexpected end of ziplist")
 	}
 
-	// Read encoding byte
 	encoding := data[offset]
 	offset++
 
-	// Parse based on encoding
-	switch {
-	case encoding>>6 == 0: // String with length < 64
-		strLen := int(encoding & 0x3F)
-		if offset+strLen > len(data) {
-			return "", offset, fmt.Errorf("string data out of bounds")
-		}
-		str := string(data[offset : offset+strLen])
-		return str, offset + strLen, nil
-
-	case encoding>>6 == 1: // String with length < 16384
-		if offset >= len(data) {
-			return "", offset, fmt.Errorf("unexpected end")
+	// Leaves room for the end byte after the entry
+	need := func(n int) error {
+		if offset+n >= len(data) {
+			return fmt.Errorf("entry out of bounds")
 		}
-		strLen := int((encoding&0x3F)<<8) | int(data[offset])
-		offset++
-		if offset+strLen > len(data) {
-			return "", offset, fmt.Errorf("string data out of bounds")
+		return nil
+	}
+	readString := func(strLen int) (string, int, error) {
+		if err := need(strLen); err != nil {
+			return "", offset, err
 		}
-		str := string(data[offset : offset+strLen])
-		return str, offset + strLen, nil
-
-	case encoding>>6 == 2: // String with length >= 16384
-		if offset+4 > len(data) {
-			return "", offset, fmt.Errorf("unexpected end")
+		return string(data[offset : offset+strLen]), offset + strLen, nil
+	}
+	readInt := func(n int) (string, int, error) {
+		if err := need(n); err != nil {
+			return "", offset, err
 		}
-		strLen := int(data[offset]) | int(data[offset+1])<<8 | int(data[offset+2])<<16 | int(data[offset+3])<<24
-		offset += 4
-		if offset+strLen > len(data) {
-			return "", offset, fmt.Errorf("string data out of bounds")
+		var val uint64
+		for i := n - 1; i >= 0; i-- {
+			val = val<<8 | uint64(data[offset+i])
 		}
-		str := string(data[offset : offset+strLen])
-		return str, offset + strLen, nil
+		// Sign extend
+		shift := 64 - 8*n
+		return strconv.FormatInt(int64(val<<shift)>>shift, 10), offset + n, nil
+	}
 
-	case encoding == 0xC0: // 16-bit integer
-		if offset+2 > len(data) {
-			return "", offset, fmt.Errorf("unexpected end")
-		}
-		val := int16(data[offset]) | int16(data[offset+1])<<8
-		return fmt.Sprintf("%d", val), offset + 2, nil
+	switch {
+	// Strings of up to 63, 16383 and 2^32-1 bytes, lengths big-endian
+	case encoding>>6 == 0:
+		return readString(int(encoding & 0x3F))
 
-	case encoding == 0xD0: // 32-bit integer
-		if offset+4 > len(data) {
-			return "", offset, fmt.Errorf("unexpected end")
+	case encoding>>6 == 1:
+		if err := need(1); err != nil {
+			return "", offset, err
 		}
-		val := int32(data[offset]) | int32(data[offset+1])<<8 | int32(data[offset+2])<<16 | int32(data[offset+3])<<24
-		return fmt.Sprintf("%d", val), offset + 4, nil
+		strLen := int(encoding&0x3F)<<8 | int(data[offset])
+		offset++
+		return readString(strLen)
 
-	case encoding == 0xE0: // 64-bit integer
-		if offset+8 > len(data) {
-			return "", offset, fmt.Errorf("unexpected end")
+	case encoding>>6 == 2:
+		if err := need(4); err != nil {
+			return "", offset, err
 		}
-		val := int64(data[offset]) | int64(data[offset+1])<<8 | int64(data[offset+2])<<16 | int64(data[offset+3])<<24 |
-			int64(data[offset+4])<<32 | int64(data[offset+5])<<40 | int64(data[offset+6])<<48 | int64(data[offset+7])<<56
-		return fmt.Sprintf("%d", val), offset + 8, nil
+		strLen := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		offset += 4
+		return readString(strLen)
 
-	case encoding == 0xF0: // 24-bit integer
-		if offset+3 > len(data) {
-			return "", offset, fmt.Errorf("unexpected end")
-		}
-		val := int32(data[offset]) | int32(data[offset+1])<<8 | int32(data[offset+2])<<16
-		if val&0x800000 != 0 {
-			val |= ^int32(0xFFFFFF) // Sign extend by setting upper 8 bits to 1
-		}
-		return fmt.Sprintf("%d", val), offset + 3, nil
+	// Little-endian signed ints
+	case encoding == 0xC0:
+		return readInt(2)
+	case encoding == 0xD0:
+		return readInt(4)
+	case encoding == 0xE0:
+		return readInt(8)
+	case encoding == 0xF0:
+		return readInt(3)
+	case encoding == 0xFE:
+		return readInt(1)
 
-	case encoding>>4 == 0xF && encoding != 0xFF: // Small integers 0-12
-		val := int(encoding & 0x0F)
-		return fmt.Sprintf("%d", val-1), offset, nil
+	// 1111xxxx holds 0 to 12 as xxxx - 1
+	case encoding >= 0xF1 && encoding <= 0xFD:
+		if err := need(0); err != nil {
+			return "", offset, err
+		}
+		return strconv.Itoa(int(encoding&0x0F) - 1), offset, nil
 
 	default:
 		return "", offset, fmt.Errorf("unknown ziplist encoding: 0x%02X", encoding)
 	}
 }
 
-func parseStreamValue(reader *bufio.Reader) (interface{}, error) {
-	return nil, fmt.Errorf("stream parsing not implemented yet")
-}
-
 func parseValue(reader *bufio.Reader, valueType byte) (interface{}, error) {
 	switch valueType {
 	case TypeString:
 		return parseStringValue(reader)
 
-	case TypeList, TypeListQuicklist, TypeListQuicklist2: // MODIFY THIS LINE
+	case TypeList, TypeZiplist, TypeListQuicklist, TypeListQuicklist2:
 		return parseListValue(reader, valueType)
 
+	case TypeSortedSet, TypeZSet2, TypeSortedSetZL, TypeSortedSetListpack:
+		return parseSortedSetValue(reader, valueType)
+
 	case TypeStream, TypeStreamListpack, TypeStreamListpack2:
-		return parseStreamValue(reader)
+		return parseStreamValue(reader, valueType)
 
-	case TypeSet, TypeSortedSet, TypeHash:
-		return nil, fmt.Errorf("type 0x%02X not implemented yet", valueType)
+	case TypeSet, TypeIntset, TypeSetListpack:
+		return parseSetValue(reader, valueType)
 
-	case TypeZiplist, TypeZipmap, TypeIntset, TypeSortedSetZL, TypeHashZL:
-		return nil, fmt.Errorf("compressed type 0x%02X not implemented yet", valueType)
+	case TypeHash, TypeZipmap, TypeHashZL, TypeHashListpack:
+		return parseHashValue(reader, valueType)
 
-	case TypeHashListpack, TypeZSet2, TypeHashZipmap2:
-		return nil, fmt.Errorf("listpack/zipmap type 0x%02X not implemented yet", valueType)
+	case TypeModule, TypeModule2:
+		return nil, fmt.Errorf("module type 0x%02X cannot be loaded without its module", valueType)
 
 	default:
 		return nil, fmt.Errorf("unknown value type: 0x%02X", valueType)
@@ -610,6 +647,13 @@ func parseKeyValuePair(reader *bufio.Reader) (*KeyValue, error) {
 		return parseKeyValuePair(reader)
 	}
 
+	// Idle time or access frequency, kept for eviction this server does
+	// not do
+	opcode, err = skipEvictionInfo(reader, opcode)
+	if err != nil {
+		return nil, err
+	}
+
 	valueType := opcode
 
 	// reading the key
@@ -630,3 +674,68 @@ func parseKeyValuePair(reader *bufio.Reader) (*KeyValue, error) {
 		Expiry:    expiry,
 	}, nil
 }
+
+// skipEvictionInfo reads past the LRU idle time or LFU frequency saved
+// before a value and returns the value type that follows them
+func skipEvictionInfo(reader *bufio.Reader, opcode byte) (byte, error) {
+	for opcode == OpIdle || opcode == OpFreq {
+		var err error
+		if opcode == OpIdle {
+			_, _, err = readLength(reader)
+		} else {
+			_, err = readByte(reader)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to skip eviction info: %w", err)
+		}
+
+		opcode, err = readByte(reader)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read value type after eviction info: %w", err)
+		}
+	}
+	return opcode, nil
+}
+
+// skipModuleAux reads past the aux data of a module: its ID, when it is
+// loaded, then opcode-tagged values up to an EOF opcode
+func skipModuleAux(reader *bufio.Reader) error {
+	if _, _, err := readLength(reader); err != nil {
+		return fmt.Errorf("failed to read module ID: %w", err)
+	}
+	whenOpcode, _, err := readLength(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read module aux opcode: %w", err)
+	}
+	if whenOpcode != moduleOpcodeUint {
+		return fmt.Errorf("invalid module aux opcode %d", whenOpcode)
+	}
+	if _, _, err := readLength(reader); err != nil {
+		return fmt.Errorf("failed to read module aux when: %w", err)
+	}
+
+	for {
+		opcode, _, err := readLength(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read module opcode: %w", err)
+		}
+
+		switch opcode {
+		case moduleOpcodeEOF:
+			return nil
+		case moduleOpcodeSint, moduleOpcodeUint:
+			_, _, err = readLength(reader)
+		case moduleOpcodeFloat:
+			_, err = readBytes(reader, 4)
+		case moduleOpcodeDouble:
+			_, err = readBytes(reader, 8)
+		case moduleOpcodeString:
+			_, err = readString(reader)
+		default:
+			return fmt.Errorf("unknown module opcode %d", opcode)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to skip module value: %w", err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// parseSetValue reads the members of a set, saved one by one, as an
+// intset or as a listpack
+func parseSetValue(reader *bufio.Reader, valueType byte) ([]string, error) {
+	if valueType == TypeSet {
+		return parseSimpleList(reader)
+	}
+
+	data, err := readString(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read set container: %w", err)
+	}
+
+	switch valueType {
+	case TypeIntset:
+		return parseIntset([]byte(data))
+	case TypeSetListpack:
+		return parseListpack([]byte(data))
+	default:
+		return nil, fmt.Errorf("unsupported set type: 0x%02X", valueType)
+	}
+}
+
+// parseIntset decodes a sorted array of integers:
+//
+//	encoding (4) | length (4) | integers...
+//
+// little-endian, every integer taking encoding bytes
+func parseIntset(data []byte) ([]string, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("intset too short: %d bytes", len(data))
+	}
+
+	encoding := binary.LittleEndian.Uint32(data[0:4])
+	length := binary.LittleEndian.Uint32(data[4:8])
+	if encoding != 2 && encoding != 4 && encoding != 8 {
+		return nil, fmt.Errorf("invalid intset encoding %d", encoding)
+	}
+	if uint64(len(data)-8) != uint64(length)*uint64(encoding) {
+		return nil, fmt.Errorf("intset of %d bytes cannot hold %d integers of %d bytes", len(data), length, encoding)
+	}
+
+	members := make([]string, length)
+	for i := range members {
+		item := data[8+i*int(encoding):]
+		var val int64
+		switch encoding {
+		case 2:
+			val = int64(int16(binary.LittleEndian.Uint16(item)))
+		case 4:
+			val = int64(int32(binary.LittleEndian.Uint32(item)))
+		case 8:
+			val = int64(binary.LittleEndian.Uint64(item))
+		}
+		members[i] = strconv.FormatInt(val, 10)
+	}
+	return members, nil
+}
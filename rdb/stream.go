@@ -0,0 +1,353 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// Flags of an entry in a stream listpack
+const (
+	streamItemFlagDeleted    = 1 << 0
+	streamItemFlagSameFields = 1 << 1
+)
+
+// parseStreamValue reads a stream: its listpack nodes, then its metadata,
+// then its consumer groups with their PELs and consumers. TypeStream has
+// no first ID, max deleted ID, entries added or entries read, which came
+// with TypeStreamListpack, and TypeStreamListpack2 added the active time of
+// consumers.
+func parseStreamValue(reader *bufio.Reader, valueType byte) (*store.Stream, error) {
+	stream := store.NewStream()
+
+	nodeCount, _, err := readLength(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream node count: %w", err)
+	}
+	for i := uint64(0); i < nodeCount; i++ {
+		nodeKey, err := readString(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stream node %d key: %w", i, err)
+		}
+		if len(nodeKey) != 16 {
+			return nil, fmt.Errorf("stream node %d key is %d bytes, expected 16", i, len(nodeKey))
+		}
+		data, err := readString(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stream node %d: %w", i, err)
+		}
+		if err := parseStreamListpack(stream, parseRawStreamID([]byte(nodeKey)), []byte(data)); err != nil {
+			return nil, fmt.Errorf("stream node %d: %w", i, err)
+		}
+	}
+
+	length, _, err := readLength(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream length: %w", err)
+	}
+	if int(length) != stream.Len() {
+		return nil, fmt.Errorf("stream length is %d but %d entries were read", length, stream.Len())
+	}
+	if stream.LastID, err = readStreamID(reader); err != nil {
+		return nil, fmt.Errorf("failed to read stream last ID: %w", err)
+	}
+
+	if valueType == TypeStream {
+		// Older dumps only know how many entries there are now
+		stream.EntriesAdded = int64(length)
+	} else {
+		// The first ID is worked out from the entries
+		if _, err := readStreamID(reader); err != nil {
+			return nil, fmt.Errorf("failed to read stream first ID: %w", err)
+		}
+		if stream.MaxDeletedID, err = readStreamID(reader); err != nil {
+			return nil, fmt.Errorf("failed to read stream max deleted ID: %w", err)
+		}
+		entriesAdded, _, err := readLength(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stream entries added: %w", err)
+		}
+		stream.EntriesAdded = int64(entriesAdded)
+	}
+
+	groupCount, _, err := readLength(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stream group count: %w", err)
+	}
+	for i := uint64(0); i < groupCount; i++ {
+		if err := parseStreamGroup(reader, stream, valueType); err != nil {
+			return nil, err
+		}
+	}
+
+	return stream, nil
+}
+
+// parseStreamListpack adds the live entries of one listpack node to the
+// stream. The node starts with a master entry:
+//
+//	count | deleted | field count | field names... | 0
+//
+// and every entry after it is
+//
+//	flags | ms delta | seq delta | [field count] | [field] value ... | lp-count
+//
+// with field names left out when the entry has the master's fields. IDs
+// are deltas from master, the ID the node is keyed by.
+func parseStreamListpack(stream *store.Stream, master store.StreamID, data []byte) error {
+	elements, err := parseListpack(data)
+	if err != nil {
+		return err
+	}
+
+	pos := 0
+	next := func() (string, error) {
+		if pos >= len(elements) {
+			return "", fmt.Errorf("listpack ends inside an entry")
+		}
+		pos++
+		return elements[pos-1], nil
+	}
+	nextInt := func() (int64, error) {
+		s, err := next()
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("expected an integer, got '%s'", s)
+		}
+		return n, nil
+	}
+
+	// Master entry
+	count, err := nextInt()
+	if err != nil {
+		return err
+	}
+	deleted, err := nextInt()
+	if err != nil {
+		return err
+	}
+	masterFieldCount, err := nextInt()
+	if err != nil {
+		return err
+	}
+	if masterFieldCount < 0 || int(masterFieldCount) > len(elements) {
+		return fmt.Errorf("invalid master field count %d", masterFieldCount)
+	}
+	masterFields := make([]string, masterFieldCount)
+	for i := range masterFields {
+		if masterFields[i], err = next(); err != nil {
+			return err
+		}
+	}
+	if terminator, err := nextInt(); err != nil || terminator != 0 {
+		return fmt.Errorf("master entry is not terminated by 0")
+	}
+
+	live, dead := int64(0), int64(0)
+	for pos < len(elements) {
+		flags, err := nextInt()
+		if err != nil {
+			return err
+		}
+		msDelta, err := nextInt()
+		if err != nil {
+			return err
+		}
+		seqDelta, err := nextInt()
+		if err != nil {
+			return err
+		}
+		id := store.StreamID{
+			Timestamp: master.Timestamp + msDelta,
+			Sequence:  master.Sequence + seqDelta,
+		}
+
+		var fields []string
+		if flags&streamItemFlagSameFields != 0 {
+			fields = make([]string, 0, 2*len(masterFields))
+			for _, name := range masterFields {
+				value, err := next()
+				if err != nil {
+					return err
+				}
+				fields = append(fields, name, value)
+			}
+		} else {
+			fieldCount, err := nextInt()
+			if err != nil {
+				return err
+			}
+			if fieldCount < 0 || int(fieldCount) > len(elements) {
+				return fmt.Errorf("invalid field count %d", fieldCount)
+			}
+			fields = make([]string, 2*fieldCount)
+			for i := range fields {
+				if fields[i], err = next(); err != nil {
+					return err
+				}
+			}
+		}
+
+		// lp-count, used by Redis to walk the node backwards
+		if _, err := nextInt(); err != nil {
+			return err
+		}
+
+		if flags&streamItemFlagDeleted != 0 {
+			dead++
+			continue
+		}
+		live++
+		if err := stream.AppendEntry(id, fields); err != nil {
+			return err
+		}
+	}
+
+	if live != count || dead != deleted {
+		return fmt.Errorf("master entry counts %d live and %d deleted entries, found %d and %d", count, deleted, live, dead)
+	}
+	return nil
+}
+
+// parseStreamGroup reads a consumer group: its name, last ID, entries read
+// and global PEL, then its consumers, each with the IDs of the PEL entries
+// it owns
+func parseStreamGroup(reader *bufio.Reader, stream *store.Stream, valueType byte) error {
+	name, err := readString(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read consumer group name: %w", err)
+	}
+	lastID, err := readStreamID(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read last ID of group '%s': %w", name, err)
+	}
+
+	// Left unknown for older dumps, the first read works it out
+	entriesRead := int64(-1)
+	if valueType != TypeStream {
+		n, _, err := readLength(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read entries read of group '%s': %w", name, err)
+		}
+		entriesRead = int64(n)
+	}
+
+	group, err := stream.CreateGroup(name, lastID, entriesRead)
+	if err != nil {
+		return err
+	}
+
+	pelSize, _, err := readLength(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read PEL size of group '%s': %w", name, err)
+	}
+	for i := uint64(0); i < pelSize; i++ {
+		id, err := readRawStreamID(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read PEL entry of group '%s': %w", name, err)
+		}
+		deliveryTime, err := readMillisecondTime(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read delivery time of PEL entry %s: %w", id, err)
+		}
+		deliveryCount, _, err := readLength(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read delivery count of PEL entry %s: %w", id, err)
+		}
+		if err := group.AddPending(id, deliveryTime, int64(deliveryCount)); err != nil {
+			return err
+		}
+	}
+
+	consumerCount, _, err := readLength(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read consumer count of group '%s': %w", name, err)
+	}
+	for i := uint64(0); i < consumerCount; i++ {
+		consumerName, err := readString(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read consumer name in group '%s': %w", name, err)
+		}
+		seenTime, err := readMillisecondTime(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read seen time of consumer '%s': %w", consumerName, err)
+		}
+		activeTime := seenTime
+		if valueType == TypeStreamListpack2 {
+			if activeTime, err = readMillisecondTime(reader); err != nil {
+				return fmt.Errorf("failed to read active time of consumer '%s': %w", consumerName, err)
+			}
+		}
+
+		consumer, err := group.AddConsumer(consumerName, seenTime, activeTime)
+		if err != nil {
+			return err
+		}
+
+		ownedCount, _, err := readLength(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read PEL size of consumer '%s': %w", consumerName, err)
+		}
+		for j := uint64(0); j < ownedCount; j++ {
+			id, err := readRawStreamID(reader)
+			if err != nil {
+				return fmt.Errorf("failed to read PEL entry of consumer '%s': %w", consumerName, err)
+			}
+			if err := group.AssignPending(consumer, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return group.CheckPending()
+}
+
+// readStreamID reads an ID saved as two lengths, milliseconds then sequence
+func readStreamID(reader *bufio.Reader) (store.StreamID, error) {
+	ms, _, err := readLength(reader)
+	if err != nil {
+		return store.StreamID{}, err
+	}
+	seq, _, err := readLength(reader)
+	if err != nil {
+		return store.StreamID{}, err
+	}
+	return store.StreamID{Timestamp: int64(ms), Sequence: int64(seq)}, nil
+}
+
+// readRawStreamID reads an ID saved as 16 big-endian bytes
+func readRawStreamID(reader *bufio.Reader) (store.StreamID, error) {
+	buf, err := readBytes(reader, 16)
+	if err != nil {
+		return store.StreamID{}, err
+	}
+	return parseRawStreamID(buf), nil
+}
+
+func parseRawStreamID(buf []byte) store.StreamID {
+	return store.StreamID{
+		Timestamp: int64(binary.BigEndian.Uint64(buf[:8])),
+		Sequence:  int64(binary.BigEndian.Uint64(buf[8:])),
+	}
+}
+
+// readMillisecondTime reads a unix time in milliseconds, saved as 8
+// little-endian bytes. -1, which Redis saves for a consumer that never got
+// an entry, is the zero time.
+func readMillisecondTime(reader *bufio.Reader) (time.Time, error) {
+	ms, err := readUint64(reader)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if int64(ms) == -1 {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(int64(ms)), nil
+}
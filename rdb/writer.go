@@ -0,0 +1,361 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// Files are written in the same version as FUNCTION DUMP payloads, with
+// strings saved as is, lists as TypeList, sorted sets as TypeZSet2 and
+// streams as TypeStreamListpack2.
+
+// streamNodeMaxEntries is how many entries go in one listpack node of a
+// stream, the default of stream-node-max-entries
+const streamNodeMaxEntries = 100
+
+// Snapshot encodes the keyspace and the code of function libraries into an
+// RDB file
+func Snapshot(functions []string) []byte {
+	return encodeFile(functions, true)
+}
+
+// FunctionsSnapshot encodes an RDB file holding only the code of function
+// libraries, as sent to replicas on a full resynchronization
+func FunctionsSnapshot(functions []string) []byte {
+	return encodeFile(functions, false)
+}
+
+// Save writes a snapshot to path. It is written to a temporary file that
+// replaces path once complete, so a failed save leaves the old file.
+func Save(path string, functions []string) error {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmp, err)
+	}
+
+	_, err = file.Write(Snapshot(functions))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+func encodeFile(functions []string, withKeys bool) []byte {
+	buf := fmt.Appendf(nil, "REDIS%04d", dumpVersion)
+	buf = appendAux(buf, "redis-ver", "7.2.0")
+	buf = appendAux(buf, "redis-bits", "64")
+	buf = appendAux(buf, "ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	buf = appendAux(buf, "aof-base", "0")
+
+	for _, code := range functions {
+		buf = append(buf, OpFunction2)
+		buf = appendString(buf, code)
+	}
+
+	if withKeys {
+		var keys []byte
+		keyCount, expireCount := 0, 0
+		store.ForEachKey(func(key string, value *store.RedisValue) {
+			var ok bool
+			if keys, ok = appendKeyValue(keys, key, value); !ok {
+				return
+			}
+			keyCount++
+			if value.Expiry != nil {
+				expireCount++
+			}
+		})
+
+		if keyCount > 0 {
+			buf = append(buf, OpSelectDB, 0)
+			buf = append(buf, OpResizeDB)
+			buf = appendLength(buf, uint64(keyCount))
+			buf = appendLength(buf, uint64(expireCount))
+			buf = append(buf, keys...)
+		}
+	}
+
+	buf = append(buf, OpEOF)
+	var crc crc64Jones
+	crc.Write(buf)
+	return binary.LittleEndian.AppendUint64(buf, uint64(crc))
+}
+
+func appendAux(buf []byte, key, value string) []byte {
+	buf = append(buf, OpAux)
+	buf = appendString(buf, key)
+	return appendString(buf, value)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendLength(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// appendKeyValue appends a key with its expiry and value, reporting false
+// for a value of a type it cannot save
+func appendKeyValue(buf []byte, key string, value *store.RedisValue) ([]byte, bool) {
+	var valueType byte
+	var encoded []byte
+	switch value.Type {
+	case store.STRING:
+		valueType = TypeString
+		encoded = appendString(nil, string(value.Bytes))
+
+	case store.LIST:
+		valueType = TypeList
+		encoded = appendLength(nil, uint64(value.List.Len()))
+		value.List.Iterate(false, func(_ int, element string) bool {
+			encoded = appendString(encoded, element)
+			return true
+		})
+
+	case store.ZSET:
+		valueType = TypeZSet2
+		entries := value.ZSet.RangeByRank(0, value.ZSet.Len()-1)
+		encoded = appendLength(nil, uint64(len(entries)))
+		for _, entry := range entries {
+			encoded = appendString(encoded, entry.Member)
+			encoded = binary.LittleEndian.AppendUint64(encoded, math.Float64bits(entry.Score))
+		}
+
+	case store.STREAM:
+		valueType = TypeStreamListpack2
+		encoded = appendStream(nil, value.Stream)
+
+	default:
+		return buf, false
+	}
+
+	if value.Expiry != nil {
+		buf = append(buf, OpExpireTimeMs)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(value.Expiry.UnixMilli()))
+	}
+	buf = append(buf, valueType)
+	buf = appendString(buf, key)
+	return append(buf, encoded...), true
+}
+
+// appendStream appends a stream the way parseStreamValue reads it, with
+// its entries in listpack nodes of up to streamNodeMaxEntries
+func appendStream(buf []byte, stream *store.Stream) []byte {
+	entries := stream.Entries()
+
+	nodeCount := (len(entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	buf = appendLength(buf, uint64(nodeCount))
+	for start := 0; start < len(entries); start += streamNodeMaxEntries {
+		node := entries[start:min(start+streamNodeMaxEntries, len(entries))]
+		buf = appendString(buf, string(appendRawStreamID(nil, node[0].ID)))
+		buf = appendString(buf, string(encodeStreamListpack(node)))
+	}
+
+	var firstID store.StreamID
+	if len(entries) > 0 {
+		firstID = entries[0].ID
+	}
+	buf = appendLength(buf, uint64(len(entries)))
+	buf = appendStreamID(buf, stream.LastID)
+	buf = appendStreamID(buf, firstID)
+	buf = appendStreamID(buf, stream.MaxDeletedID)
+	buf = appendLength(buf, uint64(stream.EntriesAdded))
+
+	names := make([]string, 0, len(stream.Groups))
+	for name := range stream.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf = appendLength(buf, uint64(len(names)))
+	for _, name := range names {
+		buf = appendStreamGroup(buf, stream.Groups[name])
+	}
+	return buf
+}
+
+// encodeStreamListpack encodes a node: a master entry with the fields of
+// the first entry, then the entries, see parseStreamListpack
+func encodeStreamListpack(entries []store.StreamEntry) []byte {
+	master := entries[0].ID
+	var masterFields []string
+	for i := 0; i < len(entries[0].Fields); i += 2 {
+		masterFields = append(masterFields, entries[0].Fields[i])
+	}
+
+	elements := []string{
+		strconv.Itoa(len(entries)), "0", strconv.Itoa(len(masterFields)),
+	}
+	elements = append(elements, masterFields...)
+	elements = append(elements, "0")
+
+	for _, entry := range entries {
+		sameFields := len(entry.Fields) == 2*len(masterFields)
+		for i := 0; sameFields && i < len(masterFields); i++ {
+			sameFields = entry.Fields[2*i] == masterFields[i]
+		}
+
+		flags := 0
+		if sameFields {
+			flags |= streamItemFlagSameFields
+		}
+		elements = append(elements,
+			strconv.Itoa(flags),
+			strconv.FormatInt(entry.ID.Timestamp-master.Timestamp, 10),
+			strconv.FormatInt(entry.ID.Sequence-master.Sequence, 10))
+
+		fieldCount := len(entry.Fields) / 2
+		if sameFields {
+			for i := 1; i < len(entry.Fields); i += 2 {
+				elements = append(elements, entry.Fields[i])
+			}
+			elements = append(elements, strconv.Itoa(fieldCount+3))
+		} else {
+			elements = append(elements, strconv.Itoa(fieldCount))
+			elements = append(elements, entry.Fields...)
+			elements = append(elements, strconv.Itoa(2*fieldCount+4))
+		}
+	}
+	return encodeListpack(elements)
+}
+
+// appendStreamGroup appends a consumer group, see parseStreamGroup
+func appendStreamGroup(buf []byte, group *store.ConsumerGroup) []byte {
+	buf = appendString(buf, group.Name)
+	buf = appendStreamID(buf, group.LastID)
+	buf = appendLength(buf, uint64(group.EntriesRead))
+
+	buf = appendLength(buf, uint64(len(group.Pending)))
+	for _, id := range sortedPendingIDs(group.Pending) {
+		pending := group.Pending[id]
+		buf = appendRawStreamID(buf, id)
+		buf = appendMillisecondTime(buf, pending.DeliveryTime)
+		buf = appendLength(buf, uint64(pending.DeliveryCount))
+	}
+
+	names := make([]string, 0, len(group.Consumers))
+	for name := range group.Consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf = appendLength(buf, uint64(len(names)))
+	for _, name := range names {
+		consumer := group.Consumers[name]
+		buf = appendString(buf, consumer.Name)
+		buf = appendMillisecondTime(buf, consumer.SeenTime)
+		buf = appendMillisecondTime(buf, consumer.ActiveTime)
+		buf = appendLength(buf, uint64(len(consumer.Pending)))
+		for _, id := range sortedPendingIDs(consumer.Pending) {
+			buf = appendRawStreamID(buf, id)
+		}
+	}
+	return buf
+}
+
+func sortedPendingIDs(pending map[store.StreamID]*store.PendingEntry) []store.StreamID {
+	ids := make([]store.StreamID, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Compare(ids[j]) < 0 })
+	return ids
+}
+
+// appendStreamID appends an ID as two lengths, see readStreamID
+func appendStreamID(buf []byte, id store.StreamID) []byte {
+	buf = appendLength(buf, uint64(id.Timestamp))
+	return appendLength(buf, uint64(id.Sequence))
+}
+
+// appendRawStreamID appends an ID as 16 big-endian bytes
+func appendRawStreamID(buf []byte, id store.StreamID) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, uint64(id.Timestamp))
+	return binary.BigEndian.AppendUint64(buf, uint64(id.Sequence))
+}
+
+// appendMillisecondTime appends a unix time in milliseconds, the zero time
+// as -1, see readMillisecondTime
+func appendMillisecondTime(buf []byte, t time.Time) []byte {
+	ms := int64(-1)
+	if !t.IsZero() {
+		ms = t.UnixMilli()
+	}
+	return binary.LittleEndian.AppendUint64(buf, uint64(ms))
+}
+
+// encodeListpack encodes elements into a listpack, integers in their
+// integer encodings, see parseListpack
+func encodeListpack(elements []string) []byte {
+	buf := make([]byte, 6, 64)
+	for _, element := range elements {
+		start := len(buf)
+		buf = appendListpackEntry(buf, element)
+		buf = appendListpackBacklen(buf, len(buf)-start)
+	}
+	buf = append(buf, 0xFF)
+
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[4:], uint16(min(len(elements), 0xFFFF)))
+	return buf
+}
+
+// appendListpackEntry appends the encoding and data of an entry
+func appendListpackEntry(buf []byte, element string) []byte {
+	if n, err := strconv.ParseInt(element, 10, 64); err == nil && strconv.FormatInt(n, 10) == element {
+		switch {
+		case n >= 0 && n <= 127:
+			return append(buf, byte(n))
+		case n >= -4096 && n <= 4095:
+			return append(buf, 0xC0|byte(n>>8)&0x1F, byte(n))
+		case n >= math.MinInt16 && n <= math.MaxInt16:
+			return binary.LittleEndian.AppendUint16(append(buf, 0xF1), uint16(n))
+		case n >= -1<<23 && n < 1<<23:
+			return append(buf, 0xF2, byte(n), byte(n>>8), byte(n>>16))
+		case n >= math.MinInt32 && n <= math.MaxInt32:
+			return binary.LittleEndian.AppendUint32(append(buf, 0xF3), uint32(n))
+		default:
+			return binary.LittleEndian.AppendUint64(append(buf, 0xF4), uint64(n))
+		}
+	}
+
+	switch n := len(element); {
+	case n < 1<<6:
+		buf = append(buf, 0x80|byte(n))
+	case n < 1<<12:
+		buf = append(buf, 0xE0|byte(n>>8), byte(n))
+	default:
+		buf = binary.LittleEndian.AppendUint32(append(buf, 0xF0), uint32(n))
+	}
+	return append(buf, element...)
+}
+
+// appendListpackBacklen appends the size of an entry the way it is read
+// from the end: 7 bits per byte, most significant first, with the high bit
+// set on all bytes but the first
+func appendListpackBacklen(buf []byte, size int) []byte {
+	n := listpackBacklenSize(size)
+	for i := n - 1; i >= 0; i-- {
+		b := byte(size>>(7*i)) & 0x7F
+		if i != n-1 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
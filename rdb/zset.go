@@ -0,0 +1,98 @@
+package rdb
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/kushalsdesk/redis_with_go/store"
+)
+
+// parseSortedSetValue reads a sorted set, rejecting NaN scores and members
+// saved twice
+func parseSortedSetValue(reader *bufio.Reader, valueType byte) ([]store.ZSetEntry, error) {
+	entries, err := readSortedSetEntries(reader, valueType)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if math.IsNaN(entry.Score) {
+			return nil, fmt.Errorf("NaN score for member '%s'", entry.Member)
+		}
+		if seen[entry.Member] {
+			return nil, fmt.Errorf("duplicated member '%s'", entry.Member)
+		}
+		seen[entry.Member] = true
+	}
+	return entries, nil
+}
+
+// readSortedSetEntries reads the entries of a sorted set. TypeSortedSet and
+// TypeZSet2 save each member followed by its score, as text and as a
+// binary double. The ziplist and listpack encodings alternate members and
+// scores in a single container.
+func readSortedSetEntries(reader *bufio.Reader, valueType byte) ([]store.ZSetEntry, error) {
+	switch valueType {
+	case TypeSortedSet, TypeZSet2:
+		length, _, err := readLength(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sorted set length: %w", err)
+		}
+
+		entries := make([]store.ZSetEntry, 0, min(length, 1024))
+		for i := uint64(0); i < length; i++ {
+			member, err := readString(reader)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read sorted set member %d: %w", i, err)
+			}
+
+			var score float64
+			if valueType == TypeZSet2 {
+				score, err = readBinaryDouble(reader)
+			} else {
+				score, err = readDouble(reader)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read score of member '%s': %w", member, err)
+			}
+
+			entries = append(entries, store.ZSetEntry{Member: member, Score: score})
+		}
+		return entries, nil
+
+	case TypeSortedSetZL, TypeSortedSetListpack:
+		data, err := readString(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sorted set container: %w", err)
+		}
+
+		var elements []string
+		if valueType == TypeSortedSetZL {
+			elements, err = parseZiplist([]byte(data))
+		} else {
+			elements, err = parseListpack([]byte(data))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(elements)%2 != 0 {
+			return nil, fmt.Errorf("sorted set container has an odd number of elements: %d", len(elements))
+		}
+
+		entries := make([]store.ZSetEntry, 0, len(elements)/2)
+		for i := 0; i < len(elements); i += 2 {
+			score, err := strconv.ParseFloat(elements[i+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid score '%s' of member '%s'", elements[i+1], elements[i])
+			}
+			entries = append(entries, store.ZSetEntry{Member: elements[i], Score: score})
+		}
+		return entries, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported sorted set type: 0x%02X", valueType)
+	}
+}
@@ -2,6 +2,8 @@ package handler
 
 import (
 	"bufio"
+	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"strings"
@@ -10,38 +12,74 @@ import (
 	"github.com/kushalsdesk/redis_with_go/store"
 )
 
+// pendingCommands is how many parsed commands may queue up behind one that
+// is still running, typically a blocking command followed by a pipeline
+const pendingCommands = 64
+
+// protectedModeReply is sent to clients refused by protected mode before
+// their connection is closed
+const protectedModeReply = "-DENIED Redis is running in protected mode because protected mode is enabled and no password is set for the default user. " +
+	"In this mode connections are only accepted from the loopback interface. " +
+	"If you want to connect from external computers, either restart the server with '--requirepass <password>', " +
+	"or, if it is not reachable from the internet, with '--protected-mode no'.\r\n"
+
 func HandleConnection(conn net.Conn) {
 	defer func() {
 		store.RemoveReplicaByConnection(conn)
 		conn.Close()
 	}()
 
-	reader := bufio.NewReader(conn)
+	if store.GetConfig().ProtectedMode && commands.DefaultUserHasNoPassword() && !isLoopback(conn) {
+		fmt.Printf("🛡️  Refused %s: protected mode without a password\n", conn.RemoteAddr())
+		conn.Write([]byte(protectedModeReply))
+		return
+	}
 
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			return
-		}
+	commands.RegisterClient(conn)
 
-		line = strings.TrimSpace(line)
+	// Commands are read on their own goroutine so a client blocked in BLPOP
+	// or XREAD is unregistered as soon as its connection closes, instead of
+	// lingering until its timeout
+	requests := make(chan []string, pendingCommands)
+	go func() {
+		defer close(requests)
+		defer commands.UnregisterClient(conn)
 
-		if strings.HasPrefix(line, "*") {
-			// Parse RESP array format
-			parts := parseRESPArray(reader, line)
-			if len(parts) > 0 {
-				commands.Dispatch(parts, conn)
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			line = strings.TrimSpace(line)
+
+			var parts []string
+			if strings.HasPrefix(line, "*") {
+				// Parse RESP array format
+				parts = parseRESPArray(reader, line)
+			} else {
+				// Parse simple string format
+				parts = strings.Fields(line)
 			}
-		} else {
-			// Parse simple string format
-			parts := strings.Fields(line)
 			if len(parts) > 0 {
-				commands.Dispatch(parts, conn)
+				requests <- parts
 			}
 		}
+	}()
+
+	for parts := range requests {
+		commands.Dispatch(parts, conn)
 	}
 }
 
+// isLoopback tells whether conn comes from this host. Connections other
+// than TCP ones are local.
+func isLoopback(conn net.Conn) bool {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	return !ok || addr.IP.IsLoopback()
+}
+
 func parseRESPArray(reader *bufio.Reader, line string) []string {
 	numArgsStr := strings.TrimPrefix(line, "*")
 	numArgs, err := strconv.Atoi(numArgsStr)
@@ -51,19 +89,37 @@ func parseRESPArray(reader *bufio.Reader, line string) []string {
 
 	var parts []string
 	for i := 0; i < numArgs; i++ {
-		// Skip bulk string length line
-		_, err := reader.ReadString('\n')
+		content, err := ReadBulkString(reader)
 		if err != nil {
 			return nil
 		}
-
-		// Read actual content
-		content, err := reader.ReadString('\n')
-		if err != nil {
-			return nil
-		}
-		parts = append(parts, strings.TrimSpace(content))
+		parts = append(parts, content)
 	}
 
 	return parts
 }
+
+// ReadBulkString reads one RESP bulk string ($<len>\r\n<bytes>\r\n).
+// The payload is read by length, so it may contain any byte including CR/LF.
+func ReadBulkString(reader *bufio.Reader) (string, error) {
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	header = strings.TrimSpace(header)
+	if !strings.HasPrefix(header, "$") {
+		return "", fmt.Errorf("expected bulk string, got %q", header)
+	}
+
+	length, err := strconv.Atoi(header[1:])
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("invalid bulk string length %q", header)
+	}
+
+	buf := make([]byte, length+2)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", err
+	}
+	return string(buf[:length]), nil
+}
@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/kushalsdesk/redis_with_go/commands"
+	"github.com/kushalsdesk/redis_with_go/rdb"
+	"github.com/kushalsdesk/redis_with_go/server/handler"
 	"github.com/kushalsdesk/redis_with_go/store"
 )
 
@@ -24,7 +28,7 @@ func StartReplicationClient(serverPort string) {
 }
 
 func performReplicationHandshake(masterHost, masterPort, serverPort string) {
-	masterAddr := fmt.Sprintf("%s:%s", masterHost, masterPort)
+	masterAddr := net.JoinHostPort(masterHost, masterPort)
 	conn, err := net.Dial("tcp", masterAddr)
 	if err != nil {
 		fmt.Printf("❌ Failed to connect to master %s: %v\n", masterAddr, err)
@@ -48,14 +52,35 @@ func performReplicationHandshake(masterHost, masterPort, serverPort string) {
 }
 
 func performHandshakeSteps(conn net.Conn, serverPort string) bool {
-	// Step 1: PING
-	if !sendCommand(conn, "*1\r\n$4\r\nPING\r\n") ||
-		!expectResponse(conn, "+PONG") {
+	// Step 1: PING. A master with a password answers NOAUTH until the
+	// replica has authenticated, which is as good as a PONG here.
+	if !sendCommand(conn, "*1\r\n$4\r\nPING\r\n") {
 		fmt.Printf("❌ PING handshake failed\n")
 		return false
 	}
+	if response, ok := readResponse(conn); !ok || (response != "+PONG" && !strings.HasPrefix(response, "-NOAUTH")) {
+		fmt.Printf("❌ PING handshake failed: %s\n", response)
+		return false
+	}
 	fmt.Printf("✅ PING successful\n")
 
+	// AUTH with masterauth, and masteruser when there is one
+	if config := store.GetConfig(); config.MasterAuth != "" {
+		authArgs := []string{"AUTH", config.MasterAuth}
+		if config.MasterUser != "" {
+			authArgs = []string{"AUTH", config.MasterUser, config.MasterAuth}
+		}
+		if !sendCommand(conn, string(commands.EncodeRESPArray(authArgs))) {
+			fmt.Printf("❌ AUTH handshake failed\n")
+			return false
+		}
+		if response, ok := readResponse(conn); !ok || response != "+OK" {
+			fmt.Printf("❌ AUTH with the master failed: %s\n", response)
+			return false
+		}
+		fmt.Printf("✅ AUTH successful\n")
+	}
+
 	// Step 2: REPLCONF listening-port
 	replconfCmd := fmt.Sprintf("*3\r\n$8\r\nREPLCONF\r\n$14\r\nlistening-port\r\n$%d\r\n%s\r\n",
 		len(serverPort), serverPort)
@@ -111,6 +136,8 @@ func listenForPropagatedCommands(conn net.Conn, reader *bufio.Reader) {
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			fmt.Printf("📡 Connection to master lost: %v\n", err)
+			// A transaction cut off by the disconnect never happened
+			replicatedTransaction = nil
 			return
 		}
 
@@ -134,19 +161,12 @@ func processRESPArray(reader *bufio.Reader, line string) bool {
 
 	var parts []string
 	for i := 0; i < numArgs; i++ {
-		// Read bulk string header
-		_, err := reader.ReadString('\n')
-		if err != nil {
-			fmt.Printf("❌ Failed to read bulk string header: %v\n", err)
-			return false
-		}
-
-		content, err := reader.ReadString('\n')
+		content, err := handler.ReadBulkString(reader)
 		if err != nil {
-			fmt.Printf("❌ Failed to read bulk string content: %v\n", err)
+			fmt.Printf("❌ Failed to read bulk string: %v\n", err)
 			return false
 		}
-		parts = append(parts, strings.TrimSpace(content))
+		parts = append(parts, content)
 	}
 
 	if len(parts) > 0 {
@@ -159,20 +179,44 @@ func processRESPArray(reader *bufio.Reader, line string) bool {
 	return true
 }
 
+// replicatedTransaction collects the commands of a MULTI/EXEC block from the
+// master until its EXEC, nil outside of one. Only the goroutine reading from
+// the master touches it.
+var replicatedTransaction [][]string
+
 func processReplicatedCommand(args []string) {
 	if len(args) == 0 {
 		return
 	}
 
 	oldOffset := store.GetSlaveOffset()
+	switch command := strings.ToUpper(args[0]); {
+	case command == "MULTI":
+		replicatedTransaction = [][]string{}
+	case command == "EXEC" && replicatedTransaction != nil:
+		// Applied as a unit, like the transaction ran on the master
+		store.BeginExclusive()
+		for _, queued := range replicatedTransaction {
+			applyReplicatedCommand(queued)
+		}
+		store.EndExclusive()
+		replicatedTransaction = nil
+	case replicatedTransaction != nil:
+		replicatedTransaction = append(replicatedTransaction, args)
+	default:
+		store.BeginCommand()
+		applyReplicatedCommand(args)
+		store.EndCommand()
+	}
+
+	cmdSize := store.EstimateCommandSize(args)
+	newOffset := oldOffset + cmdSize
+	store.SetSlaveOffset(newOffset)
+}
+
+func applyReplicatedCommand(args []string) {
 	command := strings.ToUpper(args[0])
 	switch command {
-	case "SET":
-		if len(args) >= 3 {
-			key, value := args[1], args[2]
-			store.Set(key, value, 0)
-			fmt.Printf("✅ Replicated SET %s = %s\n", key, value)
-		}
 	case "DEL":
 		if len(args) >= 2 {
 			key := args[1]
@@ -298,13 +342,13 @@ func processReplicatedCommand(args []string) {
 			}
 		}
 	default:
-		fmt.Printf("⚠️ Unknown replicated command: %s\n", command)
+		reply := commands.ExecuteReplicated(args)
+		if strings.HasPrefix(reply, "-ERR unknown command") {
+			fmt.Printf("⚠️ Unknown replicated command: %s\n", command)
+		} else {
+			fmt.Printf("✅ Replicated %s %v\n", command, args[1:])
+		}
 	}
-
-	cmdSize := store.EstimateCommandSize(args)
-	newOffset := oldOffset + cmdSize
-	store.SetSlaveOffset(newOffset)
-
 }
 
 func receiveRDB(reader *bufio.Reader) bool {
@@ -346,13 +390,36 @@ func receiveRDB(reader *bufio.Reader) bool {
 
 	fmt.Printf("📦 Received RDB file (%d bytes)\n", rdbLength)
 
-	if validateRDB(rdbData) {
-		fmt.Printf("✅ RDB validation successful\n")
-		return true
+	if !validateRDB(rdbData) {
+		fmt.Printf("❌ RDB validation failed\n")
+		return false
+	}
+	fmt.Printf("✅ RDB validation successful\n")
+
+	if err := loadReceivedRDB(rdbData); err != nil {
+		fmt.Printf("❌ Failed to load RDB from master: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// loadReceivedRDB loads what the master sent, its function libraries in
+// particular, going through a temporary file like an RDB read at startup
+func loadReceivedRDB(rdbData []byte) error {
+	file, err := os.CreateTemp("", "replica-*.rdb")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(file.Name())
 
-	fmt.Printf("❌ RDB validation failed\n")
-	return false
+	_, err = file.Write(rdbData)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return rdb.LoadRDB(file.Name(), commands.LoadFunctionLibrary)
 }
 
 func validateRDB(rdbData []byte) bool {
@@ -381,15 +448,19 @@ func sendCommand(conn net.Conn, command string) bool {
 }
 
 func expectResponse(conn net.Conn, expected string) bool {
+	response, ok := readResponse(conn)
+	return ok && response == expected
+}
+
+// readResponse reads a single line reply of the master
+func readResponse(conn net.Conn) (string, bool) {
 	reader := bufio.NewReader(conn)
 	response, err := reader.ReadString('\n')
 	if err != nil {
 		fmt.Printf("❌ Failed to read response: %v\n", err)
-		return false
+		return "", false
 	}
-
-	response = strings.TrimSpace(response)
-	return response == expected
+	return strings.TrimSpace(response), true
 }
 
 //sendACK sends REPLCONF ACK <offset> to master
@@ -0,0 +1,492 @@
+package store
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// MaxBitOffset is the highest bit addressable in a string (512MB worth of bits)
+const MaxBitOffset = MaxStringLength*8 - 1
+
+// BitOverflow selects how BITFIELD SET/INCRBY handle values that do not fit
+type BitOverflow int
+
+const (
+	OverflowWrap BitOverflow = iota
+	OverflowSat
+	OverflowFail
+)
+
+// BitFieldOpKind is the kind of a single BITFIELD operation
+type BitFieldOpKind int
+
+const (
+	BitFieldGet BitFieldOpKind = iota
+	BitFieldSet
+	BitFieldIncrBy
+)
+
+// BitFieldOp is one GET/SET/INCRBY of a BITFIELD call, with the OVERFLOW
+// policy that was in effect when it was parsed
+type BitFieldOp struct {
+	Kind     BitFieldOpKind
+	Signed   bool
+	Bits     uint
+	Offset   uint64
+	Value    int64
+	Overflow BitOverflow
+}
+
+// lookupStringForRead returns the bytes of a string key, nil for a missing key.
+// Callers hold dataMutex.RLock.
+func lookupStringForRead(key string) ([]byte, bool, error) {
+	value, exists := lookupKey(key)
+	if !exists {
+		return nil, false, nil
+	}
+	if value.Type != STRING {
+		return nil, false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return value.Bytes, true, nil
+}
+
+// lookupStringForBits returns the string value at key, creating it when
+// missing and zero-padding it so that bit maxBit is addressable.
+// Callers hold dataMutex.Lock.
+func lookupStringForBits(key string, maxBit uint64) (*RedisValue, error) {
+	value, exists := lookupKeyWrite(key)
+	if exists && value.Type != STRING {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if !exists {
+		value = &RedisValue{Type: STRING}
+		setKey(key, value)
+	}
+
+	value.Bytes = growBytes(value.Bytes, int(maxBit>>3)+1)
+	return value, nil
+}
+
+// SetBit sets or clears the bit at offset and returns its previous value
+func SetBit(key string, offset uint64, on bool) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupStringForBits(key, offset)
+	if err != nil {
+		return 0, err
+	}
+
+	byteIdx := offset >> 3
+	mask := byte(1) << (7 - offset&7)
+	old := 0
+	if value.Bytes[byteIdx]&mask != 0 {
+		old = 1
+	}
+
+	if on {
+		value.Bytes[byteIdx] |= mask
+	} else {
+		value.Bytes[byteIdx] &^= mask
+	}
+	touchWatchedKey(key)
+	return old, nil
+}
+
+// GetBit returns the bit at offset, 0 past the end of the string
+func GetBit(key string, offset uint64) (int, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	buf, _, err := lookupStringForRead(key)
+	if err != nil {
+		return 0, err
+	}
+
+	byteIdx := offset >> 3
+	if byteIdx >= uint64(len(buf)) {
+		return 0, nil
+	}
+	return int(buf[byteIdx]>>(7-offset&7)) & 1, nil
+}
+
+// bitRange is a BITCOUNT/BITPOS range as a byte range, plus masks for the
+// bits of the first and last byte that fall outside a range given in bits
+type bitRange struct {
+	startByte, endByte  int64
+	firstMask, lastMask byte
+}
+
+// resolveBitRange normalises negative and out-of-bounds indexes the way Redis
+// does, reporting false when the range is empty
+func resolveBitRange(length, start, end int64, isBit bool) (bitRange, bool) {
+	total := length
+	if isBit {
+		total = length * 8
+	}
+
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= total {
+		end = total - 1
+	}
+	if start > end {
+		return bitRange{}, false
+	}
+
+	r := bitRange{startByte: start, endByte: end}
+	if isBit {
+		r.firstMask = ^byte((1 << (8 - start&7)) - 1)
+		r.lastMask = byte((1 << (7 - end&7)) - 1)
+		r.startByte = start >> 3
+		r.endByte = end >> 3
+	}
+	return r, true
+}
+
+// BitCount counts the set bits of the string at key, optionally restricted to
+// [start, end] expressed in bytes or, with isBit, in bits
+func BitCount(key string, start, end int64, hasRange, isBit bool) (int64, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	buf, exists, err := lookupStringForRead(key)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	length := int64(len(buf))
+	r := bitRange{startByte: 0, endByte: length - 1}
+	if hasRange {
+		if start < 0 && end < 0 && start > end {
+			return 0, nil
+		}
+		var ok bool
+		if r, ok = resolveBitRange(length, start, end, isBit); !ok {
+			return 0, nil
+		}
+	}
+	if r.startByte > r.endByte {
+		return 0, nil
+	}
+
+	count := int64(popcount(buf[r.startByte : r.endByte+1]))
+	count -= int64(bits.OnesCount8(buf[r.startByte] & r.firstMask))
+	count -= int64(bits.OnesCount8(buf[r.endByte] & r.lastMask))
+	return count, nil
+}
+
+func popcount(buf []byte) int {
+	count := 0
+	for len(buf) >= 8 {
+		word := uint64(buf[0]) | uint64(buf[1])<<8 | uint64(buf[2])<<16 | uint64(buf[3])<<24 |
+			uint64(buf[4])<<32 | uint64(buf[5])<<40 | uint64(buf[6])<<48 | uint64(buf[7])<<56
+		count += bits.OnesCount64(word)
+		buf = buf[8:]
+	}
+	for _, b := range buf {
+		count += bits.OnesCount8(b)
+	}
+	return count
+}
+
+// BitPos returns the position of the first bit set to bit. Without an explicit
+// end, looking for a 0 in an all-ones range reports the first bit past the
+// string, as if it were padded with zeros; with an end it reports -1.
+func BitPos(key string, bit int, start, end int64, hasStart, hasEnd, isBit bool) (int64, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	buf, exists, err := lookupStringForRead(key)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		if bit == 1 {
+			return -1, nil
+		}
+		return 0, nil
+	}
+
+	if !hasStart {
+		start = 0
+	}
+	if !hasEnd {
+		end = -1
+	}
+
+	r, ok := resolveBitRange(int64(len(buf)), start, end, isBit)
+	if !ok {
+		return -1, nil
+	}
+
+	window := buf[r.startByte : r.endByte+1]
+	pos := int64(-1)
+	for i := range window {
+		// Bits outside the range are cleared when looking for ones and set
+		// when looking for zeros, so they never match
+		var mask byte
+		if i == 0 {
+			mask |= r.firstMask
+		}
+		if i == len(window)-1 {
+			mask |= r.lastMask
+		}
+
+		b := window[i] &^ mask
+		if bit == 0 {
+			b = ^(window[i] | mask)
+		}
+		if b != 0 {
+			pos = int64(i)*8 + int64(bits.LeadingZeros8(b))
+			break
+		}
+	}
+
+	if pos == -1 {
+		if bit == 1 || hasEnd {
+			return -1, nil
+		}
+		pos = int64(len(window)) * 8
+	}
+	return r.startByte*8 + pos, nil
+}
+
+// BitOp stores the bitwise AND/OR/XOR/NOT of the source strings at dest and
+// returns the length of the result. Missing sources count as empty strings,
+// shorter ones are zero-padded. An empty result deletes dest.
+func BitOp(op, dest string, sources []string) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	inputs := make([][]byte, len(sources))
+	maxLen := 0
+	for i, key := range sources {
+		value, exists := lookupKeyWrite(key)
+		if !exists {
+			continue
+		}
+		if value.Type != STRING {
+			return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		inputs[i] = value.Bytes
+		if len(value.Bytes) > maxLen {
+			maxLen = len(value.Bytes)
+		}
+	}
+
+	result := make([]byte, maxLen)
+	for i := 0; i < maxLen; i++ {
+		byteAt := func(src []byte) byte {
+			if i < len(src) {
+				return src[i]
+			}
+			return 0
+		}
+
+		out := byteAt(inputs[0])
+		switch op {
+		case "NOT":
+			out = ^out
+		case "AND":
+			for _, src := range inputs[1:] {
+				out &= byteAt(src)
+			}
+		case "OR":
+			for _, src := range inputs[1:] {
+				out |= byteAt(src)
+			}
+		case "XOR":
+			for _, src := range inputs[1:] {
+				out ^= byteAt(src)
+			}
+		}
+		result[i] = out
+	}
+
+	if maxLen == 0 {
+		deleteKey(dest)
+		return 0, nil
+	}
+
+	setKey(dest, &RedisValue{Type: STRING, Bytes: result})
+	return maxLen, nil
+}
+
+// BitField runs the operations of a BITFIELD call atomically. Each result is
+// nil when an operation was skipped because of OVERFLOW FAIL. changed tells
+// whether anything was written, so the caller knows whether to propagate.
+func BitField(key string, ops []BitFieldOp) (results []*int64, changed bool, err error) {
+	writes := false
+	var maxBit uint64
+	for _, op := range ops {
+		if op.Kind != BitFieldGet {
+			writes = true
+			if end := op.Offset + uint64(op.Bits) - 1; end > maxBit {
+				maxBit = end
+			}
+		}
+	}
+
+	if !writes {
+		expireIfNeeded(key)
+		dataMutex.RLock()
+		defer dataMutex.RUnlock()
+
+		buf, _, err := lookupStringForRead(key)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, op := range ops {
+			v := readBitField(buf, op)
+			results = append(results, &v)
+		}
+		return results, false, nil
+	}
+
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupStringForBits(key, maxBit)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, op := range ops {
+		old := readBitField(value.Bytes, op)
+		if op.Kind == BitFieldGet {
+			results = append(results, &old)
+			continue
+		}
+
+		var newValue int64
+		var overflow bool
+		if op.Kind == BitFieldSet {
+			newValue, overflow = fitBitField(op.Value, 0, op)
+		} else {
+			newValue, overflow = fitBitField(old, op.Value, op)
+		}
+
+		if overflow && op.Overflow == OverflowFail {
+			results = append(results, nil)
+			continue
+		}
+
+		writeBitField(value.Bytes, op.Offset, op.Bits, uint64(newValue))
+		changed = true
+		touchWatchedKey(key)
+
+		if op.Kind == BitFieldSet {
+			results = append(results, &old)
+		} else {
+			results = append(results, &newValue)
+		}
+	}
+	return results, changed, nil
+}
+
+// readBitField reads bits starting at op.Offset, most significant bit first,
+// treating bits past the end of buf as zero
+func readBitField(buf []byte, op BitFieldOp) int64 {
+	var value uint64
+	offset := op.Offset
+	for i := uint(0); i < op.Bits; i++ {
+		byteIdx := offset >> 3
+		var bit uint64
+		if byteIdx < uint64(len(buf)) {
+			bit = uint64(buf[byteIdx]>>(7-offset&7)) & 1
+		}
+		value = value<<1 | bit
+		offset++
+	}
+
+	if op.Signed && op.Bits < 64 && value&(1<<(op.Bits-1)) != 0 {
+		value |= ^uint64(0) << op.Bits
+	}
+	return int64(value)
+}
+
+func writeBitField(buf []byte, offset uint64, width uint, value uint64) {
+	for i := uint(0); i < width; i++ {
+		bit := (value >> (width - 1 - i)) & 1
+		byteIdx := offset >> 3
+		mask := byte(1) << (7 - offset&7)
+		if bit != 0 {
+			buf[byteIdx] |= mask
+		} else {
+			buf[byteIdx] &^= mask
+		}
+		offset++
+	}
+}
+
+// fitBitField computes value+incr for a field of op.Bits bits and applies the
+// overflow policy, reporting whether the result did not fit. Unsigned values
+// are passed as their int64 bit pattern, as BITFIELD parses every value as a
+// signed 64-bit integer.
+func fitBitField(value, incr int64, op BitFieldOp) (int64, bool) {
+	wrap := func() int64 {
+		res := uint64(value) + uint64(incr)
+		if op.Bits < 64 {
+			mask := uint64(1)<<op.Bits - 1
+			res &= mask
+			if op.Signed && res&(1<<(op.Bits-1)) != 0 {
+				res |= ^mask
+			}
+		}
+		return int64(res)
+	}
+
+	if !op.Signed {
+		max := uint64(1)<<op.Bits - 1
+		uvalue := uint64(value)
+		if uvalue > max || (incr > 0 && uint64(incr) > max-uvalue) {
+			if op.Overflow == OverflowSat {
+				return int64(max), true
+			}
+			return wrap(), true
+		}
+		if incr < 0 && uint64(-incr) > uvalue {
+			if op.Overflow == OverflowSat {
+				return 0, true
+			}
+			return wrap(), true
+		}
+		return int64(uvalue + uint64(incr)), false
+	}
+
+	max := int64(uint64(1)<<(op.Bits-1) - 1)
+	min := -max - 1
+	maxIncr := max - value
+	minIncr := min - value
+
+	if value > max || (op.Bits != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr) {
+		if op.Overflow == OverflowSat {
+			return max, true
+		}
+		return wrap(), true
+	}
+	if value < min || (op.Bits != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr) {
+		if op.Overflow == OverflowSat {
+			return min, true
+		}
+		return wrap(), true
+	}
+	return value + incr, false
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Waiter is a client blocked on keys by a list pop (BLPOP, BLMOVE, BLMPOP,
+// ...) or a stream read (XREAD BLOCK, XREADGROUP BLOCK). Waiters queue per key in the order
+// they blocked and are served in that order once a write signals the key.
+type Waiter struct {
+	Keys     []string
+	ClientID int64
+	Response chan BlockingResult
+
+	// Exactly one of these describes what the client waits for
+	ListOp *ListBlockingOp
+	stream *streamWait
+
+	// GroupRead is set for XREADGROUP, whose served reads are propagated
+	GroupRead *StreamGroupRead
+}
+
+// BlockingResult is what a waiter receives: the elements of a list pop, the
+// entries of a stream read, or why it was woken up without data
+type BlockingResult struct {
+	Key      string
+	Elements []string
+	Streams  []StreamReadResult
+
+	TimedOut bool  // CLIENT UNBLOCK ... TIMEOUT
+	Err      error // CLIENT UNBLOCK ... ERROR
+}
+
+// ServedWaiter is a waiter that got its data. The caller propagates what
+// the serving did and then hands the result to the waiter.
+type ServedWaiter struct {
+	Waiter *Waiter
+	Result BlockingResult
+}
+
+var (
+	blockedKeys    = make(map[string][]*Waiter)
+	blockedClients = make(map[int64]*Waiter)
+	blockingMutex  sync.Mutex
+
+	// Keys written while clients were blocked on them, in signal order
+	readyKeys    []string
+	hasReadyKeys atomic.Bool
+
+	errUnblocked = fmt.Errorf("UNBLOCKED client unblocked via CLIENT UNBLOCK")
+)
+
+// block queues a new waiter on all its keys. Callers hold dataMutex and
+// blockingMutex, so nothing can be written between the failed attempt to
+// serve the client and its registration.
+func block(waiter *Waiter) *Waiter {
+	waiter.Response = make(chan BlockingResult, 1)
+	for _, key := range waiter.Keys {
+		blockedKeys[key] = append(blockedKeys[key], waiter)
+	}
+	if waiter.ClientID != 0 {
+		blockedClients[waiter.ClientID] = waiter
+	}
+	return waiter
+}
+
+// UnregisterWaiter removes a waiter that gave up, on timeout or because its
+// connection closed. It reports false when the waiter had already been
+// served or unblocked, its result is then in flight on Response.
+func UnregisterWaiter(waiter *Waiter) bool {
+	blockingMutex.Lock()
+	defer blockingMutex.Unlock()
+	return unregisterWaiter(waiter)
+}
+
+func unregisterWaiter(waiter *Waiter) bool {
+	registered := false
+	for _, key := range waiter.Keys {
+		waiters := blockedKeys[key]
+		for i, w := range waiters {
+			if w == waiter {
+				blockedKeys[key] = append(waiters[:i], waiters[i+1:]...)
+				registered = true
+				break
+			}
+		}
+		// Clean Up empty key entries
+		if len(blockedKeys[key]) == 0 {
+			delete(blockedKeys, key)
+		}
+	}
+	if blockedClients[waiter.ClientID] == waiter {
+		delete(blockedClients, waiter.ClientID)
+	}
+	return registered
+}
+
+// UnblockClient wakes the client with the given id if it is blocked, either
+// as if it timed out or with an UNBLOCKED error
+func UnblockClient(clientID int64, withError bool) bool {
+	blockingMutex.Lock()
+	defer blockingMutex.Unlock()
+
+	waiter, exists := blockedClients[clientID]
+	if !exists || !unregisterWaiter(waiter) {
+		return false
+	}
+
+	result := BlockingResult{TimedOut: true}
+	if withError {
+		result = BlockingResult{Err: errUnblocked}
+	}
+	waiter.Response <- result
+	return true
+}
+
+// signalKeyReady records that key was written. Waiters are not served here
+// but by ServeReadyKeys once the writing command is done, so its reply and
+// propagation come first. Callers hold dataMutex.
+func signalKeyReady(key string) {
+	blockingMutex.Lock()
+	defer blockingMutex.Unlock()
+	markKeyReady(key)
+}
+
+func markKeyReady(key string) {
+	if len(blockedKeys[key]) == 0 {
+		return
+	}
+	for _, ready := range readyKeys {
+		if ready == key {
+			return
+		}
+	}
+	readyKeys = append(readyKeys, key)
+	hasReadyKeys.Store(true)
+}
+
+// ServeReadyKeys hands the data of signalled keys to the clients blocked on
+// them, longest waiting first. A served BLMOVE may fill another key, which
+// is served in the same pass.
+func ServeReadyKeys() []ServedWaiter {
+	if !hasReadyKeys.Load() {
+		return nil
+	}
+
+	dataMutex.Lock()
+	defer unlockData()
+	blockingMutex.Lock()
+	defer blockingMutex.Unlock()
+
+	var served []ServedWaiter
+	for len(readyKeys) > 0 {
+		key := readyKeys[0]
+		readyKeys = readyKeys[1:]
+
+		waiting := append([]*Waiter(nil), blockedKeys[key]...)
+		for _, waiter := range waiting {
+			var result *BlockingResult
+			var err error
+			if waiter.ListOp != nil {
+				result, err = serveListOp(*waiter.ListOp, key)
+			} else {
+				result, err = serveStreamWait(waiter.stream, key)
+			}
+			// A waiter that can't be served (the list drained, no entries
+			// past its ID, a BLMOVE destination of another type) keeps waiting
+			if err != nil || result == nil {
+				continue
+			}
+			unregisterWaiter(waiter)
+			served = append(served, ServedWaiter{Waiter: waiter, Result: *result})
+		}
+	}
+	hasReadyKeys.Store(false)
+
+	return served
+}
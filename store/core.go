@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -15,16 +16,26 @@ const (
 	STRING ValueType = iota
 	LIST
 	STREAM
+	ZSET
 )
 
+// StreamEntry is an entry of a stream. Fields holds the field/value pairs
+// in the order they were added: field1, value1, field2, value2, ...
 type StreamEntry struct {
-	ID     string
-	Fields map[string]string
+	ID     StreamID
+	Fields []string
 }
 
+// Stream keeps its entries in blocks, see stream_index.go
 type Stream struct {
-	Entries []StreamEntry
-	LastID  string
+	blocks []*streamBlock
+	length int
+
+	LastID StreamID                  // last ID generated, 0-0 if none
+	Groups map[string]*ConsumerGroup // nil until a group is created
+
+	EntriesAdded int64    // entries ever added, including deleted ones
+	MaxDeletedID StreamID // largest ID removed by XDEL, 0-0 if none
 }
 
 type StreamID struct {
@@ -33,16 +44,37 @@ type StreamID struct {
 }
 
 type RedisValue struct {
-	Type   ValueType
-	String string
-	List   []string
+	Type ValueType
+	// Bytes holds STRING values. A byte slice rather than a Go string lets
+	// bit operations and SETRANGE/APPEND modify large values in place.
+	Bytes  []byte
+	List   *Quicklist
 	Stream *Stream
+	ZSet   *SortedSet
 	Expiry *time.Time
 }
 
 type ServerConfig struct {
-	Dir        string
-	DBFilename string
+	Dir         string
+	DBFilename  string
+	RDBChecksum bool
+
+	// BusyReplyThreshold is how long a script runs before other clients get
+	// BUSY replies instead of waiting for it, 0 to always wait
+	BusyReplyThreshold time.Duration
+
+	// RequirePass is the password of the default user, "" for none.
+	// ProtectedMode refuses clients that are not on the loopback interface
+	// while the default user has no password.
+	RequirePass   string
+	ProtectedMode bool
+
+	// MasterUser and MasterAuth are what a replica AUTHs with to its master
+	MasterUser string
+	MasterAuth string
+
+	// ACLFile is where ACL SAVE and ACL LOAD keep the users, "" for none
+	ACLFile string
 }
 
 type ReplicationState struct {
@@ -69,6 +101,8 @@ type ReplicationConnection struct {
 
 var (
 	data             = make(map[string]*RedisValue)
+	expires          = make(map[string]struct{}) // keys of data that carry a TTL
+	keyIndex         = newScanDict()             // keys of data laid out for SCAN
 	dataMutex        sync.RWMutex
 	replicationState = &ReplicationState{
 		Role:             "master",
@@ -89,8 +123,15 @@ func SetConfig(dir, dbfilename string) {
 	defer configMutex.Unlock()
 
 	serverConfig = ServerConfig{
-		Dir:        dir,
-		DBFilename: dbfilename,
+		Dir:                dir,
+		DBFilename:         dbfilename,
+		RDBChecksum:        serverConfig.RDBChecksum,
+		BusyReplyThreshold: serverConfig.BusyReplyThreshold,
+		RequirePass:        serverConfig.RequirePass,
+		ProtectedMode:      serverConfig.ProtectedMode,
+		MasterUser:         serverConfig.MasterUser,
+		MasterAuth:         serverConfig.MasterAuth,
+		ACLFile:            serverConfig.ACLFile,
 	}
 	fmt.Printf("⚙️  Configuration set: dir=%s, dbfilename=%s\n", dir, dbfilename)
 
@@ -101,11 +142,63 @@ func GetConfig() ServerConfig {
 	defer configMutex.RUnlock()
 
 	return ServerConfig{
-		Dir:        serverConfig.Dir,
-		DBFilename: serverConfig.DBFilename,
+		Dir:                serverConfig.Dir,
+		DBFilename:         serverConfig.DBFilename,
+		RDBChecksum:        serverConfig.RDBChecksum,
+		BusyReplyThreshold: serverConfig.BusyReplyThreshold,
+		RequirePass:        serverConfig.RequirePass,
+		ProtectedMode:      serverConfig.ProtectedMode,
+		MasterUser:         serverConfig.MasterUser,
+		MasterAuth:         serverConfig.MasterAuth,
+		ACLFile:            serverConfig.ACLFile,
 	}
 }
 
+// SetRDBChecksum sets whether loading an RDB file verifies its checksum
+func SetRDBChecksum(enabled bool) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	serverConfig.RDBChecksum = enabled
+}
+
+// SetBusyReplyThreshold sets how long a script may run before other clients
+// are answered BUSY
+func SetBusyReplyThreshold(threshold time.Duration) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	serverConfig.BusyReplyThreshold = threshold
+}
+
+// SetAuthConfig sets the password clients need and whether clients from
+// other hosts are refused when there is none
+func SetAuthConfig(requirePass string, protectedMode bool) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	serverConfig.RequirePass = requirePass
+	serverConfig.ProtectedMode = protectedMode
+}
+
+// SetMasterAuth sets the credentials a replica AUTHs with, an empty user
+// for the default one
+func SetMasterAuth(user, password string) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	serverConfig.MasterUser = user
+	serverConfig.MasterAuth = password
+}
+
+// SetACLFile sets the file the ACL users are kept in
+func SetACLFile(path string) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	serverConfig.ACLFile = path
+}
+
 func GetConfigValue(key string) (string, bool) {
 	configMutex.RLock()
 	defer configMutex.RUnlock()
@@ -116,6 +209,32 @@ func GetConfigValue(key string) (string, bool) {
 
 	case "dbfilename":
 		return serverConfig.DBFilename, true
+	case "rdbchecksum":
+		if serverConfig.RDBChecksum {
+			return "yes", true
+		}
+		return "no", true
+	case "list-max-listpack-size":
+		fill, _ := getListConfig()
+		return strconv.Itoa(fill), true
+	case "list-compress-depth":
+		_, depth := getListConfig()
+		return strconv.Itoa(depth), true
+	case "busy-reply-threshold", "lua-time-limit":
+		return strconv.FormatInt(serverConfig.BusyReplyThreshold.Milliseconds(), 10), true
+	case "requirepass":
+		return serverConfig.RequirePass, true
+	case "protected-mode":
+		if serverConfig.ProtectedMode {
+			return "yes", true
+		}
+		return "no", true
+	case "masteruser":
+		return serverConfig.MasterUser, true
+	case "masterauth":
+		return serverConfig.MasterAuth, true
+	case "aclfile":
+		return serverConfig.ACLFile, true
 	default:
 		return "", false
 	}
@@ -133,6 +252,14 @@ func generateReplID() string {
 	return hex.EncodeToString(bytes)
 }
 
+// isReplica reports whether this server follows a master.
+// Replicas leave key deletion to the master and only hide logically expired keys.
+func isReplica() bool {
+	replicationMutex.RLock()
+	defer replicationMutex.RUnlock()
+	return replicationState.Role == "slave"
+}
+
 func AddReplicaWithConnection(conn net.Conn) {
 	replicationMutex.Lock()
 	defer replicationMutex.Unlock()
@@ -258,18 +385,16 @@ func RemoveReplica(replica string) {
 }
 
 func GetKeyType(key string) string {
+	expireIfNeeded(key)
+
 	dataMutex.RLock()
 	defer dataMutex.RUnlock()
 
-	value, exists := data[key]
+	value, exists := lookupKey(key)
 	if !exists {
 		return "none"
 	}
 
-	if isExpired(value, key) {
-		return "none"
-	}
-
 	switch value.Type {
 	case STRING:
 		return "string"
@@ -277,15 +402,44 @@ func GetKeyType(key string) string {
 		return "list"
 	case STREAM:
 		return "stream"
+	case ZSET:
+		return "zset"
 	default:
 		return "none"
 	}
 }
 
-func isExpired(value *RedisValue, key string) bool {
-	if value.Expiry != nil && time.Now().After(*value.Expiry) {
-		delete(data, key)
-		return true
+// ObjectEncoding returns what OBJECT ENCODING reports for key. Strings are
+// classified by content the way Redis creates them, sorted sets are always
+// held in a skiplist here.
+func ObjectEncoding(key string) (string, bool) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		return "", false
+	}
+
+	switch value.Type {
+	case STRING:
+		if len(value.Bytes) <= 20 {
+			if n, err := strconv.ParseInt(string(value.Bytes), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(value.Bytes) {
+				return "int", true
+			}
+		}
+		if len(value.Bytes) <= 44 {
+			return "embstr", true
+		}
+		return "raw", true
+	case LIST:
+		return value.List.Encoding(), true
+	case STREAM:
+		return "stream", true
+	case ZSET:
+		return "skiplist", true
 	}
-	return false
+	return "", false
 }
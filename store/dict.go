@@ -0,0 +1,120 @@
+package store
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+const (
+	dictInitialSize = 4
+	// dictShrinkRatio shrinks the table once it is less than 1/8 full
+	dictShrinkRatio = 8
+)
+
+var dictSeed = maphash.MakeSeed()
+
+// scanDict is a set of strings laid out in power-of-two hash buckets, the way
+// Redis lays out its dicts, so that it can be walked with a SCAN cursor.
+// Go maps do not expose their buckets, so the keyspace and any collection
+// that needs a cursor keep one of these next to the map used for lookups.
+//
+// Cursors advance over bucket indexes in reverse-binary order. When the table
+// grows or shrinks between two calls, every bucket of the new table that still
+// has to be visited maps onto cursors that come later, so a member present for
+// the whole iteration is always returned (possibly more than once).
+type scanDict struct {
+	buckets [][]string
+	count   int
+}
+
+func newScanDict() *scanDict {
+	return &scanDict{buckets: make([][]string, dictInitialSize)}
+}
+
+func (d *scanDict) bucketFor(member string) int {
+	return int(maphash.String(dictSeed, member) & uint64(len(d.buckets)-1))
+}
+
+// add inserts member, reporting false if it was already present
+func (d *scanDict) add(member string) bool {
+	idx := d.bucketFor(member)
+	for _, existing := range d.buckets[idx] {
+		if existing == member {
+			return false
+		}
+	}
+
+	d.buckets[idx] = append(d.buckets[idx], member)
+	d.count++
+
+	if d.count > len(d.buckets) {
+		d.resize(len(d.buckets) * 2)
+	}
+	return true
+}
+
+// remove deletes member, reporting false if it was not present
+func (d *scanDict) remove(member string) bool {
+	idx := d.bucketFor(member)
+	bucket := d.buckets[idx]
+	for i, existing := range bucket {
+		if existing == member {
+			last := len(bucket) - 1
+			bucket[i] = bucket[last]
+			bucket[last] = ""
+			d.buckets[idx] = bucket[:last]
+			d.count--
+
+			if len(d.buckets) > dictInitialSize && d.count*dictShrinkRatio < len(d.buckets) {
+				d.resize(len(d.buckets) / 2)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (d *scanDict) resize(size int) {
+	old := d.buckets
+	d.buckets = make([][]string, size)
+	for _, bucket := range old {
+		for _, member := range bucket {
+			idx := d.bucketFor(member)
+			d.buckets[idx] = append(d.buckets[idx], member)
+		}
+	}
+}
+
+// scan visits buckets starting at cursor until at least count members were
+// emitted or the whole table was walked, and returns the cursor to resume
+// from. A returned cursor of 0 means the iteration is complete.
+func (d *scanDict) scan(cursor uint64, count int, emit func(member string)) uint64 {
+	if d.count == 0 {
+		return 0
+	}
+
+	mask := uint64(len(d.buckets) - 1)
+	emitted := 0
+
+	// Bound the number of empty buckets visited per call so sparse tables
+	// can't turn a single SCAN into a full walk
+	maxVisits := count * 10
+
+	for visits := 0; ; visits++ {
+		for _, member := range d.buckets[cursor&mask] {
+			emit(member)
+			emitted++
+		}
+
+		// Increment the reversed cursor: set the unmasked bits so the carry
+		// propagates into the masked ones, then reverse back
+		cursor |= ^mask
+		cursor = bits.Reverse64(cursor)
+		cursor++
+		cursor = bits.Reverse64(cursor)
+
+		if cursor == 0 || emitted >= count || visits >= maxVisits {
+			return cursor
+		}
+	}
+}
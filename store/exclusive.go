@@ -0,0 +1,42 @@
+package store
+
+import "sync"
+
+// commandMutex isolates transactions. Every command holds it shared while
+// it runs, and so does each round of the active expire cycle. EXEC holds it
+// exclusively, so no other command and no expiry comes in between the
+// commands of a transaction. dataMutex still guards each store call on its
+// own, this only decides who gets to make them.
+var commandMutex sync.RWMutex
+
+// BeginCommand enters the shared section for one command. A command that
+// waits for something, like a blocked BLPOP, leaves it while waiting.
+func BeginCommand() {
+	commandMutex.RLock()
+}
+
+func EndCommand() {
+	commandMutex.RUnlock()
+}
+
+// BeginExclusive waits for running commands to finish and keeps every other
+// command out until EndExclusive
+func BeginExclusive() {
+	commandMutex.Lock()
+}
+
+func EndExclusive() {
+	commandMutex.Unlock()
+}
+
+// TryBeginCommand enters the shared section if nothing holds it exclusively
+// or waits to, false otherwise
+func TryBeginCommand() bool {
+	return commandMutex.TryRLock()
+}
+
+// TryBeginExclusive enters the exclusive section if no command is running,
+// false otherwise
+func TryBeginExclusive() bool {
+	return commandMutex.TryLock()
+}
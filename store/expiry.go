@@ -0,0 +1,282 @@
+package store
+
+import "time"
+
+// ExpireCondition restricts when a new expiry may replace the current one,
+// mirroring the NX/XX/GT/LT flags of the EXPIRE family. Flags may be combined.
+type ExpireCondition int
+
+const (
+	ExpireNX ExpireCondition = 1 << iota // only when the key has no expiry
+	ExpireXX                             // only when the key already has an expiry
+	ExpireGT                             // only when the new expiry is later than the current one
+	ExpireLT                             // only when the new expiry is earlier than the current one
+
+	ExpireAlways ExpireCondition = 0
+)
+
+// SetExpiry sets an absolute expiry on key, whatever its type.
+// applied reports whether the key existed and cond allowed the change.
+// On a master an expiry that is already in the past deletes the key instead,
+// which is reported through deleted so the caller can propagate a DEL.
+func SetExpiry(key string, at time.Time, cond ExpireCondition) (applied bool, deleted bool) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, exists := lookupKeyWrite(key)
+	if !exists {
+		return false, false
+	}
+
+	// A key without an expiry behaves as if its TTL were infinite
+	if cond&ExpireNX != 0 && value.Expiry != nil {
+		return false, false
+	}
+	if cond&ExpireXX != 0 && value.Expiry == nil {
+		return false, false
+	}
+	if cond&ExpireGT != 0 && (value.Expiry == nil || !at.After(*value.Expiry)) {
+		return false, false
+	}
+	if cond&ExpireLT != 0 && value.Expiry != nil && !at.Before(*value.Expiry) {
+		return false, false
+	}
+
+	if !at.After(time.Now()) && !isReplica() {
+		deleteKey(key)
+		return true, true
+	}
+
+	expiry := at
+	setKeyExpiry(key, value, &expiry)
+	return true, false
+}
+
+// GetExpiry returns the absolute expiry of key.
+// hasExpiry is false for persistent keys and exists is false for missing or expired keys.
+func GetExpiry(key string) (expiry time.Time, hasExpiry bool, exists bool) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	value, found := lookupKey(key)
+	if !found {
+		return time.Time{}, false, false
+	}
+
+	if value.Expiry == nil {
+		return time.Time{}, false, true
+	}
+
+	return *value.Expiry, true, true
+}
+
+// Persist removes the expiry of key, returning true if there was one to remove
+func Persist(key string) bool {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, exists := lookupKeyWrite(key)
+	if !exists || value.Expiry == nil {
+		return false
+	}
+
+	setKeyExpiry(key, value, nil)
+	return true
+}
+
+const (
+	// activeExpireSampleSize is how many keys with a TTL are checked per round
+	activeExpireSampleSize = 20
+	// activeExpireRepeatPercent keeps a cycle going while more than this share
+	// of the sampled keys turned out to be expired
+	activeExpireRepeatPercent = 25
+	activeExpireInterval      = 100 * time.Millisecond
+	activeExpireTimeBudget    = 25 * time.Millisecond
+)
+
+// expiredKeyHandler is told about every key the master deletes because its TTL elapsed
+var expiredKeyHandler func(key string)
+
+// expiredKeys are the keys expired while dataMutex was held, waiting for
+// unlockData to report them
+var expiredKeys []string
+
+// SetExpiredKeyHandler registers fn to be called, once dataMutex is released,
+// whenever a key is deleted because it expired. The master uses it to
+// propagate a DEL.
+func SetExpiredKeyHandler(fn func(key string)) {
+	dataMutex.Lock()
+	defer unlockData()
+	expiredKeyHandler = fn
+}
+
+// unlockData releases dataMutex.Lock, then reports the keys that expired
+// while it was held. The handler runs without the lock so that it may block
+// on replicas or call back into the store. It runs before the caller
+// returns, so the DEL of an expired key reaches replicas ahead of the write
+// that found it expired.
+func unlockData() {
+	keys, handler := expiredKeys, expiredKeyHandler
+	expiredKeys = nil
+	dataMutex.Unlock()
+
+	if handler == nil {
+		return
+	}
+	for _, key := range keys {
+		handler(key)
+	}
+}
+
+func isExpired(value *RedisValue, now time.Time) bool {
+	return value.Expiry != nil && now.After(*value.Expiry)
+}
+
+// lookupKey returns the live value of key without touching the keyspace, so it
+// only needs dataMutex.RLock. Expired keys are reported as missing.
+func lookupKey(key string) (*RedisValue, bool) {
+	value, exists := data[key]
+	if !exists || isExpired(value, time.Now()) {
+		return nil, false
+	}
+	return value, true
+}
+
+// lookupKeyWrite is lookupKey for callers holding dataMutex.Lock. An expired key
+// is deleted on the spot. Replicas only ever write on behalf of their master,
+// which decides on its own when a key is gone, so they keep the key as is.
+func lookupKeyWrite(key string) (*RedisValue, bool) {
+	value, exists := data[key]
+	if !exists {
+		return nil, false
+	}
+
+	if isExpired(value, time.Now()) && !isReplica() {
+		expireKey(key)
+		return nil, false
+	}
+	return value, true
+}
+
+// expireIfNeeded deletes key if its TTL elapsed. Read paths call it before
+// taking dataMutex.RLock: the check runs under the read lock and only upgrades
+// to the write lock when there is something to delete.
+func expireIfNeeded(key string) {
+	dataMutex.RLock()
+	value, exists := data[key]
+	expired := exists && isExpired(value, time.Now())
+	dataMutex.RUnlock()
+
+	if !expired || isReplica() {
+		return
+	}
+
+	dataMutex.Lock()
+	defer unlockData()
+
+	// The key may have been rewritten between the two locks
+	if value, exists := data[key]; exists && isExpired(value, time.Now()) {
+		expireKey(key)
+	}
+}
+
+// expireKey deletes an expired key and queues it for unlockData to report.
+// Callers hold dataMutex.Lock.
+func expireKey(key string) {
+	deleteKey(key)
+	expiredKeys = append(expiredKeys, key)
+}
+
+// setKey stores value under key, replacing whatever was there.
+// Callers hold dataMutex.Lock.
+func setKey(key string, value *RedisValue) {
+	data[key] = value
+	keyIndex.add(key)
+	if value.Expiry != nil {
+		expires[key] = struct{}{}
+	} else {
+		delete(expires, key)
+	}
+	touchWatchedKey(key)
+}
+
+// deleteKey removes key from the keyspace. Callers hold dataMutex.Lock.
+func deleteKey(key string) bool {
+	if _, exists := data[key]; !exists {
+		return false
+	}
+	delete(data, key)
+	delete(expires, key)
+	keyIndex.remove(key)
+	touchWatchedKey(key)
+	return true
+}
+
+// setKeyExpiry changes the expiry of a stored value, nil meaning persistent.
+// Callers hold dataMutex.Lock.
+func setKeyExpiry(key string, value *RedisValue, expiry *time.Time) {
+	value.Expiry = expiry
+	if expiry != nil {
+		expires[key] = struct{}{}
+	} else {
+		delete(expires, key)
+	}
+	touchWatchedKey(key)
+}
+
+// StartActiveExpireCycle periodically evicts expired keys that nobody reads,
+// the way Redis does: sample keys with a TTL and keep going while a large share
+// of the sample was expired, within a small time budget per run.
+func StartActiveExpireCycle() {
+	go func() {
+		ticker := time.NewTicker(activeExpireInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if isReplica() {
+				continue
+			}
+			activeExpireCycle()
+		}
+	}()
+}
+
+func activeExpireCycle() {
+	deadline := time.Now().Add(activeExpireTimeBudget)
+
+	for {
+		sampled, expired := activeExpireRound()
+		if sampled == 0 || expired*100 <= sampled*activeExpireRepeatPercent {
+			return
+		}
+		if time.Now().After(deadline) {
+			return
+		}
+	}
+}
+
+// activeExpireRound checks one sample of keys with a TTL. Map iteration order
+// is randomised by the runtime, which gives us the random sample for free.
+// Rounds run as commands of their own, never in the middle of an EXEC.
+func activeExpireRound() (sampled, expired int) {
+	commandMutex.RLock()
+	defer commandMutex.RUnlock()
+	dataMutex.Lock()
+	defer unlockData()
+
+	now := time.Now()
+	for key := range expires {
+		if sampled == activeExpireSampleSize {
+			break
+		}
+		sampled++
+
+		if value, exists := data[key]; !exists || isExpired(value, now) {
+			expireKey(key)
+			expired++
+		}
+	}
+	return sampled, expired
+}
@@ -0,0 +1,362 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// GeoSort is the ordering of GEOSEARCH results by distance
+type GeoSort int
+
+const (
+	GeoSortNone GeoSort = iota
+	GeoSortAsc
+	GeoSortDesc
+)
+
+// GeoQuery describes a GEOSEARCH: a center, either a member or a coordinate
+// pair, and a circle or a box around it. Radius, Width and Height are in the
+// caller's unit, Conversion turns them into meters.
+type GeoQuery struct {
+	FromMember string
+	UseMember  bool
+	Longitude  float64
+	Latitude   float64
+
+	ByBox      bool
+	Radius     float64
+	Width      float64
+	Height     float64
+	Conversion float64
+
+	Sort  GeoSort
+	Count int
+	Any   bool
+}
+
+// GeoPoint is a search result. Dist is in the query's unit.
+type GeoPoint struct {
+	Member    string
+	Score     float64
+	Longitude float64
+	Latitude  float64
+	Dist      float64
+}
+
+// GeoPosition returns the coordinates stored for each member, nil for
+// members that are missing
+func GeoPosition(key string, members []string) ([][]float64, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	zset, err := lookupZSet(lookupKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make([][]float64, len(members))
+	if zset == nil {
+		return positions, nil
+	}
+	for i, member := range members {
+		if score, ok := zset.Score(member); ok {
+			longitude, latitude := geoDecodeScore(score)
+			positions[i] = []float64{longitude, latitude}
+		}
+	}
+	return positions, nil
+}
+
+// GeoDistance returns the distance in meters between two members
+func GeoDistance(key, member1, member2 string) (float64, bool, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	zset, err := lookupZSet(lookupKey(key))
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+
+	score1, ok1 := zset.Score(member1)
+	score2, ok2 := zset.Score(member2)
+	if !ok1 || !ok2 {
+		return 0, false, nil
+	}
+
+	lon1, lat1 := geoDecodeScore(score1)
+	lon2, lat2 := geoDecodeScore(score2)
+	return geohashDistance(lon1, lat1, lon2, lat2), true, nil
+}
+
+// GeoHashes returns the base32 geohash of each member, "" for missing ones
+func GeoHashes(key string, members []string) ([]string, []bool, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	zset, err := lookupZSet(lookupKey(key))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(members))
+	found := make([]bool, len(members))
+	if zset == nil {
+		return hashes, found, nil
+	}
+	for i, member := range members {
+		if score, ok := zset.Score(member); ok {
+			hashes[i] = GeoHashString(score)
+			found[i] = true
+		}
+	}
+	return hashes, found, nil
+}
+
+// GeoSearch returns the members of the geo set at key inside the query
+// shape, sorted and limited as requested
+func GeoSearch(key string, q GeoQuery) ([]GeoPoint, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	zset, err := lookupZSet(lookupKey(key))
+	if err != nil || zset == nil {
+		return nil, err
+	}
+	return geoSearch(zset, q)
+}
+
+// GeoSearchStore runs a search on src and stores the results in dest, scored
+// by geohash or, with storeDist, by distance. An empty result deletes dest.
+func GeoSearchStore(dest, src string, q GeoQuery, storeDist bool) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	zset, err := lookupZSet(lookupKey(src))
+	if err != nil {
+		return 0, err
+	}
+
+	var points []GeoPoint
+	if zset != nil {
+		if points, err = geoSearch(zset, q); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(points) == 0 {
+		deleteKey(dest)
+		return 0, nil
+	}
+
+	stored := newSortedSet()
+	for _, point := range points {
+		score := point.Score
+		if storeDist {
+			score = point.Dist
+		}
+		stored.Add(point.Member, score)
+	}
+
+	// Like Redis, the destination is replaced and loses any TTL it had
+	deleteKey(dest)
+	setKey(dest, &RedisValue{Type: ZSET, ZSet: stored})
+	return len(points), nil
+}
+
+func geoSearch(zset *SortedSet, q GeoQuery) ([]GeoPoint, error) {
+	if q.UseMember {
+		score, ok := zset.Score(q.FromMember)
+		if !ok {
+			return nil, fmt.Errorf("ERR could not decode requested zset member")
+		}
+		q.Longitude, q.Latitude = geoDecodeScore(score)
+	}
+
+	// COUNT without ANY means "the closest N", which needs sorting
+	if q.Count != 0 && q.Sort == GeoSortNone && !q.Any {
+		q.Sort = GeoSortAsc
+	}
+
+	limit := 0
+	if q.Any {
+		limit = q.Count
+	}
+
+	var points []GeoPoint
+	var lastProcessed *geoHashBits
+	for _, box := range geoSearchAreas(q) {
+		if box.bits == 0 && box.step == 0 {
+			continue
+		}
+		// Huge radii make neighbors overlap, skip repeats of the last box
+		if lastProcessed != nil && *lastProcessed == box {
+			continue
+		}
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+		points = geoMembersOfBox(zset, box, q, points, limit)
+		lastProcessed = &box
+	}
+
+	switch q.Sort {
+	case GeoSortAsc:
+		sort.Slice(points, func(i, j int) bool { return points[i].Dist < points[j].Dist })
+	case GeoSortDesc:
+		sort.Slice(points, func(i, j int) bool { return points[i].Dist > points[j].Dist })
+	}
+
+	if q.Count > 0 && len(points) > q.Count {
+		points = points[:q.Count]
+	}
+	for i := range points {
+		points[i].Dist /= q.Conversion
+	}
+	return points, nil
+}
+
+// geoMembersOfBox appends the members of a geohash cell that fall inside
+// the query shape. A cell covers a contiguous range of 52 bit scores.
+func geoMembersOfBox(zset *SortedSet, box geoHashBits, q GeoQuery, points []GeoPoint, limit int) []GeoPoint {
+	shift := geoStepMax*2 - box.step*2
+	min := float64(box.bits << shift)
+	max := float64((box.bits + 1) << shift)
+
+	zset.rangeByScore(min, max, func(member string, score float64) bool {
+		if limit > 0 && len(points) >= limit {
+			return false
+		}
+
+		longitude, latitude := geoDecodeScore(score)
+		dist, ok := geoWithinShape(q, longitude, latitude)
+		if ok {
+			points = append(points, GeoPoint{
+				Member:    member,
+				Score:     score,
+				Longitude: longitude,
+				Latitude:  latitude,
+				Dist:      dist,
+			})
+		}
+		return true
+	})
+	return points
+}
+
+// geoWithinShape returns the distance in meters from the center when the
+// point lies inside the query's circle or box
+func geoWithinShape(q GeoQuery, longitude, latitude float64) (float64, bool) {
+	if !q.ByBox {
+		dist := geohashDistance(q.Longitude, q.Latitude, longitude, latitude)
+		return dist, dist <= q.Radius*q.Conversion
+	}
+
+	// The latitude distance is cheaper, check it first
+	if geohashLatDistance(latitude, q.Latitude) > q.Height*q.Conversion/2 {
+		return 0, false
+	}
+	if geohashDistance(longitude, latitude, q.Longitude, latitude) > q.Width*q.Conversion/2 {
+		return 0, false
+	}
+	return geohashDistance(q.Longitude, q.Latitude, longitude, latitude), true
+}
+
+// geoBoundingBox returns the min/max longitude and latitude enclosing the
+// query shape
+func geoBoundingBox(q GeoQuery) (minLon, minLat, maxLon, maxLat float64) {
+	height, width := q.Radius, q.Radius
+	if q.ByBox {
+		height, width = q.Height/2, q.Width/2
+	}
+	height *= q.Conversion
+	width *= q.Conversion
+
+	latDelta := radDeg(height / earthRadiusInMeters)
+	longDeltaTop := radDeg(width / earthRadiusInMeters / math.Cos(degRad(q.Latitude+latDelta)))
+	longDeltaBottom := radDeg(width / earthRadiusInMeters / math.Cos(degRad(q.Latitude-latDelta)))
+
+	// The hemispheres curve in opposite directions, so the wider edge of
+	// the box is the one nearer the equator
+	if q.Latitude < 0 {
+		minLon, maxLon = q.Longitude-longDeltaBottom, q.Longitude+longDeltaBottom
+	} else {
+		minLon, maxLon = q.Longitude-longDeltaTop, q.Longitude+longDeltaTop
+	}
+	return minLon, q.Latitude - latDelta, maxLon, q.Latitude + latDelta
+}
+
+// geoSearchAreas returns the cell containing the center followed by its 8
+// neighbors (north, south, east, west, NE, NW, SE, SW), at a precision where
+// those 9 cells cover the shape. Neighbors that cannot contain results are
+// zeroed.
+func geoSearchAreas(q GeoQuery) [9]geoHashBits {
+	minLon, minLat, maxLon, maxLat := geoBoundingBox(q)
+
+	radiusMeters := q.Radius
+	if q.ByBox {
+		radiusMeters = math.Sqrt((q.Width/2)*(q.Width/2) + (q.Height/2)*(q.Height/2))
+	}
+	radiusMeters *= q.Conversion
+
+	steps := geohashEstimateStepsByRadius(radiusMeters, q.Latitude)
+	hash, _ := geohashEncode(geoLongRange, geoLatRange, q.Longitude, q.Latitude, steps)
+	area := geohashDecode(geoLongRange, geoLatRange, hash)
+
+	// Near the edge of its cell the estimated step can be too fine for the
+	// neighbors to reach the end of the shape, use one step coarser then
+	north := geohashDecode(geoLongRange, geoLatRange, geoNeighbor(hash, 0, 1))
+	south := geohashDecode(geoLongRange, geoLatRange, geoNeighbor(hash, 0, -1))
+	east := geohashDecode(geoLongRange, geoLatRange, geoNeighbor(hash, 1, 0))
+	west := geohashDecode(geoLongRange, geoLatRange, geoNeighbor(hash, -1, 0))
+	decreaseStep := north.latitude.max < maxLat || south.latitude.min > minLat ||
+		east.longitude.max < maxLon || west.longitude.min > minLon
+
+	if steps > 1 && decreaseStep {
+		steps--
+		hash, _ = geohashEncode(geoLongRange, geoLatRange, q.Longitude, q.Latitude, steps)
+		area = geohashDecode(geoLongRange, geoLatRange, hash)
+	}
+
+	areas := [9]geoHashBits{
+		hash,
+		geoNeighbor(hash, 0, 1),   // north
+		geoNeighbor(hash, 0, -1),  // south
+		geoNeighbor(hash, 1, 0),   // east
+		geoNeighbor(hash, -1, 0),  // west
+		geoNeighbor(hash, 1, 1),   // north east
+		geoNeighbor(hash, -1, 1),  // north west
+		geoNeighbor(hash, 1, -1),  // south east
+		geoNeighbor(hash, -1, -1), // south west
+	}
+
+	// Drop the neighbors on sides where the center cell already covers the shape
+	if steps >= 2 {
+		zero := func(indexes ...int) {
+			for _, i := range indexes {
+				areas[i] = geoHashBits{}
+			}
+		}
+		if area.latitude.min < minLat {
+			zero(2, 7, 8)
+		}
+		if area.latitude.max > maxLat {
+			zero(1, 5, 6)
+		}
+		if area.longitude.min < minLon {
+			zero(4, 8, 6)
+		}
+		if area.longitude.max > maxLon {
+			zero(3, 7, 5)
+		}
+	}
+	return areas
+}
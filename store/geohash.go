@@ -0,0 +1,239 @@
+package store
+
+import "math"
+
+// Geo members are sorted set members whose score is a 52 bit geohash: 26
+// bits of longitude interleaved with 26 bits of latitude. Latitudes are
+// limited to the Web Mercator range, like Redis, so every point can be
+// searched with square-ish boxes. The code below follows Redis's geohash.c
+// and geohash_helper.c so hashes, distances and search results agree.
+const (
+	GeoLatMin  = -85.05112878
+	GeoLatMax  = 85.05112878
+	GeoLongMin = -180.0
+	GeoLongMax = 180.0
+
+	geoStepMax          = 26
+	earthRadiusInMeters = 6372797.560856
+	mercatorMax         = 20037726.37
+)
+
+type geoHashBits struct {
+	bits uint64
+	step uint
+}
+
+type geoHashRange struct {
+	min, max float64
+}
+
+type geoHashArea struct {
+	longitude, latitude geoHashRange
+}
+
+var (
+	geoLongRange = geoHashRange{GeoLongMin, GeoLongMax}
+	geoLatRange  = geoHashRange{GeoLatMin, GeoLatMax}
+)
+
+// interleave64 spreads the bits of xlo over the even positions and the bits
+// of ylo over the odd ones
+func interleave64(xlo, ylo uint32) uint64 {
+	masks := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	shifts := [...]uint{1, 2, 4, 8, 16}
+
+	x, y := uint64(xlo), uint64(ylo)
+	for i := len(masks) - 1; i >= 0; i-- {
+		x = (x | (x << shifts[i])) & masks[i]
+		y = (y | (y << shifts[i])) & masks[i]
+	}
+	return x | (y << 1)
+}
+
+// deinterleave64 reverses interleave64, returning the even bits in the low
+// half and the odd bits in the high half
+func deinterleave64(interleaved uint64) uint64 {
+	masks := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	shifts := [...]uint{0, 1, 2, 4, 8, 16}
+
+	x, y := interleaved, interleaved>>1
+	for i := range masks {
+		x = (x | (x >> shifts[i])) & masks[i]
+		y = (y | (y >> shifts[i])) & masks[i]
+	}
+	return x | (y << 32)
+}
+
+func geohashEncode(longRange, latRange geoHashRange, longitude, latitude float64, step uint) (geoHashBits, bool) {
+	if longitude < GeoLongMin || longitude > GeoLongMax || latitude < GeoLatMin || latitude > GeoLatMax {
+		return geoHashBits{}, false
+	}
+	if latitude < latRange.min || latitude > latRange.max || longitude < longRange.min || longitude > longRange.max {
+		return geoHashBits{}, false
+	}
+
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (longitude - longRange.min) / (longRange.max - longRange.min)
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+
+	return geoHashBits{bits: interleave64(uint32(latOffset), uint32(longOffset)), step: step}, true
+}
+
+func geohashDecode(longRange, latRange geoHashRange, hash geoHashBits) geoHashArea {
+	separated := deinterleave64(hash.bits)
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+
+	ilato := uint32(separated)
+	ilono := uint32(separated >> 32)
+	cells := float64(uint64(1) << hash.step)
+
+	return geoHashArea{
+		latitude: geoHashRange{
+			min: latRange.min + (float64(ilato)/cells)*latScale,
+			max: latRange.min + ((float64(ilato)+1)/cells)*latScale,
+		},
+		longitude: geoHashRange{
+			min: longRange.min + (float64(ilono)/cells)*longScale,
+			max: longRange.min + ((float64(ilono)+1)/cells)*longScale,
+		},
+	}
+}
+
+// GeoEncodeScore returns the sorted set score of a coordinate pair
+func GeoEncodeScore(longitude, latitude float64) (float64, bool) {
+	hash, ok := geohashEncode(geoLongRange, geoLatRange, longitude, latitude, geoStepMax)
+	if !ok {
+		return 0, false
+	}
+	return float64(hash.bits), true
+}
+
+// geoDecodeScore returns the center of the cell a score encodes
+func geoDecodeScore(score float64) (longitude, latitude float64) {
+	area := geohashDecode(geoLongRange, geoLatRange, geoHashBits{bits: uint64(score), step: geoStepMax})
+
+	longitude = min(max((area.longitude.min+area.longitude.max)/2, GeoLongMin), GeoLongMax)
+	latitude = min(max((area.latitude.min+area.latitude.max)/2, GeoLatMin), GeoLatMax)
+	return longitude, latitude
+}
+
+// GeoValidCoordinates reports whether a pair can be stored in a geo set
+func GeoValidCoordinates(longitude, latitude float64) bool {
+	return longitude >= GeoLongMin && longitude <= GeoLongMax &&
+		latitude >= GeoLatMin && latitude <= GeoLatMax
+}
+
+// GeoHashString returns the standard 11 character base32 geohash of a score.
+// Stored hashes use the Mercator latitude range, so the point is decoded and
+// re-encoded against the full [-90, 90] range first.
+func GeoHashString(score float64) string {
+	const alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	longitude, latitude := geoDecodeScore(score)
+	hash, _ := geohashEncode(geoHashRange{-180, 180}, geoHashRange{-90, 90}, longitude, latitude, geoStepMax)
+
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		// Only 52 bits are available, the 11th character is always 0
+		if i < 10 {
+			idx = int((hash.bits >> (52 - uint((i+1)*5))) & 0x1f)
+		}
+		buf[i] = alphabet[idx]
+	}
+	return string(buf)
+}
+
+func degRad(deg float64) float64 {
+	return deg * (math.Pi / 180.0)
+}
+
+func radDeg(rad float64) float64 {
+	return rad / (math.Pi / 180.0)
+}
+
+func geohashLatDistance(lat1, lat2 float64) float64 {
+	return earthRadiusInMeters * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// geohashDistance is the haversine distance in meters between two points
+func geohashDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lon1r, lon2r := degRad(lon1), degRad(lon2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	// Same longitude, the latitude difference is the whole distance
+	if v == 0 {
+		return geohashLatDistance(lat1, lat2)
+	}
+
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2.0 * earthRadiusInMeters * math.Asin(math.Sqrt(a))
+}
+
+func geohashEstimateStepsByRadius(rangeMeters, latitude float64) uint {
+	if rangeMeters == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	// Make sure the range is included in most of the base cases
+	step -= 2
+
+	// Cells get narrower towards the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+func geohashMoveX(hash *geoHashBits, d int) {
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - hash.step*2)
+
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+	x &= uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+func geohashMoveY(hash *geoHashBits, d int) {
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.step*2)
+
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+	y &= uint64(0x5555555555555555) >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+// geoNeighbor returns the cell dx columns east and dy rows north of hash
+func geoNeighbor(hash geoHashBits, dx, dy int) geoHashBits {
+	if dx != 0 {
+		geohashMoveX(&hash, dx)
+	}
+	if dy != 0 {
+		geohashMoveY(&hash, dy)
+	}
+	return hash
+}
@@ -0,0 +1,378 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// HyperLogLogs are plain string values laid out exactly like Redis's HYLL
+// format, so they survive RDB round trips with a real Redis server:
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// 4 magic bytes, 1 encoding byte (dense or sparse), 3 unused bytes and an
+// 8 byte little-endian cached cardinality whose top bit marks it stale,
+// followed by the registers.
+//
+// The dense encoding packs 16384 6-bit registers, least significant bit
+// first. The sparse encoding is a run-length sequence of three opcodes:
+//
+//	ZERO  00xxxxxx          xxxxxx+1 registers set to 0 (1-64)
+//	XZERO 01xxxxxx yyyyyyyy xxxxxxyyyyyyyy+1 registers set to 0 (1-16384)
+//	VAL   1vvvvvxx          xx+1 registers set to vvvvv+1 (1-4 of 1-32)
+const (
+	hllP            = 14
+	hllQ            = 64 - hllP
+	hllRegisters    = 1 << hllP
+	hllPMask        = hllRegisters - 1
+	hllBits         = 6
+	hllRegisterMax  = 1<<hllBits - 1
+	hllHeaderSize   = 16
+	hllDenseSize    = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllEncodingByte = 4
+
+	hllDense  = 0
+	hllSparse = 1
+
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+
+	// hllSparseMaxBytes is the size past which a sparse HyperLogLog is
+	// promoted to dense, Redis's default hll-sparse-max-bytes
+	hllSparseMaxBytes = 3000
+
+	hllAlphaInf = 0.721347520444481703680 // 1 / (2 ln 2)
+)
+
+var (
+	errNotHLL       = fmt.Errorf("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	errHLLCorrupted = fmt.Errorf("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// newHLL returns an empty sparse HyperLogLog with a valid cached count of 0
+func newHLL() []byte {
+	buf := make([]byte, hllHeaderSize, hllHeaderSize+2)
+	copy(buf, "HYLL")
+	buf[hllEncodingByte] = hllSparse
+	return appendSparseZeros(buf, hllRegisters)
+}
+
+// isHLL checks the header of a string value, the way Redis does before
+// touching its registers
+func isHLL(buf []byte) bool {
+	if len(buf) < hllHeaderSize || string(buf[:4]) != "HYLL" {
+		return false
+	}
+	switch buf[hllEncodingByte] {
+	case hllDense:
+		return len(buf) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+func hllCachedCount(buf []byte) (uint64, bool) {
+	if buf[15]&0x80 != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(buf[8:16]), true
+}
+
+func hllSetCachedCount(buf []byte, count uint64) {
+	binary.LittleEndian.PutUint64(buf[8:16], count)
+}
+
+func hllInvalidateCache(buf []byte) {
+	buf[15] |= 0x80
+}
+
+// murmurHash64A is the 64 bit MurmurHash2 variant Redis hashes elements with,
+// reading blocks little-endian regardless of the host byte order
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ (uint64(len(key)) * m)
+
+	blocks := len(key) / 8
+	for i := 0; i < blocks; i++ {
+		k := binary.LittleEndian.Uint64(key[i*8:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+
+	tail := key[blocks*8:]
+	for i := len(tail) - 1; i >= 0; i-- {
+		h ^= uint64(tail[i]) << (8 * uint(i))
+	}
+	if len(tail) > 0 {
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register an element maps to and the length of the
+// 000..1 pattern in the remaining hash bits, the value to store there
+func hllPatLen(element []byte) (int, uint8) {
+	hash := murmurHash64A(element, 0xadc83b19)
+	index := int(hash & hllPMask)
+
+	// The sentinel bit bounds the count to hllQ+1
+	hash >>= hllP
+	hash |= 1 << hllQ
+
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+func hllDenseGet(registers []byte, index int) uint8 {
+	bitPos := index * hllBits
+	byteIdx, shift := bitPos/8, uint(bitPos&7)
+
+	v := uint(registers[byteIdx]) >> shift
+	if byteIdx+1 < len(registers) {
+		v |= uint(registers[byteIdx+1]) << (8 - shift)
+	}
+	return uint8(v & hllRegisterMax)
+}
+
+func hllDenseSet(registers []byte, index int, value uint8) {
+	bitPos := index * hllBits
+	byteIdx, shift := bitPos/8, uint(bitPos&7)
+
+	registers[byteIdx] &^= byte(hllRegisterMax << shift)
+	registers[byteIdx] |= byte(uint(value) << shift)
+	if byteIdx+1 < len(registers) {
+		registers[byteIdx+1] &^= byte(hllRegisterMax >> (8 - shift))
+		registers[byteIdx+1] |= byte(uint(value) >> (8 - shift))
+	}
+}
+
+// hllDecode expands either encoding into one byte per register
+func hllDecode(buf []byte) ([]uint8, error) {
+	raw := make([]uint8, hllRegisters)
+	registers := buf[hllHeaderSize:]
+
+	if buf[hllEncodingByte] == hllDense {
+		for i := range raw {
+			raw[i] = hllDenseGet(registers, i)
+		}
+		return raw, nil
+	}
+
+	index := 0
+	err := walkSparse(registers, func(value uint8, runLen int) bool {
+		if index+runLen > hllRegisters {
+			return false
+		}
+		for j := 0; j < runLen; j++ {
+			raw[index+j] = value
+		}
+		index += runLen
+		return true
+	})
+	if err != nil || index != hllRegisters {
+		return nil, errHLLCorrupted
+	}
+	return raw, nil
+}
+
+// walkSparse calls fn for every opcode of a sparse register sequence.
+// fn returning false aborts the walk as corrupted.
+func walkSparse(registers []byte, fn func(value uint8, runLen int) bool) error {
+	for p := 0; p < len(registers); {
+		op := registers[p]
+
+		var value uint8
+		var runLen int
+		switch {
+		case op&0xc0 == 0x00:
+			runLen = int(op&0x3f) + 1
+			p++
+		case op&0xc0 == 0x40:
+			if p+1 >= len(registers) {
+				return errHLLCorrupted
+			}
+			runLen = (int(op&0x3f)<<8 | int(registers[p+1])) + 1
+			p += 2
+		default:
+			value = (op>>2)&0x1f + 1
+			runLen = int(op&0x3) + 1
+			p++
+		}
+
+		if !fn(value, runLen) {
+			return errHLLCorrupted
+		}
+	}
+	return nil
+}
+
+func appendSparseZeros(buf []byte, runLen int) []byte {
+	for runLen > 0 {
+		if runLen > hllSparseZeroMaxLen {
+			n := min(runLen, hllSparseXZeroMaxLen)
+			buf = append(buf, 0x40|byte((n-1)>>8), byte(n-1))
+			runLen -= n
+		} else {
+			buf = append(buf, byte(runLen-1))
+			runLen = 0
+		}
+	}
+	return buf
+}
+
+// hllEncode builds a HyperLogLog from raw registers, sparse when asked for
+// and when the registers fit it, dense otherwise. The cached count is left
+// stale.
+func hllEncode(raw []uint8, preferSparse bool) []byte {
+	if preferSparse {
+		if buf, ok := hllEncodeSparse(raw); ok {
+			hllInvalidateCache(buf)
+			return buf
+		}
+	}
+
+	buf := make([]byte, hllDenseSize)
+	copy(buf, "HYLL")
+	buf[hllEncodingByte] = hllDense
+	registers := buf[hllHeaderSize:]
+	for i, value := range raw {
+		if value != 0 {
+			hllDenseSet(registers, i, value)
+		}
+	}
+	hllInvalidateCache(buf)
+	return buf
+}
+
+func hllEncodeSparse(raw []uint8) ([]byte, bool) {
+	buf := make([]byte, hllHeaderSize, 64)
+	copy(buf, "HYLL")
+	buf[hllEncodingByte] = hllSparse
+
+	for i := 0; i < len(raw); {
+		value := raw[i]
+		runLen := 1
+		for i+runLen < len(raw) && raw[i+runLen] == value {
+			runLen++
+		}
+		i += runLen
+
+		if value == 0 {
+			buf = appendSparseZeros(buf, runLen)
+		} else {
+			if value > hllSparseValMaxValue {
+				return nil, false
+			}
+			for runLen > 0 {
+				n := min(runLen, hllSparseValMaxLen)
+				buf = append(buf, 0x80|(value-1)<<2|byte(n-1))
+				runLen -= n
+			}
+		}
+
+		if len(buf) > hllSparseMaxBytes {
+			return nil, false
+		}
+	}
+	return buf, true
+}
+
+// hllDescribeSparse renders the opcodes of a sparse HyperLogLog the way
+// PFDEBUG DECODE does
+func hllDescribeSparse(buf []byte) (string, error) {
+	var parts []string
+	registers := buf[hllHeaderSize:]
+
+	for p := 0; p < len(registers); {
+		op := registers[p]
+		switch {
+		case op&0xc0 == 0x00:
+			parts = append(parts, fmt.Sprintf("z:%d", int(op&0x3f)+1))
+			p++
+		case op&0xc0 == 0x40:
+			if p+1 >= len(registers) {
+				return "", errHLLCorrupted
+			}
+			parts = append(parts, fmt.Sprintf("Z:%d", (int(op&0x3f)<<8|int(registers[p+1]))+1))
+			p += 2
+		default:
+			parts = append(parts, fmt.Sprintf("v:%d,%d", (op>>2)&0x1f+1, int(op&0x3)+1))
+			p++
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// hllCount estimates the cardinality with the improved estimator from
+// Otmar Ertl's "New cardinality estimation algorithms for HyperLogLog
+// sketches", the one Redis uses since 5.0
+func hllCount(raw []uint8) uint64 {
+	const m = float64(hllRegisters)
+
+	var histogram [64]int
+	for _, value := range raw {
+		histogram[value]++
+	}
+
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package store
+
+import "fmt"
+
+// lookupHLL checks that the result of a key lookup holds a HyperLogLog,
+// passing nil through for a missing key. Callers hold dataMutex.
+func lookupHLL(value *RedisValue, exists bool) (*RedisValue, error) {
+	if !exists {
+		return nil, nil
+	}
+	if value.Type != STRING {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if !isHLL(value.Bytes) {
+		return nil, errNotHLL
+	}
+	return value, nil
+}
+
+// PFAdd adds elements to the HyperLogLog at key, creating it if needed.
+// It reports whether the key was created or any register changed.
+func PFAdd(key string, elements []string) (bool, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupHLL(lookupKeyWrite(key))
+	if err != nil {
+		return false, err
+	}
+
+	created := value == nil
+	if created {
+		value = &RedisValue{Type: STRING, Bytes: newHLL()}
+		setKey(key, value)
+	}
+
+	updated := false
+	if value.Bytes[hllEncodingByte] == hllDense {
+		registers := value.Bytes[hllHeaderSize:]
+		for _, element := range elements {
+			index, count := hllPatLen([]byte(element))
+			if count > hllDenseGet(registers, index) {
+				hllDenseSet(registers, index, count)
+				updated = true
+			}
+		}
+		if updated {
+			hllInvalidateCache(value.Bytes)
+		}
+	} else if len(elements) > 0 {
+		raw, err := hllDecode(value.Bytes)
+		if err != nil {
+			return false, err
+		}
+		for _, element := range elements {
+			index, count := hllPatLen([]byte(element))
+			if count > raw[index] {
+				raw[index] = count
+				updated = true
+			}
+		}
+		if updated {
+			value.Bytes = hllEncode(raw, true)
+		}
+	}
+
+	if updated {
+		touchWatchedKey(key)
+	}
+	return created || updated, nil
+}
+
+// PFCount returns the estimated cardinality of the union of the given
+// HyperLogLogs. With a single key the estimate is cached in the value, and
+// cacheUpdated reports that the stored bytes changed.
+func PFCount(keys []string) (count uint64, cacheUpdated bool, err error) {
+	if len(keys) == 1 {
+		return pfCountSingle(keys[0])
+	}
+
+	for _, key := range keys {
+		expireIfNeeded(key)
+	}
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	union := make([]uint8, hllRegisters)
+	for _, key := range keys {
+		value, err := lookupHLL(lookupKey(key))
+		if err != nil {
+			return 0, false, err
+		}
+		if value == nil {
+			continue
+		}
+		if err := hllMergeInto(union, value.Bytes); err != nil {
+			return 0, false, err
+		}
+	}
+
+	return hllCount(union), false, nil
+}
+
+func pfCountSingle(key string) (uint64, bool, error) {
+	expireIfNeeded(key)
+
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupHLL(lookupKey(key))
+	if err != nil || value == nil {
+		return 0, false, err
+	}
+
+	if count, ok := hllCachedCount(value.Bytes); ok {
+		return count, false, nil
+	}
+
+	raw, err := hllDecode(value.Bytes)
+	if err != nil {
+		return 0, false, err
+	}
+	count := hllCount(raw)
+	hllSetCachedCount(value.Bytes, count)
+	touchWatchedKey(key)
+	return count, true, nil
+}
+
+func hllMergeInto(union []uint8, buf []byte) error {
+	raw, err := hllDecode(buf)
+	if err != nil {
+		return err
+	}
+	for i, value := range raw {
+		if value > union[i] {
+			union[i] = value
+		}
+	}
+	return nil
+}
+
+// PFMerge stores the union of dest and the sources in dest. The result stays
+// sparse only when every input was sparse and it still fits the encoding.
+func PFMerge(dest string, sources []string) error {
+	dataMutex.Lock()
+	defer unlockData()
+
+	destValue, err := lookupHLL(lookupKeyWrite(dest))
+	if err != nil {
+		return err
+	}
+
+	union := make([]uint8, hllRegisters)
+	useDense := false
+
+	inputs := make([]*RedisValue, 0, len(sources)+1)
+	if destValue != nil {
+		inputs = append(inputs, destValue)
+	}
+	for _, source := range sources {
+		value, err := lookupHLL(lookupKey(source))
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			inputs = append(inputs, value)
+		}
+	}
+
+	for _, value := range inputs {
+		if value.Bytes[hllEncodingByte] == hllDense {
+			useDense = true
+		}
+		if err := hllMergeInto(union, value.Bytes); err != nil {
+			return err
+		}
+	}
+
+	merged := hllEncode(union, !useDense)
+	if destValue == nil {
+		setKey(dest, &RedisValue{Type: STRING, Bytes: merged})
+	} else {
+		destValue.Bytes = merged
+		touchWatchedKey(dest)
+	}
+	return nil
+}
+
+// lookupHLLForDebug is the PFDEBUG lookup, where a missing key is an error.
+// Callers hold dataMutex.Lock.
+func lookupHLLForDebug(key string) (*RedisValue, error) {
+	value, err := lookupHLL(lookupKeyWrite(key))
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("ERR The specified key does not exist")
+	}
+	return value, nil
+}
+
+// hllToDense converts a sparse HyperLogLog in place, keeping its cached count
+func hllToDense(value *RedisValue) (bool, error) {
+	if value.Bytes[hllEncodingByte] == hllDense {
+		return false, nil
+	}
+
+	raw, err := hllDecode(value.Bytes)
+	if err != nil {
+		return false, err
+	}
+	dense := hllEncode(raw, false)
+	copy(dense[8:16], value.Bytes[8:16])
+	value.Bytes = dense
+	return true, nil
+}
+
+// PFDebugRegisters returns every register of the HyperLogLog at key. Like
+// Redis it converts a sparse value to dense first, converted reports that.
+func PFDebugRegisters(key string) (registers []uint8, converted bool, err error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupHLLForDebug(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if converted, err = hllToDense(value); err != nil {
+		return nil, false, err
+	}
+	if converted {
+		touchWatchedKey(key)
+	}
+
+	registers, err = hllDecode(value.Bytes)
+	return registers, converted, err
+}
+
+// PFDebugDecode lists the opcodes of a sparse HyperLogLog
+func PFDebugDecode(key string) (string, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupHLLForDebug(key)
+	if err != nil {
+		return "", err
+	}
+	if value.Bytes[hllEncodingByte] != hllSparse {
+		return "", fmt.Errorf("ERR HLL encoding is not sparse")
+	}
+	return hllDescribeSparse(value.Bytes)
+}
+
+// PFDebugEncoding returns "sparse" or "dense"
+func PFDebugEncoding(key string) (string, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupHLLForDebug(key)
+	if err != nil {
+		return "", err
+	}
+	if value.Bytes[hllEncodingByte] == hllSparse {
+		return "sparse", nil
+	}
+	return "dense", nil
+}
+
+// PFDebugToDense converts the HyperLogLog at key to the dense encoding and
+// reports whether it was sparse
+func PFDebugToDense(key string) (bool, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupHLLForDebug(key)
+	if err != nil {
+		return false, err
+	}
+	converted, err := hllToDense(value)
+	if converted {
+		touchWatchedKey(key)
+	}
+	return converted, err
+}
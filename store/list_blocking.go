@@ -1,132 +1,87 @@
 package store
 
-import (
-	"sync"
-	"time"
-)
-
-type BlockingClient struct {
-	Keys     []string
-	Left     bool
-	Response chan BlockingResult
-	Timeout  time.Duration
-}
-
-type BlockingResult struct {
-	Key     string
-	Value   string
-	Success bool
+// ListBlockingOp describes a list pop that may block: BLPOP/BRPOP pop one
+// element, BLMPOP pops up to Count, and BLMOVE/BRPOPLPUSH push the element
+// onto Destination. The non-blocking forms run through the same code.
+type ListBlockingOp struct {
+	Keys        []string
+	Left        bool
+	Count       int
+	Destination string
+	ToLeft      bool
 }
 
-var (
-	blockingClients = make(map[string][]*BlockingClient)
-	blockingMutex   sync.Mutex
-)
-
-func ListBlockingPopImmediate(keys []string, left bool) (string, string, bool) {
-
+// ListPopOrBlock runs op against the first of its keys holding a non-empty
+// list. When none does and blocking is set, the client is registered on all
+// the keys before the lock is released so no push can slip in between.
+func ListPopOrBlock(op ListBlockingOp, clientID int64, blocking bool) (*BlockingResult, *Waiter, error) {
 	dataMutex.Lock()
-	defer dataMutex.Unlock()
-
-	for _, key := range keys {
-		value, exists := data[key]
-		if !exists {
-			continue
-		}
-
-		if value.Type != LIST {
-			continue
-		}
-
-		if value.Expiry != nil && time.Now().After(*value.Expiry) {
-			delete(data, key)
-			continue
-		}
-		listlen := len(value.List)
+	defer unlockData()
+	blockingMutex.Lock()
+	defer blockingMutex.Unlock()
 
-		if listlen == 0 {
-			continue
+	for _, key := range op.Keys {
+		result, err := serveListOp(op, key)
+		if err != nil {
+			return nil, nil, err
 		}
-
-		var element string
-		if left {
-			element = value.List[0]
-			value.List = value.List[1:]
-		} else {
-			element = value.List[listlen-1]
-			value.List = value.List[:listlen-1]
+		if result != nil {
+			return result, nil, nil
 		}
-
-		return key, element, true
 	}
-	return "", "", false
-}
 
-// Registering a client to wait for elements on keys
-func RegisterBlockingClient(keys []string, left bool, timeout time.Duration) *BlockingClient {
-	blockingMutex.Lock()
-	defer blockingMutex.Unlock()
-
-	client := &BlockingClient{
-		Keys:     keys,
-		Left:     left,
-		Response: make(chan BlockingResult, 1),
-		Timeout:  timeout,
+	if !blocking {
+		return nil, nil, nil
 	}
 
-	// Register client for each key
-	for _, key := range keys {
-		blockingClients[key] = append(blockingClients[key], client)
-	}
-	return client
+	waiter := block(&Waiter{Keys: op.Keys, ClientID: clientID, ListOp: &op})
+	return nil, waiter, nil
 }
 
-func UnregisterBlockingClient(client *BlockingClient) {
-	blockingMutex.Lock()
-	defer blockingMutex.Unlock()
+// serveListOp pops from key for op, returning nil when key holds no
+// elements. Callers hold dataMutex and blockingMutex.
+func serveListOp(op ListBlockingOp, key string) (*BlockingResult, error) {
+	source, err := lookupList(lookupKeyWrite(key))
+	if err != nil || source == nil || source.List.Len() == 0 {
+		return nil, err
+	}
 
-	for _, key := range client.Keys {
-		clients := blockingClients[key]
-		for i, c := range clients {
-			if c == client {
-				blockingClients[key] = append(clients[:i], clients[i+1:]...)
-				break
-			}
-		}
-		// Clean Up empty key entries
-		if len(blockingClients[key]) == 0 {
-			delete(blockingClients, key)
+	// Check the destination before popping so a type error leaves both alone
+	var destination *RedisValue
+	if op.Destination != "" {
+		if destination, err = lookupList(lookupKeyWrite(op.Destination)); err != nil {
+			return nil, err
 		}
 	}
-}
 
-// Notify waiting clients when new elements are added
-func NotifyBlockingClients(key string) {
-	blockingMutex.Lock()
-	clients := blockingClients[key]
-	// Make a copy to avoid holding the lock too long
-
-	clientsCopy := make([]*BlockingClient, len(clients))
-	copy(clientsCopy, clients)
-	blockingMutex.Unlock()
-
-	if len(clientsCopy) == 0 {
-		return
+	count := max(op.Count, 1)
+	var elements []string
+	if op.Left {
+		elements = source.List.PopHead(count)
+	} else {
+		elements = source.List.PopTail(count)
 	}
+	touchWatchedKey(key)
 
-	for _, client := range clientsCopy {
-		foundKey, element, found := ListBlockingPopImmediate(client.Keys, client.Left)
-		if !found {
-			break
+	if op.Destination != "" {
+		if destination == nil {
+			destination = &RedisValue{Type: LIST, List: NewQuicklist()}
+			setKey(op.Destination, destination)
 		}
-
-		select {
-		case client.Response <- BlockingResult{Key: foundKey, Value: element, Success: true}:
-			// Successfully notified client, remove from waiting list
-			UnregisterBlockingClient(client)
-		default:
-			// Client channel is full or closed, remove it
-			UnregisterBlockingClient(client)
+		if op.ToLeft {
+			destination.List.PushHead(elements[0])
+		} else {
+			destination.List.PushTail(elements[0])
 		}
+		touchWatchedKey(op.Destination)
+		markKeyReady(op.Destination)
+	}
+
+	// When source is destination the push refilled it, so this only fires
+	// for a separate source that was drained
+	if source.List.Len() == 0 {
+		deleteKey(key)
 	}
+
+	return &BlockingResult{Key: key, Elements: elements}, nil
 }
@@ -1,12 +1,17 @@
 package store
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 func GetListLength(key string) int {
+	expireIfNeeded(key)
+
 	dataMutex.RLock()
 	defer dataMutex.RUnlock()
 
-	value, exists := data[key]
+	value, exists := lookupKey(key)
 	if !exists {
 		return 0
 	}
@@ -15,20 +20,17 @@ func GetListLength(key string) int {
 		return -1
 	}
 
-	//check expiry
-	if value.Expiry != nil && time.Now().After(*value.Expiry) {
-		return 0
-	}
-
-	return len(value.List)
+	return value.List.Len()
 
 }
 
 func ListIndex(key string, index int) (string, bool) {
+	expireIfNeeded(key)
+
 	dataMutex.RLock()
 	defer dataMutex.RUnlock()
 
-	value, exists := data[key]
+	value, exists := lookupKey(key)
 
 	if !exists {
 		return "", false
@@ -38,36 +40,18 @@ func ListIndex(key string, index int) (string, bool) {
 		return "", false
 	}
 
-	//check expiry
-	if value.Expiry != nil && time.Now().After(*value.Expiry) {
-		return "", false
-	}
-
-	listlen := len(value.List)
-	if listlen == 0 {
-		return "", false
-	}
-
-	// handle negative indexing
-	if index < 0 {
-		index = listlen + index
-	}
-
-	//check bounds
-	if index < 0 || index >= listlen {
-		return "", false
-	}
-
-	return value.List[index], true
+	return value.List.Index(index)
 
 }
 
 func ListRange(key string, start, stop int) ([]string, bool) {
 
+	expireIfNeeded(key)
+
 	dataMutex.RLock()
 	defer dataMutex.RUnlock()
 
-	value, exists := data[key]
+	value, exists := lookupKey(key)
 	if !exists {
 		return []string{}, true
 	}
@@ -76,12 +60,7 @@ func ListRange(key string, start, stop int) ([]string, bool) {
 		return nil, false
 	}
 
-	//Check expiry
-	if value.Expiry != nil && time.Now().After(*value.Expiry) {
-		return []string{}, true
-	}
-
-	listlen := len(value.List)
+	listlen := value.List.Len()
 	if listlen == 0 {
 		return []string{}, true
 	}
@@ -107,7 +86,7 @@ func ListRange(key string, start, stop int) ([]string, bool) {
 		return []string{}, true
 	}
 
-	return value.List[start : stop+1], true
+	return value.List.Range(start, stop), true
 }
 
 func ListPop(key string, left bool) (string, bool) {
@@ -120,9 +99,9 @@ func ListPop(key string, left bool) (string, bool) {
 
 func ListPopMultiple(key string, count int, left bool) ([]string, bool) {
 	dataMutex.Lock()
-	defer dataMutex.Unlock()
+	defer unlockData()
 
-	value, exists := data[key]
+	value, exists := lookupKeyWrite(key)
 	if !exists {
 		return nil, false
 	}
@@ -131,35 +110,16 @@ func ListPopMultiple(key string, count int, left bool) ([]string, bool) {
 		return nil, false
 	}
 
-	if value.Expiry != nil && time.Now().After(*value.Expiry) {
-		delete(data, key)
-		return nil, false
-	}
-
-	listlen := len(value.List)
-	if listlen == 0 {
-		return []string{}, true
-	}
-
-	if count > listlen {
-		count = listlen
-	}
-
 	var result []string
 	if left {
-		result = make([]string, count)
-		copy(result, value.List[:count])
-		value.List = value.List[count:]
+		result = value.List.PopHead(count)
 	} else {
-		result = make([]string, count)
-		startIndex := listlen - count
-		copy(result, value.List[startIndex:])
-		value.List = value.List[:startIndex]
-
-		for i := 0; i < len(result)/2; i++ {
-			j := len(result) - 1 - i
-			result[i], result[j] = result[j], result[i]
-		}
+		result = value.List.PopTail(count)
+	}
+	touchWatchedKey(key)
+
+	if value.List.Len() == 0 {
+		deleteKey(key)
 	}
 
 	return result, true
@@ -169,16 +129,16 @@ func ListPopMultiple(key string, count int, left bool) ([]string, bool) {
 // New List Operations
 func ListPush(key string, elements []string, left bool) int {
 	dataMutex.Lock()
-	defer dataMutex.Unlock()
+	defer unlockData()
 
-	value, exists := data[key]
+	value, exists := lookupKeyWrite(key)
 	//for very first value, to create one
 	if !exists {
 		value = &RedisValue{
 			Type: LIST,
-			List: make([]string, 0),
+			List: NewQuicklist(),
 		}
-		data[key] = value
+		setKey(key, value)
 	}
 
 	//type check
@@ -186,41 +146,28 @@ func ListPush(key string, elements []string, left bool) int {
 		return -1
 	}
 
-	//expiry check
-	if value.Expiry != nil && time.Now().After(*value.Expiry) {
-		//reset expired list
-		value.List = make([]string, 0)
-		value.Expiry = nil
-	}
-
 	// add elements
 	if left {
-		// LPUSH: prepend elements (reverse order for multiples)
-		for i := len(elements) - 1; i >= 0; i-- {
-			value.List = append([]string{elements[i]}, value.List...)
-		}
+		// LPUSH: each element is prepended in turn, so the last one ends up first
+		value.List.PushHead(elements...)
 	} else {
 		//RPUSH: append elements
-		value.List = append(value.List, elements...)
+		value.List.PushTail(elements...)
 	}
-	length := len(value.List)
-
-	dataMutex.Unlock()
-
-	go NotifyBlockingClients(key)
+	touchWatchedKey(key)
+	signalKeyReady(key)
 
-	dataMutex.Lock()
-	return length
+	return value.List.Len()
 }
 
 // CreateEmptyList creates an empty list (used when loading empty lists from RDB)
 func CreateEmptyList(key string, ttl time.Duration) {
 	dataMutex.Lock()
-	defer dataMutex.Unlock()
+	defer unlockData()
 
 	value := &RedisValue{
 		Type: LIST,
-		List: make([]string, 0),
+		List: NewQuicklist(),
 	}
 
 	if ttl > 0 {
@@ -228,20 +175,20 @@ func CreateEmptyList(key string, ttl time.Duration) {
 		value.Expiry = &expiry
 	}
 
-	data[key] = value
+	setKey(key, value)
 }
 
 // ListPushBulk pushes multiple elements without notifying blocking clients
 // Used for efficient RDB loading
 func ListPushBulk(key string, elements []string, left bool, ttl time.Duration) int {
 	dataMutex.Lock()
-	defer dataMutex.Unlock()
+	defer unlockData()
 
-	value, exists := data[key]
+	value, exists := lookupKeyWrite(key)
 	if !exists {
 		value = &RedisValue{
 			Type: LIST,
-			List: make([]string, 0),
+			List: NewQuicklist(),
 		}
 
 		if ttl > 0 {
@@ -249,7 +196,7 @@ func ListPushBulk(key string, elements []string, left bool, ttl time.Duration) i
 			value.Expiry = &expiry
 		}
 
-		data[key] = value
+		setKey(key, value)
 	}
 
 	if value.Type != LIST {
@@ -258,14 +205,234 @@ func ListPushBulk(key string, elements []string, left bool, ttl time.Duration) i
 
 	// Add elements
 	if left {
-		// LPUSH: prepend elements (reverse order for multiples)
-		for i := len(elements) - 1; i >= 0; i-- {
-			value.List = append([]string{elements[i]}, value.List...)
-		}
+		value.List.PushHead(elements...)
 	} else {
 		// RPUSH: append elements (maintains RDB order)
-		value.List = append(value.List, elements...)
+		value.List.PushTail(elements...)
+	}
+
+	return value.List.Len()
+}
+
+// lookupList checks that the result of a key lookup holds a list, passing nil
+// through for a missing key. Callers hold dataMutex.
+func lookupList(value *RedisValue, exists bool) (*RedisValue, error) {
+	if !exists {
+		return nil, nil
+	}
+	if value.Type != LIST {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return value, nil
+}
+
+// ListPushExisting is LPUSHX/RPUSHX: it pushes only onto an existing list
+// and returns the new length, 0 when the key does not exist
+func ListPushExisting(key string, elements []string, left bool) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupList(lookupKeyWrite(key))
+	if err != nil || value == nil {
+		return 0, err
+	}
+
+	if left {
+		value.List.PushHead(elements...)
+	} else {
+		value.List.PushTail(elements...)
+	}
+	touchWatchedKey(key)
+	signalKeyReady(key)
+
+	return value.List.Len(), nil
+}
+
+func reversed(elements []string) []string {
+	result := make([]string, len(elements))
+	for i, element := range elements {
+		result[len(elements)-1-i] = element
 	}
+	return result
+}
 
-	return len(value.List)
+// ListSet replaces the element at index, negative indexes counting from the tail
+func ListSet(key string, index int, element string) error {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupList(lookupKeyWrite(key))
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return fmt.Errorf("ERR no such key")
+	}
+
+	if index < 0 {
+		index += value.List.Len()
+	}
+	if index < 0 || index >= value.List.Len() {
+		return fmt.Errorf("ERR index out of range")
+	}
+
+	value.List.Set(index, element)
+	touchWatchedKey(key)
+	return nil
+}
+
+// ListInsert inserts element before or after the first occurrence of pivot.
+// It returns the new length, -1 when pivot was not found and 0 when the key
+// does not exist.
+func ListInsert(key string, before bool, pivot, element string) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupList(lookupKeyWrite(key))
+	if err != nil || value == nil {
+		return 0, err
+	}
+
+	position := -1
+	value.List.Iterate(false, func(index int, existing string) bool {
+		if existing == pivot {
+			position = index
+			return false
+		}
+		return true
+	})
+	if position < 0 {
+		return -1, nil
+	}
+
+	if !before {
+		position++
+	}
+	value.List.InsertAt(position, element)
+	touchWatchedKey(key)
+	return value.List.Len(), nil
+}
+
+// ListRemove removes occurrences of element: the first count from the head
+// for count > 0, the last -count from the tail for count < 0, all of them for
+// count == 0. It returns how many were removed.
+func ListRemove(key string, count int, element string) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupList(lookupKeyWrite(key))
+	if err != nil || value == nil {
+		return 0, err
+	}
+
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+
+	// A negative count removes from the tail, so the list is walked backwards
+	removed := 0
+	value.List.Filter(count < 0, func(existing string) bool {
+		if existing == element && (limit == 0 || removed < limit) {
+			removed++
+			return false
+		}
+		return true
+	})
+	if removed > 0 {
+		touchWatchedKey(key)
+	}
+
+	if value.List.Len() == 0 {
+		deleteKey(key)
+	}
+	return removed, nil
+}
+
+// ListTrim keeps only the elements between start and stop inclusive
+func ListTrim(key string, start, stop int) error {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, err := lookupList(lookupKeyWrite(key))
+	if err != nil || value == nil {
+		return err
+	}
+
+	length := value.List.Len()
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	stop = min(stop, length-1)
+
+	if start > stop {
+		deleteKey(key)
+		return nil
+	}
+
+	value.List.Trim(start, stop)
+	touchWatchedKey(key)
+	return nil
+}
+
+// ListPosition returns the indexes of matches of element, starting at the
+// rank-th match (from the tail when rank is negative), returning at most
+// count of them (all when count is 0) and comparing at most maxLen elements
+// (all when maxLen is 0)
+func ListPosition(key, element string, rank, count, maxLen int) ([]int, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	value, err := lookupList(lookupKey(key))
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+
+	var matches []int
+	compared := 0
+	value.List.Iterate(rank < 0, func(index int, existing string) bool {
+		if maxLen > 0 && compared >= maxLen {
+			return false
+		}
+		compared++
+
+		if existing != element {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		matches = append(matches, index)
+		return count == 0 || len(matches) < count
+	})
+	return matches, nil
+}
+
+// ListMove atomically pops an element from one end of source and pushes it
+// onto one end of destination, which may be the same list. It reports false
+// when source does not exist.
+func ListMove(source, destination string, fromLeft, toLeft bool) (string, bool, error) {
+	op := ListBlockingOp{
+		Keys:        []string{source},
+		Left:        fromLeft,
+		Destination: destination,
+		ToLeft:      toLeft,
+	}
+
+	result, _, err := ListPopOrBlock(op, 0, false)
+	if err != nil || result == nil {
+		return "", false, err
+	}
+	return result.Elements[0], true, nil
 }
@@ -0,0 +1,607 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/kushalsdesk/redis_with_go/lzf"
+)
+
+// Lists are stored like Redis's quicklist: a doubly linked list of nodes,
+// each holding a bounded chunk of elements. Pushes and pops at either end
+// touch a single small node, indexing skips whole nodes, and a drained node
+// is released as a whole instead of pinning a large backing array.
+//
+// list-max-listpack-size bounds a node: negative values -1..-5 limit its
+// estimated listpack size to 4, 8, 16, 32 or 64 KB, positive values limit
+// its element count. list-compress-depth > 0 keeps that many nodes at each
+// end plain and LZF-compresses the ones in between.
+var (
+	listMaxListpackSize = -2
+	listCompressDepth   = 0
+	listConfigMutex     sync.RWMutex
+)
+
+const (
+	listpackHeaderSize = 7 // total bytes, element count and end marker
+
+	// Nodes smaller than this are not worth compressing, and compression has
+	// to save at least quicklistMinCompressImprove bytes to be kept
+	quicklistMinCompressBytes   = 48
+	quicklistMinCompressImprove = 8
+
+	// Count-limited nodes are still kept under this many bytes
+	quicklistSizeSafetyLimit = 8192
+)
+
+// SetListConfig sets list-max-listpack-size and list-compress-depth for
+// lists created from now on
+func SetListConfig(maxListpackSize, compressDepth int) {
+	listConfigMutex.Lock()
+	defer listConfigMutex.Unlock()
+
+	if maxListpackSize == 0 || maxListpackSize < -5 {
+		maxListpackSize = -2
+	}
+	listMaxListpackSize = maxListpackSize
+	listCompressDepth = max(compressDepth, 0)
+}
+
+func getListConfig() (int, int) {
+	listConfigMutex.RLock()
+	defer listConfigMutex.RUnlock()
+	return listMaxListpackSize, listCompressDepth
+}
+
+type quicklistNode struct {
+	prev, next *quicklistNode
+
+	// entries is nil while the node is compressed, compressed then holds the
+	// LZF form of the entries serialized to rawSize bytes
+	entries    []string
+	compressed []byte
+	rawSize    int
+
+	count int
+	size  int // estimated listpack bytes
+}
+
+type Quicklist struct {
+	head, tail *quicklistNode
+	count      int
+	nodes      int
+
+	fill  int
+	depth int
+
+	// packed reports whether Redis would hold the list as a single
+	// listpack. Like Redis it only converts back once the list shrank to
+	// half the limit, so lists at the boundary don't flip on every push.
+	packed bool
+}
+
+func NewQuicklist() *Quicklist {
+	fill, depth := getListConfig()
+	return &Quicklist{fill: fill, depth: depth, packed: true}
+}
+
+// listpackEntrySize estimates the bytes an element takes in a listpack:
+// an encoding header, the payload and a back length
+func listpackEntrySize(element string) int {
+	var encoded int
+	if v, err := strconv.ParseInt(element, 10, 64); err == nil && len(element) <= 20 && strconv.FormatInt(v, 10) == element {
+		switch {
+		case v >= -64 && v <= 63:
+			encoded = 1
+		case v >= -2048 && v <= 2047:
+			encoded = 2
+		case v >= -32768 && v <= 32767:
+			encoded = 3
+		case v >= -8388608 && v <= 8388607:
+			encoded = 4
+		case v >= -2147483648 && v <= 2147483647:
+			encoded = 5
+		default:
+			encoded = 9
+		}
+	} else {
+		switch n := len(element); {
+		case n < 64:
+			encoded = 1 + n
+		case n < 4096:
+			encoded = 2 + n
+		default:
+			encoded = 5 + n
+		}
+	}
+
+	switch {
+	case encoded < 128:
+		return encoded + 1
+	case encoded < 16384:
+		return encoded + 2
+	case encoded < 2097152:
+		return encoded + 3
+	case encoded < 268435456:
+		return encoded + 4
+	}
+	return encoded + 5
+}
+
+func (ql *Quicklist) sizeLimit() int {
+	return 4096 << (-ql.fill - 1)
+}
+
+// allowsInsert reports whether node can take one more element of the given
+// size without going over list-max-listpack-size. A single oversized
+// element still gets a node of its own.
+func (ql *Quicklist) allowsInsert(node *quicklistNode, size int) bool {
+	if node == nil {
+		return false
+	}
+	if ql.fill > 0 {
+		return node.count < ql.fill && node.size+size <= quicklistSizeSafetyLimit
+	}
+	return node.size+size <= ql.sizeLimit()
+}
+
+func (ql *Quicklist) overLimit(node *quicklistNode) bool {
+	if node.count <= 1 {
+		return false
+	}
+	if ql.fill > 0 {
+		return node.count > ql.fill || node.size > quicklistSizeSafetyLimit
+	}
+	return node.size > ql.sizeLimit()
+}
+
+func (ql *Quicklist) updatePacked() {
+	switch {
+	case ql.nodes > 1:
+		ql.packed = false
+	case ql.packed || ql.head == nil:
+		ql.packed = true
+	case ql.fill > 0:
+		ql.packed = ql.head.count <= ql.fill/2
+	default:
+		ql.packed = ql.head.size <= ql.sizeLimit()/2
+	}
+}
+
+// Encoding is what OBJECT ENCODING reports for the list
+func (ql *Quicklist) Encoding() string {
+	if ql.packed {
+		return "listpack"
+	}
+	return "quicklist"
+}
+
+func (ql *Quicklist) Len() int {
+	return ql.count
+}
+
+func newQuicklistNode() *quicklistNode {
+	return &quicklistNode{size: listpackHeaderSize}
+}
+
+func (ql *Quicklist) linkBefore(node, at *quicklistNode) {
+	node.next = at
+	if at == nil {
+		node.prev = ql.tail
+		if ql.tail != nil {
+			ql.tail.next = node
+		}
+		ql.tail = node
+	} else {
+		node.prev = at.prev
+		if at.prev != nil {
+			at.prev.next = node
+		}
+		at.prev = node
+	}
+	if node.prev == nil {
+		ql.head = node
+	}
+	ql.nodes++
+}
+
+func (ql *Quicklist) unlink(node *quicklistNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		ql.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		ql.tail = node.prev
+	}
+	node.prev, node.next = nil, nil
+	ql.nodes--
+}
+
+// PushHead prepends elements one by one, so the last one ends up first
+func (ql *Quicklist) PushHead(elements ...string) {
+	for _, element := range elements {
+		size := listpackEntrySize(element)
+		if !ql.allowsInsert(ql.head, size) {
+			ql.linkBefore(newQuicklistNode(), ql.head)
+			ql.compressBoundary()
+		}
+		node := ql.head
+		node.entries = append(node.entries, "")
+		copy(node.entries[1:], node.entries)
+		node.entries[0] = element
+		node.count++
+		node.size += size
+		ql.count++
+	}
+	ql.updatePacked()
+}
+
+// PushTail appends elements in order
+func (ql *Quicklist) PushTail(elements ...string) {
+	for _, element := range elements {
+		size := listpackEntrySize(element)
+		if !ql.allowsInsert(ql.tail, size) {
+			ql.linkBefore(newQuicklistNode(), nil)
+			ql.compressBoundary()
+		}
+		node := ql.tail
+		node.entries = append(node.entries, element)
+		node.count++
+		node.size += size
+		ql.count++
+	}
+	ql.updatePacked()
+}
+
+// PopHead removes up to n elements from the head, in list order
+func (ql *Quicklist) PopHead(n int) []string {
+	n = min(n, ql.count)
+	result := make([]string, 0, n)
+	for len(result) < n {
+		node := ql.head
+		take := min(n-len(result), node.count)
+		for _, element := range node.entries[:take] {
+			node.size -= listpackEntrySize(element)
+		}
+		result = append(result, node.entries[:take]...)
+		clear(node.entries[:take])
+		node.entries = node.entries[take:]
+		node.count -= take
+		ql.count -= take
+		if node.count == 0 {
+			ql.unlink(node)
+			ql.compressBoundary()
+		}
+	}
+	ql.updatePacked()
+	return result
+}
+
+// PopTail removes up to n elements from the tail, last element first
+func (ql *Quicklist) PopTail(n int) []string {
+	n = min(n, ql.count)
+	result := make([]string, 0, n)
+	for len(result) < n {
+		node := ql.tail
+		take := min(n-len(result), node.count)
+		for i := node.count - 1; i >= node.count-take; i-- {
+			node.size -= listpackEntrySize(node.entries[i])
+			result = append(result, node.entries[i])
+		}
+		clear(node.entries[node.count-take:])
+		node.entries = node.entries[:node.count-take]
+		node.count -= take
+		ql.count -= take
+		if node.count == 0 {
+			ql.unlink(node)
+			ql.compressBoundary()
+		}
+	}
+	ql.updatePacked()
+	return result
+}
+
+// locate returns the node holding index (0 <= index < count) and the offset
+// of the element within it, walking from whichever end is closer
+func (ql *Quicklist) locate(index int) (*quicklistNode, int) {
+	if index < ql.count/2 {
+		node := ql.head
+		for index >= node.count {
+			index -= node.count
+			node = node.next
+		}
+		return node, index
+	}
+
+	node := ql.tail
+	fromTail := ql.count - 1 - index
+	for fromTail >= node.count {
+		fromTail -= node.count
+		node = node.prev
+	}
+	return node, node.count - 1 - fromTail
+}
+
+// Index returns the element at index, negative indexes counting from the tail
+func (ql *Quicklist) Index(index int) (string, bool) {
+	if index < 0 {
+		index += ql.count
+	}
+	if index < 0 || index >= ql.count {
+		return "", false
+	}
+	node, offset := ql.locate(index)
+	return node.view()[offset], true
+}
+
+// Set replaces the element at index (0 <= index < count)
+func (ql *Quicklist) Set(index int, element string) {
+	node, offset := ql.locate(index)
+	node.decompress()
+	node.size += listpackEntrySize(element) - listpackEntrySize(node.entries[offset])
+	node.entries[offset] = element
+	if ql.overLimit(node) {
+		ql.split(node)
+	}
+	ql.compressAll()
+	ql.updatePacked()
+}
+
+// Range returns the elements between start and stop inclusive, both already
+// clamped to the list
+func (ql *Quicklist) Range(start, stop int) []string {
+	if start > stop || ql.count == 0 {
+		return []string{}
+	}
+
+	result := make([]string, 0, stop-start+1)
+	node, offset := ql.locate(start)
+	for ; node != nil && len(result) < cap(result); node = node.next {
+		entries := node.view()
+		take := min(len(entries)-offset, cap(result)-len(result))
+		result = append(result, entries[offset:offset+take]...)
+		offset = 0
+	}
+	return result
+}
+
+// Iterate calls fn with each index and element, from the tail when reverse
+// is set, until fn returns false
+func (ql *Quicklist) Iterate(reverse bool, fn func(index int, element string) bool) {
+	if !reverse {
+		index := 0
+		for node := ql.head; node != nil; node = node.next {
+			for _, element := range node.view() {
+				if !fn(index, element) {
+					return
+				}
+				index++
+			}
+		}
+		return
+	}
+
+	index := ql.count - 1
+	for node := ql.tail; node != nil; node = node.prev {
+		entries := node.view()
+		for i := len(entries) - 1; i >= 0; i-- {
+			if !fn(index, entries[i]) {
+				return
+			}
+			index--
+		}
+	}
+}
+
+// InsertAt inserts element so it ends up at index (0 <= index <= count)
+func (ql *Quicklist) InsertAt(index int, element string) {
+	if index == 0 {
+		ql.PushHead(element)
+		return
+	}
+	if index == ql.count {
+		ql.PushTail(element)
+		return
+	}
+
+	node, offset := ql.locate(index)
+	node.decompress()
+	node.entries = append(node.entries, "")
+	copy(node.entries[offset+1:], node.entries[offset:])
+	node.entries[offset] = element
+	node.count++
+	node.size += listpackEntrySize(element)
+	ql.count++
+
+	if ql.overLimit(node) {
+		ql.split(node)
+	}
+	ql.compressAll()
+	ql.updatePacked()
+}
+
+// split moves the second half of an over-full node into a new node after it
+func (ql *Quicklist) split(node *quicklistNode) {
+	half := node.count / 2
+
+	moved := newQuicklistNode()
+	moved.entries = append(moved.entries, node.entries[half:]...)
+	moved.count = len(moved.entries)
+	for _, element := range moved.entries {
+		moved.size += listpackEntrySize(element)
+	}
+
+	clear(node.entries[half:])
+	node.entries = node.entries[:half]
+	node.count = half
+	node.size -= moved.size - listpackHeaderSize
+
+	ql.linkBefore(moved, node.next)
+}
+
+// Filter keeps the elements keep accepts, visiting them from the tail when
+// reverse is set, and returns how many were dropped
+func (ql *Quicklist) Filter(reverse bool, keep func(element string) bool) int {
+	removed := 0
+	node := ql.head
+	if reverse {
+		node = ql.tail
+	}
+
+	for node != nil {
+		next := node.next
+		if reverse {
+			next = node.prev
+		}
+
+		node.decompress()
+		kept := make([]string, 0, node.count)
+		if reverse {
+			for i := node.count - 1; i >= 0; i-- {
+				if keep(node.entries[i]) {
+					kept = append(kept, node.entries[i])
+				}
+			}
+			kept = reversed(kept)
+		} else {
+			for _, element := range node.entries {
+				if keep(element) {
+					kept = append(kept, element)
+				}
+			}
+		}
+
+		if dropped := node.count - len(kept); dropped > 0 {
+			removed += dropped
+			ql.count -= dropped
+			node.entries = kept
+			node.count = len(kept)
+			node.size = listpackHeaderSize
+			for _, element := range kept {
+				node.size += listpackEntrySize(element)
+			}
+			if node.count == 0 {
+				ql.unlink(node)
+			}
+		}
+		node = next
+	}
+
+	ql.compressAll()
+	ql.updatePacked()
+	return removed
+}
+
+// Trim keeps only the elements between start and stop inclusive, both
+// already clamped to the list
+func (ql *Quicklist) Trim(start, stop int) {
+	if start > stop {
+		ql.PopHead(ql.count)
+		return
+	}
+	ql.PopTail(ql.count - 1 - stop)
+	ql.PopHead(start)
+}
+
+// view returns the entries of a node without changing it, decoding a
+// compressed node into a temporary slice. Reads run under the shared lock
+// so they must not decompress in place.
+func (node *quicklistNode) view() []string {
+	if node.compressed == nil {
+		return node.entries
+	}
+	return node.decode()
+}
+
+// decode decompresses a node. Only compress writes compressed nodes, so
+// data that does not decode is a bug of this package rather than bad input,
+// and is reported with what went wrong.
+func (node *quicklistNode) decode() []string {
+	raw, err := lzf.Decompress(node.compressed, node.rawSize)
+	if err != nil {
+		panic(fmt.Sprintf("quicklist: compressed node of %d bytes does not expand to %d: %v", len(node.compressed), node.rawSize, err))
+	}
+
+	entries := make([]string, 0, node.count)
+	for len(raw) > 0 {
+		length, n := binary.Uvarint(raw)
+		if n <= 0 || length > uint64(len(raw)-n) {
+			panic(fmt.Sprintf("quicklist: compressed node has a bad entry length after %d entries", len(entries)))
+		}
+		entries = append(entries, string(raw[n:n+int(length)]))
+		raw = raw[n+int(length):]
+	}
+	return entries
+}
+
+func (node *quicklistNode) decompress() {
+	if node.compressed == nil {
+		return
+	}
+	node.entries = node.decode()
+	node.compressed = nil
+	node.rawSize = 0
+}
+
+func (node *quicklistNode) compress() {
+	if node.compressed != nil || node.size < quicklistMinCompressBytes {
+		return
+	}
+
+	var raw []byte
+	for _, element := range node.entries {
+		raw = binary.AppendUvarint(raw, uint64(len(element)))
+		raw = append(raw, element...)
+	}
+
+	compressed := lzf.Compress(raw)
+	if compressed == nil || len(raw)-len(compressed) < quicklistMinCompressImprove {
+		return
+	}
+	node.compressed = compressed
+	node.rawSize = len(raw)
+	node.entries = nil
+}
+
+// compressBoundary keeps the depth nodes at each end plain and compresses
+// the first node past them, which is all a push or pop at an end can change
+func (ql *Quicklist) compressBoundary() {
+	if ql.depth == 0 || ql.nodes <= 2*ql.depth {
+		for node := ql.head; node != nil && ql.depth > 0; node = node.next {
+			node.decompress()
+		}
+		return
+	}
+
+	forward, backward := ql.head, ql.tail
+	for i := 0; i < ql.depth; i++ {
+		forward.decompress()
+		backward.decompress()
+		forward, backward = forward.next, backward.prev
+	}
+	forward.compress()
+	backward.compress()
+}
+
+// compressAll recomputes the compression of every node after a change in the
+// middle of the list
+func (ql *Quicklist) compressAll() {
+	if ql.depth == 0 {
+		return
+	}
+
+	i := 0
+	for node := ql.head; node != nil; node = node.next {
+		if i < ql.depth || ql.nodes-1-i < ql.depth {
+			node.decompress()
+		} else {
+			node.compress()
+		}
+		i++
+	}
+}
@@ -0,0 +1,15 @@
+package store
+
+// ScanKeys returns a batch of roughly count keys starting at cursor, together
+// with the cursor for the next call (0 once the keyspace has been walked).
+// Keys are returned whether or not they have expired, callers filter them.
+func ScanKeys(cursor uint64, count int) ([]string, uint64) {
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	keys := make([]string, 0, count)
+	next := keyIndex.scan(cursor, count, func(key string) {
+		keys = append(keys, key)
+	})
+	return keys, next
+}
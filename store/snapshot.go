@@ -0,0 +1,19 @@
+package store
+
+import "time"
+
+// ForEachKey calls fn with every live key and its value, holding the read
+// lock so the keys form a consistent snapshot. fn must not modify the value
+// or call back into the store.
+func ForEachKey(fn func(key string, value *RedisValue)) {
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	now := time.Now()
+	for key, value := range data {
+		if isExpired(value, now) {
+			continue
+		}
+		fn(key, value)
+	}
+}
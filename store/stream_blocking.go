@@ -1,149 +1,112 @@
 package store
 
-import (
-	"sync"
-	"time"
-)
-
-// StreamBlockingClient represents a client waiting for stream entries
-type StreamBlockingClient struct {
-	StreamKeys []string
-	StartIDs   []string
-	Count      int
-	Response   chan StreamBlockingResult
-	Timeout    time.Duration
-}
-
-type StreamBlockingResult struct {
-	Results []StreamReadResult
-	Success bool
-	Timeout bool
-}
+import "fmt"
 
 type StreamReadResult struct {
 	StreamKey string
 	Entries   []StreamEntry
 }
 
-var (
-	streamBlockingClients = make(map[string][]*StreamBlockingClient)
-	streamBlockingMutex   sync.Mutex
-)
-
-// RegisterStreamBlockingClient registers a client to wait for stream entries
-
-func RegisterStreamBlockingClient(streamKeys, startIDs []string, count int, timeout time.Duration) *StreamBlockingClient {
-	streamBlockingMutex.Lock()
-	defer streamBlockingMutex.Unlock()
-
-	processedStartIDs := make([]string, len(startIDs))
-	for i, startID := range startIDs {
-		if startID == "$" {
-			lastID := GetStreamLastID(streamKeys[i])
-			if lastID != "" {
-				processedStartIDs[i] = lastID
-			} else {
-				processedStartIDs[i] = "0-0"
-			}
-		} else {
-			processedStartIDs[i] = startID
-		}
-	}
+// streamWait is what an XREAD BLOCK client waits for: entries past ids[i]
+// on keys[i], at most count of them (all when count <= 0). For XREADGROUP
+// BLOCK, group is set and the entries come after the group's last ID.
+type streamWait struct {
+	keys  []string
+	ids   []StreamID
+	count int
+	group *StreamGroupRead
+}
 
-	client := &StreamBlockingClient{
-		StreamKeys: streamKeys,
-		StartIDs:   processedStartIDs,
-		Count:      count,
-		Response:   make(chan StreamBlockingResult, 1),
-		Timeout:    timeout,
+// lookupStream checks that the result of a key lookup holds a stream,
+// passing nil through for a missing key. Callers hold dataMutex.
+func lookupStream(value *RedisValue, exists bool) (*Stream, error) {
+	if !exists {
+		return nil, nil
 	}
-
-	//Register client for each stream key
-
-	for _, key := range streamKeys {
-		streamBlockingClients[key] = append(streamBlockingClients[key], client)
+	if value.Type != STREAM {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	return client
+	return value.Stream, nil
 }
 
-// UnregisterStreamBlockingClient removes a waiting client
-func UnregisterStreamBlockingClient(client *StreamBlockingClient) {
-	streamBlockingMutex.Lock()
-	defer streamBlockingMutex.Unlock()
-
-	for _, key := range client.StreamKeys {
-		clients := streamBlockingClients[key]
-		for i, c := range clients {
-			if c == client {
-				streamBlockingClients[key] = append(clients[:i], clients[i+1:]...)
-				break
-			}
+// StreamReadOrBlock returns the entries after ids on each stream, "$"
+// standing for the stream's current last ID. When none has new entries and
+// blocking is set, the client is registered on the streams before the lock
+// is released, so no XADD can slip in between.
+func StreamReadOrBlock(keys, ids []string, count int, clientID int64, blocking bool) ([]StreamReadResult, *Waiter, error) {
+	dataMutex.Lock()
+	defer unlockData()
+	blockingMutex.Lock()
+	defer blockingMutex.Unlock()
+
+	resolved := make([]StreamID, len(ids))
+	for i, id := range ids {
+		if id == "$" {
+			continue
 		}
-
-		if len(streamBlockingClients[key]) == 0 {
-			delete(streamBlockingClients, key)
+		parsed, err := ParseStreamIDArg(id, 0)
+		if err != nil {
+			return nil, nil, err
 		}
+		resolved[i] = parsed
 	}
-}
-
-func NotifyStreamBlockingClients(key string) {
-	streamBlockingMutex.Lock()
-	clients := streamBlockingClients[key]
-
-	//making a copy to avoid holding the lock too long
-	clientsCopy := make([]*StreamBlockingClient, len(clients))
-	copy(clientsCopy, clients)
-	streamBlockingMutex.Unlock()
 
-	if len(clientsCopy) == 0 {
-		return
-	}
+	var results []StreamReadResult
+	for i, key := range keys {
+		stream, err := lookupStream(lookupKeyWrite(key))
+		if err != nil {
+			return nil, nil, err
+		}
 
-	for _, client := range clientsCopy {
-		keyIndex := -1
-		for i, streamKey := range client.StreamKeys {
-			if streamKey == key {
-				keyIndex = i
-				break
+		// "$" only ever waits for entries added from now on
+		if ids[i] == "$" {
+			if stream != nil {
+				resolved[i] = stream.LastID
 			}
+			continue
 		}
-		if keyIndex == -1 {
+		if stream == nil {
 			continue
 		}
 
-		startID := client.StartIDs[keyIndex]
+		if entries := stream.entriesAfter(resolved[i], count); len(entries) > 0 {
+			results = append(results, StreamReadResult{StreamKey: key, Entries: entries})
+		}
+	}
 
-		// if startID == "$" {
-		// 	lastID := GetStreamLastID(key)
-		// 	if lastID != "" {
-		// 		startID = lastID
-		// 	} else {
-		// 		continue
-		// 	}
-		// }
+	if len(results) > 0 || !blocking {
+		return results, nil, nil
+	}
 
-		entries, err := StreamReadFrom(key, startID, client.Count)
+	wait := &streamWait{keys: keys, ids: resolved, count: count}
+	return nil, block(&Waiter{Keys: keys, ClientID: clientID, stream: wait}), nil
+}
 
-		if err != nil || len(entries) == 0 {
-			continue
-		}
+// serveStreamWait reads the entries a waiter is after from the stream at
+// key, returning nil when there are none yet. Callers hold dataMutex and
+// blockingMutex.
+func serveStreamWait(wait *streamWait, key string) (*BlockingResult, error) {
+	if wait.group != nil {
+		return serveGroupWait(wait, key)
+	}
 
-		results := []StreamReadResult{
-			{
-				StreamKey: key,
-				Entries:   entries,
-			},
-		}
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil || stream == nil {
+		return nil, err
+	}
 
-		select {
-		case client.Response <- StreamBlockingResult{
-			Results: results,
-			Success: true,
-			Timeout: false,
-		}:
-			UnregisterStreamBlockingClient(client)
-		default:
-			UnregisterStreamBlockingClient(client)
+	for i, waitKey := range wait.keys {
+		if waitKey != key {
+			continue
+		}
+		entries := stream.entriesAfter(wait.ids[i], wait.count)
+		if len(entries) == 0 {
+			return nil, nil
 		}
+		return &BlockingResult{
+			Key:     key,
+			Streams: []StreamReadResult{{StreamKey: key, Entries: entries}},
+		}, nil
 	}
+	return nil, nil
 }
@@ -0,0 +1,189 @@
+package store
+
+import "time"
+
+// ClaimOptions carries the XCLAIM options. DeliveryTime is what the claimed
+// entries get as last delivery (from IDLE or TIME, else now), RetryCount
+// replaces the delivery count when >= 0.
+type ClaimOptions struct {
+	MinIdle      time.Duration
+	DeliveryTime time.Time
+	RetryCount   int64
+	Force        bool
+	JustID       bool
+	LastID       StreamID // 0-0 when not given
+}
+
+// ClaimedEntry is an entry that changed owner, with its pending state after
+// the claim so it can be replicated exactly
+type ClaimedEntry struct {
+	Entry   StreamEntry
+	Pending PendingEntry
+}
+
+// ClaimResult reports what XCLAIM or XAUTOCLAIM did. Deleted holds the
+// pending entries dropped because their stream entry no longer exists.
+type ClaimResult struct {
+	Claimed []ClaimedEntry
+	Deleted []PendingEntry
+
+	// The group's last ID, and whether LASTID moved it
+	LastID        StreamID
+	EntriesRead   int64
+	LastIDChanged bool
+
+	// Where XAUTOCLAIM should continue, 0-0 once the PEL was scanned to the end
+	Cursor StreamID
+}
+
+// claim hands a pending entry over to consumer
+func (group *ConsumerGroup) claim(pending *PendingEntry, consumer *StreamConsumer) {
+	if pending.Consumer != consumer.Name {
+		if owner := group.Consumers[pending.Consumer]; owner != nil {
+			delete(owner.Pending, pending.ID)
+		}
+		pending.Consumer = consumer.Name
+		consumer.Pending[pending.ID] = pending
+	}
+}
+
+// dropDeleted removes a pending entry whose stream entry was deleted
+func (group *ConsumerGroup) dropDeleted(pending *PendingEntry, result *ClaimResult) {
+	group.ack(pending.ID)
+	result.Deleted = append(result.Deleted, *pending)
+}
+
+// StreamClaim changes the owner of pending entries that have been idle for
+// at least MinIdle. With Force, IDs that exist in the stream but are not
+// pending are added to the PEL first.
+func StreamClaim(key, group, consumerName string, ids []StreamID, opts ClaimOptions) (*ClaimResult, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	stream, cg, err := lookupGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ClaimResult{}
+	if opts.LastID.Compare(cg.LastID) > 0 {
+		cg.LastID = opts.LastID
+		result.LastIDChanged = true
+	}
+
+	now := time.Now()
+	var consumer *StreamConsumer
+	for _, id := range ids {
+		pending := cg.Pending[id]
+
+		entry, exists := stream.findEntry(id)
+		if !exists {
+			if pending != nil {
+				cg.dropDeleted(pending, result)
+			}
+			continue
+		}
+
+		// A pending entry made up by FORCE has no owner to take it from,
+		// so the idle time does not apply
+		forced := false
+		if pending == nil {
+			if !opts.Force {
+				continue
+			}
+			pending = &PendingEntry{ID: id, DeliveryTime: now, DeliveryCount: 1}
+			cg.Pending[id] = pending
+			forced = true
+		}
+		if !forced && now.Sub(pending.DeliveryTime) < opts.MinIdle {
+			continue
+		}
+
+		if consumer == nil {
+			consumer, _ = cg.consumer(consumerName)
+		}
+		cg.claim(pending, consumer)
+		pending.DeliveryTime = opts.DeliveryTime
+		if opts.RetryCount >= 0 {
+			pending.DeliveryCount = opts.RetryCount
+		} else if !opts.JustID {
+			pending.DeliveryCount++
+		}
+		consumer.ActiveTime = now
+
+		result.Claimed = append(result.Claimed, ClaimedEntry{Entry: entry, Pending: *pending})
+	}
+	if consumer != nil {
+		consumer.SeenTime = now
+	}
+	if consumer != nil || len(result.Deleted) > 0 || result.LastIDChanged {
+		touchWatchedKey(key)
+	}
+
+	result.LastID, result.EntriesRead = cg.LastID, cg.EntriesRead
+	return result, nil
+}
+
+// StreamAutoClaim scans the PEL from start and claims up to count entries
+// idle for at least minIdle, looking at no more than ten times count
+// entries. Pending entries whose stream entry is gone are dropped and count
+// toward count as well.
+func StreamAutoClaim(key, group, consumerName string, minIdle time.Duration, start StreamID, count int, justID bool) (*ClaimResult, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	stream, cg, err := lookupGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ClaimResult{}
+	now := time.Now()
+	attempts := count * 10
+	var consumer *StreamConsumer
+
+	pel := sortedPending(cg.Pending)
+	i := 0
+	for i < len(pel) && pel[i].ID.Compare(start) < 0 {
+		i++
+	}
+	for ; i < len(pel) && attempts > 0 && count > 0; i++ {
+		attempts--
+		pending := pel[i]
+
+		entry, exists := stream.findEntry(pending.ID)
+		if !exists {
+			cg.dropDeleted(pending, result)
+			count--
+			continue
+		}
+		if now.Sub(pending.DeliveryTime) < minIdle {
+			continue
+		}
+
+		if consumer == nil {
+			consumer, _ = cg.consumer(consumerName)
+		}
+		cg.claim(pending, consumer)
+		pending.DeliveryTime = now
+		if !justID {
+			pending.DeliveryCount++
+		}
+		consumer.ActiveTime = now
+		count--
+
+		result.Claimed = append(result.Claimed, ClaimedEntry{Entry: entry, Pending: *pending})
+	}
+	if i < len(pel) {
+		result.Cursor = pel[i].ID
+	}
+	if consumer != nil {
+		consumer.SeenTime = now
+	}
+	if consumer != nil || len(result.Deleted) > 0 || result.LastIDChanged {
+		touchWatchedKey(key)
+	}
+
+	result.LastID, result.EntriesRead = cg.LastID, cg.EntriesRead
+	return result, nil
+}
@@ -0,0 +1,578 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConsumerGroup tracks which entries of a stream were handed to which
+// consumer. LastID is the last entry delivered to the group, and every
+// delivered entry stays pending (in the PEL) until a consumer acknowledges
+// it.
+type ConsumerGroup struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64 // -1 when unknown
+	Pending     map[StreamID]*PendingEntry
+	Consumers   map[string]*StreamConsumer
+}
+
+type StreamConsumer struct {
+	Name       string
+	SeenTime   time.Time // last time it tried to read
+	ActiveTime time.Time // last time it got entries, zero if never
+	Pending    map[StreamID]*PendingEntry
+}
+
+// PendingEntry is an entry delivered to a consumer but not acknowledged
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	DeliveryTime  time.Time
+	DeliveryCount int64
+}
+
+// StreamGroupRead identifies who reads with XREADGROUP
+type StreamGroupRead struct {
+	Group    string
+	Consumer string
+	NoAck    bool
+}
+
+// PendingSummary is the short form of XPENDING
+type PendingSummary struct {
+	Count     int
+	MinID     StreamID
+	MaxID     StreamID
+	Consumers []ConsumerPending
+}
+
+type ConsumerPending struct {
+	Name    string
+	Pending int
+}
+
+// PendingRange selects entries for the extended form of XPENDING. Start and
+// End are inclusive, Consumer is empty for all consumers.
+type PendingRange struct {
+	Start, End StreamID
+	Count      int
+	MinIdle    time.Duration
+	Consumer   string
+}
+
+// PendingInfo is one entry of the extended form of XPENDING
+type PendingInfo struct {
+	ID            StreamID
+	Consumer      string
+	Idle          time.Duration
+	DeliveryCount int64
+}
+
+const errNoStreamKey = "ERR The XGROUP subcommand requires the key to exist. " +
+	"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."
+
+// ParseStreamIDArg parses an ID given to a stream command, where the
+// sequence part may be left out and then defaults to missingSeq
+func ParseStreamIDArg(arg string, missingSeq int64) (StreamID, error) {
+	if !strings.Contains(arg, "-") {
+		ms, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || ms < 0 {
+			return StreamID{}, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+		}
+		return StreamID{Timestamp: ms, Sequence: missingSeq}, nil
+	}
+
+	id, err := ParseStreamID(arg)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+	}
+	return id, nil
+}
+
+func noGroupError(key, group string) error {
+	return fmt.Errorf("NOGROUP No such consumer group '%s' for key name '%s'", group, key)
+}
+
+func newConsumerGroup(name string, lastID StreamID, entriesRead int64) *ConsumerGroup {
+	return &ConsumerGroup{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+		Pending:     make(map[StreamID]*PendingEntry),
+		Consumers:   make(map[string]*StreamConsumer),
+	}
+}
+
+// consumer returns the named consumer, creating it when missing. The bool
+// reports whether it was created.
+func (group *ConsumerGroup) consumer(name string) (*StreamConsumer, bool) {
+	if consumer, exists := group.Consumers[name]; exists {
+		return consumer, false
+	}
+	consumer := &StreamConsumer{
+		Name:     name,
+		SeenTime: time.Now(),
+		Pending:  make(map[StreamID]*PendingEntry),
+	}
+	group.Consumers[name] = consumer
+	return consumer, true
+}
+
+// deliver records that entry id was handed to consumer, taking it over from
+// another consumer if it was already pending
+func (group *ConsumerGroup) deliver(id StreamID, consumer *StreamConsumer, now time.Time) {
+	if pending, exists := group.Pending[id]; exists {
+		delete(group.Consumers[pending.Consumer].Pending, id)
+	}
+	pending := &PendingEntry{ID: id, Consumer: consumer.Name, DeliveryTime: now, DeliveryCount: 1}
+	group.Pending[id] = pending
+	consumer.Pending[id] = pending
+}
+
+func (group *ConsumerGroup) ack(id StreamID) bool {
+	pending, exists := group.Pending[id]
+	if !exists {
+		return false
+	}
+	delete(group.Pending, id)
+	delete(group.Consumers[pending.Consumer].Pending, id)
+	return true
+}
+
+// sortedPending returns the entries of a PEL in ID order
+func sortedPending(pel map[StreamID]*PendingEntry) []*PendingEntry {
+	entries := make([]*PendingEntry, 0, len(pel))
+	for _, pending := range pel {
+		entries = append(entries, pending)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID.Compare(entries[j].ID) < 0
+	})
+	return entries
+}
+
+// lookupGroup returns the stream at key and its group, with the NOGROUP
+// error when either is missing. Callers hold dataMutex.
+func lookupGroup(key, group string) (*Stream, *ConsumerGroup, error) {
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil {
+		return nil, nil, err
+	}
+	if stream == nil || stream.Groups[group] == nil {
+		return nil, nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+	}
+	return stream, stream.Groups[group], nil
+}
+
+// resolveGroupID turns the ID of XGROUP CREATE/SETID into a full ID, "$"
+// standing for the last ID of the stream
+func resolveGroupID(stream *Stream, id string) (StreamID, error) {
+	if id == "$" {
+		return stream.LastID, nil
+	}
+	return ParseStreamIDArg(id, 0)
+}
+
+// StreamGroupCreate creates a consumer group that will deliver the entries
+// after id. entriesRead is -1 unless ENTRIESREAD was given.
+func StreamGroupCreate(key, group, id string, mkstream bool, entriesRead int64) error {
+	dataMutex.Lock()
+	defer unlockData()
+
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil {
+		return err
+	}
+	if stream == nil && !mkstream {
+		return fmt.Errorf(errNoStreamKey)
+	}
+
+	newStream := stream == nil
+	if newStream {
+		stream = &Stream{}
+	}
+
+	lastID, err := resolveGroupID(stream, id)
+	if err != nil {
+		return err
+	}
+	if stream.Groups[group] != nil {
+		return fmt.Errorf("BUSYGROUP Consumer Group name already exists")
+	}
+
+	if newStream {
+		setKey(key, &RedisValue{Type: STREAM, Stream: stream})
+	}
+	if stream.Groups == nil {
+		stream.Groups = make(map[string]*ConsumerGroup)
+	}
+	stream.Groups[group] = newConsumerGroup(group, lastID, entriesRead)
+	touchWatchedKey(key)
+	return nil
+}
+
+// StreamGroupSetID moves the last delivered ID of a group
+func StreamGroupSetID(key, group, id string, entriesRead int64) error {
+	dataMutex.Lock()
+	defer unlockData()
+
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		return fmt.Errorf(errNoStreamKey)
+	}
+	cg := stream.Groups[group]
+	if cg == nil {
+		return noGroupError(key, group)
+	}
+
+	lastID, err := resolveGroupID(stream, id)
+	if err != nil {
+		return err
+	}
+	cg.LastID = lastID
+	cg.EntriesRead = entriesRead
+	touchWatchedKey(key)
+	return nil
+}
+
+// StreamGroupDestroy removes a group, reporting whether it existed. Clients
+// blocked reading from it are woken up with an error.
+func StreamGroupDestroy(key, group string) (bool, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil {
+		return false, err
+	}
+	if stream == nil {
+		return false, fmt.Errorf(errNoStreamKey)
+	}
+	if stream.Groups[group] == nil {
+		return false, nil
+	}
+
+	delete(stream.Groups, group)
+	touchWatchedKey(key)
+	signalKeyReady(key)
+	return true, nil
+}
+
+// StreamGroupCreateConsumer adds a consumer to a group, reporting whether it
+// is new
+func StreamGroupCreateConsumer(key, group, consumer string) (bool, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil {
+		return false, err
+	}
+	if stream == nil {
+		return false, fmt.Errorf(errNoStreamKey)
+	}
+	cg := stream.Groups[group]
+	if cg == nil {
+		return false, noGroupError(key, group)
+	}
+
+	_, created := cg.consumer(consumer)
+	if created {
+		touchWatchedKey(key)
+	}
+	return created, nil
+}
+
+// StreamGroupDelConsumer removes a consumer along with its pending entries,
+// returning how many were pending and whether the consumer existed
+func StreamGroupDelConsumer(key, group, consumer string) (pending int, deleted bool, err error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil {
+		return 0, false, err
+	}
+	if stream == nil {
+		return 0, false, fmt.Errorf(errNoStreamKey)
+	}
+	cg := stream.Groups[group]
+	if cg == nil {
+		return 0, false, noGroupError(key, group)
+	}
+
+	c, exists := cg.Consumers[consumer]
+	if !exists {
+		return 0, false, nil
+	}
+	for id := range c.Pending {
+		delete(cg.Pending, id)
+	}
+	delete(cg.Consumers, consumer)
+	touchWatchedKey(key)
+	return len(c.Pending), true, nil
+}
+
+// StreamAck removes ids from the PEL of a group, returning how many were
+// pending. A missing key or group acknowledges nothing.
+func StreamAck(key, group string, ids []StreamID) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil {
+		return 0, err
+	}
+	if stream == nil || stream.Groups[group] == nil {
+		return 0, nil
+	}
+
+	cg := stream.Groups[group]
+	acked := 0
+	for _, id := range ids {
+		if cg.ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		touchWatchedKey(key)
+	}
+	return acked, nil
+}
+
+// StreamPendingSummary returns the short form of XPENDING
+func StreamPendingSummary(key, group string) (PendingSummary, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	var summary PendingSummary
+	_, cg, err := lookupGroup(key, group)
+	if err != nil {
+		return summary, err
+	}
+
+	summary.Count = len(cg.Pending)
+	if summary.Count == 0 {
+		return summary, nil
+	}
+	summary.MinID = MaxStreamID
+	for id := range cg.Pending {
+		if id.Compare(summary.MinID) < 0 {
+			summary.MinID = id
+		}
+		if id.Compare(summary.MaxID) > 0 {
+			summary.MaxID = id
+		}
+	}
+
+	for name, consumer := range cg.Consumers {
+		if len(consumer.Pending) > 0 {
+			summary.Consumers = append(summary.Consumers, ConsumerPending{Name: name, Pending: len(consumer.Pending)})
+		}
+	}
+	sort.Slice(summary.Consumers, func(i, j int) bool {
+		return summary.Consumers[i].Name < summary.Consumers[j].Name
+	})
+	return summary, nil
+}
+
+// StreamPendingRange returns the extended form of XPENDING
+func StreamPendingRange(key, group string, r PendingRange) ([]PendingInfo, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	_, cg, err := lookupGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	pel := cg.Pending
+	if r.Consumer != "" {
+		consumer, exists := cg.Consumers[r.Consumer]
+		if !exists {
+			return nil, nil
+		}
+		pel = consumer.Pending
+	}
+
+	now := time.Now()
+	var result []PendingInfo
+	for _, pending := range sortedPending(pel) {
+		if len(result) >= r.Count {
+			break
+		}
+		if pending.ID.Compare(r.Start) < 0 {
+			continue
+		}
+		if pending.ID.Compare(r.End) > 0 {
+			break
+		}
+		idle := now.Sub(pending.DeliveryTime)
+		if idle < r.MinIdle {
+			continue
+		}
+		result = append(result, PendingInfo{
+			ID:            pending.ID,
+			Consumer:      pending.Consumer,
+			Idle:          idle,
+			DeliveryCount: pending.DeliveryCount,
+		})
+	}
+	return result, nil
+}
+
+// StreamReadGroupOrBlock reads for a consumer of a group. For ">" it hands
+// out entries no consumer of the group got yet, any other ID reads the
+// consumer's own pending entries after it. When all IDs are ">", nothing is
+// new and blocking is set, the client is registered on the streams like
+// for XREAD. created reports that the consumer was added to the group.
+func StreamReadGroupOrBlock(read StreamGroupRead, keys, ids []string, count int, clientID int64, blocking bool) (results []StreamReadResult, created bool, waiter *Waiter, err error) {
+	dataMutex.Lock()
+	defer unlockData()
+	blockingMutex.Lock()
+	defer blockingMutex.Unlock()
+
+	// Every ID must parse and every stream and group exist before anything
+	// is read
+	startIDs := make([]StreamID, len(ids))
+	for i, id := range ids {
+		if id == ">" {
+			continue
+		}
+		parsed, err := ParseStreamIDArg(id, 0)
+		if err != nil {
+			return nil, false, nil, err
+		}
+		startIDs[i] = parsed
+	}
+	groups := make([]*ConsumerGroup, len(keys))
+	streams := make([]*Stream, len(keys))
+	for i, key := range keys {
+		stream, err := lookupStream(lookupKeyWrite(key))
+		if err != nil {
+			return nil, false, nil, err
+		}
+		if stream == nil || stream.Groups[read.Group] == nil {
+			return nil, false, nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, read.Group)
+		}
+		streams[i], groups[i] = stream, stream.Groups[read.Group]
+	}
+
+	now := time.Now()
+	onlyNew := true
+	for i, key := range keys {
+		consumer, isNew := groups[i].consumer(read.Consumer)
+		created = created || isNew
+		if isNew {
+			touchWatchedKey(key)
+		}
+		consumer.SeenTime = now
+
+		if ids[i] == ">" {
+			entries := readNewGroupEntries(streams[i], groups[i], consumer, read.NoAck, count, now)
+			if len(entries) > 0 {
+				touchWatchedKey(key)
+				results = append(results, StreamReadResult{StreamKey: key, Entries: entries})
+			}
+			continue
+		}
+
+		// History is answered even when empty, so it never blocks
+		onlyNew = false
+		entries := readPendingEntries(streams[i], consumer, startIDs[i], count, now)
+		results = append(results, StreamReadResult{StreamKey: key, Entries: entries})
+	}
+
+	if len(results) > 0 || !blocking || !onlyNew {
+		return results, created, nil, nil
+	}
+
+	wait := &streamWait{keys: keys, count: count, group: &read}
+	return nil, created, block(&Waiter{Keys: keys, ClientID: clientID, stream: wait, GroupRead: &read}), nil
+}
+
+// readNewGroupEntries delivers up to count (all when count <= 0) entries
+// past the group's last ID to consumer
+func readNewGroupEntries(stream *Stream, cg *ConsumerGroup, consumer *StreamConsumer, noAck bool, count int, now time.Time) []StreamEntry {
+	entries := stream.entriesAfter(cg.LastID, count)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	for _, entry := range entries {
+		// The entries-read counter stays exact while no deleted entry could
+		// lie ahead, otherwise it is worked out again from the stream
+		if cg.EntriesRead >= 0 && !stream.rangeHasTombstones(entry.ID, MaxStreamID) {
+			cg.EntriesRead++
+		} else if stream.EntriesAdded > 0 {
+			cg.EntriesRead = stream.estimateEntriesRead(entry.ID)
+		}
+		cg.LastID = entry.ID
+
+		if !noAck {
+			cg.deliver(entry.ID, consumer, now)
+		}
+	}
+	consumer.ActiveTime = now
+	return entries
+}
+
+// readPendingEntries returns up to count of the consumer's pending entries
+// after id, counting them as delivered again. Entries deleted from the
+// stream since come back with nil Fields.
+func readPendingEntries(stream *Stream, consumer *StreamConsumer, id StreamID, count int, now time.Time) []StreamEntry {
+	entries := []StreamEntry{}
+	for _, pending := range sortedPending(consumer.Pending) {
+		if count > 0 && len(entries) >= count {
+			break
+		}
+		if pending.ID.Compare(id) <= 0 {
+			continue
+		}
+
+		entry, exists := stream.findEntry(pending.ID)
+		if !exists {
+			entries = append(entries, StreamEntry{ID: pending.ID})
+			continue
+		}
+		pending.DeliveryTime = now
+		pending.DeliveryCount++
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// serveGroupWait delivers new entries of the stream at key to a blocked
+// XREADGROUP client. A client whose group was destroyed in the meantime is
+// woken up with the NOGROUP error. Callers hold dataMutex and blockingMutex.
+func serveGroupWait(wait *streamWait, key string) (*BlockingResult, error) {
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil || stream.Groups[wait.group.Group] == nil {
+		return &BlockingResult{Err: fmt.Errorf("NOGROUP the consumer group this client was blocked on no longer exists")}, nil
+	}
+
+	cg := stream.Groups[wait.group.Group]
+	consumer, _ := cg.consumer(wait.group.Consumer)
+	now := time.Now()
+	consumer.SeenTime = now
+
+	entries := readNewGroupEntries(stream, cg, consumer, wait.group.NoAck, wait.count, now)
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	touchWatchedKey(key)
+	return &BlockingResult{
+		Key:     key,
+		Streams: []StreamReadResult{{StreamKey: key, Entries: entries}},
+	}, nil
+}
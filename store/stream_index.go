@@ -0,0 +1,385 @@
+package store
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// Stream entries are stored the way Redis stores them: in blocks of up to
+// streamNodeMaxEntries entries or about streamNodeMaxBytes bytes. A block
+// is one byte slice in which IDs are deltas from the block's master ID (its
+// first entry) and field names are left out when they repeat the master
+// entry's. Deleted entries are only flagged until their whole block goes.
+// The blocks are kept in ID order, so finding the block of an ID is a
+// binary search and reading inside a block a short scan.
+//
+// Each entry is encoded as:
+//
+//	flags byte | ms delta (uvarint) | seq delta (varint) | fields
+//
+// where fields are the values alone with entrySameFields set, and otherwise
+// a field count (uvarint) followed by the names and values. Every string is
+// a uvarint length followed by its bytes.
+
+const (
+	streamNodeMaxEntries = 100
+	streamNodeMaxBytes   = 4096
+
+	entryDeleted    = 1 << 0
+	entrySameFields = 1 << 1
+)
+
+type streamBlock struct {
+	master StreamID // ID of the first entry added to the block
+	last   StreamID // ID of the last entry added to the block
+	fields []string // field names of the master entry
+	data   []byte
+
+	entries int // encoded entries, including deleted ones
+	live    int // entries not deleted
+}
+
+// blockEntry is an entry decoded from a block. offset is where its flags
+// byte sits, so it can be marked deleted in place.
+type blockEntry struct {
+	offset  int
+	deleted bool
+	entry   StreamEntry
+}
+
+func (id StreamID) Compare(other StreamID) int {
+	switch {
+	case id.Timestamp < other.Timestamp:
+		return -1
+	case id.Timestamp > other.Timestamp:
+		return 1
+	case id.Sequence < other.Sequence:
+		return -1
+	case id.Sequence > other.Sequence:
+		return 1
+	}
+	return 0
+}
+
+// IsZero reports whether id is 0-0, which no entry can have
+func (id StreamID) IsZero() bool {
+	return id.Timestamp == 0 && id.Sequence == 0
+}
+
+func sameFieldNames(names []string, fields []string) bool {
+	if len(names)*2 != len(fields) {
+		return false
+	}
+	for i, name := range names {
+		if fields[2*i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+func appendBlockString(data []byte, s string) []byte {
+	data = binary.AppendUvarint(data, uint64(len(s)))
+	return append(data, s...)
+}
+
+func (block *streamBlock) append(id StreamID, fields []string) {
+	if block.entries == 0 {
+		block.master = id
+	}
+
+	flags := byte(0)
+	same := sameFieldNames(block.fields, fields)
+	if same {
+		flags |= entrySameFields
+	}
+	block.data = append(block.data, flags)
+	block.data = binary.AppendUvarint(block.data, uint64(id.Timestamp-block.master.Timestamp))
+	block.data = binary.AppendVarint(block.data, id.Sequence-block.master.Sequence)
+
+	if same {
+		for i := 1; i < len(fields); i += 2 {
+			block.data = appendBlockString(block.data, fields[i])
+		}
+	} else {
+		block.data = binary.AppendUvarint(block.data, uint64(len(fields)/2))
+		for _, s := range fields {
+			block.data = appendBlockString(block.data, s)
+		}
+	}
+
+	block.last = id
+	block.entries++
+	block.live++
+}
+
+func (block *streamBlock) full() bool {
+	return block.entries >= streamNodeMaxEntries || len(block.data) >= streamNodeMaxBytes
+}
+
+// each decodes the entries of the block in order until fn returns false.
+// Fields are only decoded with withFields, scans that look for an ID skip
+// them.
+func (block *streamBlock) each(withFields bool, fn func(blockEntry) bool) {
+	data := block.data
+	pos := 0
+
+	readUvarint := func() uint64 {
+		v, n := binary.Uvarint(data[pos:])
+		pos += n
+		return v
+	}
+	readString := func() string {
+		length := int(readUvarint())
+		s := string(data[pos : pos+length])
+		pos += length
+		return s
+	}
+	skipString := func() {
+		pos += int(readUvarint())
+	}
+
+	for pos < len(data) {
+		e := blockEntry{offset: pos, deleted: data[pos]&entryDeleted != 0}
+		flags := data[pos]
+		pos++
+
+		msDelta := readUvarint()
+		seqDelta, n := binary.Varint(data[pos:])
+		pos += n
+		e.entry.ID = StreamID{
+			Timestamp: block.master.Timestamp + int64(msDelta),
+			Sequence:  block.master.Sequence + seqDelta,
+		}
+
+		if flags&entrySameFields != 0 {
+			if withFields {
+				e.entry.Fields = make([]string, 0, 2*len(block.fields))
+			}
+			for _, name := range block.fields {
+				if withFields {
+					e.entry.Fields = append(e.entry.Fields, name, readString())
+				} else {
+					skipString()
+				}
+			}
+		} else {
+			count := int(readUvarint())
+			if withFields {
+				e.entry.Fields = make([]string, 0, 2*count)
+			}
+			for range 2 * count {
+				if withFields {
+					e.entry.Fields = append(e.entry.Fields, readString())
+				} else {
+					skipString()
+				}
+			}
+		}
+
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+// liveEntries decodes the entries of the block that are not deleted
+func (block *streamBlock) liveEntries() []StreamEntry {
+	entries := make([]StreamEntry, 0, block.live)
+	block.each(true, func(e blockEntry) bool {
+		if !e.deleted {
+			entries = append(entries, e.entry)
+		}
+		return true
+	})
+	return entries
+}
+
+// Len returns the number of entries in the stream
+func (stream *Stream) Len() int {
+	return stream.length
+}
+
+// appendEntry adds an entry, whose ID must be greater than any in the stream
+func (stream *Stream) appendEntry(id StreamID, fields []string) {
+	if len(stream.blocks) == 0 || stream.blocks[len(stream.blocks)-1].full() {
+		block := &streamBlock{}
+		for i := 0; i < len(fields); i += 2 {
+			block.fields = append(block.fields, fields[i])
+		}
+		stream.blocks = append(stream.blocks, block)
+	}
+	stream.blocks[len(stream.blocks)-1].append(id, fields)
+	stream.length++
+}
+
+// blockFor returns the index of the first block that may hold an entry
+// with an ID of at least id
+func (stream *Stream) blockFor(id StreamID) int {
+	return sort.Search(len(stream.blocks), func(i int) bool {
+		return stream.blocks[i].last.Compare(id) >= 0
+	})
+}
+
+// firstEntry returns the entry with the smallest ID. Blocks without live
+// entries are removed, so it is in the first block.
+func (stream *Stream) firstEntry() (StreamEntry, bool) {
+	if stream.length == 0 {
+		return StreamEntry{}, false
+	}
+	var first StreamEntry
+	stream.blocks[0].each(true, func(e blockEntry) bool {
+		if e.deleted {
+			return true
+		}
+		first = e.entry
+		return false
+	})
+	return first, true
+}
+
+func (stream *Stream) lastEntry() (StreamEntry, bool) {
+	if stream.length == 0 {
+		return StreamEntry{}, false
+	}
+	entries := stream.blocks[len(stream.blocks)-1].liveEntries()
+	return entries[len(entries)-1], true
+}
+
+// findEntry looks an entry up by ID
+func (stream *Stream) findEntry(id StreamID) (StreamEntry, bool) {
+	i := stream.blockFor(id)
+	if i == len(stream.blocks) || stream.blocks[i].master.Compare(id) > 0 {
+		return StreamEntry{}, false
+	}
+
+	var found StreamEntry
+	exists := false
+	stream.blocks[i].each(true, func(e blockEntry) bool {
+		cmp := e.entry.ID.Compare(id)
+		if cmp == 0 && !e.deleted {
+			found, exists = e.entry, true
+		}
+		return cmp < 0
+	})
+	return found, exists
+}
+
+// rangeEntries returns up to count (all when count < 0) entries with IDs
+// between start and end inclusive, last to first with reverse
+func (stream *Stream) rangeEntries(start, end StreamID, count int, reverse bool) []StreamEntry {
+	var result []StreamEntry
+	if count == 0 || start.Compare(end) > 0 {
+		return result
+	}
+	inRange := func(id StreamID) bool {
+		return id.Compare(start) >= 0 && id.Compare(end) <= 0
+	}
+
+	if !reverse {
+		for i := stream.blockFor(start); i < len(stream.blocks); i++ {
+			block := stream.blocks[i]
+			if block.master.Compare(end) > 0 {
+				break
+			}
+			done := false
+			block.each(true, func(e blockEntry) bool {
+				if e.entry.ID.Compare(end) > 0 {
+					done = true
+					return false
+				}
+				if !e.deleted && inRange(e.entry.ID) {
+					result = append(result, e.entry)
+					if count > 0 && len(result) >= count {
+						done = true
+						return false
+					}
+				}
+				return true
+			})
+			if done {
+				break
+			}
+		}
+		return result
+	}
+
+	// The last block starting at or before end
+	i := sort.Search(len(stream.blocks), func(i int) bool {
+		return stream.blocks[i].master.Compare(end) > 0
+	}) - 1
+	for ; i >= 0; i-- {
+		block := stream.blocks[i]
+		if block.last.Compare(start) < 0 {
+			break
+		}
+		entries := block.liveEntries()
+		for j := len(entries) - 1; j >= 0; j-- {
+			if !inRange(entries[j].ID) {
+				continue
+			}
+			result = append(result, entries[j])
+			if count > 0 && len(result) >= count {
+				return result
+			}
+		}
+	}
+	return result
+}
+
+// Entries returns every entry of the stream in ID order
+func (stream *Stream) Entries() []StreamEntry {
+	return stream.rangeEntries(StreamID{}, MaxStreamID, -1, false)
+}
+
+// entriesAfter returns up to count (all when count <= 0) entries with IDs
+// greater than id
+func (stream *Stream) entriesAfter(id StreamID, count int) []StreamEntry {
+	if id.Compare(MaxStreamID) >= 0 {
+		return nil
+	}
+	if count <= 0 {
+		count = -1
+	}
+	return stream.rangeEntries(id.next(), MaxStreamID, count, false)
+}
+
+// deleteEntry flags an entry as deleted, dropping its block once no entry
+// of it is left. It reports whether the entry existed.
+func (stream *Stream) deleteEntry(id StreamID) bool {
+	i := stream.blockFor(id)
+	if i == len(stream.blocks) {
+		return false
+	}
+	block := stream.blocks[i]
+
+	deleted := false
+	block.each(false, func(e blockEntry) bool {
+		cmp := e.entry.ID.Compare(id)
+		if cmp == 0 && !e.deleted {
+			block.data[e.offset] |= entryDeleted
+			deleted = true
+		}
+		return cmp < 0
+	})
+	if !deleted {
+		return false
+	}
+
+	block.live--
+	stream.length--
+	if block.live == 0 {
+		stream.blocks = append(stream.blocks[:i], stream.blocks[i+1:]...)
+	}
+	return true
+}
+
+// removeFirstBlock drops the first block, returning how many live entries
+// went with it
+func (stream *Stream) removeFirstBlock() int {
+	removed := stream.blocks[0].live
+	stream.blocks[0] = nil
+	stream.blocks = stream.blocks[1:]
+	stream.length -= removed
+	return removed
+}
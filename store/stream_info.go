@@ -0,0 +1,218 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// StreamInfo is what XINFO STREAM reports. Entries and GroupDetails are only
+// filled for the FULL form.
+type StreamInfo struct {
+	Length         int
+	RadixTreeKeys  int
+	RadixTreeNodes int
+	LastID         StreamID
+	MaxDeletedID   StreamID
+	EntriesAdded   int64
+	FirstID        StreamID
+	Groups         int
+	FirstEntry     *StreamEntry
+	LastEntry      *StreamEntry
+
+	Entries      []StreamEntry
+	GroupDetails []GroupInfo
+}
+
+// GroupInfo is what XINFO GROUPS reports for a group. EntriesRead is -1 and
+// LagKnown false when those can't be told. PEL and ConsumerDetails are only
+// filled for XINFO STREAM FULL.
+type GroupInfo struct {
+	Name        string
+	Consumers   int
+	Pending     int
+	LastID      StreamID
+	EntriesRead int64
+	Lag         int64
+	LagKnown    bool
+
+	PEL             []PendingEntry
+	ConsumerDetails []ConsumerInfo
+}
+
+// ConsumerInfo is what XINFO CONSUMERS reports for a consumer, ActiveTime
+// being zero if it never got an entry
+type ConsumerInfo struct {
+	Name       string
+	Pending    int
+	SeenTime   time.Time
+	ActiveTime time.Time
+
+	PEL []PendingEntry
+}
+
+// streamNodes reports the blocks of the stream as the keys of Redis's radix
+// tree, which holds one listpack per key. Its node count is estimated as
+// one more than that.
+func streamNodes(stream *Stream) (keys, nodes int) {
+	keys = len(stream.blocks)
+	return keys, keys + 1
+}
+
+// firstPending returns up to count (all when count <= 0) entries of a PEL
+// in ID order
+func firstPending(pel map[StreamID]*PendingEntry, count int) []PendingEntry {
+	var result []PendingEntry
+	for _, pending := range sortedPending(pel) {
+		if count > 0 && len(result) >= count {
+			break
+		}
+		result = append(result, *pending)
+	}
+	return result
+}
+
+func sortedGroups(stream *Stream) []*ConsumerGroup {
+	groups := make([]*ConsumerGroup, 0, len(stream.Groups))
+	for _, cg := range stream.Groups {
+		groups = append(groups, cg)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+func sortedConsumers(cg *ConsumerGroup) []*StreamConsumer {
+	consumers := make([]*StreamConsumer, 0, len(cg.Consumers))
+	for _, consumer := range cg.Consumers {
+		consumers = append(consumers, consumer)
+	}
+	sort.Slice(consumers, func(i, j int) bool { return consumers[i].Name < consumers[j].Name })
+	return consumers
+}
+
+func groupInfo(stream *Stream, cg *ConsumerGroup) GroupInfo {
+	info := GroupInfo{
+		Name:        cg.Name,
+		Consumers:   len(cg.Consumers),
+		Pending:     len(cg.Pending),
+		LastID:      cg.LastID,
+		EntriesRead: cg.EntriesRead,
+	}
+	info.Lag, info.LagKnown = stream.groupLag(cg)
+	return info
+}
+
+func consumerInfo(consumer *StreamConsumer) ConsumerInfo {
+	return ConsumerInfo{
+		Name:       consumer.Name,
+		Pending:    len(consumer.Pending),
+		SeenTime:   consumer.SeenTime,
+		ActiveTime: consumer.ActiveTime,
+	}
+}
+
+// lookupStreamForInfo returns the stream at key for XINFO, which fails on
+// a missing key. Callers hold dataMutex.
+func lookupStreamForInfo(key string) (*Stream, error) {
+	stream, err := lookupStream(lookupKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil {
+		return nil, fmt.Errorf("ERR no such key")
+	}
+	return stream, nil
+}
+
+// StreamInfoOf returns XINFO STREAM for key. With full, the first count
+// entries (all when count <= 0) are included along with every group, the
+// first count entries of its PEL and its consumers.
+func StreamInfoOf(key string, full bool, count int) (*StreamInfo, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	stream, err := lookupStreamForInfo(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &StreamInfo{
+		Length:       stream.Len(),
+		LastID:       stream.LastID,
+		MaxDeletedID: stream.MaxDeletedID,
+		EntriesAdded: stream.EntriesAdded,
+		FirstID:      stream.firstID(),
+		Groups:       len(stream.Groups),
+	}
+	info.RadixTreeKeys, info.RadixTreeNodes = streamNodes(stream)
+
+	if !full {
+		if first, exists := stream.firstEntry(); exists {
+			last, _ := stream.lastEntry()
+			info.FirstEntry, info.LastEntry = &first, &last
+		}
+		return info, nil
+	}
+
+	if count <= 0 {
+		count = -1
+	}
+	info.Entries = stream.rangeEntries(StreamID{}, MaxStreamID, count, false)
+
+	for _, cg := range sortedGroups(stream) {
+		group := groupInfo(stream, cg)
+		group.PEL = firstPending(cg.Pending, count)
+		for _, consumer := range sortedConsumers(cg) {
+			details := consumerInfo(consumer)
+			details.PEL = firstPending(consumer.Pending, count)
+			group.ConsumerDetails = append(group.ConsumerDetails, details)
+		}
+		info.GroupDetails = append(info.GroupDetails, group)
+	}
+	return info, nil
+}
+
+// StreamGroupsInfo returns XINFO GROUPS for key, groups in name order
+func StreamGroupsInfo(key string) ([]GroupInfo, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	stream, err := lookupStreamForInfo(key)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []GroupInfo{}
+	for _, cg := range sortedGroups(stream) {
+		groups = append(groups, groupInfo(stream, cg))
+	}
+	return groups, nil
+}
+
+// StreamConsumersInfo returns XINFO CONSUMERS for a group, consumers in
+// name order
+func StreamConsumersInfo(key, group string) ([]ConsumerInfo, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	stream, err := lookupStreamForInfo(key)
+	if err != nil {
+		return nil, err
+	}
+	cg := stream.Groups[group]
+	if cg == nil {
+		return nil, noGroupError(key, group)
+	}
+
+	consumers := []ConsumerInfo{}
+	for _, consumer := range sortedConsumers(cg) {
+		consumers = append(consumers, consumerInfo(consumer))
+	}
+	return consumers, nil
+}
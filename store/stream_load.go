@@ -0,0 +1,108 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Streams read from an RDB file are built up front and then stored as a
+// whole with SetStream. The methods below check what a well-formed dump
+// guarantees, so a corrupt one is reported instead of loaded.
+
+// NewStream returns an empty stream to be filled by a loader
+func NewStream() *Stream {
+	return &Stream{}
+}
+
+// AppendEntry adds an entry to a stream being loaded. IDs must increase.
+func (stream *Stream) AppendEntry(id StreamID, fields []string) error {
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return fmt.Errorf("stream entry %s has %d field/value strings", id, len(fields))
+	}
+	if n := len(stream.blocks); n > 0 && id.Compare(stream.blocks[n-1].last) <= 0 {
+		return fmt.Errorf("stream entry %s is not greater than %s", id, stream.blocks[n-1].last)
+	}
+	stream.appendEntry(id, fields)
+	return nil
+}
+
+// CreateGroup adds a consumer group to a stream being loaded. entriesRead
+// is -1 when unknown.
+func (stream *Stream) CreateGroup(name string, lastID StreamID, entriesRead int64) (*ConsumerGroup, error) {
+	if stream.Groups[name] != nil {
+		return nil, fmt.Errorf("duplicated consumer group name '%s'", name)
+	}
+	if stream.Groups == nil {
+		stream.Groups = make(map[string]*ConsumerGroup)
+	}
+	cg := newConsumerGroup(name, lastID, entriesRead)
+	stream.Groups[name] = cg
+	return cg, nil
+}
+
+// AddPending adds an entry to the PEL of a group being loaded. It has no
+// owner until AssignPending gives it one.
+func (group *ConsumerGroup) AddPending(id StreamID, deliveryTime time.Time, deliveryCount int64) error {
+	if group.Pending[id] != nil {
+		return fmt.Errorf("duplicated PEL entry %s in group '%s'", id, group.Name)
+	}
+	group.Pending[id] = &PendingEntry{ID: id, DeliveryTime: deliveryTime, DeliveryCount: deliveryCount}
+	return nil
+}
+
+// AddConsumer adds a consumer to a group being loaded
+func (group *ConsumerGroup) AddConsumer(name string, seenTime, activeTime time.Time) (*StreamConsumer, error) {
+	if group.Consumers[name] != nil {
+		return nil, fmt.Errorf("duplicated consumer '%s' in group '%s'", name, group.Name)
+	}
+	consumer := &StreamConsumer{
+		Name:       name,
+		SeenTime:   seenTime,
+		ActiveTime: activeTime,
+		Pending:    make(map[StreamID]*PendingEntry),
+	}
+	group.Consumers[name] = consumer
+	return consumer, nil
+}
+
+// AssignPending makes consumer the owner of an entry of the group's PEL
+func (group *ConsumerGroup) AssignPending(consumer *StreamConsumer, id StreamID) error {
+	pending := group.Pending[id]
+	if pending == nil {
+		return fmt.Errorf("consumer '%s' has PEL entry %s missing from group '%s'", consumer.Name, id, group.Name)
+	}
+	if pending.Consumer != "" {
+		return fmt.Errorf("PEL entry %s of group '%s' has more than one consumer", id, group.Name)
+	}
+	pending.Consumer = consumer.Name
+	consumer.Pending[id] = pending
+	return nil
+}
+
+// CheckPending reports a PEL entry that no consumer of the group owns
+func (group *ConsumerGroup) CheckPending() error {
+	for id, pending := range group.Pending {
+		if pending.Consumer == "" {
+			return fmt.Errorf("PEL entry %s of group '%s' has no consumer", id, group.Name)
+		}
+	}
+	return nil
+}
+
+// SetStream stores a loaded stream at key
+func SetStream(key string, stream *Stream, ttl time.Duration) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value := &RedisValue{
+		Type:   STREAM,
+		Stream: stream,
+	}
+
+	if ttl > 0 {
+		expiry := time.Now().Add(ttl)
+		value.Expiry = &expiry
+	}
+
+	setKey(key, value)
+}
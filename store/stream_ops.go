@@ -2,42 +2,43 @@ package store
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func StreamAdd(key, id string, fields map[string]string) (string, error) {
+// XAddOptions carries the XADD flags. Trim, when set, is applied once the
+// entry was added.
+type XAddOptions struct {
+	NoMkStream bool
+	Trim       *StreamTrim
+}
+
+// StreamAdd adds an entry and returns its ID, which is empty when the key
+// did not exist and NoMkStream kept it from being created, and the length
+// of the stream once trimmed. fields holds the field/value pairs in order.
+func StreamAdd(key, id string, fields []string, opts XAddOptions) (string, int, error) {
 	dataMutex.Lock()
-	defer dataMutex.Unlock()
+	defer unlockData()
 
-	value, exists := data[key]
+	value, exists := lookupKeyWrite(key)
 	if !exists {
+		if opts.NoMkStream {
+			return "", 0, nil
+		}
 		//new stream
 		value = &RedisValue{
-			Type: STREAM,
-			Stream: &Stream{
-				Entries: make([]StreamEntry, 0),
-				LastID:  "",
-			},
+			Type:   STREAM,
+			Stream: &Stream{},
 		}
-		data[key] = value
+		setKey(key, value)
 	}
 	if value.Type != STREAM {
-		return "", fmt.Errorf("WRONGTYPE Operation against a key holding wrong kind of value")
+		return "", 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	if value.Expiry != nil && time.Now().After(*value.Expiry) {
-		//resetting expired stream
-		value.Stream = &Stream{
-			Entries: make([]StreamEntry, 0),
-			LastID:  "",
-		}
-		value.Expiry = nil
-	}
-
-	var finalID string
-	var err error
+	var finalID StreamID
 
 	switch {
 	case id == "*":
@@ -47,317 +48,231 @@ func StreamAdd(key, id string, fields map[string]string) (string, error) {
 		timestampStr := strings.TrimSuffix(id, "-*")
 		timestamp, parseErr := strconv.ParseInt(timestampStr, 10, 64)
 		if parseErr != nil {
-			return "", fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+			return "", 0, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
 		}
 
 		if timestamp < 0 {
-			return "", fmt.Errorf("ERR The ID specified in XADD must be greater than 0-0")
+			return "", 0, fmt.Errorf("ERR The ID specified in XADD must be greater than 0-0")
 		}
 
 		sequence, seqErr := GenerateNextSequence(timestamp, value.Stream.LastID)
 		if seqErr != nil {
-			return "", seqErr
+			return "", 0, seqErr
 		}
 
-		finalID = fmt.Sprintf("%d-%d", timestamp, sequence)
+		finalID = StreamID{Timestamp: timestamp, Sequence: sequence}
 
 	default:
-		err = ValidateStreamID(id, value.Stream.LastID)
+		parsed, err := ParseStreamID(id)
 		if err != nil {
-			return "", err
+			return "", 0, err
+		}
+		if err := ValidateStreamID(parsed, value.Stream.LastID); err != nil {
+			return "", 0, err
 		}
-		finalID = id
+		finalID = parsed
 	}
 
-	entry := StreamEntry{
-		ID:     finalID,
-		Fields: fields,
-	}
-	value.Stream.Entries = append(value.Stream.Entries, entry)
+	value.Stream.appendEntry(finalID, fields)
 	value.Stream.LastID = finalID
+	value.Stream.EntriesAdded++
 
-	go NotifyStreamBlockingClients(key)
+	if opts.Trim != nil {
+		trimStream(value.Stream, *opts.Trim)
+	}
 
-	return finalID, nil
+	touchWatchedKey(key)
+	signalKeyReady(key)
 
+	return finalID.String(), value.Stream.Len(), nil
 }
 
-func ParseStreamID(idStr string) (*StreamID, error) {
+// MaxStreamID is the largest ID a stream entry can have
+var MaxStreamID = StreamID{Timestamp: math.MaxInt64, Sequence: math.MaxInt64}
+
+func ParseStreamID(idStr string) (StreamID, error) {
 	if idStr == "" {
-		return nil, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+		return StreamID{}, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
 	}
 
-	parts := strings.Split(idStr, "-")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+	msPart, seqPart, found := strings.Cut(idStr, "-")
+	if !found {
+		return StreamID{}, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
 	}
 
-	timestamp, err := strconv.ParseInt(parts[0], 10, 64)
+	timestamp, err := strconv.ParseInt(msPart, 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+		return StreamID{}, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
 	}
 
-	sequence, err := strconv.ParseInt(parts[1], 10, 64)
+	sequence, err := strconv.ParseInt(seqPart, 10, 64)
 	if err != nil {
-		return nil, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+		return StreamID{}, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
 	}
 
 	//negative checking
 	if timestamp < 0 {
-		return nil, fmt.Errorf("ERR the ID specified in XADD must be greater than 0-0")
+		return StreamID{}, fmt.Errorf("ERR the ID specified in XADD must be greater than 0-0")
 	}
 
 	if sequence < 0 {
-		return nil, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
+		return StreamID{}, fmt.Errorf("ERR Invalid stream ID specified as stream command argument")
 	}
 
-	return &StreamID{
+	return StreamID{
 		Timestamp: timestamp,
 		Sequence:  sequence,
 	}, nil
 }
 
 // to convert StreamID back to string
-func (s *StreamID) String() string {
-	return fmt.Sprintf("%d-%d", s.Timestamp, s.Sequence)
+func (id StreamID) String() string {
+	return strconv.FormatInt(id.Timestamp, 10) + "-" + strconv.FormatInt(id.Sequence, 10)
 }
 
-func CompareStreamIDs(id1, id2 string) int {
-	parsed1, err1 := ParseStreamID(id1)
-	parsed2, err2 := ParseStreamID(id2)
-
-	if err1 != nil || err2 != nil {
-		return strings.Compare(id1, id2)
-	}
-
-	if parsed1.Timestamp < parsed2.Timestamp {
-		return -1
+// next returns the smallest ID greater than id, which must be below
+// MaxStreamID
+func (id StreamID) next() StreamID {
+	if id.Sequence < math.MaxInt64 {
+		return StreamID{Timestamp: id.Timestamp, Sequence: id.Sequence + 1}
 	}
-
-	if parsed1.Timestamp > parsed2.Timestamp {
-		return 1
-	}
-
-	if parsed1.Sequence < parsed2.Sequence {
-		return -1
-	}
-
-	if parsed1.Sequence > parsed2.Sequence {
-		return 1
-	}
-	return 0
+	return StreamID{Timestamp: id.Timestamp + 1}
 }
 
-func ValidateStreamID(newID, lastID string) error {
-	parsed, err := ParseStreamID(newID)
-	if err != nil {
-		return err
-	}
-
-	if parsed.Timestamp == 0 && parsed.Sequence == 0 {
+func ValidateStreamID(newID, lastID StreamID) error {
+	if newID.IsZero() {
 		return fmt.Errorf("ERR The ID specified in XADD must be greater than 0-0")
 
 	}
-	if lastID == "" {
-		return nil
-	}
-
-	comparison := CompareStreamIDs(newID, lastID)
-	if comparison <= 0 {
+	if newID.Compare(lastID) <= 0 {
 		return fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
 	}
 	return nil
 }
 
-func GenerateNextSequence(timestamp int64, lastID string) (int64, error) {
-	if lastID == "" {
+func GenerateNextSequence(timestamp int64, lastID StreamID) (int64, error) {
+	if timestamp > lastID.Timestamp {
 		return 0, nil
 	}
 
-	lastParsed, err := ParseStreamID(lastID)
-	if err != nil {
-		return 0, err
-	}
-
-	if timestamp > lastParsed.Timestamp {
-		return 0, nil
-	}
-
-	if timestamp == lastParsed.Timestamp {
-		return lastParsed.Sequence + 1, nil
+	if timestamp == lastID.Timestamp {
+		if lastID.Sequence == math.MaxInt64 {
+			return 0, fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+		return lastID.Sequence + 1, nil
 	}
 
 	return 0, fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
 }
 
-func GenerateAutoID(lastID string) string {
+func GenerateAutoID(lastID StreamID) StreamID {
 	currentTime := time.Now().UnixMilli()
 
-	if lastID == "" {
-		return fmt.Sprintf("%d-0", currentTime)
-	}
-	lastParsed, err := ParseStreamID(lastID)
-	if err != nil {
-		return fmt.Sprintf("%d-0", currentTime)
-	}
-
-	if currentTime > lastParsed.Timestamp {
-		return fmt.Sprintf("%d-0", currentTime)
-	}
-
-	if currentTime == lastParsed.Timestamp {
-		return fmt.Sprintf("%d-%d", currentTime, lastParsed.Sequence+1)
+	if currentTime > lastID.Timestamp {
+		return StreamID{Timestamp: currentTime}
 	}
 
-	return fmt.Sprintf("%d-0", lastParsed.Timestamp+1)
-
+	return lastID.next()
 }
 
-// StreaRange returns entries within specified ID range
-func StreamRange(key, start, end string) ([]StreamEntry, error) {
+// StreamRange returns up to count (all when count < 0) entries with IDs
+// between start and end inclusive. With reverse the entries come last to
+// first.
+func StreamRange(key string, start, end StreamID, count int, reverse bool) ([]StreamEntry, error) {
+	expireIfNeeded(key)
+
 	dataMutex.RLock()
 	defer dataMutex.RUnlock()
 
-	value, exists := data[key]
-	if !exists {
-		return []StreamEntry{}, nil
-	}
-
-	if value.Type != STREAM {
-		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-
-	if value.Expiry != nil && time.Now().After(*value.Expiry) {
-		return []StreamEntry{}, nil
+	stream, err := lookupStream(lookupKey(key))
+	if err != nil {
+		return nil, err
 	}
-
-	stream := value.Stream
-	if len(stream.Entries) == 0 {
+	if stream == nil {
 		return []StreamEntry{}, nil
 	}
+	return stream.rangeEntries(start, end, count, reverse), nil
+}
 
-	startID, endID := resolveRangeIDs(start, end, stream)
+// StreamLen returns the number of entries of the stream at key
+func StreamLen(key string) (int, error) {
+	expireIfNeeded(key)
 
-	var result []StreamEntry
-	for _, entry := range stream.Entries {
-		entryID := entry.ID
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
 
-		if CompareStreamIDs(entryID, startID) >= 0 && CompareStreamIDs(entryID, endID) <= 0 {
-			result = append(result, entry)
-		}
+	stream, err := lookupStream(lookupKey(key))
+	if err != nil || stream == nil {
+		return 0, err
 	}
-
-	return result, nil
-
+	return stream.Len(), nil
 }
 
-func resolveRangeIDs(start, end string, stream *Stream) (string, string) {
-	startID := start
-	endID := end
+// StreamSetID overrides the last ID of a stream and, when entriesAdded or
+// maxDeletedID are given (>= 0, not 0-0), its other metadata
+func StreamSetID(key string, lastID StreamID, entriesAdded int64, maxDeletedID StreamID) error {
+	dataMutex.Lock()
+	defer unlockData()
 
-	//handling minimum possible ID/start of stream
-	if start == "-" {
-		if len(stream.Entries) > 0 {
-			startID = stream.Entries[0].ID
-		} else {
-			startID = "0-1" // minimum valid id
-		}
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		return fmt.Errorf("ERR no such key")
 	}
 
-	//handling maximum possible ID/end of stream
-	if end == "+" {
-		if len(stream.Entries) > 0 {
-			endID = stream.Entries[len(stream.Entries)-1].ID
-		} else {
-			endID = "9223372036854775807-9223372036854775807"
-		}
+	if entriesAdded >= 0 && entriesAdded < int64(stream.Len()) {
+		return fmt.Errorf("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if lastID.Compare(maxDeletedID) < 0 {
+		return fmt.Errorf("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+	}
+	if last, exists := stream.lastEntry(); exists && lastID.Compare(last.ID) < 0 {
+		return fmt.Errorf("ERR The ID specified in XSETID is smaller than the target stream top item")
 	}
 
-	return startID, endID
+	stream.LastID = lastID
+	if entriesAdded >= 0 {
+		stream.EntriesAdded = entriesAdded
+	}
+	if !maxDeletedID.IsZero() {
+		stream.MaxDeletedID = maxDeletedID
+	}
+	touchWatchedKey(key)
+	return nil
 }
 
 // StreamReadFrom returns entries after the given ID
-func StreamReadFrom(key, startID string, count int) ([]StreamEntry, error) {
+func StreamReadFrom(key string, startID StreamID, count int) ([]StreamEntry, error) {
+	expireIfNeeded(key)
+
 	dataMutex.RLock()
 	defer dataMutex.RUnlock()
 
-	value, exists := data[key]
-	if !exists {
-		return []StreamEntry{}, nil
-	}
-
-	if value.Type != STREAM {
-		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-
-	if value.Expiry != nil && time.Now().After(*value.Expiry) {
-		return []StreamEntry{}, nil
+	stream, err := lookupStream(lookupKey(key))
+	if err != nil {
+		return nil, err
 	}
-
-	stream := value.Stream
-	if len(stream.Entries) == 0 {
+	if stream == nil {
 		return []StreamEntry{}, nil
 	}
-
-	var result []StreamEntry
-	for _, entry := range stream.Entries {
-		if CompareStreamIDs(entry.ID, startID) > 0 {
-			result = append(result, entry)
-
-			if count > 0 && len(result) >= count {
-				break
-			}
-		}
-	}
-	return result, nil
+	return stream.entriesAfter(startID, count), nil
 }
 
 func GetStreamLastID(key string) string {
+	expireIfNeeded(key)
+
 	dataMutex.RLock()
 	defer dataMutex.RUnlock()
 
-	value, exists := data[key]
+	value, exists := lookupKey(key)
 	if !exists {
 		return ""
 	}
 
-	if value.Type != STREAM {
-		return ""
-	}
-
-	if value.Expiry != nil && time.Now().After(*value.Expiry) {
+	if value.Type != STREAM || value.Stream.LastID.IsZero() {
 		return ""
 	}
-	return value.Stream.LastID
-}
-
-func StreamReadFromImmediate(streamKeys, startIDs []string, count int) ([]StreamReadResult, bool) {
-	results := make([]StreamReadResult, 0)
-
-	for i, key := range streamKeys {
-		startID := startIDs[i]
-
-		if startID == "$" {
-			lastID := GetStreamLastID(key)
-			if lastID != "" {
-				startID = lastID
-			} else {
-				continue
-			}
-		}
-
-		entries, err := StreamReadFrom(key, startID, count)
-		if err != nil {
-			continue
-		}
-
-		if len(entries) > 0 {
-			results = append(results, StreamReadResult{
-				StreamKey: key,
-				Entries:   entries,
-			})
-		}
-	}
-
-	return results, len(results) > 0
+	return value.Stream.LastID.String()
 }
@@ -0,0 +1,177 @@
+package store
+
+// StreamTrim describes the trimming of XADD and XTRIM: down to MaxLen
+// entries, or dropping the entries below MinID when MinID is set. Approx
+// (~) trims whole blocks only, removing at most Limit entries. Limit is 0
+// for no limit and -1 when not given, which caps ~ at 100 blocks.
+type StreamTrim struct {
+	MaxLen int64
+	MinID  *StreamID
+	Approx bool
+	Limit  int64
+}
+
+// trimStream removes the oldest entries as trim asks, returning how many
+func trimStream(stream *Stream, trim StreamTrim) int {
+	qualifies := func(id StreamID, remaining int) bool {
+		if trim.MinID != nil {
+			return id.Compare(*trim.MinID) < 0
+		}
+		return int64(remaining) > trim.MaxLen
+	}
+
+	limit := int64(0)
+	if trim.Approx {
+		limit = trim.Limit
+		if limit < 0 {
+			limit = 100 * streamNodeMaxEntries
+		}
+	}
+
+	// A block goes as a whole when all of its entries qualify
+	removed := 0
+	for len(stream.blocks) > 0 {
+		block := stream.blocks[0]
+		if limit > 0 && int64(removed+block.live) > limit {
+			break
+		}
+		if !qualifies(block.last, stream.length-block.live+1) {
+			break
+		}
+		removed += stream.removeFirstBlock()
+	}
+	if trim.Approx || len(stream.blocks) == 0 {
+		return removed
+	}
+
+	// Exact trimming goes on entry by entry in the first block left
+	var ids []StreamID
+	remaining := stream.length
+	stream.blocks[0].each(false, func(e blockEntry) bool {
+		if e.deleted {
+			return true
+		}
+		if !qualifies(e.entry.ID, remaining) {
+			return false
+		}
+		ids = append(ids, e.entry.ID)
+		remaining--
+		return true
+	})
+	for _, id := range ids {
+		stream.deleteEntry(id)
+	}
+	return removed + len(ids)
+}
+
+// StreamTrimKey trims the stream at key (XTRIM), returning how many entries
+// were removed and how many are left
+func StreamTrimKey(key string, trim StreamTrim) (removed int, length int, err error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil || stream == nil {
+		return 0, 0, err
+	}
+	removed = trimStream(stream, trim)
+	if removed > 0 {
+		touchWatchedKey(key)
+	}
+	return removed, stream.Len(), nil
+}
+
+// StreamDelete removes the entries with the given IDs (XDEL), returning how
+// many existed. The largest deleted ID is recorded, consumer groups use it
+// to tell whether their entries-read counter is still exact.
+func StreamDelete(key string, ids []StreamID) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	stream, err := lookupStream(lookupKeyWrite(key))
+	if err != nil || stream == nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, id := range ids {
+		if !stream.deleteEntry(id) {
+			continue
+		}
+		if id.Compare(stream.MaxDeletedID) > 0 {
+			stream.MaxDeletedID = id
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		touchWatchedKey(key)
+	}
+	return deleted, nil
+}
+
+// firstID is the ID of the first entry, 0-0 for an empty stream
+func (stream *Stream) firstID() StreamID {
+	first, _ := stream.firstEntry()
+	return first.ID
+}
+
+// rangeHasTombstones reports whether an entry between start and end
+// (inclusive) may have been deleted. Only the largest deleted ID is known,
+// so this can only be ruled out when it lies outside the range or before
+// the first entry.
+func (stream *Stream) rangeHasTombstones(start, end StreamID) bool {
+	if stream.Len() == 0 || stream.MaxDeletedID.IsZero() {
+		return false
+	}
+	if stream.firstID().Compare(stream.MaxDeletedID) > 0 {
+		return false
+	}
+	return start.Compare(stream.MaxDeletedID) <= 0 && end.Compare(stream.MaxDeletedID) >= 0
+}
+
+// estimateEntriesRead returns how many entries were added to the stream up
+// to and including id, or -1 when deletions make that unknowable
+func (stream *Stream) estimateEntriesRead(id StreamID) int64 {
+	if stream.EntriesAdded == 0 {
+		return 0
+	}
+
+	cmpLast := id.Compare(stream.LastID)
+	if stream.Len() == 0 && cmpLast <= 0 {
+		return stream.EntriesAdded
+	}
+	if cmpLast == 0 {
+		return stream.EntriesAdded
+	}
+	if cmpLast > 0 {
+		return -1
+	}
+
+	// Without deletions past the first entry, the entries before it are
+	// exactly the ones trimmed away
+	firstID := stream.firstID()
+	if stream.MaxDeletedID.Compare(firstID) < 0 {
+		switch cmpFirst := id.Compare(firstID); {
+		case cmpFirst < 0:
+			return stream.EntriesAdded - int64(stream.Len())
+		case cmpFirst == 0:
+			return stream.EntriesAdded - int64(stream.Len()) + 1
+		}
+	}
+	return -1
+}
+
+// groupLag returns how many entries the group has yet to read, false when
+// that is unknown
+func (stream *Stream) groupLag(cg *ConsumerGroup) (int64, bool) {
+	if stream.EntriesAdded == 0 {
+		return 0, true
+	}
+	if cg.EntriesRead >= 0 && !stream.rangeHasTombstones(cg.LastID, MaxStreamID) {
+		return stream.EntriesAdded - cg.EntriesRead, true
+	}
+	if read := stream.estimateEntriesRead(cg.LastID); read >= 0 {
+		return stream.EntriesAdded - read, true
+	}
+	return 0, false
+}
@@ -9,11 +9,11 @@ import (
 
 func Set(key, val string, ttl time.Duration) {
 	dataMutex.Lock()
-	defer dataMutex.Unlock()
+	defer unlockData()
 
 	value := &RedisValue{
-		Type:   STRING,
-		String: val,
+		Type:  STRING,
+		Bytes: []byte(val),
 	}
 
 	if ttl > 0 {
@@ -21,39 +21,35 @@ func Set(key, val string, ttl time.Duration) {
 		value.Expiry = &expiry
 	}
 
-	data[key] = value
+	setKey(key, value)
 }
 
 func Get(key string) (string, bool) {
+	expireIfNeeded(key)
+
 	dataMutex.RLock()
 	defer dataMutex.RUnlock()
 
-	value, exists := data[key]
+	value, exists := lookupKey(key)
 	if !exists {
 		return "", false
 	}
 
-	if isExpired(value, key) {
-		return "", false
-	}
-
 	if value.Type != STRING {
 		return "", false
 	}
 
-	return value.String, true
+	return string(value.Bytes), true
 }
 
 func Delete(key string) bool {
 	dataMutex.Lock()
-	defer dataMutex.Unlock()
+	defer unlockData()
 
-	_, exists := data[key]
-	if exists {
-		delete(data, key)
-		return true
+	if _, exists := lookupKeyWrite(key); !exists {
+		return false
 	}
-	return false
+	return deleteKey(key)
 }
 
 // Increment operations for replication
@@ -67,9 +63,9 @@ func Decrement(key string) (int64, error) {
 
 func IncrementBy(key string, amount int64) (int64, error) {
 	dataMutex.Lock()
-	defer dataMutex.Unlock()
+	defer unlockData()
 
-	value, exists := data[key]
+	value, exists := lookupKeyWrite(key)
 	var currentVal int64 = 0
 
 	if exists {
@@ -77,16 +73,11 @@ func IncrementBy(key string, amount int64) (int64, error) {
 			return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
 		}
 
-		if value.Expiry != nil && time.Now().After(*value.Expiry) {
-			// Key expired, treat as non-existent
-			currentVal = 0
-		} else {
-			parsedVal, err := strconv.ParseInt(value.String, 10, 64)
-			if err != nil {
-				return 0, fmt.Errorf("value is not an integer or out of range")
-			}
-			currentVal = parsedVal
+		parsedVal, err := strconv.ParseInt(string(value.Bytes), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value is not an integer or out of range")
 		}
+		currentVal = parsedVal
 	}
 
 	// Check for overflow/underflow
@@ -104,14 +95,14 @@ func IncrementBy(key string, amount int64) (int64, error) {
 
 	// Store the new value
 	if !exists {
-		data[key] = &RedisValue{
-			Type:   STRING,
-			String: strconv.FormatInt(newValue, 10),
-		}
+		setKey(key, &RedisValue{
+			Type:  STRING,
+			Bytes: []byte(strconv.FormatInt(newValue, 10)),
+		})
 	} else {
-		value.String = strconv.FormatInt(newValue, 10)
-		// Clear expiry when modifying
-		value.Expiry = nil
+		// Modifying the value in place keeps its TTL
+		value.Bytes = []byte(strconv.FormatInt(newValue, 10))
+		touchWatchedKey(key)
 	}
 
 	return newValue, nil
@@ -120,3 +111,258 @@ func IncrementBy(key string, amount int64) (int64, error) {
 func DecrementBy(key string, amount int64) (int64, error) {
 	return IncrementBy(key, -amount)
 }
+
+// MaxStringLength is the largest string value we accept, matching Redis's
+// default proto-max-bulk-len
+const MaxStringLength = 512 * 1024 * 1024
+
+// SetCondition restricts SET to missing (NX) or existing (XX) keys
+type SetCondition int
+
+const (
+	SetAlways SetCondition = iota
+	SetNX
+	SetXX
+)
+
+// SetOptions carries the parsed options of SET. Expiry is absolute so the
+// same options can be replayed on a replica without clock drift.
+type SetOptions struct {
+	Condition SetCondition
+	Expiry    *time.Time
+	KeepTTL   bool
+	Get       bool
+}
+
+// SetResult reports what SetWithOptions did. Old and OldExists are only
+// filled in when the GET option was requested.
+type SetResult struct {
+	Applied   bool
+	Old       string
+	OldExists bool
+}
+
+// SetWithOptions implements SET with all of its options in one atomic step
+func SetWithOptions(key, val string, opts SetOptions) (SetResult, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	var result SetResult
+	current, exists := lookupKeyWrite(key)
+
+	if opts.Get && exists {
+		if current.Type != STRING {
+			return result, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		result.Old = string(current.Bytes)
+		result.OldExists = true
+	}
+
+	if (opts.Condition == SetNX && exists) || (opts.Condition == SetXX && !exists) {
+		return result, nil
+	}
+
+	value := &RedisValue{
+		Type:   STRING,
+		Bytes:  []byte(val),
+		Expiry: opts.Expiry,
+	}
+	if opts.KeepTTL && exists {
+		value.Expiry = current.Expiry
+	}
+
+	setKey(key, value)
+	result.Applied = true
+	return result, nil
+}
+
+// MultiSet stores every key/value pair, dropping any TTL they had.
+// With onlyIfNoneExist nothing is written unless all keys are missing.
+func MultiSet(pairs [][2]string, onlyIfNoneExist bool) bool {
+	dataMutex.Lock()
+	defer unlockData()
+
+	if onlyIfNoneExist {
+		for _, pair := range pairs {
+			if _, exists := lookupKeyWrite(pair[0]); exists {
+				return false
+			}
+		}
+	}
+
+	for _, pair := range pairs {
+		setKey(pair[0], &RedisValue{Type: STRING, Bytes: []byte(pair[1])})
+	}
+	return true
+}
+
+// GetString returns the string stored at key, failing with WRONGTYPE when the
+// key holds another type. Unlike Get it lets callers tell the two apart.
+func GetString(key string) (string, bool, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	value, exists := lookupKey(key)
+	if !exists {
+		return "", false, nil
+	}
+	if value.Type != STRING {
+		return "", false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return string(value.Bytes), true, nil
+}
+
+// GetDel returns the string stored at key and deletes the key
+func GetDel(key string) (string, bool, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, exists := lookupKeyWrite(key)
+	if !exists {
+		return "", false, nil
+	}
+	if value.Type != STRING {
+		return "", false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	deleteKey(key)
+	return string(value.Bytes), true, nil
+}
+
+// GetEx returns the string stored at key and optionally changes its expiry.
+// With persist the TTL is removed, otherwise a non-nil expiry replaces it.
+// An expiry already in the past deletes the key on a master, which is
+// reported through deleted.
+func GetEx(key string, expiry *time.Time, persist bool) (val string, exists bool, deleted bool, err error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, exists := lookupKeyWrite(key)
+	if !exists {
+		return "", false, false, nil
+	}
+	if value.Type != STRING {
+		return "", false, false, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	switch {
+	case persist && value.Expiry != nil:
+		setKeyExpiry(key, value, nil)
+	case expiry != nil:
+		if !expiry.After(time.Now()) && !isReplica() {
+			deleteKey(key)
+			return string(value.Bytes), true, true, nil
+		}
+		setKeyExpiry(key, value, expiry)
+	}
+
+	return string(value.Bytes), true, false, nil
+}
+
+// Append appends suffix to the string at key, creating it if needed,
+// and returns the new length
+func Append(key, suffix string) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, exists := lookupKeyWrite(key)
+	if !exists {
+		setKey(key, &RedisValue{Type: STRING, Bytes: []byte(suffix)})
+		return len(suffix), nil
+	}
+	if value.Type != STRING {
+		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	if len(value.Bytes)+len(suffix) > MaxStringLength {
+		return 0, fmt.Errorf("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+
+	value.Bytes = append(value.Bytes, suffix...)
+	touchWatchedKey(key)
+	return len(value.Bytes), nil
+}
+
+// SetRange overwrites the string at key starting at offset, padding with
+// zero bytes when offset is past the end, and returns the new length
+func SetRange(key string, offset int, patch string) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, exists := lookupKeyWrite(key)
+	if exists && value.Type != STRING {
+		return 0, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	// An empty patch never creates or grows the key
+	if len(patch) == 0 {
+		if !exists {
+			return 0, nil
+		}
+		return len(value.Bytes), nil
+	}
+
+	if offset+len(patch) > MaxStringLength {
+		return 0, fmt.Errorf("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+
+	if !exists {
+		value = &RedisValue{Type: STRING}
+		setKey(key, value)
+	}
+
+	value.Bytes = growBytes(value.Bytes, offset+len(patch))
+	copy(value.Bytes[offset:], patch)
+	touchWatchedKey(key)
+	return len(value.Bytes), nil
+}
+
+// IncrementByFloat adds amount to the number stored at key, keeping its TTL,
+// and returns the new value formatted the way it is stored
+func IncrementByFloat(key string, amount float64) (string, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	value, exists := lookupKeyWrite(key)
+	current := 0.0
+
+	if exists {
+		if value.Type != STRING {
+			return "", fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+		parsed, err := strconv.ParseFloat(string(value.Bytes), 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+			return "", fmt.Errorf("ERR value is not a valid float")
+		}
+		current = parsed
+	}
+
+	result := current + amount
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return "", fmt.Errorf("ERR increment would produce NaN or Infinity")
+	}
+
+	formatted := strconv.FormatFloat(result, 'f', -1, 64)
+	if !exists {
+		setKey(key, &RedisValue{Type: STRING, Bytes: []byte(formatted)})
+	} else {
+		value.Bytes = []byte(formatted)
+		touchWatchedKey(key)
+	}
+	return formatted, nil
+}
+
+// growBytes zero-pads buf up to size bytes, reusing its capacity when possible
+func growBytes(buf []byte, size int) []byte {
+	if size <= len(buf) {
+		return buf
+	}
+	if size <= cap(buf) {
+		tail := buf[len(buf):size]
+		clear(tail)
+		return buf[:size]
+	}
+	return append(buf, make([]byte, size-len(buf))...)
+}
@@ -0,0 +1,102 @@
+package store
+
+import "time"
+
+// Watch is the set of keys a client WATCHed before MULTI. Any write to one
+// of them, including its deletion when it expires, makes the watch dirty
+// and the client's EXEC fails.
+type Watch struct {
+	// keys maps each watched key to whether it was already expired when
+	// it was watched. Deleting such a key does not change what clients see,
+	// so it does not count as a modification.
+	keys  map[string]bool
+	dirty bool
+}
+
+// watchedKeys indexes the watches by key, guarded by dataMutex
+var watchedKeys = make(map[string]map[*Watch]struct{})
+
+// NewWatch returns an empty watch
+func NewWatch() *Watch {
+	return &Watch{keys: make(map[string]bool)}
+}
+
+// Add starts watching keys. Keys already watched keep their state.
+func (w *Watch) Add(keys ...string) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	now := time.Now()
+	for _, key := range keys {
+		if _, watching := w.keys[key]; watching {
+			continue
+		}
+		value, exists := data[key]
+		w.keys[key] = exists && isExpired(value, now)
+
+		watches := watchedKeys[key]
+		if watches == nil {
+			watches = make(map[*Watch]struct{})
+			watchedKeys[key] = watches
+		}
+		watches[w] = struct{}{}
+	}
+}
+
+// Dirty reports whether a watched key was modified since it was watched.
+// A key that was alive when watched and has expired since counts as
+// modified even if nothing deleted it yet.
+func (w *Watch) Dirty() bool {
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	if w.dirty {
+		return true
+	}
+	now := time.Now()
+	for key, expiredWhenWatched := range w.keys {
+		if value, exists := data[key]; exists && !expiredWhenWatched && isExpired(value, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Release stops watching every key. The watch can be reused afterwards.
+func (w *Watch) Release() {
+	dataMutex.Lock()
+	defer unlockData()
+	w.release()
+}
+
+func (w *Watch) release() {
+	for key := range w.keys {
+		if watches := watchedKeys[key]; watches != nil {
+			delete(watches, w)
+			if len(watches) == 0 {
+				delete(watchedKeys, key)
+			}
+		}
+	}
+	w.keys = make(map[string]bool)
+	w.dirty = false
+}
+
+// touchWatchedKey marks the watches on key dirty. Every write to a key
+// calls it: setKey, deleteKey and setKeyExpiry do, and so do the paths
+// that modify a value in place. Callers hold dataMutex.Lock.
+func touchWatchedKey(key string) {
+	for w := range watchedKeys[key] {
+		if w.dirty {
+			continue
+		}
+		if w.keys[key] {
+			if _, exists := data[key]; !exists {
+				// The key was expired when watched and is only now deleted
+				w.keys[key] = false
+				continue
+			}
+		}
+		w.dirty = true
+	}
+}
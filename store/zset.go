@@ -0,0 +1,251 @@
+package store
+
+import "math/rand/v2"
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+// SortedSet pairs a member->score map with a skiplist ordered by (score,
+// member), the same layout Redis uses for large sorted sets. The map answers
+// ZSCORE in O(1), the skiplist answers rank and score range queries in
+// O(log n), and members keeps them walkable by a ZSCAN cursor.
+type SortedSet struct {
+	dict    map[string]float64
+	zsl     *zskiplist
+	members *scanDict
+}
+
+// ZSetEntry is a member with its score, as returned by range queries
+type ZSetEntry struct {
+	Member string
+	Score  float64
+}
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+func newSortedSet() *SortedSet {
+	return &SortedSet{
+		dict:    make(map[string]float64),
+		zsl:     newZskiplist(),
+		members: newScanDict(),
+	}
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// zslLess orders nodes by score, then lexicographically by member
+func zslLess(score float64, member string, node *zskiplistNode) bool {
+	return node.score < score || (node.score == score && node.member < member)
+}
+
+func (zsl *zskiplist) insert(score float64, member string) {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && zslLess(score, member, x.level[i].forward) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+}
+
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	var update [zskiplistMaxLevel]*zskiplistNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && zslLess(score, member, x.level[i].forward) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// byRank returns the node at the 0-based rank, nil when out of range
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	if rank < 0 || rank >= zsl.length {
+		return nil
+	}
+
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank+1 {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstFrom returns the first node with a score >= min, nil when there is none
+func (zsl *zskiplist) firstFrom(min float64) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.score < min {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// Len returns the number of members
+func (z *SortedSet) Len() int {
+	return len(z.dict)
+}
+
+// Score returns the score of member
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Add inserts member or moves it to a new score, reporting whether it was new
+func (z *SortedSet) Add(member string, score float64) bool {
+	if old, exists := z.dict[member]; exists {
+		if old != score {
+			z.zsl.delete(old, member)
+			z.zsl.insert(score, member)
+			z.dict[member] = score
+		}
+		return false
+	}
+
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	z.members.add(member)
+	return true
+}
+
+// Remove deletes member, reporting whether it was present
+func (z *SortedSet) Remove(member string) bool {
+	score, exists := z.dict[member]
+	if !exists {
+		return false
+	}
+
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	z.members.remove(member)
+	return true
+}
+
+// RangeByRank returns the members between the 0-based ranks start and stop
+// inclusive, already clamped by the caller
+func (z *SortedSet) RangeByRank(start, stop int) []ZSetEntry {
+	if start > stop || start >= z.zsl.length {
+		return nil
+	}
+
+	entries := make([]ZSetEntry, 0, stop-start+1)
+	for x := z.zsl.byRank(start); x != nil && len(entries) < stop-start+1; x = x.level[0].forward {
+		entries = append(entries, ZSetEntry{Member: x.member, Score: x.score})
+	}
+	return entries
+}
+
+// rangeByScore calls fn for members with min <= score < max in order,
+// stopping early when fn returns false
+func (z *SortedSet) rangeByScore(min, max float64, fn func(member string, score float64) bool) {
+	for x := z.zsl.firstFrom(min); x != nil && x.score < max; x = x.level[0].forward {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ZAddOptions carries the ZADD flags. NX/XX gate on whether a member exists,
+// GT/LT only let a score move in one direction, and Incr turns the single
+// score into an increment.
+type ZAddOptions struct {
+	NX, XX bool
+	GT, LT bool
+	Incr   bool
+}
+
+// ZAddResult reports what ZAdd did. Score is the final score of the member
+// for INCR, which Aborted reports was not applied.
+type ZAddResult struct {
+	Added   int
+	Updated int
+	Score   float64
+	Aborted bool
+}
+
+// lookupZSet checks that the result of a key lookup holds a sorted set,
+// passing nil through for a missing key. Callers hold dataMutex.
+func lookupZSet(value *RedisValue, exists bool) (*SortedSet, error) {
+	if !exists {
+		return nil, nil
+	}
+	if value.Type != ZSET {
+		return nil, fmt.Errorf("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return value.ZSet, nil
+}
+
+// ZAdd adds or updates members following the ZADD flags
+func ZAdd(key string, entries []ZSetEntry, opts ZAddOptions) (ZAddResult, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	var result ZAddResult
+
+	zset, err := lookupZSet(lookupKeyWrite(key))
+	if err != nil {
+		return result, err
+	}
+	if zset == nil {
+		if opts.XX {
+			result.Aborted = true
+			return result, nil
+		}
+		zset = newSortedSet()
+		setKey(key, &RedisValue{Type: ZSET, ZSet: zset})
+	}
+
+	for _, entry := range entries {
+		score := entry.Score
+		current, exists := zset.Score(entry.Member)
+
+		if exists {
+			if opts.NX {
+				result.Aborted = true
+				continue
+			}
+			if opts.Incr {
+				score += current
+				if math.IsNaN(score) {
+					return result, fmt.Errorf("ERR resulting score is not a number (NaN)")
+				}
+			}
+			if (opts.GT && score <= current) || (opts.LT && score >= current) {
+				result.Aborted = true
+				continue
+			}
+			if score != current {
+				zset.Add(entry.Member, score)
+				result.Updated++
+			}
+		} else {
+			if opts.XX {
+				result.Aborted = true
+				continue
+			}
+			zset.Add(entry.Member, score)
+			result.Added++
+		}
+		result.Score = score
+	}
+	if result.Added+result.Updated > 0 {
+		touchWatchedKey(key)
+	}
+
+	// NX/XX can leave a freshly created key without members
+	if zset.Len() == 0 {
+		deleteKey(key)
+	}
+	return result, nil
+}
+
+// ZRem removes members and returns how many were present
+func ZRem(key string, members []string) (int, error) {
+	dataMutex.Lock()
+	defer unlockData()
+
+	zset, err := lookupZSet(lookupKeyWrite(key))
+	if err != nil || zset == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if zset.Remove(member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		touchWatchedKey(key)
+	}
+	if zset.Len() == 0 {
+		deleteKey(key)
+	}
+	return removed, nil
+}
+
+// ZScore returns the score of member
+func ZScore(key, member string) (float64, bool, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	zset, err := lookupZSet(lookupKey(key))
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+
+	score, exists := zset.Score(member)
+	return score, exists, nil
+}
+
+// ZCard returns the number of members of the sorted set at key
+func ZCard(key string) (int, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	zset, err := lookupZSet(lookupKey(key))
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.Len(), nil
+}
+
+// ZRange returns the members between start and stop by rank, negative
+// indexes counting from the highest rank
+func ZRange(key string, start, stop int) ([]ZSetEntry, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	zset, err := lookupZSet(lookupKey(key))
+	if err != nil || zset == nil {
+		return nil, err
+	}
+
+	length := zset.Len()
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+
+	return zset.RangeByRank(start, stop), nil
+}
+
+// ZScan returns a batch of members of the sorted set at key with their
+// scores, with the same cursor contract as ScanKeys
+func ZScan(key string, cursor uint64, count int) ([]ZSetEntry, uint64, error) {
+	expireIfNeeded(key)
+
+	dataMutex.RLock()
+	defer dataMutex.RUnlock()
+
+	zset, err := lookupZSet(lookupKey(key))
+	if err != nil || zset == nil {
+		return nil, 0, err
+	}
+
+	entries := make([]ZSetEntry, 0, count)
+	next := zset.members.scan(cursor, count, func(member string) {
+		entries = append(entries, ZSetEntry{Member: member, Score: zset.dict[member]})
+	})
+	return entries, next, nil
+}
+
+// SetSortedSet stores a sorted set read from an RDB file at key. The
+// loader has checked that members are unique and scores are numbers.
+func SetSortedSet(key string, entries []ZSetEntry, ttl time.Duration) error {
+	if len(entries) == 0 {
+		return fmt.Errorf("empty sorted set")
+	}
+
+	zset := newSortedSet()
+	for _, entry := range entries {
+		zset.Add(entry.Member, entry.Score)
+	}
+
+	dataMutex.Lock()
+	defer unlockData()
+
+	value := &RedisValue{Type: ZSET, ZSet: zset}
+	if ttl > 0 {
+		expiry := time.Now().Add(ttl)
+		value.Expiry = &expiry
+	}
+	setKey(key, value)
+	return nil
+}